@@ -69,7 +69,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _pluginsApiSchemaGraphql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x6c\x52\xc1\x8e\xe2\x30\x0c\xbd\xe7\x2b\x8c\xb8\x14\x89\x2f\xc8\x6d\x25\x2e\xac\xc4\xae\x76\x61\x4f\x88\x43\x68\xbd\x6d\x46\x6d\xd2\xb1\x4d\xa5\xce\x68\xfe\x7d\xe4\xa4\x1a\x5a\x98\x53\xed\xa7\xf7\x6c\xbf\x97\x72\xe9\x5a\x47\x70\xf2\x1d\x9a\xa9\xfe\x79\xfc\xfd\xcb\x18\x2e\x1b\xec\x1c\xbc\x1b\x80\xd7\x1b\xd2\x68\xe1\x8f\x7e\xcc\x87\x31\x6b\x38\x35\x98\x51\x90\xb1\xc7\x2d\x10\xf6\x84\x8c\x41\x18\x5c\xdb\x42\xfc\x0f\xd2\x20\x60\x10\x1a\xa1\x8f\x5e\x71\x1f\x24\x42\xbc\x11\xc4\xeb\x0b\x96\x02\x35\xb9\xbe\x31\x2a\xcf\x83\xd3\xa6\x35\xfc\x45\x21\x8f\x03\x02\xfb\x50\xb7\x08\x37\x46\x82\xeb\x08\xfb\x9d\x81\xd4\x14\xbe\xb2\xb0\xdf\x6d\x2c\xfc\x63\xa4\xa5\x46\x77\x2b\x87\x27\x2e\x17\x1b\x0b\x67\xe5\x5d\x56\x77\xe6\x80\x20\xe4\x7c\xab\xac\x5c\x14\x38\x60\x10\x0b\x47\x21\x1f\x6a\xd5\x9c\x14\x7f\x10\x75\xfa\x2d\x55\x35\x55\x05\x8b\x23\xe1\x1f\x62\x53\x7e\x5b\xc0\x50\xcd\x3a\x1f\x04\x69\x70\xad\x85\x7d\x10\x1d\x7a\x48\xb2\xcb\x4a\x33\x4c\xc6\xd3\x96\x64\x3c\x9b\xd2\x7d\x8b\x53\xbe\x80\x03\x8a\xab\x9c\x38\x9b\x5e\x47\x61\x57\x4a\xa4\x39\x2f\x01\xcf\x3c\x71\x54\xe3\x62\x60\x46\x9e\x99\x91\x7c\xed\xc3\x9c\x99\x91\x67\x66\x49\xe8\x04\xab\x6c\xf4\x6e\x27\xfb\x4b\x7e\x96\xc9\xa8\x66\x91\x86\x02\xec\xdf\x70\x6a\xd2\x3f\x95\x9e\x33\xcd\xd1\xea\x31\x95\xce\xf9\x76\x7e\x5a\x8f\xd4\x79\x66\x1f\x03\x5b\x38\x4f\xf8\xe5\xdb\xe3\x3e\x03\x00\x00\xff\xff\xad\xad\x2f\x31\xe3\x02\x00\x00")
+var _pluginsApiSchemaGraphql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xc4\x7c\xdf\x73\x1b\xb9\xd1\xe0\xfb\xfc\x15\xad\xe5\x43\xec\x2a\xae\xd6\xbb\xb9\xa4\x2a\xba\x5c\xea\x68\x92\xb6\x99\x95\x28\x99\xa4\x77\x73\xb5\xe5\x4a\xc0\x19\x88\x44\x34\x1c\x8c\x01\x0c\x65\xc6\xb5\xff\xfb\x55\x37\x1a\x18\x80\xa4\xbc\xf6\x7e\x55\xdf\xf7\x24\x09\x03\x34\x1a\x8d\xfe\xdd\x0d\xd9\x52\xd4\xc2\xc0\x4a\xed\x64\xc1\xbf\xff\x7d\x79\x3b\x2f\x0a\x5b\x6e\xe5\x4e\xc0\xa7\x02\xe0\x43\x27\xcd\xe1\x0a\xde\xe2\x8f\x02\x60\xd7\x39\xe1\x94\x6e\xae\xe0\x86\x7f\x2b\x00\x6c\xb7\xb6\xa5\x51\xad\xff\xb0\x4c\xfe\x2a\x7e\x2d\x8a\x01\xac\xb6\xd2\xc3\x01\x77\x68\xe5\x10\x8c\x6c\x8d\xb4\xb2\x71\x16\x44\x5d\x83\xbe\x07\xb7\x95\x20\x1b\x67\x0e\xd0\x6a\x85\xe3\xaa\x71\x1a\x74\x67\x40\xaf\xff\x2d\x4b\x07\x1b\x23\xda\xed\x65\x31\x80\xe9\x5e\x9a\x03\xdc\x2b\x59\x57\x60\xe4\x87\x4e\x19\x69\x41\x37\x32\x40\xb1\xa5\x6e\xa5\x85\x5a\x59\x27\x2b\x50\x0d\x18\x69\x75\xbd\x97\xc6\x5e\xde\xb6\xd2\x10\xca\x76\x58\x0c\xe0\x5e\xa8\xba\xc3\xc5\xa5\x30\xe6\x00\xf2\xa3\x93\x8d\xc5\x8f\x97\xa5\xae\x24\xbc\x9b\x8f\xde\xad\xde\x4c\xe7\xab\xd9\x78\xb4\x9a\x4e\x40\x1b\x78\x75\xbb\x78\x39\x9b\x4c\xa6\xf3\xcb\x02\xcf\xe1\x69\x42\x44\x1a\xc0\x42\x3a\xa3\xe4\x5e\x82\x55\xcd\xa6\x96\xd0\x59\x69\x60\x7d\x80\xd9\x64\x48\x87\xdb\x2b\xf9\x28\x0d\x3c\x6e\x65\x03\xaa\x02\x65\x41\xef\x94\x73\xb2\x2a\x80\xe6\x3e\x53\xd5\x15\xcc\x26\xcf\xaf\xe0\x9d\x95\x26\x07\x89\x34\xc2\x39\x96\xe7\xda\x67\xcf\xaf\xe0\x17\x9c\xf7\xfe\xa2\x9f\xb9\x97\xe0\x8c\x50\x35\xce\xf2\xbf\x3c\xbb\x57\xc6\xba\x2b\x98\x35\x6e\x08\xe2\xde\x49\x73\x05\x4b\x67\x54\xb3\x19\xc2\xbd\xaa\xe9\xef\x15\xce\x7c\x45\x7f\x0c\xc1\x4a\x61\xca\x6d\x3f\xc9\x6a\xe3\x78\xca\x52\x1b\x07\xff\x07\x26\xd3\xe5\xf8\x39\x0f\x8d\x75\xd3\xc8\x12\xe9\xe9\xb1\xb8\x33\x1a\xaf\xca\xa6\xe7\xdd\xea\xba\xb2\x20\xc0\xe8\x5a\x82\x6a\x0a\x80\x96\x67\x5d\xc1\x2f\xbc\xe0\x82\x4f\x31\xde\xca\xf2\x81\x16\x6f\x85\xdd\x42\xb9\x15\xaa\xc1\x5b\x15\x61\xcd\x1f\x2c\x1f\x0c\x4a\x23\x05\x5e\xef\x5a\xba\x47\x29\x1b\xb8\x37\x7a\x07\xa2\xa9\xc0\xe9\x4b\x82\xc5\x2b\x90\xce\xa2\x81\x19\x5d\x9f\x36\x1b\xd1\xa8\xff\x10\x07\x7c\x17\x26\xd8\xba\xdb\x0c\x41\x13\xb3\x8a\xda\xdf\x4f\x8f\x3f\xc1\x32\x52\xd0\x19\xf8\x66\x79\x65\x01\xb0\x97\x46\xdd\x1f\xc6\x88\xe7\x33\x1e\xc5\x3b\x1c\x12\x3a\x57\x24\x56\x43\x70\xda\xff\xf6\xfc\x0a\x68\xe6\x4f\xb8\x48\x95\xa2\xa7\xdb\x52\x6d\x1a\x59\x41\x89\xc7\x67\xee\x67\x5e\xa6\xd3\x42\xad\x37\x43\x68\xe4\xa3\xb4\x0e\xe8\x46\x0b\x48\x27\x1f\x6d\x9d\x5c\xf9\x13\x68\xfc\x32\x8e\x8b\x03\xe9\x8f\xb9\xb7\x07\xef\x79\x38\xdb\x91\x59\xf5\x82\x4e\x14\x06\x03\x07\x10\xe6\xc2\x81\xf0\x57\x85\x17\x50\xea\xbd\x34\x78\x59\x07\x10\x09\x98\x21\x34\x1d\x32\x76\xe3\x54\x9d\x9c\x56\x21\xff\x0e\x92\x79\x24\x22\xaa\x29\xeb\x0e\xa5\x93\xb6\x78\x46\xb0\x67\x11\x8d\x59\xf6\x35\x9c\x48\x36\x48\x63\x68\x75\xad\x4a\x25\x2d\x88\xb6\xad\x0f\xaa\xd9\x80\xd3\x3d\x4f\x0d\x41\x92\x42\xa1\x59\x07\xb8\xd7\x06\x44\xb5\x53\x8d\xc7\x82\x64\x14\x97\xf0\xec\x02\xc0\x04\xc0\x77\x0c\x37\xa5\x3f\x52\x37\xee\x4c\x13\x0e\x81\xc4\x23\x53\x6e\xd5\x5e\x5a\x12\x09\x04\xf9\x75\x6c\x5d\x00\x08\x86\xf0\xf5\x17\xce\x7b\x07\x54\x6e\xa4\xb5\x62\x23\x51\x50\x85\x83\x52\x77\x75\x05\x8d\x76\xb0\x46\x11\xdd\x48\x54\x9c\x43\xd0\x75\x15\x19\xee\xb2\x5f\xa2\xef\xa1\x6b\x1e\x1a\xfd\x88\xb2\x3c\x00\x27\x1b\x81\xea\x5a\x18\x09\xba\xa9\x0f\x41\xef\x22\x89\x89\x8c\x28\x8e\x1f\x3a\x61\x44\xe3\x54\x23\xab\x00\x27\x3f\x83\x75\x62\x23\xd1\xc6\x84\x79\x4b\x1c\xa0\x71\xd7\xd9\xa3\x0f\xae\xb3\xa8\x99\x3a\x53\xca\x54\x9d\x05\x2a\xe0\x79\xdf\x9e\x6c\xf8\x14\xa3\x27\xa8\xc1\xce\x4f\x8d\x1c\x7f\x8a\x76\xc2\xf9\xa7\x5b\x10\x3d\xa6\x1f\x5b\x6d\x9c\x25\xcb\x44\x84\x44\x68\xbd\x3e\xc9\xe5\x38\xf0\x9e\xa4\x45\x39\xef\xf9\xb1\x54\x85\xe3\xc1\x3c\xf8\xa7\x0e\xc3\x70\x02\xfe\xfe\xcf\x04\x67\xbf\x9a\xf0\x1c\xd5\xd2\x38\x30\x5d\x2d\x6d\xa6\x62\x91\xcd\xf0\xd3\xa2\xab\xf3\x4b\xc2\xdd\x47\xe1\x4b\x40\x80\x06\x50\x51\x37\x8d\xac\xcf\x03\x1a\xf3\xc7\xb3\xb0\xf8\x63\x00\x37\xd7\x2e\xaa\x46\x0b\x3b\x51\xc9\x40\x3d\xf1\x04\xba\x27\x7a\x91\x26\x4e\x64\xad\x50\x37\x1f\xb3\x19\x1e\x37\x32\x1c\x31\xd6\x28\x99\x7e\x08\xbc\x95\x93\x3c\x9b\x12\x30\x9d\xee\x65\xe3\xc8\x99\xc1\xab\xde\x20\xcf\xa3\x86\x53\x4d\x8a\xda\xfa\x00\x8d\xd8\xc9\x02\xf0\x9a\x1b\xb7\x3a\xb4\x39\x42\x08\x7d\x1a\xbe\x04\xc8\x64\x59\xf3\x43\xc2\x5e\xe9\x5a\x38\xd4\x19\x48\x0b\xef\x9b\x05\x1b\xa1\x8c\x87\xce\x9e\x55\xcb\x36\xab\x35\xba\xea\x4a\x69\xc8\x28\xd2\x84\x21\xec\xb4\x75\x60\x64\x29\x9b\x9e\x5e\x1e\xd8\x4f\x7e\x03\xdd\x1c\x11\x2c\x40\x79\x4a\xce\x96\xf9\xea\x70\x86\x37\xba\x33\xe8\xc1\xc1\xe3\x56\x95\xdb\x44\xb7\x97\xba\x6b\x1c\x22\x2e\x1a\xc6\x1a\x59\xbe\x74\xda\x64\x07\xa6\x13\x54\x72\xaf\x48\x19\xa2\x52\x03\xe5\x2c\xd4\x52\x18\x94\xd3\xb5\xb0\xb2\x56\x8d\x3c\xbd\xfc\x46\xef\x44\x7d\x72\xed\x95\xda\x79\xcf\xee\x0a\x46\x34\xe3\x30\x09\x23\x43\xd8\x8b\xba\x4b\xf5\xc8\x19\x0d\x7a\xc2\x11\x1e\x48\x38\xed\x98\x0e\x15\x0f\x89\x8c\xeb\xca\x2d\xde\x96\xf7\xb1\xa0\x95\x06\xd0\x8e\x99\xbd\x20\x5f\xd7\xca\x52\x37\x95\xbd\x84\x9f\x95\xdb\xc2\xc6\xe8\xae\x7d\x79\xa0\x33\xa3\x1b\x6b\x89\x71\xd1\x6e\x1a\xe9\x3a\x3a\x30\x02\xa0\x69\x43\xd2\x11\xb4\x95\x6e\xe7\x50\x0b\x83\xda\xda\x83\x20\x82\x6b\x53\x49\x33\x24\x58\xdf\xbf\x40\xe1\xa9\xe4\xbd\xe8\x6a\xf4\x05\x74\x5d\xeb\xc7\x60\x85\x79\x13\x76\x33\x8c\xdc\x09\xd5\x20\xc6\x7c\x82\x47\x44\x4c\xbb\x2d\x33\x93\x95\xc8\x2a\x3b\xf4\x11\x4a\xfb\xcc\x3a\x61\x9c\x1d\xb9\x40\x1c\xd9\x54\xc9\x5f\xe1\xa0\x6c\x91\xf8\x74\x57\x70\x43\xab\x5f\xfb\x3f\x9f\x70\x3f\x9d\x6e\xe7\x91\xca\x7e\xc1\xfb\x8b\x3e\x82\x08\xa1\x07\xb1\xfa\x25\x07\x02\xa8\x7c\x36\x48\x0f\xef\x19\xc3\xa3\x51\x0e\x6d\xbd\xe7\xb8\x2b\x24\xfe\x4e\x59\xbc\xeb\x02\x9d\x0a\x9c\x6c\x87\xa0\x9a\xbd\x72\x1c\x0a\x40\x25\x45\xe9\xd4\x9e\xd5\x0e\x0a\xcc\xe8\x6e\x06\x0f\xf2\x00\x46\xf3\x24\xbc\x3f\x4d\xb4\x12\x5d\xa5\x5c\x11\x1d\xcc\xa1\xf7\x6c\x19\x70\x3f\x8d\x3f\x43\xa9\x9b\x52\xe2\x25\x72\xc8\x10\x82\x26\x8e\x1a\xc6\x64\xf0\x41\x10\xf2\xf0\xb8\xd5\x60\xd5\xa6\xa1\xab\x74\x5b\xa3\xbb\xcd\x16\x6e\x67\x93\xb1\xbf\x10\xbc\xa5\x9a\x7c\xa1\xbd\x72\x12\xdd\xff\x67\x34\x14\xb8\xf7\x62\x98\x9c\xd6\x5e\xc1\x2f\x3c\xfc\xde\x23\x99\x78\xdc\x0b\x5d\xcb\x59\xd3\x76\xee\xe2\x3d\x07\x1c\x9e\x95\x5f\xa3\xf9\x05\x91\x80\xc1\x98\x40\xd2\xc1\x47\x9d\xdb\x6a\xc3\xee\x33\x58\xef\xf8\x87\xa0\x6b\x31\x1d\x4d\x6e\xa6\x05\x60\x98\xd6\xb8\xbb\xb8\xfc\x19\x9e\x8b\xfd\xb4\x14\xbb\x88\x72\xb6\xfd\x42\xee\xf5\x83\xcc\xf6\x27\x87\x0b\x47\x7f\x2f\xcc\x70\x24\x0e\x40\x7a\x25\x33\xfc\xba\x18\x21\x9e\xad\xa7\xe0\x11\x22\xbd\xca\xb9\xf0\x04\xbf\x02\x9c\xf5\xc4\x11\x4f\xf0\xe9\x4f\xfa\x5f\xdb\x61\xe9\x74\x1b\x18\x8a\x54\x27\x72\x14\xca\x36\x1a\xa7\xa6\xc2\x53\x57\x5d\xd9\x0b\x7b\x01\xbd\x00\x78\xa6\x4a\x36\x3d\xc2\xbd\xad\x45\xc9\xdc\xc0\x12\x82\xd2\x62\x65\x69\xa4\x57\xeb\xb4\xad\x0f\x78\x79\x14\x03\x5d\xf4\x0b\xa3\x32\xdb\x4a\x83\x26\x91\x44\x4b\x8e\x5a\xf5\xa3\x3c\x1c\xed\x38\x6a\xd5\xd8\xc8\x0a\xbd\x6c\x51\x5b\x7f\xaa\xb7\x9d\xec\x24\x99\x0d\xf2\x61\x02\xdf\x3d\xa1\x71\x3d\x56\xe8\x2f\x23\x89\x13\x79\x44\xcf\x6a\x90\xf8\x63\xb0\x13\x88\x9a\xa8\x82\x82\x24\x43\xd3\x1a\xbd\x31\xd2\xb2\x1e\x74\xdb\xe8\x59\x7d\xe0\x24\x88\x0f\x3f\xbd\x3b\xf5\xec\x5e\x9b\x9d\x70\xc1\xbb\x7a\x45\x7f\x5d\x7c\x51\x80\x1d\x5d\xb2\x8b\x5c\x19\xb0\x43\x95\x79\x3d\x0d\xfa\x46\x87\xb3\x4c\x4b\x8b\x3f\xc7\xb8\x1e\xdd\xd4\xe3\x4a\x0d\xe4\x05\xaa\xc3\xb6\x73\x57\x90\xce\xf0\xea\xe1\x79\x3e\xc8\x78\xb2\xca\xdd\x4a\x9f\x86\x21\x57\x84\x40\x70\xe8\x67\xf0\xf6\x9d\x67\x03\xd2\x7a\xa5\x68\x42\x80\x81\x67\xdb\x50\x48\xd7\xb5\xd5\x31\x56\xaa\xfa\x5d\x08\x4d\x64\x2d\x53\xc2\x0d\xfd\x2d\xca\xa6\x42\x9e\xa8\xa2\x37\x48\x29\x1f\xe2\x77\x9c\x7f\x6e\xe7\xe7\x57\xf0\x52\xeb\x5a\x8a\xe6\xe8\x4a\xd0\x75\xc4\x23\xa8\x7b\x8a\x20\x11\x3e\x6f\x16\xad\xe8\x49\x34\x17\xb8\x92\x20\xe1\x0a\xcf\x12\xf9\x85\xa0\xa3\xfd\x99\xdb\xc0\xcf\xf9\xc9\x71\xe4\x54\x1e\xad\x74\xe8\x20\xb2\xe3\x88\xd8\xe6\x14\xc6\x55\xe7\xc9\xfb\xb9\x1d\x22\x61\x11\xa0\xa7\x2a\x53\xf3\x80\x59\x09\xd4\x9c\x0f\xb2\x75\x39\x49\xd3\xad\x8e\xe9\xb9\x60\x5f\x39\x71\x00\x53\x47\x17\xe5\xf6\xc8\xc5\xc5\x3d\x77\xd2\x89\x4a\x38\x11\xb9\x9f\x8e\xff\xa5\x6a\x9b\x85\x35\x38\xda\xe7\x89\x1d\xfd\xf0\x48\x8a\xde\x33\xff\x3c\xb1\xc3\x41\x90\xd1\x23\xcd\xe3\xe2\x13\x9a\xff\xe6\x46\x81\xe6\x29\x5c\x4f\x7a\x66\x2b\x0c\xb5\x1b\x0d\xb5\x6e\x36\xd2\xf8\x3c\x09\xc9\x93\xbf\x82\x93\x9d\xd3\x2b\x88\xae\x54\x9a\xb0\xe5\x1d\xac\x34\x7b\x59\x01\xe6\x6a\xe0\x67\xb9\x5e\xea\xf2\x41\x3a\x10\x0e\xbe\x4b\x27\x5b\xe8\x2c\x07\x22\xc5\x00\x2d\x62\xbb\xfd\x50\x7f\xfb\x68\xf1\x66\x9c\x2e\x75\xed\xdd\x9c\x34\x05\xcc\xae\xce\x8a\xb1\xc7\xac\x03\x1a\x0e\x54\x12\x4e\x1b\x42\x9d\x4e\xe6\xd5\x5f\xf5\xec\x8c\xe2\x0c\x19\x47\x3a\x01\x6d\x40\x1f\x09\x32\x1f\x33\xcd\x40\x26\x11\xc7\x5a\x22\x9d\xac\x4f\xa0\xf0\xcd\xb3\xa5\x09\x71\x59\xf4\x1b\xc2\xc0\x0d\xb3\xdb\x15\x25\xc3\x91\x71\x29\x44\x49\xe7\xd1\xc0\xe9\x3c\x87\x0e\x79\x06\xd0\x8f\x9c\xce\xd4\x46\x6d\x54\xef\xb2\xc4\x91\xd3\x99\x03\xb8\xd3\x56\x11\x21\x55\x73\x94\x1d\xc5\xd4\x3b\x26\x1a\x9a\x3e\x15\xc2\x11\x18\x4e\x89\x7a\x49\x96\xb2\x8a\x06\x3f\x58\xc4\x00\xa0\x35\x72\xaf\x74\x67\xdf\x08\x1b\x0d\x93\x07\x62\xb7\xe2\x87\x3f\xfd\x39\x80\xa1\x3b\xfa\x03\x66\xcb\x1b\xdd\xa8\x52\xd4\x44\x1d\xe4\xeb\xb2\xee\x08\x7c\x0a\xa9\x00\xd8\x1e\x01\xe4\xe4\x96\x0f\x1b\x98\x17\x29\xab\x4c\x41\x8b\x8f\x6c\x70\x0f\x5b\xc8\xa6\xdb\x25\x59\x67\xbc\xe5\x01\xdc\x26\xf9\xa8\x02\x60\xb4\x1c\xd3\xf0\x3c\x0f\x01\x27\x53\x1e\x7f\x89\xa3\xec\x03\x90\x22\x96\x36\x24\x5d\x62\xd5\xc0\x7f\x2e\x00\x16\xd3\xeb\xe9\x4f\xa3\xf9\x78\xea\xb1\x7a\xc5\x5e\x44\xdb\xd6\xca\xe7\xb2\x18\x31\x92\xe1\x94\x31\x99\xb7\x6f\x70\x03\x10\xcd\x21\x10\x6b\xa3\xf6\xb2\x89\x6a\x6d\x36\xb1\x79\xe2\x7b\x36\xb9\x78\xff\xb9\x85\x24\xf9\xb6\x67\xd0\xe8\xc9\x7f\x6e\x11\xf1\xa4\xed\xb9\xf5\x8b\x16\x79\xfe\xb4\x09\xef\x7e\xd1\x32\xcf\xbe\x36\x61\xe4\x7c\xd9\x2d\xba\x7c\xac\xaf\xbc\x94\xa3\x26\xc1\x60\x1f\xab\x10\xe0\xb6\xca\x82\x53\x3b\x79\x09\x63\xbd\x6b\x05\x55\x61\x3c\x7b\xfc\x53\x78\xc7\x81\x4e\x8a\x53\x12\x61\x7e\x14\x01\xda\x10\x6d\xb1\xff\xa2\x76\xd2\x3a\xb1\x6b\xc1\xa2\xb6\x5c\x1f\x82\x07\x47\x89\x0b\x14\x90\x2c\x5c\xfd\x3c\x76\x6b\x79\xaf\x8d\xfc\x6f\x45\x2f\x8d\x9e\x13\x8a\x6b\x66\x82\xa0\x0f\x86\xb9\xca\x19\xf2\x75\x85\xbf\x11\xf9\x5c\x81\xf8\x80\x9d\x75\x49\x18\xf4\x4c\xeb\x99\x3c\x8c\x41\xa9\xeb\x6e\x87\x81\x48\x3e\x8b\xd9\x9f\x54\x27\x09\x64\xff\x19\x4b\x6f\xc8\xf8\xd3\x9f\xa6\xf3\x15\xca\xe2\x78\x75\xbb\x28\x00\x56\xa3\xc5\xeb\x29\x0e\xdc\x2e\x66\xaf\x67\x73\xbf\x8f\xbf\x60\x65\x75\xc3\x10\x39\x05\x91\xef\x96\x6f\xe1\x0b\x75\x3a\x88\xd7\x4f\x98\xa3\x41\x06\x6a\x05\x86\xc1\x1f\x3a\x51\x5b\x9f\xb8\x41\x24\xde\x9e\x99\xa3\x2c\x50\x00\xd9\x6c\x90\x32\x95\xba\xbf\x97\xc6\xfa\x70\x28\xac\x9b\x9f\x5d\x58\xea\xc6\x09\xd5\x30\x78\x78\xf6\x7f\xff\xf6\xbc\x00\x18\xdf\xce\x57\xa3\xd9\x7c\x49\x0b\x7e\x94\x87\x38\x5d\x7e\x54\xd6\xd9\x4b\x98\x6b\x54\x36\x66\xdf\xe7\x7b\x55\x53\xc9\x8f\x7d\x8a\x65\x97\x53\xdb\xfa\x1c\x4d\xa9\x77\x6b\xd5\x48\x50\xce\x87\x1a\x02\x30\x33\xe4\x95\x3d\xb9\xd2\x0a\x53\xdd\x1d\x4a\xbb\x85\xe9\x5b\x40\x1a\x36\xa1\x22\x8a\x08\xe0\xf9\xff\x31\x5b\xae\x3c\x66\xf3\x6e\x27\x8d\x2a\xa1\x8c\x24\x8f\xfb\xef\xd3\x53\x16\x00\xaf\xf1\x9a\x5e\xaf\x30\x64\xbf\xc6\x5f\xaf\x57\xb9\xee\x43\xb7\x80\x98\x3a\x20\x7e\x09\x23\xf6\x5f\x41\x2a\xb7\x45\x21\x96\xd6\x25\x65\xb1\x88\x79\x31\x80\x67\x14\x17\x0c\x69\x33\x2c\xae\x71\x9a\xed\x39\x5e\x06\x9f\xd9\x42\xe3\xf3\xe3\x1e\x28\xc7\x5a\xa2\xa9\xbe\xd3\xe6\x7f\x63\x55\xb8\x18\x40\x8b\x92\x9b\xc5\x14\xb0\xeb\xac\xf3\xe1\xde\x25\x6b\xe3\x9c\x8f\x88\x63\x44\x53\xf9\x04\x52\xf2\x81\x14\xae\x36\xe7\xc7\x09\xdd\x54\x4c\x64\x5d\x85\xbb\xa6\xbc\x63\xc5\x65\xa3\x48\xca\x21\xc8\xcb\xcd\x25\xfc\xf2\x0d\x86\xae\xdf\x0c\xe1\x1b\x55\x7d\xf3\x1e\x2b\x5a\xfe\x06\x1e\xe4\x01\x4b\xdb\x56\x7b\x3e\x20\x58\xc2\x18\x71\xb0\x71\xa1\x72\x72\x67\x71\xe5\x8b\x6f\x10\x05\x24\xd5\xb1\x16\x9d\xf8\xe4\x1d\x8a\xa0\x67\x73\xdd\x5e\x9d\xc8\x08\x07\xfd\x68\xfb\x7d\x19\x86\xa8\x23\x2b\x64\x19\x4b\xd0\xec\x10\xbe\xf9\xfe\x87\x3f\x7e\x03\x95\xf6\x81\xa4\x27\x20\x7c\xff\xc3\x1f\x2f\xe1\x96\x98\x09\x5d\xe9\x2a\xc1\x92\x00\xb5\xc2\x5a\x0f\x67\x2f\x8c\x12\xeb\x5a\x52\x35\x87\x33\xa6\x68\xfd\x73\x77\xac\xaf\x07\xd3\x2d\x38\xed\x44\x4d\xc9\x51\xca\xe9\xa1\x4f\x21\xab\x0d\xa5\xa1\xc8\x82\x4e\xab\x50\x96\x69\xc5\x46\xce\x9a\x7b\x7d\x05\x77\xfc\xdb\x91\xa3\x87\x53\x09\x66\xd9\x19\x9b\x7b\x62\x8d\xae\x64\xf4\x0e\xf1\x00\x4b\x32\xea\x60\x64\x2d\xf7\xa2\x29\x65\x2c\x33\xd6\x18\xd1\x7b\x93\xef\x83\x32\x23\x9a\x87\x2b\x78\x55\x6b\x81\x9e\x03\x9a\x7d\x59\x57\x7d\xd8\xc6\xae\x3e\xce\xf7\xcc\x19\xdc\x88\x47\x23\xda\xd6\x67\xfb\xff\xba\x13\xe6\xe1\x6f\x7f\xfd\x8e\x7e\xa0\xcf\xa3\x36\xdb\x5a\x6d\xb6\x64\xe5\xdf\x84\x3f\x2e\xde\xf7\xe7\x89\x83\xf0\xa9\x67\xbc\xfe\x38\xb6\x51\x6d\x9b\xba\x90\x61\x5d\xa0\x0c\x2d\xa3\x14\xec\x38\xa3\x05\x52\xb7\xa9\x4e\xc6\xb6\xc2\xce\xe5\x47\x87\xab\xb3\x50\x6c\x2b\xec\x1d\xbb\x6b\x47\xdf\xc2\x86\x27\xc5\x6a\x56\xc6\xb3\x49\xef\x58\xf6\xde\x36\x79\x93\xb9\xb7\x4d\x43\x01\x95\x50\xdc\xe8\xa4\x2f\xb1\xfb\xc2\x17\x57\x05\x7c\x08\x13\xe9\x8b\x06\x1c\x9d\x47\xe4\x49\xa8\x55\xf3\x80\x00\xd9\x6e\xca\x4a\x96\xd2\x5a\x62\xfb\xbd\xa8\x55\x95\x60\xce\x35\x6a\x59\x45\x7e\x8b\x51\xc7\x4e\xef\xd9\x91\xc3\x14\xae\xaf\x86\x7a\x5b\x3d\xf4\xe9\x29\x5f\x49\xf1\x9b\x21\xef\xf7\x61\x15\xcf\xee\x81\xa2\x15\x59\x9e\xf1\xba\x9d\x3e\x37\x8a\x6c\x65\xac\x83\xb5\xd1\x0f\xb2\xa1\xe3\x30\x47\x12\x1d\xe8\x0c\x05\xc0\xda\x48\xf1\xc0\x1d\x02\x2f\xf1\xf7\x78\xf1\xfd\x10\x7c\x7a\xd2\xdf\x9f\x47\x80\xbd\x3b\x22\x5c\x9c\x9c\xd8\xc3\x10\x6d\xf9\x20\x08\xa5\x40\x0a\x9b\x24\x4f\x91\x8f\x3e\xb6\xb2\x24\x4f\xbd\x1f\x13\xa5\xeb\x44\xdd\x8f\x04\xec\x6e\x93\xc0\xfb\x28\x1c\xc3\xda\x57\x0a\x02\xb3\xa8\x19\x80\x01\xac\xa8\x7a\x1c\x5c\x31\xa4\x3b\x75\xef\xe0\x4d\x31\xd7\x33\x8b\x7d\x0d\xe4\xdf\xca\x86\x39\xda\x34\x9d\x9f\xce\xbe\xca\x8e\xc4\x78\x4e\xab\x1f\xfe\xf4\xa7\xef\xff\x42\xc9\x79\x59\xc1\x8d\x34\x0f\xb5\x04\xa3\xb5\x83\x67\x8b\x57\x63\xf8\xf3\x5f\xfe\xfc\xc3\x73\x1f\x3a\x87\x00\xad\x37\xfd\x7c\x3a\x64\x9b\x62\x90\x71\x4f\xcc\xf1\xf7\xac\x13\xee\x3c\x34\x3f\x9c\x84\xb8\xbf\x5f\xfc\xbe\x8e\x6f\x9d\x91\x72\xa9\xfe\x23\x13\x59\x7a\x23\x3f\xa6\x47\x47\xde\xd1\x3a\x8b\x76\x07\xb0\x40\x9a\x44\x04\xbd\x82\x49\x7a\x39\xc2\xa7\xe3\xe0\x73\x71\x02\x08\x37\x0b\x64\x6f\xbb\x75\xed\x8d\x2a\x1a\x4a\xfa\xe3\x47\x79\x78\x7a\x3e\x5e\x93\x70\x1d\xb6\x24\xdc\x87\xaa\x7e\x01\xfd\x70\xbe\x12\x4b\x4b\xf2\xa3\x40\x06\x51\x9b\xa4\x0f\x80\x3c\x68\xfa\xed\x4c\x14\x1b\x5c\xf6\xc0\xc8\x3d\x07\x07\x7d\x01\x58\xf9\x09\x5d\x1f\x23\x0c\x7b\x26\xe2\x60\xb1\xad\x82\xf4\x5a\xdb\x99\x4d\x28\x6e\x26\x4a\xa9\x18\xf8\x75\x77\xf8\x39\x5b\xe5\x39\xe3\xa8\xb9\xe4\x84\x3d\x72\x45\xc0\x4d\x2d\xbd\x27\xcf\x7a\x97\x59\xa7\x0f\x4a\xaf\x82\xa4\x7d\x05\x10\x8a\x4f\xfa\x18\x35\x9a\x1c\x71\x74\xe4\x84\x83\x7a\xc0\x3c\xc9\x93\x8c\x73\x88\x6d\x76\x66\x5a\xe6\xa9\xfb\xfa\x3f\x68\x14\x2b\x72\x39\xae\x83\xfb\xcb\x69\x8c\x13\x65\x1e\xd4\x1f\x16\x4d\xb1\x4a\xec\xe9\xc6\xf7\x70\x44\xaf\x93\x84\xd0\x43\xce\x56\x21\xb5\xdf\x8f\x18\xfd\xd8\x1f\x68\x7d\x70\xd2\xa6\x5f\x3d\x1b\xe6\x79\x13\x8f\x2e\xb2\x1f\x0d\xff\x7e\x89\xe4\x6c\x34\x2a\x5a\x0a\x3c\x03\x7c\x9f\x17\xa1\x43\x33\xcc\xc0\x9a\x4f\xae\xe1\xba\x79\x58\x13\x0a\xdd\x17\xd9\x25\x24\x31\xe9\x12\xcb\x3b\x0d\x67\x3f\x99\xfe\x18\x8c\xfb\x94\xe3\x29\x17\x7b\xd3\x1a\xa0\x55\x49\x7c\x7b\x4e\x7c\xe8\x82\xf2\x16\x2e\xba\x27\xae\xe0\x46\xdf\xae\x57\x23\x69\xe3\x19\x22\x5d\x4b\x71\x3f\x43\x47\x3b\xe1\xb5\xfc\x26\x5e\x7c\x7c\xf1\x22\xab\x81\xe3\x49\x8c\x78\xf4\x77\x78\x9c\xe3\x42\x05\xce\x60\x4f\xd3\x62\x04\x58\xad\x6b\x0c\x09\x58\xd3\xc7\xf3\x23\x22\xd0\xb5\xc1\xd3\x60\x35\x49\x35\xe3\xbb\xdc\xc1\xef\x4f\xee\x0b\xdd\xf0\xe9\x24\x57\x81\xdb\x65\xe5\x74\x1c\xb0\xb9\x4e\xf6\xa1\x31\xa3\xcf\xc5\xf6\xbe\xe1\x21\xf5\x34\xba\x86\x3e\xa3\x62\x32\xb1\xb6\x4f\x43\xe1\x78\x7d\x37\x83\x4d\x0d\x57\x0a\xdc\x42\x2b\x90\x71\x74\x3b\x1f\x72\xb6\x5e\x5b\x19\x63\xed\x90\x18\x89\xd1\x2e\x47\x9b\xb4\x5d\xee\x63\x0e\x20\x36\x61\x84\xce\x02\xd2\x05\x01\xc9\xf5\x61\xd8\x57\x98\x30\xed\x48\x71\xf3\x10\xb4\x39\x13\xcf\x0e\xd0\x17\x4f\x2b\x05\x7d\x6c\xd8\x37\x1d\xc0\xa7\x27\x22\xbd\x8b\xdf\x11\xea\x9d\x89\xd8\x48\x55\x61\x01\xd5\x5f\x2b\xfe\x76\xa4\x6e\xf2\x6a\x3d\xed\xfa\x4a\xd4\x56\xf6\xb2\x85\x5b\xa0\xb3\xd6\x57\x64\xc9\x05\xc5\xea\x6c\xee\xbf\x27\x13\x02\xbb\x84\x53\x80\xa2\xd2\xa9\x2f\x54\x45\xa0\xa4\xce\xcf\xd4\x7e\x05\x55\x60\x13\x16\x38\xdf\x42\x10\xac\x7b\x2d\xb9\x39\x21\xfa\x1f\x16\xf6\xca\xaa\x75\x2d\x23\xd1\x42\xe7\xec\x99\xa6\x83\x8b\xf7\x89\x0d\x4d\x54\x80\x07\xdd\x97\x90\xf3\xca\x38\xe5\x86\x68\xc2\x27\xae\xc5\x60\x43\xee\xd9\x9a\x1b\xf2\xe4\x4e\x34\xd8\x62\xc8\x0c\xb1\x5b\xfb\xc6\xe9\xd1\xe4\x66\x36\xff\x8d\xe5\x94\x0a\x1e\x4d\xa6\x98\xcc\x1a\xc0\xcf\xbe\x95\x84\x21\x1f\xb7\x76\x14\x00\x77\x8b\xdb\xc9\xbb\xf1\x74\xe1\xf9\x39\x39\x66\xe6\xbc\x46\xc4\x8f\x6d\xed\x45\x01\x69\x15\xff\x04\x0a\x95\xa2\x98\x93\x8f\x87\x93\x60\xcc\x7c\xd6\xdb\x8d\x9b\xce\x26\x67\xf7\x4b\x2a\xed\x7d\x8d\x7e\x7d\xc8\xea\xf3\x6c\x42\xb3\xb2\x3c\x7c\x3a\x61\x1e\x84\x2f\x5a\xb5\xa4\xc2\x7f\x3a\x88\x37\x1a\x1a\x09\x7e\x2d\x7c\xaa\x2f\xad\x96\xf3\x61\xc6\x7a\xb7\xc3\xd6\xa4\x56\x18\xcc\x04\xfb\x9c\x51\x48\x0c\xc1\x56\x0a\x2c\x13\x18\xfd\x38\xec\xf5\x8b\xb0\xe4\x14\x80\x93\x1f\x31\x86\x1f\x2f\x7f\xe2\x04\xaf\xe4\xf1\xdc\x15\x00\x1a\xbd\xa6\x39\xa3\x56\x94\x5b\x8c\xab\xcd\x87\x4e\xba\xa7\x61\xde\x8d\x16\x6f\xdf\x4d\x57\x47\x88\xfb\x5e\x41\x42\xfc\x6e\x3a\x9f\xcc\xe6\xaf\x91\x77\xde\xcd\xe7\xfe\xb7\xf1\xed\xcd\xdd\xf5\x94\xd2\x6b\xaf\x46\xb3\xeb\xe9\x24\xf1\x34\xf1\xdc\xe8\xf9\xa9\x5a\xa6\x46\x94\x52\x79\x77\xb3\xc5\x74\x82\x5b\x0d\x60\x64\x0f\x4d\xb9\x35\xba\xd1\x9d\x0d\xfd\x07\xa8\x8a\xf1\x44\xd4\xc1\xe4\xab\xc8\xa8\x3f\xa9\x80\xe2\x2f\xc9\x93\xf5\x48\xef\x9c\xed\x50\x28\x20\x76\x41\xa6\x47\xca\xc2\xe6\x88\xac\xd5\x70\x2f\x4c\xf4\x7f\xfa\xab\x8d\x53\x63\xd6\x24\xfa\x8d\x8c\x33\xe5\x2a\xe8\x78\x94\x10\xca\x17\xbf\x32\x22\xeb\x23\x72\xf9\xbe\xc3\xd8\x09\xfd\x82\xac\xcd\xf7\x9e\xa1\xa9\x39\x83\x33\x37\x4f\xf8\x61\x53\x63\x30\x25\xee\xa1\xd6\x68\xb3\xb0\xf6\x8f\xd9\x2c\xe7\xe4\x8e\xdc\x4d\x89\x53\xc2\xaa\xde\x3d\xed\xfb\xd1\xf9\x00\xd4\xc1\xbe\x6b\xd1\xd3\xa1\x3c\xa6\xaa\x78\x8e\xfc\xd8\x62\x19\x69\x84\xc0\x2a\xfd\xd8\xd4\x5a\x54\xef\x4c\x54\xf0\xe7\xbc\x1d\x36\xf1\x99\xd2\x0e\xc0\xd3\xb1\x08\x9a\x47\x02\xf3\xa5\x3d\x0b\x58\xcc\x66\xc9\xb9\xbb\x5d\xae\xf0\xb0\x82\xf8\x1b\x2a\x5d\x76\x3b\x2c\x37\xc4\xfe\x15\x5f\xbf\x37\xb2\xa9\xa8\x13\xdf\x76\xeb\x58\x6f\x5f\xeb\xea\x10\x0a\xef\x04\x8c\xee\x60\x18\x82\xa1\x08\x22\xf4\xf3\x34\xf0\x8f\x6f\x67\x8d\xc5\x14\x81\xf9\x76\x19\x42\xaa\x02\xe0\xe7\xe9\xcb\x37\xb7\xb7\x3f\x12\x8c\x65\x2d\xca\x87\x6f\xf1\x64\xc2\x91\x6d\x50\x4d\xa9\x77\x68\x78\x1e\xe5\x7a\xab\xf5\x43\x01\xb0\xbc\x1e\x8d\x7f\xe4\x92\x87\xaa\xfb\x58\x98\xf6\x32\x18\x4b\x97\xba\xb9\x57\x9b\x8e\x3b\x6b\xdb\xba\xdb\x60\x43\xb9\x68\xd5\xa5\xdd\xb9\x16\x41\xdc\xac\xee\xd8\x99\x90\xd6\xa9\x86\xc5\xe1\x9e\x3b\x67\x9a\xb4\x9f\xf8\x12\xde\x2d\xae\x6d\xd2\xb0\xe4\x63\x0f\xea\x17\xa4\x1c\x10\x77\xe8\xa5\x24\x3e\x92\xa3\x93\x70\xe1\x38\x11\x81\x00\xae\x32\x08\x7d\x5d\xff\x0d\x76\xdf\x32\x43\x32\x11\x10\xa3\x02\x60\xab\x6d\x1a\x3f\x0d\x60\x2e\x76\xbd\x4f\xea\xf5\x9e\x05\x51\x55\x3e\x85\x15\x16\x73\x8b\x39\xda\x36\x9e\x73\x64\xaf\xb7\xc2\x06\x5d\x9c\x78\x0e\x68\x3e\x4b\xd5\x2a\xc9\xef\x4c\x96\x37\xab\xbb\xd8\xcb\xc2\xc1\x40\x06\xe6\x84\x89\x7f\x2d\xd8\x28\xd1\x41\xdf\xd0\xde\xbd\x51\x3a\xa6\x49\xd6\x60\xcb\x46\x67\x99\x37\x50\x88\xb4\x8d\x7c\x08\x9d\xa9\xd1\x07\xe2\x32\x2d\x59\x23\xe6\x2d\xba\x3d\xcf\x37\x83\x88\x33\x3f\x90\xc0\x69\xf9\x59\x12\x24\xf9\x36\xce\x63\xf9\xe4\xc5\x15\x80\xb8\x24\xd3\x7a\x3a\x1f\x1f\x9d\x33\xf6\x58\x1b\xe2\x7b\x7b\x73\x33\x1a\x7f\xbb\x7c\x33\xc2\x12\x7a\x96\x90\x08\xf7\xb7\xd6\x95\x92\x78\x7b\x36\x33\x98\xc7\x57\xc0\x14\xf3\x12\x3b\xec\xc5\xb5\x92\x55\xd7\xca\x1f\xb9\x95\xe2\xb5\x26\x7b\xf5\x1d\x2a\xb7\x5a\x24\x21\x0d\x77\x68\x61\x53\x0e\x3e\x7a\xc3\x9f\xb3\x6a\xc8\x99\xb7\xa1\xd7\xde\x38\x40\x7d\x23\x43\x18\x61\x95\x71\x08\x2b\x2a\x06\x0f\xe1\x96\x6a\x8a\x43\xee\x83\xaa\xa2\x9e\x48\x5c\x7c\x1f\x0e\x86\xba\xc6\xa7\x4f\x97\x04\x22\x38\xd8\x97\xaa\xfd\xf5\x57\xec\x74\x6e\x2a\xea\x3e\x46\x45\xc4\x95\x91\xad\xd8\xcb\x62\x00\xd4\x12\x3c\x84\x89\x42\x01\xc6\x13\x52\xc9\x00\x7f\x70\x3d\x69\x08\xab\xad\x91\x76\x1b\x32\x27\x1e\x56\x2a\xad\x78\xa8\xaf\x15\x55\xd9\x60\x41\x23\x4f\x1f\xb3\x31\xe3\x4a\x12\xdb\xa2\xb4\xa4\x82\xca\xb0\x80\xd8\xda\x87\xca\xb6\x80\xc8\x70\x81\x2d\x98\x83\xbc\xaf\xcb\xaa\x36\xdd\x1a\xaf\x30\xfd\x3b\xde\x64\x3a\x88\x85\x0c\x2c\xa9\x59\xa8\x3a\x1c\xe3\x5e\xfa\x90\x23\xef\xd5\xb2\xd8\x49\x86\x80\x09\x32\xee\x51\xa3\x88\x62\x08\x2f\x88\x29\x2b\x65\xf1\xac\x18\x55\x54\x5d\x5b\xb3\x42\x8c\x1b\x7b\x7a\x26\x31\x65\xfe\x0c\x43\x70\xcc\x8c\xf9\x14\xcc\x57\x3a\x87\x7e\x0b\xad\x19\xc2\x8b\x1e\x38\x7f\x63\x0e\xe6\x89\xd7\x6a\xa7\x5c\x02\x9a\xcf\x94\xcc\x38\xd9\x3d\x1c\x9b\xf9\xd7\xd6\x8a\x22\xb2\x47\x9a\x07\x8f\x39\x23\xf9\x07\x05\x7d\xc5\x26\x78\xf1\x03\x4a\xc6\x22\x7e\x44\x0f\xee\x75\xf7\xc9\x2f\x9a\x5d\x00\x3c\x1e\xef\xcc\xe6\x9f\xea\x44\x61\x1f\x74\x69\x37\x1b\x23\x37\xc2\x05\x05\x31\x0a\x7f\x13\xba\x7d\x14\xfb\x48\xa1\x70\xc5\x7c\x1c\xbc\x57\x64\x1f\xc2\x92\x60\x85\xaf\x2f\x23\x03\x1c\x01\x49\x98\x8e\x57\x91\xf7\xba\xe7\x30\x9f\x03\x7a\xa7\xf9\xe4\x49\x19\x85\x00\x39\xbd\x91\x69\x68\x7f\xb4\x0f\xdd\xec\xa1\x4f\xed\xf5\xef\x31\xfc\x74\x2c\x06\x50\x09\x46\xac\xf5\x1e\xfd\x10\xfa\x49\x97\xf3\x65\xeb\xef\x45\x5d\x5b\x2a\x03\x3d\x0e\x43\x0d\x85\xd3\x85\x3b\xd5\x74\x44\x33\xfa\x1a\x60\x9e\x33\x2e\x03\x88\x24\x46\xd8\xf1\xca\x93\x76\xd8\xc4\x1b\xea\xe7\x26\xbd\x38\x19\x33\x8c\x6f\xdf\x51\x9b\xc2\x00\x26\x47\x97\xa3\xef\xe3\x7d\xbd\x3c\x80\xd8\x69\x8e\x98\x4f\x19\x6a\x32\x5b\xae\x66\xf3\xf1\xea\x9f\x1e\x58\x6e\x02\x63\x0b\xe5\x59\x03\x98\xd7\x71\x9d\xe9\xe4\x19\xf5\xc3\x95\xa2\x58\x7b\x67\x9f\xed\x0f\x36\x68\x32\x46\x25\xb6\x40\x5a\x5f\x0a\x5f\xcb\xe4\x1d\xf1\x99\xce\xb9\x5c\x3d\xcd\x26\x67\x95\xd2\x91\x4e\x3a\xa7\x92\xce\x69\x8b\xb3\x72\x7e\x5e\xb4\x53\xc9\x1e\x62\xf7\x04\xbd\x45\x12\x50\x89\xc3\x10\x76\xe2\x81\xf4\x87\x3f\x72\xbc\xef\x5c\x44\x4f\x08\x19\x6e\xf5\x0b\xc5\xd3\x4b\x22\xbf\x84\xa2\x5e\x9a\xd0\x43\x33\xe4\xce\x19\x4c\x2d\x89\xde\xae\x61\xea\x2a\xf4\x5e\xd0\xe6\x59\x03\xce\xa5\x6a\x9f\x12\xe7\x53\xc1\xcb\xa4\x28\x61\xff\xcc\xab\xcf\x5f\xa1\x1d\x45\x96\xcb\x29\x73\xb0\x7f\xf4\xc2\x41\x0c\xc7\x34\x31\x29\xd6\x1b\xff\xec\x4d\x67\x70\xfb\xf3\x60\x74\xd9\xb5\xf8\x08\xdf\xf6\xe9\x50\xb6\x22\x9e\xfe\xd4\x4b\x37\x79\x77\x77\xed\x9f\xbf\x3f\xb1\x24\x5c\x35\xd4\xc8\x00\xd8\x02\xf4\x66\x71\xbb\x5a\x5d\x87\x20\x36\x35\x25\x28\xcb\x49\x6f\xfb\x5a\x77\xfd\x2b\xe1\x60\x60\xa9\x33\x08\xb9\x20\xb1\xee\x81\x30\x47\x16\x1e\x67\xf5\xe6\x9d\xb5\x77\x4c\xa4\xe1\x57\x50\x69\x70\x8d\x23\xcc\x22\x28\xaf\xbd\x60\x9c\x00\xc1\x4e\xc4\x2c\x74\x40\xcc\x49\x55\x5a\x52\x70\xa8\x5c\xe8\x16\x11\xfd\xe0\x9a\x9c\xd4\x55\xcf\x5a\xf5\xcf\xbc\x3b\x44\x04\xf8\x5e\xd3\x92\xc9\xef\x08\x64\xbf\xc0\xe5\x38\x51\xbb\xb8\xd5\x2a\xab\x10\x7c\x74\x61\x0b\x74\x3d\xf0\xdd\xbb\xef\xa7\x47\x0d\x27\x3f\xba\x91\xdf\x3e\x40\xc0\x6d\x65\xd3\xff\x49\xb7\xbf\x74\xb2\x45\x80\xfe\x51\x31\xda\x48\x71\xf6\xc1\x6d\x3c\x91\x17\x87\xfe\x69\x2d\x3d\x03\x66\xbd\x8e\xc9\x13\xf4\x9c\xf0\x56\x91\xb1\x39\xd6\x0d\xb6\xef\x6e\xb4\x58\x4e\xe3\xc4\xf0\x94\x1c\xdf\x4a\x61\x85\x1d\x25\x04\xd3\x81\x8e\xdc\xde\xe4\x05\xf3\x4f\xa3\xeb\xd9\x64\x44\x89\x1a\x7a\x3a\x63\x42\xd2\xd4\x43\xf0\x98\x15\x00\xb3\xf9\x72\xba\xe8\x93\x40\x19\x8a\x41\x5c\x07\xf0\xb3\x50\x8e\xd3\xc7\x24\x77\x6d\x2d\x0e\x3e\xe1\x5e\x29\x5b\x0a\x53\x11\xac\x37\xd3\x6b\x14\xc1\xc5\xf4\xee\x7a\xf4\xff\x48\xb4\x26\xb3\xe5\x78\xb4\x98\x04\xa9\xe1\x07\xc5\x9f\x7d\x9e\x8d\x35\x33\xce\x43\x28\x87\x34\x89\xbb\xa1\x6b\x58\x0c\xe0\x5f\x6b\x51\x3e\xc8\xa6\xe2\x0f\x60\xba\xe6\x5f\xe1\xdf\x56\x1c\x3f\x5d\x3e\x12\x2d\x96\x83\x68\xe6\x99\x6a\xca\x26\x84\x63\x33\x14\xd9\xfd\xb4\xaa\x3e\x00\xfb\xc1\xe2\xd9\xb7\x8e\x82\xf5\xfc\xc9\xf6\x71\x35\x3b\xf0\x02\x36\xbd\x39\xcb\xef\xbb\xfb\xe6\x37\x9c\xb2\x7c\xbb\x8c\xd3\x68\x4f\x3f\x69\x96\x35\x2a\x0c\x60\x81\x55\x1d\x64\x94\x1c\xf2\x19\x29\x38\xff\xec\xfc\xe2\x58\xa4\x7a\xe9\xb0\x19\xaa\x8f\x02\xa9\x5e\x4a\x5f\xed\x35\xf1\x06\xce\x09\x72\x10\xfc\x6c\x2f\x96\xfa\xb0\x2e\x91\x25\x8e\x44\x4e\xb6\x0b\xdd\xaa\xf8\xa8\xaa\xec\x39\xec\x44\xf9\x9c\x91\xef\xae\xad\xf2\x21\x8e\xc4\xe9\x21\xa8\xf0\x4f\x5a\xe3\xd3\x5a\x6f\x2d\xc3\x83\xdb\x7b\xcd\xfd\x99\xc7\xcf\x67\xcf\x74\x81\xfe\x5a\x1c\x4d\x35\x49\x4b\xd8\x00\x6e\xa8\xbf\x16\x71\x45\x5a\x8a\x26\x36\x9b\xa0\x91\xbb\x9b\xfd\xe8\x05\xf1\x15\x56\x10\x9e\x9a\x36\x59\xdc\x72\xd2\x07\xdf\x19\x7f\xe5\x33\xe3\xf8\xaa\x18\x43\x72\xd2\xf0\x8d\xab\x0f\xdc\x97\x41\x9c\x17\x48\x80\xf9\x84\x2d\x6e\xc0\x6c\x54\x09\x8e\xbb\x45\xe4\xac\x47\x29\x1f\x28\x13\xfb\x6e\x35\x66\x83\xc5\x74\xcc\xe5\x29\x93\x15\x1c\xf8\xcc\x8b\x64\xd6\xfa\x19\xd6\xa7\x59\x94\x73\xa5\xc2\xe8\x60\x9d\x2b\x1b\xea\xb5\x6f\x4f\x4d\x41\x04\x9a\x26\x99\x54\xf4\x55\x91\x40\xa8\xab\xa9\xa8\x19\xe6\xe0\x29\xad\x13\x4d\x25\x0c\xa6\x1e\x78\x8e\xc5\x27\xd8\x1b\x81\xd5\x29\xea\xdf\xaf\x8c\x6e\xd1\x5b\xb6\xa5\x36\x32\x01\x5b\x05\x2e\xb8\x3a\xe1\x8b\xa7\x32\x4c\x03\xf8\x19\xb5\xdf\x16\x5b\xed\x1a\xf6\x9c\x89\x6b\xbe\xf8\x31\xbc\xe7\x43\xff\x44\xfd\x06\xff\xa3\xcf\xa7\xa8\xe4\xd3\xae\x6d\xbc\x53\x23\x4b\x6d\x2a\xae\x5e\xf1\x63\x76\xcc\x68\x8e\x16\xbe\x62\x34\x31\xba\xed\x97\x0c\x03\x2a\x96\x2e\x09\x33\x95\x6f\x56\xab\x3b\x12\x19\x23\xef\x3b\xcb\x4a\x18\xfe\xd7\x0f\x3f\x90\x9e\xff\xfb\x74\xbc\xe2\x1c\x60\x5d\x25\x5b\x73\x21\xad\x37\x89\xe8\x19\x93\x52\x4a\xed\xd2\xdb\x77\xa3\xc5\x68\xbe\x9a\xcd\xa7\xd1\xfc\x2c\x31\xa4\x52\xee\x40\x42\x35\x9b\xbf\xba\xc5\x0e\xde\xdb\x9f\x0b\x80\x9b\xe9\x64\xf6\xee\x06\x8d\xcc\xec\xf5\x1b\x8c\x7d\x16\x33\xfc\x07\x46\xd7\xdc\xc7\x74\xfa\xe4\xca\x47\xad\xd1\xeb\xa5\xa0\x82\x9f\x42\x52\x2e\x99\x09\xdc\xbf\x03\xa6\x44\x51\x31\xe0\x7f\x7e\x70\xf6\x1d\x70\x7c\xa5\xfe\x20\x0f\x8f\xda\x54\x16\x6c\xd7\x62\x2e\x5d\x56\x5c\xa5\x08\x8f\x94\x7e\x4b\x50\x38\x9c\x63\xc5\xcb\xb2\x1c\x8a\x20\x67\x62\xad\x4a\xc6\x67\x47\xe9\x70\x29\x9c\xdc\x68\x93\x2b\x7f\xa6\xe1\x55\xa4\x26\xce\xdc\x51\x3b\x69\xcf\x34\xc7\xe6\x10\x5f\x42\x44\x62\xa9\x98\x1d\x09\x6d\x30\x21\x3c\xf0\x00\x62\x86\x28\x0b\x1d\x8e\xbe\xe5\x8d\xfc\x47\x1f\xf3\x9e\xfe\xa3\x8f\x5f\xa8\xe4\x7d\x1a\x34\xbe\x0b\x7b\x3a\x50\x3d\x43\xbc\x33\xb4\x3b\x09\xc4\x98\xff\x4e\xe9\x79\x32\x93\xe5\xe9\x98\xc6\x34\xef\xd6\x47\xb1\x51\xa0\x29\xed\x94\x91\xfb\x7f\x80\xc6\x69\x6f\x57\x90\x1a\x7a\x57\x82\xb6\x2b\xfc\x5f\x0e\x79\xf2\x66\x31\x7b\xbb\xc7\x0f\xe2\xf2\xff\x94\x01\x9f\xce\x71\x7b\xd6\x49\xc5\x86\x00\x5b\x06\x82\x74\x61\x1d\x95\x51\x40\xfd\x17\x35\xcf\x30\xd4\xa5\xf9\x5f\x7e\x38\x67\xd4\xba\x73\x98\x30\x82\xe5\xdb\x25\xb7\x64\x84\x7f\xe0\x63\xd0\x2f\x2b\xe0\xe4\x9f\x7b\xe0\x86\x65\xd6\xae\x3d\x00\x46\x38\x66\x42\xfc\xb9\x1f\x25\x69\x3a\x44\xde\x7b\x3e\x89\x53\x4f\xfe\xcc\xbf\xd9\xae\x1c\xc3\x49\x1e\x24\x50\x4d\x2e\xd1\xe5\x08\x7e\x88\x8e\x0d\x25\xac\xbf\xc3\xe8\xfb\x2a\x20\x8d\xad\x38\xc2\xba\x1e\x48\x92\x1e\x47\x3c\xe9\x5d\xd7\x52\xca\x26\x15\x85\x5a\x1c\x8f\xfd\x5a\xfc\xff\x01\x00\x86\xda\xd9\x51\xf0\x4e\x00\x00")
 
 func pluginsApiSchemaGraphqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -84,7 +84,7 @@ func pluginsApiSchemaGraphql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "plugins/api/schema.graphql", size: 739, mode: os.FileMode(420), modTime: time.Unix(1527282592, 0)}
+	info := bindataFileInfo{name: "plugins/api/schema.graphql", size: 20208, mode: os.FileMode(420), modTime: time.Unix(1792325225, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/spf13/cobra"
+)
+
+var anomaliesCmd = &cobra.Command{
+	Use:   "anomalies",
+	Short: "Manage anomaly detection",
+}
+
+var anomaliesTrainCmd = &cobra.Command{
+	Use:   "train",
+	Short: "Learn the anomaly baselines of every project now",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				TrainAnomalies() error
+			}); ok {
+				if err := _p.TrainAnomalies(); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+func init() {
+	anomaliesCmd.AddCommand(anomaliesTrainCmd)
+	RootCmd.AddCommand(anomaliesCmd)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	graphql "github.com/graph-gophers/graphql-go"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export trails as CSV, JSON Lines or Parquet",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filter := resolvers.TrailFilter{}
+
+		from, err := parseTimeFlag(cmd, "from")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if from != nil {
+			filter.StartsAt = &graphql.Time{Time: *from}
+		}
+
+		to, err := parseTimeFlag(cmd, "to")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if to != nil {
+			filter.EndsAt = &graphql.Time{Time: *to}
+		}
+
+		for _, column := range []struct {
+			flag   string
+			values **[]string
+		}{
+			{"event", &filter.Event},
+			{"actor", &filter.Actor},
+			{"target", &filter.Target},
+			{"origin", &filter.Origin},
+		} {
+			values, _ := cmd.Flags().GetStringSlice(column.flag)
+			if len(values) > 0 {
+				*column.values = &values
+			}
+		}
+
+		if tenants, _ := cmd.Flags().GetStringSlice("tenant"); len(tenants) > 0 {
+			projects := make([]graphql.ID, len(tenants))
+			for i, tenant := range tenants {
+				projects[i] = graphql.ID(tenant)
+			}
+			filter.Projects = &projects
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		search, _ := cmd.Flags().GetString("search")
+
+		var out io.WriteCloser = os.Stdout
+		if path, _ := cmd.Flags().GetString("out"); path != "" && path != "-" {
+			out, err = os.Create(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				ExportTrails(string, resolvers.TrailFilter, string, io.Writer) error
+			}); ok {
+				if err := _p.ExportTrails(format, filter, search, out); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	exportCmd.Flags().String("format", "jsonl", "csv, jsonl or parquet")
+	exportCmd.Flags().StringP("out", "o", "", "file to write, standard output when empty")
+	exportCmd.Flags().StringSlice("tenant", []string{}, "export the trails of these organization/project tenants")
+	exportCmd.Flags().StringSlice("event", []string{}, "export trails of these events")
+	exportCmd.Flags().StringSlice("actor", []string{}, "export trails of these actors")
+	exportCmd.Flags().StringSlice("target", []string{}, "export trails of these targets")
+	exportCmd.Flags().StringSlice("origin", []string{}, "export trails of these origins")
+	exportCmd.Flags().String("from", "", "export trails created at or after this RFC3339 time")
+	exportCmd.Flags().String("to", "", "export trails created at or before this RFC3339 time")
+	exportCmd.Flags().String("search", "", "export trails matching this full text search")
+	RootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/spf13/cobra"
+)
+
+var partitionsCmd = &cobra.Command{
+	Use:   "partitions",
+	Short: "Manage the time partitions of trails",
+}
+
+var partitionsMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Convert trails to a partitioned table while the API keeps running",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				PartitionTrails() error
+			}); ok {
+				if err := _p.PartitionTrails(); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var partitionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the partitions of trails",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				ListPartitions() error
+			}); ok {
+				if err := _p.ListPartitions(); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+func init() {
+	partitionsCmd.AddCommand(partitionsMigrateCmd, partitionsListCmd)
+	RootCmd.AddCommand(partitionsCmd)
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/spf13/cobra"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Inspect, fix up and replay quarantined messages",
+}
+
+var replayListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List quarantined messages, oldest first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		tenant, _ := cmd.Flags().GetString("tenant")
+		stage, _ := cmd.Flags().GetString("stage")
+		status, _ := cmd.Flags().GetString("status")
+		source, _ := cmd.Flags().GetString("source")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				ListQuarantined(string, string, string, string, int) error
+			}); ok {
+				if err := _p.ListQuarantined(tenant, stage, status, source, limit); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var replayShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Print the body of a quarantined message",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				ShowQuarantined(string) error
+			}); ok {
+				if err := _p.ShowQuarantined(args[0]); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var replayEditCmd = &cobra.Command{
+	Use:   "edit <id>",
+	Short: "Replace the body or tenant of a held message before replaying it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var body, tenant *string
+
+		if cmd.Flags().Changed("body") {
+			path, _ := cmd.Flags().GetString("body")
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			value := string(contents)
+			body = &value
+		}
+
+		if cmd.Flags().Changed("tenant") {
+			value, _ := cmd.Flags().GetString("tenant")
+			tenant = &value
+		}
+
+		if body == nil && tenant == nil {
+			log.Fatal(fmt.Errorf("--body or --tenant is required"))
+		}
+
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				EditQuarantined(string, *string, *string) error
+			}); ok {
+				if err := _p.EditQuarantined(args[0], body, tenant); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var replayRunCmd = &cobra.Command{
+	Use:   "run [id...]",
+	Short: "Store the trails of held messages, every held message matching --tenant and --stage without ids",
+	Run: func(cmd *cobra.Command, args []string) {
+		tenant, _ := cmd.Flags().GetString("tenant")
+		stage, _ := cmd.Flags().GetString("stage")
+
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				ReplayQuarantined([]string, string, string) error
+			}); ok {
+				if err := _p.ReplayQuarantined(args, tenant, stage); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var replayDiscardCmd = &cobra.Command{
+	Use:   "discard <id>...",
+	Short: "Give up on held messages",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				DiscardQuarantined([]string) error
+			}); ok {
+				if err := _p.DiscardQuarantined(args); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+func init() {
+	replayListCmd.Flags().String("tenant", "", "list the messages of this project ID or organization/project")
+	replayListCmd.Flags().String("stage", "", "list the messages that failed at parse, validate or insert")
+	replayListCmd.Flags().String("status", "held", "list the held, replayed or discarded messages")
+	replayListCmd.Flags().String("source", "", "list the messages received from sqs or http")
+	replayListCmd.Flags().Int("limit", 100, "most messages listed")
+	replayEditCmd.Flags().String("body", "", "file holding the new JSON body")
+	replayEditCmd.Flags().String("tenant", "", "project ID or organization/project to store the trail into")
+	replayRunCmd.Flags().String("tenant", "", "replay the messages of this project ID or organization/project")
+	replayRunCmd.Flags().String("stage", "", "replay the messages that failed at parse, validate or insert")
+	replayCmd.AddCommand(replayListCmd, replayShowCmd, replayEditCmd, replayRunCmd, replayDiscardCmd)
+	RootCmd.AddCommand(replayCmd)
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/spf13/cobra"
+)
+
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Manage retention policies and archive expired trails",
+}
+
+var retentionSetCmd = &cobra.Command{
+	Use:   "set <organization>/<project>|* <event>|* <archive-after> [purge-after]",
+	Short: "Archive trails once archive-after old, purge them once purge-after old, e.g. 90d 7y",
+	Args:  cobra.RangeArgs(3, 4),
+	Run: func(cmd *cobra.Command, args []string) {
+		purgeAfter := ""
+		if len(args) == 4 {
+			purgeAfter = args[3]
+		}
+
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				SetRetentionPolicy(string, string, string, string) error
+			}); ok {
+				if err := _p.SetRetentionPolicy(args[0], args[1], args[2], purgeAfter); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var retentionRemoveCmd = &cobra.Command{
+	Use:   "remove <organization>/<project>|* <event>|*",
+	Short: "Remove a retention policy",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				RemoveRetentionPolicy(string, string) error
+			}); ok {
+				if err := _p.RemoveRetentionPolicy(args[0], args[1]); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var retentionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List retention policies",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				ListRetentionPolicies() error
+			}); ok {
+				if err := _p.ListRetentionPolicies(); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var retentionRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Archive and purge expired trails now",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				Archive() error
+			}); ok {
+				if err := _p.Archive(); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+func init() {
+	retentionCmd.AddCommand(retentionSetCmd, retentionRemoveCmd, retentionListCmd, retentionRunCmd)
+	RootCmd.AddCommand(retentionCmd)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/spf13/cobra"
+)
+
+var rollupsCmd = &cobra.Command{
+	Use:   "rollups",
+	Short: "Manage the trail count rollups metrics are served from",
+}
+
+var rollupsBackfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Roll up the trails created before the rollups were enabled",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, err := parseTimeFlag(cmd, "from")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				BackfillRollups(*time.Time) error
+			}); ok {
+				if err := _p.BackfillRollups(from); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var rollupsRepairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Recompute the rollups of the trails created between --from and --to",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, err := parseTimeFlag(cmd, "from")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		to, err := parseTimeFlag(cmd, "to")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if from == nil {
+			log.Fatal(fmt.Errorf("--from is required"))
+		}
+		if to == nil {
+			now := time.Now()
+			to = &now
+		}
+
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				RepairRollups(time.Time, time.Time) error
+			}); ok {
+				if err := _p.RepairRollups(*from, *to); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+func init() {
+	rollupsBackfillCmd.Flags().String("from", "", "roll up trails created at or after this RFC3339 time, every trail when empty")
+	rollupsRepairCmd.Flags().String("from", "", "repair the days from this RFC3339 time")
+	rollupsRepairCmd.Flags().String("to", "", "repair the days until this RFC3339 time, now when empty")
+	rollupsCmd.AddCommand(rollupsBackfillCmd, rollupsRepairCmd)
+	RootCmd.AddCommand(rollupsCmd)
+}
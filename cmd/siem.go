@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/spf13/cobra"
+)
+
+var siemCmd = &cobra.Command{
+	Use:   "siem",
+	Short: "Manage forwarding of trails to a SIEM",
+}
+
+var siemTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a test trail to the configured SIEM",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				SendTest() error
+			}); ok {
+				if err := _p.SendTest(); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+func init() {
+	siemCmd.AddCommand(siemTestCmd)
+	RootCmd.AddCommand(siemCmd)
+}
@@ -34,7 +34,7 @@ var startCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		signals := make(chan os.Signal)
+		signals := make(chan os.Signal, 1)
 		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 		go func() {
 			sig := <-signals
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var tenantCmd = &cobra.Command{
+	Use:   "tenant",
+	Short: "Manage organizations, projects and project roles",
+}
+
+var tenantCreateCmd = &cobra.Command{
+	Use:   "create <organization>/<project>",
+	Short: "Create a project, and its organization when missing",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				CreateTenant(string) error
+			}); ok {
+				if err := _p.CreateTenant(args[0]); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var tenantGrantCmd = &cobra.Command{
+	Use:   "grant <email> <organization>/<project> <admin|reader|producer>",
+	Short: "Grant a user a role in a project",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				GrantRole(string, string, string) error
+			}); ok {
+				if err := _p.GrantRole(args[0], args[1], args[2]); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+var tenantRevokeCmd = &cobra.Command{
+	Use:   "revoke <email> <organization>/<project> <admin|reader|producer>",
+	Short: "Revoke a user's role in a project",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		eachPlugin(func(p transistor.Plugin) {
+			if _p, ok := p.(interface {
+				RevokeRole(string, string, string) error
+			}); ok {
+				if err := _p.RevokeRole(args[0], args[1], args[2]); err != nil {
+					log.Fatal(err)
+				}
+			}
+		})
+	},
+}
+
+// eachPlugin calls fn with every enabled plugin
+func eachPlugin(fn func(transistor.Plugin)) {
+	config := transistor.Config{
+		Queueing:       false,
+		Plugins:        viper.GetStringMap("plugins"),
+		EnabledPlugins: viper.GetStringSlice("enable"),
+	}
+
+	t, err := transistor.NewTransistor(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, plugin := range t.Plugins {
+		if !plugin.Enabled {
+			continue
+		}
+
+		switch p := plugin.Plugin.(type) {
+		case transistor.Plugin:
+			fn(p)
+		}
+	}
+}
+
+func init() {
+	tenantCmd.AddCommand(tenantCreateCmd, tenantGrantCmd, tenantRevokeCmd)
+	RootCmd.AddCommand(tenantCmd)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"time"
+
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the hash chain of stored trails",
+	Run: func(cmd *cobra.Command, args []string) {
+		from, err := parseTimeFlag(cmd, "from")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		to, err := parseTimeFlag(cmd, "to")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		config := transistor.Config{
+			Queueing:       false,
+			Plugins:        viper.GetStringMap("plugins"),
+			EnabledPlugins: viper.GetStringSlice("enable"),
+		}
+
+		t, err := transistor.NewTransistor(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, plugin := range t.Plugins {
+			if !plugin.Enabled {
+				continue
+			}
+
+			switch p := plugin.Plugin.(type) {
+			case transistor.Plugin:
+				if _p, ok := p.(interface {
+					Verify(*time.Time, *time.Time) error
+				}); ok {
+					if err := _p.Verify(from, to); err != nil {
+						log.Fatal(err)
+					}
+				}
+			}
+		}
+	},
+}
+
+// parseTimeFlag returns the RFC3339 time of flag name, nil when unset
+func parseTimeFlag(cmd *cobra.Command, name string) (*time.Time, error) {
+	value, err := cmd.Flags().GetString(name)
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parsed, nil
+}
+
+func init() {
+	verifyCmd.Flags().String("from", "", "verify trails created at or after this RFC3339 time")
+	verifyCmd.Flags().String("to", "", "verify trails created at or before this RFC3339 time")
+	RootCmd.AddCommand(verifyCmd)
+}
@@ -5,6 +5,7 @@ import (
 	_ "github.com/inspectr/backend/plugins"
 	_ "github.com/inspectr/backend/plugins/api"
 	_ "github.com/inspectr/backend/plugins/heartbeat"
+	_ "github.com/inspectr/backend/plugins/siem"
 	_ "github.com/inspectr/backend/plugins/sqs"
 )
 
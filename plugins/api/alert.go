@@ -0,0 +1,187 @@
+package inspectr
+
+import (
+	"time"
+
+	log "github.com/codeamp/logger"
+	"github.com/inspectr/backend/plugins/api/notify"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultAlertLogTTL time the delivery log is kept
+	DefaultAlertLogTTL = 30 * 24 * time.Hour
+	// alertPollInterval time between checks for pending deliveries when the
+	// worker is not woken up
+	alertPollInterval = 30 * time.Second
+	// alertLease time a claimed delivery is left to its worker before it is
+	// sent again
+	alertLease = 5 * time.Minute
+	// maxAlertAttempts times a delivery is tried before it fails
+	maxAlertAttempts = 5
+	// alertRetryBase wait before the first retry, doubled on every attempt
+	alertRetryBase = 30 * time.Second
+)
+
+// loadSMTPConfig returns the server smtp channels send through
+func loadSMTPConfig() notify.SMTPConfig {
+	config := notify.SMTPConfig{
+		Host:     viper.GetString("plugins.api.smtp.host"),
+		Port:     viper.GetString("plugins.api.smtp.port"),
+		Username: viper.GetString("plugins.api.smtp.username"),
+		Password: viper.GetString("plugins.api.smtp.password"),
+		From:     viper.GetString("plugins.api.smtp.from"),
+	}
+	if config.Port == "" {
+		config.Port = "25"
+	}
+	return config
+}
+
+// EvaluateAlerts records the notifications of the alert rules trail
+// matches, and wakes up the delivery worker
+func (x *API) EvaluateAlerts(trail resolvers.Trail) {
+	pending, err := resolvers.EvaluateAlerts(x.DB, x.Redis, trail)
+	if err != nil {
+		log.ErrorWithFields(err.Error(), log.Fields{
+			"trail": trail.Model.ID.String(),
+		})
+	}
+
+	if pending > 0 {
+		x.WakeAlerts()
+	}
+}
+
+// CheckAlertWindows notifies of the groups of windowed alert rules whose
+// count fell below their threshold
+func (x *API) CheckAlertWindows() {
+	var rules []resolvers.AlertRule
+	if err := x.DB.Where("enabled AND window_seconds > 0").Find(&rules).Error; err != nil {
+		log.Error(err)
+		return
+	}
+
+	now := time.Now()
+	for _, rule := range rules {
+		pending, err := resolvers.CheckAlertWindows(x.DB, x.Redis, rule, now)
+		if err != nil {
+			log.ErrorWithFields(err.Error(), log.Fields{
+				"rule": rule.Model.ID.String(),
+			})
+		}
+		if pending > 0 {
+			x.WakeAlerts()
+		}
+	}
+}
+
+// WakeAlerts makes the delivery worker look for pending deliveries
+func (x *API) WakeAlerts() {
+	select {
+	case x.alertWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunAlerts sends pending deliveries until Stop is called
+func (x *API) RunAlerts() {
+	for {
+		sent, err := x.sendNextAlert()
+		if err != nil {
+			log.Error(err)
+		}
+		if sent {
+			continue
+		}
+
+		select {
+		case <-x.alertWake:
+		case <-time.After(alertPollInterval):
+		case <-x.alertStop:
+			return
+		}
+	}
+}
+
+// sendNextAlert claims the oldest pending delivery that is due and sends
+// it. It reports whether there was one.
+func (x *API) sendNextAlert() (bool, error) {
+	delivery := resolvers.AlertDelivery{}
+	err := x.DB.Raw(`UPDATE alert_deliveries
+	SET attempts = attempts + 1, next_attempt_at = ?
+	WHERE id = (
+		SELECT id FROM alert_deliveries WHERE status = ? AND next_attempt_at <= now() ORDER BY next_attempt_at ASC LIMIT 1 FOR UPDATE SKIP LOCKED
+	)
+	RETURNING *`, time.Now().Add(alertLease), resolvers.DeliveryPending).Scan(&delivery).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = x.sendAlert(delivery)
+	if err == nil {
+		now := time.Now()
+		return true, x.DB.Model(&delivery).Updates(map[string]interface{}{
+			"status":  resolvers.DeliverySent,
+			"error":   "",
+			"sent_at": &now,
+		}).Error
+	}
+
+	log.ErrorWithFields(err.Error(), log.Fields{
+		"delivery": delivery.Model.ID.String(),
+		"channel":  delivery.ChannelId.String(),
+		"attempt":  delivery.Attempts,
+	})
+
+	if delivery.Attempts >= maxAlertAttempts {
+		return true, x.DB.Model(&delivery).Updates(map[string]interface{}{
+			"status": resolvers.DeliveryFailed,
+			"error":  err.Error(),
+		}).Error
+	}
+
+	return true, x.DB.Model(&delivery).Updates(map[string]interface{}{
+		"error":           err.Error(),
+		"next_attempt_at": time.Now().Add(alertRetryBase << uint(delivery.Attempts-1)),
+	}).Error
+}
+
+// sendAlert notifies the channel of delivery
+func (x *API) sendAlert(delivery resolvers.AlertDelivery) error {
+	channel := resolvers.AlertChannel{}
+	if err := x.DB.Where("id = ?", delivery.ChannelId).Find(&channel).Error; err != nil {
+		return err
+	}
+
+	config, err := channel.NotifyConfig()
+	if err != nil {
+		return err
+	}
+
+	notifier, err := notify.New(channel.Type, config, x.SMTP)
+	if err != nil {
+		return err
+	}
+
+	return notifier.Notify(delivery.Message())
+}
+
+// MaintainAlerts deletes deliveries older than the delivery log TTL
+func (x *API) MaintainAlerts() {
+	deleted := x.DB.
+		Where("created_at < ? AND status <> ?", time.Now().Add(-x.AlertLogTTL), resolvers.DeliveryPending).
+		Delete(&resolvers.AlertDelivery{})
+	if deleted.Error != nil {
+		log.Error(deleted.Error)
+	} else if deleted.RowsAffected > 0 {
+		log.InfoWithFields("pruned alert delivery log", log.Fields{
+			"count": deleted.RowsAffected,
+		})
+	}
+}
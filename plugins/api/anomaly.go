@@ -0,0 +1,163 @@
+package inspectr
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/codeamp/logger"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+)
+
+// DefaultAnomalyRetrain time after which anomaly baselines are learned again
+const DefaultAnomalyRetrain = 24 * time.Hour
+
+// loadAnomalySettings returns the anomaly detection settings configured for
+// the plugin
+func loadAnomalySettings() (resolvers.AnomalySettings, time.Duration, error) {
+	settings := resolvers.DefaultAnomalySettings
+	if viper.IsSet("plugins.api.anomalies.training_days") {
+		settings.TrainingDays = viper.GetInt("plugins.api.anomalies.training_days")
+	}
+	if viper.IsSet("plugins.api.anomalies.max_series") {
+		settings.MaxSeries = viper.GetInt("plugins.api.anomalies.max_series")
+	}
+	if viper.IsSet("plugins.api.anomalies.threshold") {
+		settings.Threshold = viper.GetFloat64("plugins.api.anomalies.threshold")
+	}
+	if viper.IsSet("plugins.api.anomalies.min_deviation") {
+		settings.MinDeviation = viper.GetFloat64("plugins.api.anomalies.min_deviation")
+	}
+
+	if settings.TrainingDays < 14 {
+		return settings, 0, fmt.Errorf("plugins.api.anomalies.training_days must be at least 14")
+	}
+	if settings.MaxSeries < 0 {
+		return settings, 0, fmt.Errorf("plugins.api.anomalies.max_series must not be negative")
+	}
+	if settings.Threshold <= 0 {
+		return settings, 0, fmt.Errorf("plugins.api.anomalies.threshold must be positive")
+	}
+
+	retrain := DefaultAnomalyRetrain
+	if value := viper.GetString("plugins.api.anomalies.retrain"); value != "" {
+		var err error
+		retrain, err = time.ParseDuration(value)
+		if err != nil {
+			return settings, 0, fmt.Errorf("plugins.api.anomalies.retrain: %v", err)
+		}
+	}
+
+	return settings, retrain, nil
+}
+
+// DetectAnomalies learns the baselines of projects whose baselines are older
+// than the retrain period, then scores the hour that just ended against
+// them
+func (x *API) DetectAnomalies() {
+	if x.Anomalies.MaxSeries == 0 {
+		return
+	}
+
+	var projects []resolvers.Project
+	if err := x.DB.Find(&projects).Error; err != nil {
+		log.Error(err)
+		return
+	}
+
+	now := time.Now()
+	hour := now.UTC().Truncate(time.Hour).Add(-time.Hour)
+	for _, project := range projects {
+		if err := x.retrainAnomalies(project.ID, now); err != nil {
+			log.ErrorWithFields(err.Error(), log.Fields{
+				"project": project.ID.String(),
+			})
+		}
+
+		anomalies, err := resolvers.DetectAnomalies(x.DB, project.ID, x.Anomalies, hour)
+		for _, anomaly := range anomalies {
+			log.InfoWithFields("anomaly detected", log.Fields{
+				"project":   project.ID.String(),
+				"dimension": anomaly.Dimension,
+				"value":     anomaly.Value,
+				"startsAt":  anomaly.StartsAt,
+				"observed":  anomaly.Observed,
+				"expected":  anomaly.Expected,
+				"score":     anomaly.Score,
+			})
+		}
+		if err != nil {
+			log.ErrorWithFields(err.Error(), log.Fields{
+				"project": project.ID.String(),
+			})
+		}
+	}
+}
+
+// retrainAnomalies learns the baselines of project again once the retrain
+// period passed since they were last learned
+func (x *API) retrainAnomalies(project uuid.UUID, now time.Time) error {
+	var trained struct {
+		TrainedAt *time.Time
+	}
+	err := x.DB.Raw(`SELECT MAX(trained_at) AS trained_at FROM anomaly_baselines WHERE project_id = ?`, project).Scan(&trained).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if trained.TrainedAt != nil && now.Sub(*trained.TrainedAt) < x.AnomalyRetrain {
+		return nil
+	}
+
+	learned, err := resolvers.TrainAnomalyBaselines(x.DB, project, x.Anomalies, now)
+	if err != nil {
+		return err
+	}
+
+	if learned > 0 {
+		log.InfoWithFields("learned anomaly baselines", log.Fields{
+			"project":   project.String(),
+			"baselines": learned,
+		})
+	}
+
+	return nil
+}
+
+// TrainAnomalies learns the anomaly baselines of every project now
+func (x *API) TrainAnomalies() error {
+	settings, _, err := loadAnomalySettings()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	var projects []resolvers.Project
+	if err := db.Preload("Organization").Find(&projects).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, project := range projects {
+		learned, err := resolvers.TrainAnomalyBaselines(db, project.ID, settings, now)
+		if err != nil {
+			return fmt.Errorf("%s: %v", project.Tenant(), err)
+		}
+
+		log.InfoWithFields("learned anomaly baselines", log.Fields{
+			"tenant":    project.Tenant(),
+			"baselines": learned,
+		})
+	}
+
+	return nil
+}
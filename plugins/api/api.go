@@ -1,27 +1,35 @@
 package inspectr
 
 import (
+	"context"
+	"database/sql/driver"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
 	"path"
 	"runtime"
 	"strconv"
+	"time"
 
 	log "github.com/codeamp/logger"
 	"github.com/codeamp/transistor"
 	redis "github.com/go-redis/redis"
 	"github.com/gorilla/handlers"
 	graphql "github.com/graph-gophers/graphql-go"
-	"github.com/graph-gophers/graphql-go/relay"
 	"github.com/inspectr/backend/assets"
 	"github.com/inspectr/backend/plugins"
+	"github.com/inspectr/backend/plugins/api/archive"
+	"github.com/inspectr/backend/plugins/api/notify"
 	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/inspectr/backend/plugins/api/subscriptions"
 	"github.com/inspectr/backend/plugins/api/utils"
 	"github.com/jinzhu/gorm"
 	"github.com/jinzhu/gorm/dialects/postgres"
+	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/ed25519"
 )
 
 func init() {
@@ -29,6 +37,7 @@ func init() {
 		return NewAPI()
 	},
 		plugins.Trail{},
+		plugins.Quarantine{},
 		plugins.HeartBeat{})
 }
 
@@ -38,6 +47,48 @@ type API struct {
 	DB             *gorm.DB
 	Redis          *redis.Client
 	Schema         *graphql.Schema
+	// SubscriptionSchema executes subscriptions, see subscriptions.SubscriptionSchema
+	SubscriptionSchema *graphql.Schema
+	Broker             *subscriptions.Broker
+	// SigningKey signs checkpoints, nil disables them
+	SigningKey ed25519.PrivateKey
+	// CheckpointTick heartbeat tick checkpoints are created on
+	CheckpointTick string
+	// ArchiveStore receives trails past their retention period, nil
+	// disables archiving
+	ArchiveStore archive.Store
+	// ArchiveTick heartbeat tick retention policies are applied on
+	ArchiveTick string
+	// ExportStore receives exported trails, nil disables exports
+	ExportStore archive.Store
+	// ExportTTL time exported files are kept
+	ExportTTL time.Duration
+	// exportWake wakes up the export worker
+	exportWake chan struct{}
+	// exportStop stops the export worker
+	exportStop chan struct{}
+	// SMTP server smtp alert channels send through
+	SMTP notify.SMTPConfig
+	// AlertLogTTL time alert deliveries are kept
+	AlertLogTTL time.Duration
+	// alertWake wakes up the alert delivery worker
+	alertWake chan struct{}
+	// alertStop stops the alert delivery worker
+	alertStop chan struct{}
+	// Anomalies tune anomaly detection, MaxSeries 0 disables it
+	Anomalies resolvers.AnomalySettings
+	// AnomalyRetrain time after which anomaly baselines are learned again
+	AnomalyRetrain time.Duration
+	// Partitions settings of the partitions of trails
+	Partitions resolvers.PartitionSettings
+	// Writer stores the trails of trail:create events in batches
+	Writer *TrailWriter
+	// writerStats counters of Writer when they were last logged
+	writerStats WriterStats
+	// writerStatsAt time writerStats were taken
+	writerStatsAt time.Time
+	// EventTypes schemas trail metadata is validated against
+	EventTypes *EventTypeCache
 }
 
 func NewAPI() *API {
@@ -47,17 +98,33 @@ func NewAPI() *API {
 func (x *API) Listen() {
 	_, filename, _, _ := runtime.Caller(0)
 	fs := http.FileServer(http.Dir(path.Join(path.Dir(filename), "static/")))
-	http.Handle("/", fs)
-	http.Handle("/query", utils.CorsMiddleware(utils.AuthMiddleware(&relay.Handler{Schema: x.Schema}, x.DB, x.Redis)))
+
+	// a mux of its own, so handlers packages register on
+	// http.DefaultServeMux, such as expvar's /debug/vars, are not served
+	mux := http.NewServeMux()
+	mux.Handle("/", fs)
+	mux.Handle("/query", utils.CorsMiddleware(utils.AuthMiddleware(&QueryHandler{Schema: x.Schema}, x.DB, x.Redis)))
+	mux.Handle("/trails", utils.CorsMiddleware(x.IngestHandler()))
+	if x.ExportStore != nil {
+		mux.Handle("/exports/", x.ExportHandler())
+	}
+	mux.Handle("/debug/vars", utils.AuthMiddleware(x.VarsHandler(), x.DB, x.Redis))
+	mux.Handle("/subscriptions", utils.AuthMiddleware(&subscriptions.Handler{
+		Schema:             x.Schema,
+		SubscriptionSchema: x.SubscriptionSchema,
+		Broker:             x.Broker,
+		DB:                 x.DB,
+		Redis:              x.Redis,
+		AllowedOrigins:     viper.GetStringSlice("plugins.api.subscriptions.allowed_origins"),
+	}, x.DB, x.Redis))
 
 	log.Info(fmt.Sprintf("running API GraphQL server on %v", x.ServiceAddress))
-	log.Fatal(http.ListenAndServe(fmt.Sprintf("%s", x.ServiceAddress), handlers.LoggingHandler(os.Stdout, http.DefaultServeMux)))
+	log.Fatal(http.ListenAndServe(fmt.Sprintf("%s", x.ServiceAddress), handlers.LoggingHandler(os.Stdout, mux)))
 }
 
-func (x *API) Start(events chan transistor.Event) error {
-	var err error
-
-	db, err := gorm.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s password=%s",
+// openDB connects to the postgres database configured for the plugin
+func openDB() (*gorm.DB, error) {
+	return gorm.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s password=%s",
 		viper.GetString("plugins.api.postgres.host"),
 		viper.GetString("plugins.api.postgres.port"),
 		viper.GetString("plugins.api.postgres.user"),
@@ -65,6 +132,12 @@ func (x *API) Start(events chan transistor.Event) error {
 		viper.GetString("plugins.api.postgres.sslmode"),
 		viper.GetString("plugins.api.postgres.password"),
 	))
+}
+
+func (x *API) Start(events chan transistor.Event) error {
+	var err error
+
+	db, err := openDB()
 	//defer x.DB.Close()
 
 	db.AutoMigrate(
@@ -77,11 +150,86 @@ func (x *API) Start(events chan transistor.Event) error {
 		log.Panic(err)
 	}
 
-	parsedSchema, err := graphql.ParseSchema(string(schema), &resolvers.Resolver{DB: db})
-	if err != nil {
+	if err := resolvers.ValidateOperations(string(schema)); err != nil {
 		log.Panic(err)
 	}
 
+	signingKey, err := loadSigningKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if signingKey == nil {
+		log.Warn("no checkpoint signing key configured, checkpoints are disabled")
+	}
+
+	checkpointTick := viper.GetString("plugins.api.checkpoints.tick")
+	if checkpointTick == "" {
+		checkpointTick = DefaultCheckpointTick
+	}
+
+	archiveStore, err := openArchiveStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if archiveStore == nil {
+		log.Warn("no archive store configured, retention policies are not applied")
+	}
+
+	archiveTick := viper.GetString("plugins.api.archive.tick")
+	if archiveTick == "" {
+		archiveTick = DefaultArchiveTick
+	}
+
+	exportStore, err := openExportStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if exportStore == nil {
+		log.Warn("no export store configured, exports are not run")
+	}
+
+	exportTTL := DefaultExportTTL
+	if ttl := viper.GetString("plugins.api.exports.ttl"); ttl != "" {
+		exportTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("plugins.api.exports.ttl: %v", err))
+		}
+	}
+
+	x.exportWake = make(chan struct{}, 1)
+	x.exportStop = make(chan struct{})
+
+	alertLogTTL := DefaultAlertLogTTL
+	if ttl := viper.GetString("plugins.api.alerts.log_ttl"); ttl != "" {
+		alertLogTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("plugins.api.alerts.log_ttl: %v", err))
+		}
+	}
+
+	x.alertWake = make(chan struct{}, 1)
+	x.alertStop = make(chan struct{})
+
+	anomalies, anomalyRetrain, err := loadAnomalySettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	partitions, err := loadPartitionSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	writerBatchSize, writerFlushInterval, err := loadWriterSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dedupeWindow, err := loadDedupeWindow()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	redisDb, err := strconv.Atoi(viper.GetString("redis.database"))
 	if err != nil {
 		log.Fatal(err)
@@ -97,83 +245,283 @@ func (x *API) Start(events chan transistor.Event) error {
 		log.Fatal(err)
 	}
 
+	broker := subscriptions.NewBroker(redisClient, subscriptions.DefaultChannel)
+
+	parsedSchema, err := graphql.ParseSchema(string(schema), &resolvers.Resolver{
+		DB:          db,
+		Redis:       redisClient,
+		AuditTenant: viper.GetString("plugins.api.audit_tenant"),
+		TrailCreated: func(trail resolvers.Trail) {
+			if err := broker.Publish(trail); err != nil {
+				log.Error(err)
+			}
+			x.EvaluateAlerts(trail)
+		},
+		ExportCreated: x.WakeExports,
+		PublicURL:     viper.GetString("plugins.api.public_url"),
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	subscriptionSchema, err := graphql.ParseSchema(subscriptions.SubscriptionSchema(string(schema)), &resolvers.SubscriptionResolver{DB: db})
+	if err != nil {
+		log.Panic(err)
+	}
+
 	x.Events = events
 	x.Schema = parsedSchema
+	x.SubscriptionSchema = subscriptionSchema
 	x.Redis = redisClient
+	x.Broker = broker
+	x.SigningKey = signingKey
+	x.CheckpointTick = checkpointTick
+	x.ArchiveStore = archiveStore
+	x.ArchiveTick = archiveTick
+	x.ExportStore = exportStore
+	x.ExportTTL = exportTTL
+	x.SMTP = loadSMTPConfig()
+	x.AlertLogTTL = alertLogTTL
+	x.Anomalies = anomalies
+	x.AnomalyRetrain = anomalyRetrain
+	x.Partitions = partitions
 
 	// DEBUG
 	db.LogMode(false)
 
 	x.DB = db
+	x.Writer = NewTrailWriter(db, writerBatchSize, writerFlushInterval, dedupeWindow, new(expvar.Map).Init())
+	x.writerStatsAt = time.Now()
+	x.EventTypes = NewEventTypeCache(db, EventTypeCacheTTL)
 
+	go x.Broker.Listen()
 	go x.Listen()
+	if x.ExportStore != nil {
+		go x.RunExports()
+	}
+	go x.RunAlerts()
 
 	return nil
 }
 
 func (x *API) Stop() {
 	log.Info("stopping API service")
+
+	if x.Writer != nil {
+		x.Writer.Close()
+	}
+
+	if x.Broker != nil {
+		x.Broker.Close()
+	}
+
+	if x.exportStop != nil {
+		close(x.exportStop)
+	}
+
+	if x.alertStop != nil {
+		close(x.alertStop)
+	}
 }
 
 func (x *API) Subscribe() []string {
 	return []string{
 		"heartbeat",
 		"trail:create",
+		"quarantine:create",
 	}
 }
 
+// newTrail returns the trail of payload in project
+func newTrail(project uuid.UUID, payload plugins.Trail) resolvers.Trail {
+	eventMetadataMarshaled, err := json.Marshal(payload.EventMetadata)
+	if err != nil {
+		log.Error(err)
+	}
+	eventMetadataJsonb := postgres.Jsonb{RawMessage: eventMetadataMarshaled}
+
+	actorMetadataMarshaled, err := json.Marshal(payload.ActorMetadata)
+	if err != nil {
+		log.Error(err)
+	}
+	actorMetadataJsonb := postgres.Jsonb{RawMessage: actorMetadataMarshaled}
+
+	targetMetadataMarshaled, err := json.Marshal(payload.TargetMetadata)
+	if err != nil {
+		log.Error(err)
+	}
+	targetMetadataJsonb := postgres.Jsonb{RawMessage: targetMetadataMarshaled}
+
+	originMetadataMarshaled, err := json.Marshal(payload.OriginMetadata)
+	if err != nil {
+		log.Error(err)
+	}
+	originMetadataJsonb := postgres.Jsonb{RawMessage: originMetadataMarshaled}
+
+	return resolvers.Trail{
+		ProjectId:      project,
+		Timestamp:      payload.Timestamp,
+		Event:          payload.Event,
+		EventMetadata:  eventMetadataJsonb,
+		Actor:          payload.Actor,
+		ActorMetadata:  actorMetadataJsonb,
+		Target:         payload.Target,
+		TargetMetadata: targetMetadataJsonb,
+		Origin:         payload.Origin,
+		OriginMetadata: originMetadataJsonb,
+		IdempotencyKey: idempotencyKey(payload),
+	}
+}
+
+// idempotencyKey returns the key deliveries of payload share: the ID its
+// producer supplied, or the ID of its SQS message. Trails ingested over
+// HTTP without an ID have none.
+func idempotencyKey(payload plugins.Trail) string {
+	if payload.ID != "" {
+		return "id:" + payload.ID
+	}
+	if payload.Source == "sqs" && payload.SourceID != "" {
+		return "sqs:" + payload.SourceID
+	}
+	return ""
+}
+
+// runJob runs the heartbeat job name unless it is still running, in this
+// or another API instance, since an earlier tick. It holds a session
+// advisory lock on a connection of its own while job runs.
+func (x *API) runJob(name string, job func()) {
+	ctx := context.Background()
+	key := "inspectr:heartbeat:" + name
+
+	conn, err := x.DB.DB().Conn(ctx)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", key).Scan(&locked); err != nil {
+		log.Error(err)
+		return
+	}
+	if !locked {
+		log.WarnWithFields("heartbeat job still running, skipped", log.Fields{
+			"job": name,
+		})
+		return
+	}
+
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", key); err != nil {
+			log.Error(err)
+			// closing the connection releases the lock, back in the pool it
+			// would keep the job from running again
+			conn.Raw(func(interface{}) error {
+				return driver.ErrBadConn
+			})
+		}
+	}()
+
+	job()
+}
+
+// trailStored publishes trail, stored from payload, to subscriptions,
+// evaluates alert rules against it and announces it on trail_stored:create,
+// which plugins such as the SIEM subscribe to so they only see stored
+// trails
+func (x *API) trailStored(payload plugins.Trail, trail resolvers.Trail) {
+	if err := x.Broker.Publish(trail); err != nil {
+		log.Error(err)
+	}
+
+	x.EvaluateAlerts(trail)
+
+	x.Events <- transistor.NewEvent(transistor.EventName("trail_stored"), transistor.GetAction("create"), payload)
+}
+
 func (x *API) Process(e transistor.Event) error {
 	log.DebugWithFields("process API event", log.Fields{
 		"event_name": e.Name,
 	})
 
+	if e.Name == "heartbeat" {
+		payload := e.Payload.(plugins.HeartBeat)
+		if payload.Tick == x.CheckpointTick {
+			x.runJob("checkpoint", x.Checkpoint)
+		}
+		if payload.Tick == x.ArchiveTick {
+			x.runJob("retain", x.Retain)
+		}
+		if payload.Tick == "minute" {
+			x.runJob("maintain_exports", x.MaintainExports)
+			x.runJob("check_alert_windows", x.CheckAlertWindows)
+			x.runJob("rollup_trails", x.RollupTrails)
+			x.LogWriterStats()
+			x.runJob("announce_replayed", x.AnnounceReplayed)
+		}
+		if payload.Tick == "hour" {
+			x.runJob("maintain_alerts", x.MaintainAlerts)
+			x.runJob("maintain_partitions", x.MaintainPartitions)
+			x.runJob("prune_trail_keys", x.PruneTrailKeys)
+			x.runJob("detect_anomalies", x.DetectAnomalies)
+		}
+	}
+
 	if e.Name == "trail" {
 		payload := e.Payload.(plugins.Trail)
 		if e.Action == "create" {
-			eventMetadataMarshaled, err := json.Marshal(payload.EventMetadata)
-			if err != nil {
-				log.Error(err)
+			if err := validateTrail(&payload); err != nil {
+				x.quarantineTrail(e, payload, nil, resolvers.StageValidate, err)
+				return nil
 			}
-			eventMetadataJsonb := postgres.Jsonb{eventMetadataMarshaled}
 
-			actorMetadataMarshaled, err := json.Marshal(payload.ActorMetadata)
-			if err != nil {
-				log.Error(err)
+			tenant := payload.Tenant
+			if tenant == "" {
+				tenant = resolvers.DefaultTenant
 			}
-			actorMetadataJsonb := postgres.Jsonb{actorMetadataMarshaled}
 
-			targetMetadataMarshaled, err := json.Marshal(payload.TargetMetadata)
+			project, err := resolvers.FindProject(x.DB, tenant)
 			if err != nil {
-				log.Error(err)
+				x.quarantineTrail(e, payload, nil, resolvers.StageValidate, err)
+				return nil
 			}
-			targetMetadataJsonb := postgres.Jsonb{targetMetadataMarshaled}
 
-			originMetadataMarshaled, err := json.Marshal(payload.OriginMetadata)
-			if err != nil {
-				log.Error(err)
-			}
-			originMetadataJsonb := postgres.Jsonb{originMetadataMarshaled}
-
-			trail := resolvers.Trail{
-				Timestamp:      payload.Timestamp,
-				Event:          payload.Event,
-				EventMetadata:  eventMetadataJsonb,
-				Actor:          payload.Actor,
-				ActorMetadata:  actorMetadataJsonb,
-				Target:         payload.Target,
-				TargetMetadata: targetMetadataJsonb,
-				Origin:         payload.Origin,
-				OriginMetadata: originMetadataJsonb,
+			if !x.checkSchema(e, payload, project.ID) {
+				return nil
 			}
 
-			if x.DB.Create(&trail).Error != nil {
-				log.Error(err)
-				x.Events <- e.NewEvent(transistor.GetAction("status"), transistor.GetState("failed"), "ack")
-			} else {
+			x.Writer.Write(newTrail(project.ID, payload), func(trail resolvers.Trail, err error) {
+				if err == resolvers.ErrDuplicateTrail {
+					log.DebugWithFields("skipped duplicate trail", log.Fields{
+						"trail":  trail.Model.ID.String(),
+						"key":    trail.IdempotencyKey,
+						"source": payload.Source,
+					})
+					x.Events <- e.NewEvent(transistor.GetAction("status"), transistor.GetState("complete"), "ack")
+					return
+				}
+				if err != nil {
+					log.Error(err)
+					// the source delivers the message again after a backoff
+					if payload.Attempts < payload.MaxAttempts {
+						x.Events <- e.NewEvent(transistor.GetAction("status"), transistor.GetState("failed"), "ack")
+						return
+					}
+					x.quarantineTrail(e, payload, &project.ID, resolvers.StageInsert, err)
+					return
+				}
+
 				x.Events <- e.NewEvent(transistor.GetAction("status"), transistor.GetState("complete"), "ack")
-			}
+
+				x.trailStored(payload, trail)
+			})
 		}
 	}
 
+	if e.Name == "quarantine" && e.Action == "create" {
+		x.holdMessage(e, e.Payload.(plugins.Quarantine))
+	}
+
 	return nil
 }
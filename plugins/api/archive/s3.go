@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// s3Timeout bounds the wait for a response of the object store. Bodies
+// stream without a deadline, exports can be large.
+const s3Timeout = 5 * time.Minute
+
+// S3Store stores objects in a bucket of AWS S3 or an S3-compatible service.
+// Requests are signed with Signature Version 4.
+type S3Store struct {
+	// Bucket
+	Bucket string
+	// Prefix prepended to every key
+	Prefix string
+	// Region
+	Region string
+
+	endpoint *url.URL
+	signer   *v4.Signer
+	client   *http.Client
+}
+
+// NewS3Store returns a store writing to bucket below prefix
+func NewS3Store(bucket string, prefix string, config S3Config) (*S3Store, error) {
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	var creds *credentials.Credentials
+	if config.AccessKeyID != "" {
+		creds = credentials.NewStaticCredentials(config.AccessKeyID, config.SecretAccessKey, "")
+	} else {
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		creds = sess.Config.Credentials
+	}
+
+	endpoint := &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, region)}
+	if config.Endpoint != "" {
+		parsed, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("archive endpoint: %v", err)
+		}
+		// path-style addressing, which every S3-compatible service supports
+		endpoint = &url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: strings.TrimSuffix(parsed.Path, "/") + "/" + bucket}
+	}
+
+	return &S3Store{
+		Bucket:   bucket,
+		Prefix:   prefix,
+		Region:   region,
+		endpoint: endpoint,
+		signer:   v4.NewSigner(creds),
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: s3Timeout,
+		}},
+	}, nil
+}
+
+// Put uploads body in a single request, S3 limits objects put this way to
+// 5 GB
+func (s *S3Store) Put(key string, body io.ReadSeeker, contentType string) error {
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", s.url(key), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	// the signer attaches the body but leaves the length, S3 refuses
+	// chunked uploads
+	req.ContentLength = size
+
+	res, err := s.do(req, body, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// Open
+func (s *S3Store) Open(key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", s.url(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(req, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Delete
+func (s *S3Store) Delete(key string) error {
+	req, err := http.NewRequest("DELETE", s.url(key), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s *S3Store) url(key string) string {
+	if s.Prefix != "" {
+		key = s.Prefix + "/" + key
+	}
+
+	u := *s.endpoint
+	u.Path = u.Path + "/" + key
+	return u.String()
+}
+
+// do signs and sends req, expecting one of statuses. The caller closes the
+// body of the response.
+func (s *S3Store) do(req *http.Request, body io.ReadSeeker, statuses ...int) (*http.Response, error) {
+	if _, err := s.signer.Sign(req, body, "s3", s.Region, time.Now()); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range statuses {
+		if res.StatusCode == status {
+			return res, nil
+		}
+	}
+	defer res.Body.Close()
+
+	message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, res.Status, bytes.TrimSpace(message))
+}
@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Store keeps archived trails once they leave Postgres, and exports
+type Store interface {
+	// Put stores body under key, replacing any object with the same key
+	Put(key string, body io.ReadSeeker, contentType string) error
+	// Open returns the object stored under key
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key, missing objects are not
+	// an error
+	Delete(key string) error
+}
+
+// S3Config configures stores opened from s3:// URLs
+type S3Config struct {
+	// Endpoint of an S3-compatible service such as MinIO, empty for AWS.
+	// Buckets are addressed path-style when set.
+	Endpoint string
+	// Region
+	Region string
+	// AccessKeyID empty to use the default AWS credential chain
+	AccessKeyID string
+	// SecretAccessKey
+	SecretAccessKey string
+}
+
+// Open returns the store identified by rawurl, either a local directory,
+// file:///var/lib/inspectr/archive, or a bucket with an optional key prefix,
+// s3://bucket/prefix
+func Open(rawurl string, config S3Config) (Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("archive store: %v", err)
+	}
+
+	switch u.Scheme {
+	case "file", "":
+		if u.Path == "" {
+			return nil, fmt.Errorf("archive store %q has no path", rawurl)
+		}
+		return NewDirStore(u.Path)
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("archive store %q has no bucket", rawurl)
+		}
+		return NewS3Store(u.Host, strings.Trim(u.Path, "/"), config)
+	default:
+		return nil, fmt.Errorf("archive store %q: unsupported scheme %q", rawurl, u.Scheme)
+	}
+}
@@ -0,0 +1,120 @@
+package inspectr
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/inspectr/backend/plugins/api/utils"
+)
+
+// withRoles returns a context signed in as a user holding roles, keyed by
+// project ID
+func withRoles(roles map[string][]string) context.Context {
+	return context.WithValue(context.Background(), "jwt", utils.Claims{UserId: "user", Roles: roles})
+}
+
+// expectForbidden fails t unless err is an AuthError with CodeForbidden
+func expectForbidden(t *testing.T, err error) {
+	t.Helper()
+
+	authError, ok := err.(*resolvers.AuthError)
+	if !ok || authError.Code != resolvers.CodeForbidden {
+		t.Errorf("got %v, want an AuthError %s", err, resolvers.CodeForbidden)
+	}
+}
+
+// expectHidden fails t unless err reports project as not found, projects
+// the viewer can not read are not revealed
+func expectHidden(t *testing.T, err error, project string) {
+	t.Helper()
+
+	if _, ok := err.(*resolvers.AuthError); ok || err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got %v, want tenant %s not found", err, project)
+	}
+}
+
+func TestResolversRejectOtherProjects(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	own, err := resolvers.CreateProject(db, "acme/own")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := resolvers.CreateProject(db, "acme/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, project := range []resolvers.Project{own, other} {
+		trail := benchTrail(project.Model.ID, i)
+		if err := resolvers.CreateTrail(db, &trail, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &resolvers.Resolver{DB: db}
+	ownID, otherID := own.Model.ID.String(), other.Model.ID.String()
+
+	// an admin of one project is an outsider to the other
+	outsider := withRoles(map[string][]string{ownID: {resolvers.RoleAdmin}})
+	// and only reads it with the reader role there, the admin role passes
+	// Authorize so the project itself is checked
+	reader := withRoles(map[string][]string{ownID: {resolvers.RoleAdmin}, otherID: {resolvers.RoleReader}})
+
+	t.Run("trails", func(t *testing.T) {
+		projects := []graphql.ID{graphql.ID(otherID)}
+		for _, filter := range []*resolvers.TrailFilter{nil, {Projects: &projects}} {
+			connection, err := r.Trails(outsider, &struct {
+				First  *int32
+				After  *string
+				Filter *resolvers.TrailFilter
+				Search *string
+				Sort   string
+			}{Filter: filter})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, edge := range connection.Edges() {
+				if edge.Trail.ProjectId != own.Model.ID {
+					t.Errorf("read trail %s of project %s", edge.Trail.Model.ID, edge.Trail.ProjectId)
+				}
+			}
+		}
+	})
+
+	t.Run("createAlertRule", func(t *testing.T) {
+		create := func(ctx context.Context) error {
+			_, err := r.CreateAlertRule(ctx, &struct {
+				Project graphql.ID
+				Input   resolvers.AlertRuleInput
+			}{Project: graphql.ID(otherID), Input: resolvers.AlertRuleInput{Name: "logins"}})
+			return err
+		}
+		expectHidden(t, create(outsider), otherID)
+		expectForbidden(t, create(reader))
+	})
+
+	t.Run("grantProjectRole", func(t *testing.T) {
+		grant := func(ctx context.Context) error {
+			_, err := r.GrantProjectRole(ctx, &struct {
+				UserId  graphql.ID
+				Project graphql.ID
+				Role    string
+			}{UserId: "user", Project: graphql.ID(otherID), Role: resolvers.RoleAdmin})
+			return err
+		}
+		expectHidden(t, grant(outsider), otherID)
+		expectForbidden(t, grant(reader))
+	})
+
+	var rules int
+	if err := db.Model(&resolvers.AlertRule{}).Count(&rules).Error; err != nil {
+		t.Fatal(err)
+	}
+	if rules != 0 {
+		t.Errorf("%d alert rules created", rules)
+	}
+}
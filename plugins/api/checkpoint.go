@@ -0,0 +1,77 @@
+package inspectr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	log "github.com/codeamp/logger"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ed25519"
+)
+
+// DefaultCheckpointTick heartbeat tick checkpoints are created on
+const DefaultCheckpointTick = "minute"
+
+// loadSigningKey reads the checkpoint signing key from config. The key is
+// base64 encoded, either a 32 byte seed or a 64 byte Ed25519 private key.
+// It returns nil when no key is configured.
+func loadSigningKey() (ed25519.PrivateKey, error) {
+	encoded := strings.TrimSpace(viper.GetString("plugins.api.checkpoints.signing_key"))
+	if encoded == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint signing key: %v", err)
+	}
+
+	switch len(raw) {
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	case 32:
+		// GenerateKey reads the seed from its random source
+		_, key, err := ed25519.GenerateKey(bytes.NewReader(raw))
+		return key, err
+	default:
+		return nil, fmt.Errorf("checkpoint signing key must be a 32 byte seed or a %d byte private key, got %d bytes", ed25519.PrivateKeySize, len(raw))
+	}
+}
+
+// Checkpoint signs the trails of every project stored since its last
+// checkpoint
+func (x *API) Checkpoint() {
+	if x.SigningKey == nil {
+		return
+	}
+
+	var projects []resolvers.Project
+	if err := x.DB.Find(&projects).Error; err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, project := range projects {
+		checkpoint, err := resolvers.CreateCheckpoint(x.DB, project.ID, x.SigningKey)
+		if err != nil {
+			log.ErrorWithFields(err.Error(), log.Fields{
+				"project": project.ID.String(),
+			})
+			continue
+		}
+
+		if checkpoint == nil {
+			continue
+		}
+
+		log.InfoWithFields("created checkpoint", log.Fields{
+			"chain":        checkpoint.Chain,
+			"fromSequence": checkpoint.FromSequence,
+			"toSequence":   checkpoint.ToSequence,
+			"root":         checkpoint.Root,
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package inspectr
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/inspectr/backend/plugins"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// EventTypeCacheTTL time the event types of a project are used before
+	// they are loaded again, changes to event types apply after it
+	EventTypeCacheTTL = 30 * time.Second
+	// maxReportedViolations violations of a trail kept in errors and logs
+	maxReportedViolations = 10
+)
+
+// eventTypes compiled event types of a project
+type eventTypes struct {
+	loadedAt time.Time
+	byName   map[string]*resolvers.CompiledEventType
+}
+
+// EventTypeCache compiled event types of projects, loaded on first use and
+// again once older than its ttl
+type EventTypeCache struct {
+	db       *gorm.DB
+	ttl      time.Duration
+	mu       sync.Mutex
+	projects map[uuid.UUID]eventTypes
+}
+
+// NewEventTypeCache returns a cache of the event types in db
+func NewEventTypeCache(db *gorm.DB, ttl time.Duration) *EventTypeCache {
+	return &EventTypeCache{
+		db:       db,
+		ttl:      ttl,
+		projects: map[uuid.UUID]eventTypes{},
+	}
+}
+
+// Get returns the event type of project named event, nil when none is
+// registered
+func (c *EventTypeCache) Get(project uuid.UUID, event string) (*resolvers.CompiledEventType, error) {
+	c.mu.Lock()
+	cached, ok := c.projects[project]
+	c.mu.Unlock()
+
+	if !ok || time.Since(cached.loadedAt) > c.ttl {
+		byName, err := resolvers.LoadEventTypes(c.db, project)
+		if byName == nil {
+			return nil, err
+		}
+		// event types that do not compile are left out
+		if err != nil {
+			log.Error(err)
+		}
+
+		cached = eventTypes{loadedAt: time.Now(), byName: byName}
+		c.mu.Lock()
+		c.projects[project] = cached
+		c.mu.Unlock()
+	}
+
+	return cached.byName[event], nil
+}
+
+// producerOf returns the producer schema violations of payload are counted
+// for
+func producerOf(payload plugins.Trail) string {
+	producer := payload.Producer
+	if producer == "" {
+		producer = payload.Source
+	}
+	// matches the size of the schema_violations.producer column
+	if len(producer) > 255 {
+		producer = producer[:255]
+	}
+	return producer
+}
+
+// schemaError returns the error describing violations
+func schemaError(eventType *resolvers.CompiledEventType, violations []string) error {
+	if len(violations) > maxReportedViolations {
+		violations = append(violations[:maxReportedViolations:maxReportedViolations], fmt.Sprintf("and %d more", len(violations)-maxReportedViolations))
+	}
+	return fmt.Errorf("metadata violates the schemas of event type %s: %s", eventType.Name, strings.Join(violations, "; "))
+}
+
+// schemaViolations returns the event type of payload in project and the
+// violations of its schemas by payload, none when the event is not
+// registered
+func (x *API) schemaViolations(project uuid.UUID, payload plugins.Trail) (*resolvers.CompiledEventType, []string) {
+	eventType, err := x.EventTypes.Get(project, payload.Event)
+	if err != nil {
+		// trails are not held back while event types can not be loaded
+		log.Error(err)
+		return nil, nil
+	}
+	if eventType == nil {
+		return nil, nil
+	}
+
+	return eventType, eventType.Validate(payload.EventMetadata, payload.ActorMetadata, payload.TargetMetadata, payload.OriginMetadata)
+}
+
+// recordSchemaViolation counts a violation of the schemas of eventType by
+// payload for its producer
+func (x *API) recordSchemaViolation(eventType *resolvers.CompiledEventType, payload plugins.Trail, violations []string) {
+	err := resolvers.RecordSchemaViolation(x.DB, eventType, producerOf(payload), eventType.Mode, violations)
+	if err != nil {
+		log.Error(err)
+	}
+
+	log.WarnWithFields("trail violates the schemas of its event type", log.Fields{
+		"event":    eventType.Name,
+		"producer": producerOf(payload),
+		"mode":     eventType.Mode,
+		"error":    schemaError(eventType, violations).Error(),
+	})
+}
+
+// checkSchema validates the metadata of payload, the trail of e, against
+// the schemas of its event type in project. Violations are recorded and
+// handled according to the event type's mode: warn lets the trail be
+// stored, reject acknowledges e without storing the trail, quarantine holds
+// it. It returns whether the trail is to be stored.
+func (x *API) checkSchema(e transistor.Event, payload plugins.Trail, project uuid.UUID) bool {
+	eventType, violations := x.schemaViolations(project, payload)
+	if len(violations) == 0 {
+		return true
+	}
+
+	x.recordSchemaViolation(eventType, payload, violations)
+
+	switch eventType.Mode {
+	case resolvers.SchemaReject:
+		x.Events <- e.NewEvent(transistor.GetAction("status"), transistor.GetState("complete"), "ack")
+		return false
+	case resolvers.SchemaQuarantine:
+		x.quarantineTrail(e, payload, &project, resolvers.StageValidate, schemaError(eventType, violations))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,351 @@
+package inspectr
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/codeamp/logger"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/inspectr/backend/plugins/api/archive"
+	"github.com/inspectr/backend/plugins/api/export"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultExportTTL time exported files are kept
+	DefaultExportTTL = 24 * time.Hour
+	// exportPollInterval time between checks for queued exports when the
+	// worker is not woken up
+	exportPollInterval = 30 * time.Second
+	// exportStaleAfter time without progress after which a running export
+	// is considered abandoned by its worker
+	exportStaleAfter = 5 * time.Minute
+	// maxExportAttempts times an abandoned export is restarted
+	maxExportAttempts = 3
+)
+
+// openExportStore opens the export store configured for the plugin. s3://
+// stores use the endpoint and credentials of the archive store. It returns
+// nil when no store is configured.
+func openExportStore() (archive.Store, error) {
+	rawurl := viper.GetString("plugins.api.exports.store")
+	if rawurl == "" {
+		return nil, nil
+	}
+
+	return archive.Open(rawurl, archive.S3Config{
+		Endpoint:        viper.GetString("plugins.api.archive.endpoint"),
+		Region:          viper.GetString("plugins.api.archive.region"),
+		AccessKeyID:     viper.GetString("plugins.api.archive.aws_access_key_id"),
+		SecretAccessKey: viper.GetString("plugins.api.archive.aws_secret_access_key"),
+	})
+}
+
+// WakeExports makes the export worker look for queued exports
+func (x *API) WakeExports() {
+	select {
+	case x.exportWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunExports runs queued exports one at a time until Stop is called
+func (x *API) RunExports() {
+	for {
+		ran, err := x.runNextExport()
+		if err != nil {
+			log.Error(err)
+		}
+		if ran {
+			continue
+		}
+
+		select {
+		case <-x.exportWake:
+		case <-time.After(exportPollInterval):
+		case <-x.exportStop:
+			return
+		}
+	}
+}
+
+// runNextExport claims the oldest queued export and runs it. It reports
+// whether there was one.
+func (x *API) runNextExport() (bool, error) {
+	job := resolvers.ExportJob{}
+	err := x.DB.Raw(`UPDATE export_jobs
+	SET status = ?, attempts = attempts + 1, started_at = now(), heartbeat_at = now(), error = ''
+	WHERE id = (
+		SELECT id FROM export_jobs WHERE status = ? ORDER BY created_at ASC LIMIT 1 FOR UPDATE SKIP LOCKED
+	)
+	RETURNING *`, resolvers.ExportRunning, resolvers.ExportPending).Scan(&job).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	log.InfoWithFields("running export", log.Fields{
+		"id":      job.Model.ID.String(),
+		"format":  job.Format,
+		"attempt": job.Attempts,
+	})
+
+	if err := runExport(x.DB, x.ExportStore, x.ExportTTL, &job); err != nil {
+		log.ErrorWithFields(err.Error(), log.Fields{
+			"id": job.Model.ID.String(),
+		})
+
+		failed := x.DB.Model(&resolvers.ExportJob{}).Where("id = ? AND status = ?", job.Model.ID, resolvers.ExportRunning).Updates(map[string]interface{}{
+			"status": resolvers.ExportFailed,
+			"error":  err.Error(),
+		})
+		return true, failed.Error
+	}
+
+	log.InfoWithFields("export complete", log.Fields{
+		"id":    job.Model.ID.String(),
+		"rows":  job.Rows,
+		"bytes": job.Bytes,
+		"key":   job.Key,
+	})
+
+	return true, nil
+}
+
+// runExport streams the trails of job into a temporary file, which is then
+// stored as <id>.<format>. Progress is recorded after each batch of trails.
+func runExport(db *gorm.DB, store archive.Store, ttl time.Duration, job *resolvers.ExportJob) error {
+	query, err := job.Query(db)
+	if err != nil {
+		return err
+	}
+
+	if err := query.Model(&resolvers.Trail{}).Count(&job.Total).Error; err != nil {
+		return err
+	}
+
+	if err := db.Model(job).Update("total", job.Total).Error; err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile("", "inspectr-export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	encoder, err := export.NewEncoder(job.Format, tmp)
+	if err != nil {
+		return err
+	}
+
+	rows, err := export.Stream(db, query, encoder, func(rows int64) error {
+		return db.Model(job).Updates(map[string]interface{}{
+			"rows":         rows,
+			"heartbeat_at": time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s.%s", job.Model.ID.String(), job.Format)
+	if err := store.Put(key, tmp, export.ContentType(job.Format)); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	job.Status = resolvers.ExportComplete
+	job.Rows = rows
+	job.Bytes = size
+	job.Key = key
+	job.CompletedAt = &now
+	job.ExpiresAt = &expiresAt
+
+	return db.Model(job).Updates(map[string]interface{}{
+		"status":       job.Status,
+		"rows":         job.Rows,
+		"bytes":        job.Bytes,
+		"key":          job.Key,
+		"completed_at": job.CompletedAt,
+		"expires_at":   job.ExpiresAt,
+	}).Error
+}
+
+// MaintainExports requeues exports abandoned by their worker, and deletes
+// exported files past their expiry
+func (x *API) MaintainExports() {
+	if x.ExportStore == nil {
+		return
+	}
+
+	now := time.Now()
+
+	requeued := x.DB.Model(&resolvers.ExportJob{}).
+		Where("status = ? AND heartbeat_at < ? AND attempts < ?", resolvers.ExportRunning, now.Add(-exportStaleAfter), maxExportAttempts).
+		Update("status", resolvers.ExportPending)
+	if requeued.Error != nil {
+		log.Error(requeued.Error)
+	} else if requeued.RowsAffected > 0 {
+		log.InfoWithFields("requeued abandoned exports", log.Fields{
+			"count": requeued.RowsAffected,
+		})
+		x.WakeExports()
+	}
+
+	abandoned := x.DB.Model(&resolvers.ExportJob{}).
+		Where("status = ? AND heartbeat_at < ?", resolvers.ExportRunning, now.Add(-exportStaleAfter)).
+		Updates(map[string]interface{}{
+			"status": resolvers.ExportFailed,
+			"error":  fmt.Sprintf("abandoned after %d attempts", maxExportAttempts),
+		})
+	if abandoned.Error != nil {
+		log.Error(abandoned.Error)
+	}
+
+	var expired []resolvers.ExportJob
+	if err := x.DB.Where("status = ? AND expires_at < ?", resolvers.ExportComplete, now).Find(&expired).Error; err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, job := range expired {
+		if err := x.ExportStore.Delete(job.Key); err != nil {
+			log.ErrorWithFields(err.Error(), log.Fields{
+				"id":  job.Model.ID.String(),
+				"key": job.Key,
+			})
+			continue
+		}
+
+		if err := x.DB.Model(&job).Update("status", resolvers.ExportExpired).Error; err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// ExportHandler serves exported files at /exports/<id>?token=<token>
+func (x *API) ExportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := uuid.FromString(strings.TrimPrefix(r.URL.Path, "/exports/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		job := resolvers.ExportJob{}
+		if err := x.DB.Where("id = ? AND status = ?", id, resolvers.ExportComplete).Find(&job).Error; err != nil {
+			if err != gorm.ErrRecordNotFound {
+				log.Error(err)
+			}
+			http.NotFound(w, r)
+			return
+		}
+
+		token := r.URL.Query().Get("token")
+		if job.DownloadToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(job.DownloadToken)) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		body, err := x.ExportStore.Open(job.Key)
+		if err != nil {
+			log.Error(err)
+			http.Error(w, "export unavailable", http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+
+		w.Header().Set("Content-Type", export.ContentType(job.Format))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", job.Bytes))
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="trails-%s.%s"`, job.Model.ID.String(), job.Format))
+
+		if _, err := io.Copy(w, body); err != nil {
+			log.Error(err)
+		}
+	})
+}
+
+// ExportTrails writes the trails matching filter and search to w in format.
+// The projects of filter may be given as organization/project slugs.
+func (x *API) ExportTrails(format string, filter resolvers.TrailFilter, search string, w io.Writer) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	if filter.Projects != nil {
+		for i, tenant := range *filter.Projects {
+			project, err := resolvers.FindProject(db, string(tenant))
+			if err != nil {
+				return err
+			}
+			(*filter.Projects)[i] = graphql.ID(project.ID.String())
+		}
+	}
+
+	query, err := filter.Apply(db)
+	if err != nil {
+		return err
+	}
+
+	if search = strings.TrimSpace(search); search != "" {
+		query = query.Where("search_vector @@ "+resolvers.SearchQuery, search)
+	}
+
+	encoder, err := export.NewEncoder(format, w)
+	if err != nil {
+		return err
+	}
+
+	rows, err := export.Stream(db, query, encoder, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	log.InfoWithFields("exported trails", log.Fields{
+		"format": format,
+		"rows":   rows,
+	})
+
+	return nil
+}
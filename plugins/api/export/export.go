@@ -0,0 +1,235 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm"
+)
+
+// cursorBatchSize number of trails fetched from the cursor at a time
+const cursorBatchSize = 1000
+
+// Formats trails can be exported in
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// Formats
+var Formats = []string{FormatCSV, FormatJSONL, FormatParquet}
+
+// ContentType returns the media type of format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatJSONL:
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Encoder writes trails in an export format
+type Encoder interface {
+	// Encode writes trail
+	Encode(trail *resolvers.Trail) error
+	// Close flushes buffered trails, it does not close the underlying writer
+	Close() error
+}
+
+// NewEncoder returns an encoder writing format to w
+func NewEncoder(format string, w io.Writer) (Encoder, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columnNames()); err != nil {
+			return nil, err
+		}
+		return &csvEncoder{writer: writer}, nil
+	case FormatJSONL:
+		buffered := bufio.NewWriter(w)
+		return &jsonlEncoder{buffered: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case FormatParquet:
+		writer, err := newParquetWriter(w, parquetColumns())
+		if err != nil {
+			return nil, err
+		}
+		return &parquetEncoder{writer: writer}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q, expected one of %v", format, Formats)
+	}
+}
+
+// columns of the CSV and Parquet formats
+var columns = []struct {
+	name string
+	// parquet physical and converted type
+	physical  int32
+	converted int32
+	value     func(trail *resolvers.Trail) interface{}
+}{
+	{"id", parquetByteArray, parquetUTF8, func(t *resolvers.Trail) interface{} { return t.ID.String() }},
+	{"project_id", parquetByteArray, parquetUTF8, func(t *resolvers.Trail) interface{} { return t.ProjectId.String() }},
+	{"created_at", parquetInt64, parquetTimestampMicros, func(t *resolvers.Trail) interface{} { return t.CreatedAt }},
+	{"timestamp", parquetInt64, -1, func(t *resolvers.Trail) interface{} { return t.Timestamp }},
+	{"event", parquetByteArray, parquetUTF8, func(t *resolvers.Trail) interface{} { return t.Event }},
+	{"event_metadata", parquetByteArray, parquetJSON, func(t *resolvers.Trail) interface{} { return metadata(t.EventMetadata.RawMessage) }},
+	{"actor", parquetByteArray, parquetUTF8, func(t *resolvers.Trail) interface{} { return t.Actor }},
+	{"actor_metadata", parquetByteArray, parquetJSON, func(t *resolvers.Trail) interface{} { return metadata(t.ActorMetadata.RawMessage) }},
+	{"target", parquetByteArray, parquetUTF8, func(t *resolvers.Trail) interface{} { return t.Target }},
+	{"target_metadata", parquetByteArray, parquetJSON, func(t *resolvers.Trail) interface{} { return metadata(t.TargetMetadata.RawMessage) }},
+	{"origin", parquetByteArray, parquetUTF8, func(t *resolvers.Trail) interface{} { return t.Origin }},
+	{"origin_metadata", parquetByteArray, parquetJSON, func(t *resolvers.Trail) interface{} { return metadata(t.OriginMetadata.RawMessage) }},
+	{"sequence", parquetInt64, -1, func(t *resolvers.Trail) interface{} { return t.Sequence }},
+	{"previous_hash", parquetByteArray, parquetUTF8, func(t *resolvers.Trail) interface{} { return t.PreviousHash }},
+	{"hash", parquetByteArray, parquetUTF8, func(t *resolvers.Trail) interface{} { return t.Hash }},
+}
+
+func columnNames() []string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+func parquetColumns() []parquetColumn {
+	result := make([]parquetColumn, len(columns))
+	for i, column := range columns {
+		result[i] = parquetColumn{Name: column.name, Type: column.physical, ConvertedType: column.converted}
+	}
+	return result
+}
+
+// metadata returns the JSON text of a metadata column, null when empty
+func metadata(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return "null"
+	}
+	return string(raw)
+}
+
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvEncoder) Encode(trail *resolvers.Trail) error {
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		switch v := column.value(trail).(type) {
+		case string:
+			record[i] = v
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case time.Time:
+			record[i] = v.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return e.writer.Write(record)
+}
+
+func (e *csvEncoder) Close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type jsonlEncoder struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (e *jsonlEncoder) Encode(trail *resolvers.Trail) error {
+	return e.encoder.Encode(trail)
+}
+
+func (e *jsonlEncoder) Close() error {
+	return e.buffered.Flush()
+}
+
+type parquetEncoder struct {
+	writer *parquetWriter
+}
+
+func (e *parquetEncoder) Encode(trail *resolvers.Trail) error {
+	row := make([]interface{}, len(columns))
+	for i, column := range columns {
+		value := column.value(trail)
+		if t, ok := value.(time.Time); ok {
+			value = t.UnixNano() / int64(time.Microsecond)
+		}
+		row[i] = value
+	}
+	return e.writer.WriteRow(row)
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.writer.Close()
+}
+
+// Stream encodes the trails query selects in creation order. Trails are
+// read through a server-side cursor, cursorBatchSize at a time, so memory
+// use does not grow with the export. progress is called after each batch
+// with the number of trails encoded so far, an error aborts the export.
+func Stream(db *gorm.DB, query *gorm.DB, encoder Encoder, progress func(int64) error) (int64, error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	// the transaction only reads, and ends with the cursor
+	defer tx.Rollback()
+
+	selection := query.Model(&resolvers.Trail{}).Order("created_at asc, id asc").QueryExpr()
+	if err := tx.Exec("DECLARE export_trails NO SCROLL CURSOR FOR ?", selection).Error; err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for {
+		rows, err := tx.Raw(fmt.Sprintf("FETCH FORWARD %d FROM export_trails", cursorBatchSize)).Rows()
+		if err != nil {
+			return count, err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			trail := resolvers.Trail{}
+			if err := tx.ScanRows(rows, &trail); err != nil {
+				rows.Close()
+				return count, err
+			}
+
+			if err := encoder.Encode(&trail); err != nil {
+				rows.Close()
+				return count, err
+			}
+			fetched++
+		}
+
+		if err := rows.Close(); err != nil {
+			return count, err
+		}
+		if err := rows.Err(); err != nil {
+			return count, err
+		}
+
+		count += int64(fetched)
+		if progress != nil {
+			if err := progress(count); err != nil {
+				return count, err
+			}
+		}
+
+		if fetched < cursorBatchSize {
+			return count, nil
+		}
+	}
+}
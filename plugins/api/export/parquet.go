@@ -0,0 +1,226 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// parquetRowGroupSize rows buffered in memory before a row group is written
+const parquetRowGroupSize = 5000
+
+// parquet physical types
+const (
+	parquetInt64     int32 = 2
+	parquetByteArray int32 = 6
+)
+
+// parquet converted types, -1 for none
+const (
+	parquetUTF8            int32 = 0
+	parquetTimestampMicros int32 = 10
+	parquetJSON            int32 = 19
+)
+
+// parquet enum values written by parquetWriter
+const (
+	parquetDataPage int32 = 0
+	parquetPlain    int32 = 0
+	parquetRLE      int32 = 3
+	parquetGzip     int32 = 2
+	parquetRequired int32 = 0
+)
+
+// parquetColumn a required column of a flat schema
+type parquetColumn struct {
+	// Name
+	Name string
+	// Type parquetInt64 or parquetByteArray
+	Type int32
+	// ConvertedType -1 for none
+	ConvertedType int32
+}
+
+// parquetWriter writes a Parquet file with a flat schema of required
+// columns. Each row group holds a single gzipped, plain encoded data page
+// per column.
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []parquetColumn
+	// pages plain encoded values of the current row group by column
+	pages     []bytes.Buffer
+	rows      int64
+	total     int64
+	rowGroups thriftList
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	p := &parquetWriter{
+		w:       w,
+		columns: columns,
+		pages:   make([]bytes.Buffer, len(columns)),
+	}
+
+	if err := p.write([]byte("PAR1")); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// WriteRow appends a row holding an int64 for each parquetInt64 column and
+// a string for each parquetByteArray column
+func (p *parquetWriter) WriteRow(values []interface{}) error {
+	if len(values) != len(p.columns) {
+		return fmt.Errorf("parquet: row has %d values, expected %d", len(values), len(p.columns))
+	}
+
+	for i, value := range values {
+		page := &p.pages[i]
+		switch v := value.(type) {
+		case int64:
+			binary.Write(page, binary.LittleEndian, v)
+		case string:
+			binary.Write(page, binary.LittleEndian, uint32(len(v)))
+			page.WriteString(v)
+		default:
+			return fmt.Errorf("parquet: unsupported value %T in column %s", value, p.columns[i].Name)
+		}
+	}
+
+	p.rows++
+	if p.rows >= parquetRowGroupSize {
+		return p.flush()
+	}
+
+	return nil
+}
+
+// Close writes the last row group and the footer
+func (p *parquetWriter) Close() error {
+	if p.rows > 0 {
+		if err := p.flush(); err != nil {
+			return err
+		}
+	}
+
+	schema := thriftList{thriftStruct{
+		{4, "schema"},
+		{5, int32(len(p.columns))},
+	}}
+	for _, column := range p.columns {
+		element := thriftStruct{
+			{1, column.Type},
+			{3, parquetRequired},
+			{4, column.Name},
+		}
+		if column.ConvertedType >= 0 {
+			element = append(element, thriftField{6, column.ConvertedType})
+		}
+		schema = append(schema, element)
+	}
+
+	footer, err := encodeThrift(thriftStruct{
+		{1, int32(1)},
+		{2, schema},
+		{3, p.total},
+		{4, p.rowGroups},
+		{6, "inspectr"},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := p.write(footer); err != nil {
+		return err
+	}
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	if err := p.write(length[:]); err != nil {
+		return err
+	}
+
+	return p.write([]byte("PAR1"))
+}
+
+// flush writes the buffered rows as a row group
+func (p *parquetWriter) flush() error {
+	var chunks thriftList
+	var groupSize int64
+
+	for i, column := range p.columns {
+		page := p.pages[i].Bytes()
+
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(page); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+
+		header, err := encodeThrift(thriftStruct{
+			{1, parquetDataPage},
+			{2, int32(len(page))},
+			{3, int32(compressed.Len())},
+			{5, thriftStruct{
+				{1, int32(p.rows)},
+				{2, parquetPlain},
+				{3, parquetRLE},
+				{4, parquetRLE},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+
+		offset := p.offset
+		if err := p.write(header); err != nil {
+			return err
+		}
+		if err := p.write(compressed.Bytes()); err != nil {
+			return err
+		}
+
+		uncompressedSize := int64(len(header) + len(page))
+		groupSize += uncompressedSize
+
+		chunks = append(chunks, thriftStruct{
+			{2, offset},
+			{3, thriftStruct{
+				{1, column.Type},
+				{2, thriftList{parquetPlain}},
+				{3, thriftList{column.Name}},
+				{4, parquetGzip},
+				{5, p.rows},
+				{6, uncompressedSize},
+				{7, int64(len(header) + compressed.Len())},
+				{9, offset},
+			}},
+		})
+
+		p.pages[i].Reset()
+	}
+
+	p.rowGroups = append(p.rowGroups, thriftStruct{
+		{1, chunks},
+		{2, groupSize},
+		{3, p.rows},
+	})
+
+	p.total += p.rows
+	p.rows = 0
+
+	return nil
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
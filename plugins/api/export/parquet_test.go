@@ -0,0 +1,482 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm/dialects/postgres"
+	uuid "github.com/satori/go.uuid"
+)
+
+// testTrails returns n trails with distinct values in every column
+func testTrails(n int) []*resolvers.Trail {
+	project := uuid.NewV4()
+	created := time.Date(2026, 10, 18, 9, 0, 0, 123456000, time.UTC)
+
+	trails := make([]*resolvers.Trail, n)
+	for i := range trails {
+		trail := &resolvers.Trail{
+			ProjectId:      project,
+			Timestamp:      int64(1760000000 + i),
+			Event:          fmt.Sprintf("user.login.%d", i),
+			EventMetadata:  postgres.Jsonb{RawMessage: json.RawMessage(fmt.Sprintf(`{"n":%d}`, i))},
+			Actor:          fmt.Sprintf("actor-%d@example.com", i),
+			ActorMetadata:  postgres.Jsonb{RawMessage: json.RawMessage(`{"ip":"10.0.0.1","tags":["ü",null]}`)},
+			Target:         "",
+			TargetMetadata: postgres.Jsonb{},
+			Origin:         "http",
+			Sequence:       int64(i + 1),
+			PreviousHash:   fmt.Sprintf("%064d", i),
+			Hash:           fmt.Sprintf("%064d", i+1),
+		}
+		trail.ID = uuid.NewV4()
+		trail.CreatedAt = created.Add(time.Duration(i) * time.Millisecond)
+		trails[i] = trail
+	}
+
+	return trails
+}
+
+// expectedRow returns the values a reader finds for trail in the Parquet
+// columns
+func expectedRow(trail *resolvers.Trail) []interface{} {
+	row := make([]interface{}, len(columns))
+	for i, column := range columns {
+		value := column.value(trail)
+		if t, ok := value.(time.Time); ok {
+			value = t.UnixNano() / int64(time.Microsecond)
+		}
+		row[i] = value
+	}
+	return row
+}
+
+func encodeParquet(t *testing.T, trails []*resolvers.Trail) []byte {
+	var buffer bytes.Buffer
+	encoder, err := NewEncoder(FormatParquet, &buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trail := range trails {
+		if err := encoder.Encode(trail); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, parquetRowGroupSize, 2*parquetRowGroupSize + 3} {
+		t.Run(fmt.Sprintf("%d rows", n), func(t *testing.T) {
+			trails := testTrails(n)
+			file, err := readParquet(encodeParquet(t, trails))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(file.columns, columnNames()) {
+				t.Errorf("columns = %v, want %v", file.columns, columnNames())
+			}
+			for i, column := range columns {
+				if file.types[i] != column.physical || file.converted[i] != column.converted {
+					t.Errorf("column %s has type %d/%d, want %d/%d", column.name, file.types[i], file.converted[i], column.physical, column.converted)
+				}
+			}
+
+			groups := (n + parquetRowGroupSize - 1) / parquetRowGroupSize
+			if file.rowGroups != groups {
+				t.Errorf("%d row groups, want %d", file.rowGroups, groups)
+			}
+			if file.numRows != int64(n) || len(file.rows) != n {
+				t.Fatalf("%d rows in the footer and %d in the pages, want %d", file.numRows, len(file.rows), n)
+			}
+
+			for i, trail := range trails {
+				if want := expectedRow(trail); !reflect.DeepEqual(file.rows[i], want) {
+					t.Fatalf("row %d = %v, want %v", i, file.rows[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeThriftUnsupportedValue(t *testing.T) {
+	for name, s := range map[string]thriftStruct{
+		"float":      {{1, 1.5}},
+		"nested":     {{1, thriftStruct{{2, true}}}},
+		"mixed list": {{1, thriftList{int32(1), "a"}}},
+	} {
+		if _, err := encodeThrift(s); err == nil {
+			t.Errorf("encodeThrift of a %s value succeeded, want an error", name)
+		}
+	}
+}
+
+// pyarrowScript prints the rows of the Parquet file in argv[1] as JSON,
+// timestamps as microseconds and JSON columns as text
+const pyarrowScript = `
+import datetime, json, sys
+import pyarrow.parquet as pq
+
+epoch = datetime.datetime(1970, 1, 1, tzinfo=datetime.timezone.utc)
+table = pq.read_table(sys.argv[1])
+rows = []
+for row in table.to_pylist():
+    for key, value in row.items():
+        if isinstance(value, datetime.datetime):
+            if value.tzinfo is None:
+                value = value.replace(tzinfo=datetime.timezone.utc)
+            delta = value - epoch
+            row[key] = (delta.days * 86400 + delta.seconds) * 1000000 + delta.microseconds
+        elif isinstance(value, bytes):
+            row[key] = value.decode("utf-8")
+    rows.append(row)
+json.dump({"columns": table.column_names, "rows": rows}, sys.stdout)
+`
+
+// TestParquetReadByPyarrow reads an export with Apache Arrow. It is skipped
+// where python3 with pyarrow is missing, unless PARQUET_READER_REQUIRED is
+// set, as in CI.
+func TestParquetReadByPyarrow(t *testing.T) {
+	if err := exec.Command("python3", "-c", "import pyarrow.parquet").Run(); err != nil {
+		if os.Getenv("PARQUET_READER_REQUIRED") != "" {
+			t.Fatalf("pyarrow is required: %v", err)
+		}
+		t.Skip("python3 with pyarrow is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	trails := testTrails(parquetRowGroupSize + 7)
+	path := filepath.Join(dir, "trails.parquet")
+	if err := ioutil.WriteFile(path, encodeParquet(t, trails), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := exec.Command("python3", "-c", pyarrowScript, path).Output()
+	if err != nil {
+		t.Fatalf("pyarrow could not read the export: %v", err)
+	}
+
+	var read struct {
+		Columns []string
+		Rows    []map[string]interface{}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+	if err := decoder.Decode(&read); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(read.Columns, columnNames()) {
+		t.Errorf("columns = %v, want %v", read.Columns, columnNames())
+	}
+	if len(read.Rows) != len(trails) {
+		t.Fatalf("pyarrow read %d rows, want %d", len(read.Rows), len(trails))
+	}
+
+	for i, trail := range trails {
+		for j, want := range expectedRow(trail) {
+			got := read.Rows[i][columns[j].name]
+			if number, ok := got.(json.Number); ok {
+				got, _ = number.Int64()
+			}
+			if got != want {
+				t.Fatalf("row %d column %s = %v, want %v", i, columns[j].name, got, want)
+			}
+		}
+	}
+}
+
+// parquetFile contents of a Parquet file written by parquetWriter
+type parquetFile struct {
+	columns   []string
+	types     []int32
+	converted []int32
+	numRows   int64
+	rowGroups int
+	rows      [][]interface{}
+}
+
+// readParquet decodes a Parquet file of required, plain encoded, gzipped
+// columns, following the format specification independently of
+// parquetWriter
+func readParquet(data []byte) (*parquetFile, error) {
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		return nil, fmt.Errorf("missing PAR1 magic")
+	}
+
+	length := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	start := len(data) - 8 - length
+	if start < 4 {
+		return nil, fmt.Errorf("footer length %d out of range", length)
+	}
+
+	footer := &thriftReader{data: data[start : len(data)-8]}
+	metadata, err := footer.readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("footer: %v", err)
+	}
+	if footer.pos != len(footer.data) {
+		return nil, fmt.Errorf("footer has %d trailing bytes", len(footer.data)-footer.pos)
+	}
+
+	file := &parquetFile{numRows: metadata[3].(int64)}
+
+	schema := metadata[2].([]interface{})
+	root := schema[0].(map[int16]interface{})
+	if root[5].(int64) != int64(len(schema)-1) {
+		return nil, fmt.Errorf("root has %d children, schema %d columns", root[5], len(schema)-1)
+	}
+	for _, element := range schema[1:] {
+		e := element.(map[int16]interface{})
+		if e[3].(int64) != int64(parquetRequired) {
+			return nil, fmt.Errorf("column %s is not required", e[4])
+		}
+		converted := int32(-1)
+		if c, ok := e[6]; ok {
+			converted = int32(c.(int64))
+		}
+		file.columns = append(file.columns, string(e[4].([]byte)))
+		file.types = append(file.types, int32(e[1].(int64)))
+		file.converted = append(file.converted, converted)
+	}
+
+	for _, group := range metadata[4].([]interface{}) {
+		g := group.(map[int16]interface{})
+		numRows := int(g[3].(int64))
+		chunks := g[1].([]interface{})
+		if len(chunks) != len(file.columns) {
+			return nil, fmt.Errorf("row group has %d columns, want %d", len(chunks), len(file.columns))
+		}
+
+		rows := make([][]interface{}, numRows)
+		for i := range rows {
+			rows[i] = make([]interface{}, len(chunks))
+		}
+
+		var groupSize int64
+		for c, chunk := range chunks {
+			values, uncompressed, err := readColumnChunk(data, chunk.(map[int16]interface{}), file.types[c], file.columns[c], numRows)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %v", file.columns[c], err)
+			}
+			groupSize += uncompressed
+			for i, value := range values {
+				rows[i][c] = value
+			}
+		}
+		if g[2].(int64) != groupSize {
+			return nil, fmt.Errorf("row group size %d, columns add up to %d", g[2], groupSize)
+		}
+
+		file.rows = append(file.rows, rows...)
+		file.rowGroups++
+	}
+
+	return file, nil
+}
+
+func readColumnChunk(data []byte, chunk map[int16]interface{}, physical int32, name string, numRows int) ([]interface{}, int64, error) {
+	meta := chunk[3].(map[int16]interface{})
+	if int32(meta[1].(int64)) != physical {
+		return nil, 0, fmt.Errorf("chunk type %d, schema %d", meta[1], physical)
+	}
+	if path := meta[3].([]interface{}); len(path) != 1 || string(path[0].([]byte)) != name {
+		return nil, 0, fmt.Errorf("path in schema %q", path)
+	}
+	if meta[4].(int64) != int64(parquetGzip) {
+		return nil, 0, fmt.Errorf("codec %d", meta[4])
+	}
+	if meta[5].(int64) != int64(numRows) {
+		return nil, 0, fmt.Errorf("%d values, row group has %d rows", meta[5], numRows)
+	}
+
+	offset := int(meta[9].(int64))
+	if chunk[2].(int64) != int64(offset) {
+		return nil, 0, fmt.Errorf("file offset %d, data page offset %d", chunk[2], offset)
+	}
+
+	reader := &thriftReader{data: data[offset:]}
+	header, err := reader.readStruct()
+	if err != nil {
+		return nil, 0, fmt.Errorf("page header: %v", err)
+	}
+	if header[1].(int64) != int64(parquetDataPage) {
+		return nil, 0, fmt.Errorf("page type %d", header[1])
+	}
+	page := header[5].(map[int16]interface{})
+	if page[1].(int64) != int64(numRows) || page[2].(int64) != int64(parquetPlain) {
+		return nil, 0, fmt.Errorf("page has %d values encoded with %d", page[1], page[2])
+	}
+
+	uncompressedSize := int(header[2].(int64))
+	compressedSize := int(header[3].(int64))
+	if meta[7].(int64) != int64(reader.pos+compressedSize) || meta[6].(int64) != int64(reader.pos+uncompressedSize) {
+		return nil, 0, fmt.Errorf("chunk sizes %d/%d, page %d/%d", meta[7], meta[6], reader.pos+compressedSize, reader.pos+uncompressedSize)
+	}
+
+	compressed := data[offset+reader.pos : offset+reader.pos+compressedSize]
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, 0, err
+	}
+	plain, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(plain) != uncompressedSize {
+		return nil, 0, fmt.Errorf("page is %d bytes uncompressed, header says %d", len(plain), uncompressedSize)
+	}
+
+	values := make([]interface{}, numRows)
+	for i := range values {
+		switch physical {
+		case parquetInt64:
+			if len(plain) < 8 {
+				return nil, 0, fmt.Errorf("page ends in value %d", i)
+			}
+			values[i] = int64(binary.LittleEndian.Uint64(plain))
+			plain = plain[8:]
+		case parquetByteArray:
+			if len(plain) < 4 {
+				return nil, 0, fmt.Errorf("page ends in value %d", i)
+			}
+			n := int(binary.LittleEndian.Uint32(plain))
+			if len(plain) < 4+n {
+				return nil, 0, fmt.Errorf("page ends in value %d", i)
+			}
+			values[i] = string(plain[4 : 4+n])
+			plain = plain[4+n:]
+		default:
+			return nil, 0, fmt.Errorf("unexpected physical type %d", physical)
+		}
+	}
+	if len(plain) > 0 {
+		return nil, 0, fmt.Errorf("page has %d trailing bytes", len(plain))
+	}
+
+	return values, int64(reader.pos + uncompressedSize), nil
+}
+
+// thriftReader decodes the Thrift compact protocol. Structs decode to maps
+// of field IDs, integers to int64, binaries to []byte and lists to slices.
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) readByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, fmt.Errorf("unexpected end at %d", r.pos)
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) readVarint() (uint64, error) {
+	n, size := binary.Uvarint(r.data[r.pos:])
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid varint at %d", r.pos)
+	}
+	r.pos += size
+	return n, nil
+}
+
+func (r *thriftReader) readZigzag() (int64, error) {
+	n, err := r.readVarint()
+	return int64(n>>1) ^ -int64(n&1), err
+}
+
+func (r *thriftReader) readStruct() (map[int16]interface{}, error) {
+	fields := map[int16]interface{}{}
+	last := int16(0)
+
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return fields, nil
+		}
+
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			long, err := r.readZigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(long)
+		}
+		if id <= last {
+			return nil, fmt.Errorf("field %d follows field %d", id, last)
+		}
+		last = id
+
+		if fields[id], err = r.readValue(b & 0x0f); err != nil {
+			return nil, fmt.Errorf("field %d: %v", id, err)
+		}
+	}
+}
+
+func (r *thriftReader) readValue(typ byte) (interface{}, error) {
+	switch typ {
+	case 1, 2:
+		return typ == 1, nil
+	case compactI32, compactI64:
+		return r.readZigzag()
+	case compactBinary:
+		n, err := r.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		if r.pos+int(n) > len(r.data) {
+			return nil, fmt.Errorf("binary of %d bytes exceeds the data", n)
+		}
+		value := r.data[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return value, nil
+	case compactList:
+		b, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(b >> 4)
+		if size == 15 {
+			if size, err = r.readVarint(); err != nil {
+				return nil, err
+			}
+		}
+		items := make([]interface{}, size)
+		for i := range items {
+			if items[i], err = r.readValue(b & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case compactStruct:
+		return r.readStruct()
+	default:
+		return nil, fmt.Errorf("unsupported type %d", typ)
+	}
+}
@@ -0,0 +1,134 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Parquet metadata is serialized with the Thrift compact protocol. Only the
+// types the Parquet footer and page headers use are supported.
+
+// compact protocol type ids
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// thriftField is a field of a thriftStruct. Value is an int32, int64,
+// string, thriftStruct or thriftList.
+type thriftField struct {
+	ID    int16
+	Value interface{}
+}
+
+// thriftStruct fields in ascending ID order, nil values are skipped
+type thriftStruct []thriftField
+
+// thriftList list of int32, string or thriftStruct
+type thriftList []interface{}
+
+// encodeThrift returns the compact protocol encoding of s
+func encodeThrift(s thriftStruct) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := writeThriftStruct(&buffer, s); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeThriftStruct(buffer *bytes.Buffer, s thriftStruct) error {
+	last := int16(0)
+	for _, field := range s {
+		if field.Value == nil {
+			continue
+		}
+
+		typ, err := thriftType(field.Value)
+		if err != nil {
+			return fmt.Errorf("thrift: field %d: %v", field.ID, err)
+		}
+		if delta := field.ID - last; delta > 0 && delta <= 15 {
+			buffer.WriteByte(byte(delta)<<4 | typ)
+		} else {
+			buffer.WriteByte(typ)
+			writeVarint(buffer, zigzag(int64(field.ID)))
+		}
+		last = field.ID
+
+		if err := writeThriftValue(buffer, field.Value); err != nil {
+			return err
+		}
+	}
+	// stop
+	buffer.WriteByte(0)
+	return nil
+}
+
+func writeThriftValue(buffer *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case int32:
+		writeVarint(buffer, zigzag(int64(v)))
+	case int64:
+		writeVarint(buffer, zigzag(v))
+	case string:
+		writeVarint(buffer, uint64(len(v)))
+		buffer.WriteString(v)
+	case thriftStruct:
+		return writeThriftStruct(buffer, v)
+	case thriftList:
+		typ := byte(compactStruct)
+		if len(v) > 0 {
+			var err error
+			if typ, err = thriftType(v[0]); err != nil {
+				return err
+			}
+		}
+		if len(v) < 15 {
+			buffer.WriteByte(byte(len(v))<<4 | typ)
+		} else {
+			buffer.WriteByte(0xf0 | typ)
+			writeVarint(buffer, uint64(len(v)))
+		}
+		for _, item := range v {
+			if itemType, err := thriftType(item); err != nil || itemType != typ {
+				return fmt.Errorf("thrift: list of mixed or unsupported values %T", item)
+			}
+			if err := writeThriftValue(buffer, item); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("thrift: unsupported value %T", value)
+	}
+	return nil
+}
+
+func thriftType(value interface{}) (byte, error) {
+	switch value.(type) {
+	case int32:
+		return compactI32, nil
+	case int64:
+		return compactI64, nil
+	case string:
+		return compactBinary, nil
+	case thriftList:
+		return compactList, nil
+	case thriftStruct:
+		return compactStruct, nil
+	default:
+		return 0, fmt.Errorf("unsupported value %T", value)
+	}
+}
+
+func zigzag(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func writeVarint(buffer *bytes.Buffer, n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buffer.Write(b[:binary.PutUvarint(b[:], n)])
+}
@@ -0,0 +1,248 @@
+package inspectr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"time"
+
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/inspectr/backend/plugins"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/inspectr/backend/plugins/api/utils"
+)
+
+const (
+	// MaxIngestBodySize largest request body accepted by IngestHandler
+	MaxIngestBodySize = 10 << 20
+	// MaxIngestBatchSize most trails accepted in a single request
+	MaxIngestBatchSize = 1000
+	// MaxEventLength matches the size of the trails.event column
+	MaxEventLength = 100
+	// MaxIDLength keeps idempotency keys within the trail_keys.key column
+	MaxIDLength = 200
+)
+
+// IngestError describes an invalid trail of an ingest request
+type IngestError struct {
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+// IngestHandler accepts trails from producers over HTTP. The body is
+// either a JSON trail, a JSON array of trails or newline delimited JSON
+// (application/x-ndjson). Trails go into the project named by the X-Tenant
+// header, or the only project the producer writes into. Valid batches are
+// emitted as trail:create events, the same path trails received from SQS
+// take.
+func (x *API) IngestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeIngestResponse(w, http.StatusMethodNotAllowed, map[string]interface{}{
+				"error": "method not allowed",
+			})
+			return
+		}
+
+		producer, err := utils.AuthenticateProducer(utils.ParseProducer(r), x.DB, x.Redis)
+		if err != nil {
+			w.Header().Set("Www-Authenticate", "Basic realm=\"inspectr\"")
+			writeIngestResponse(w, http.StatusUnauthorized, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		project, err := utils.ProducerProject(producer, r.Header.Get("X-Tenant"), x.DB)
+		if err != nil {
+			writeIngestResponse(w, http.StatusForbidden, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		x.ingest(w, r, producer, project)
+	})
+}
+
+// ingest emits the trails in the body of r, sent by producer into project
+func (x *API) ingest(w http.ResponseWriter, r *http.Request, producer resolvers.User, project resolvers.Project) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxIngestBodySize))
+	if err != nil {
+		writeIngestResponse(w, http.StatusRequestEntityTooLarge, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var trails []plugins.Trail
+	switch mediaType {
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		trails, err = decodeNDJSON(body)
+	default:
+		trails, err = decodeJSON(body)
+	}
+	if err != nil {
+		writeIngestResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if len(trails) == 0 {
+		writeIngestResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error": "no trails in request",
+		})
+		return
+	}
+
+	if len(trails) > MaxIngestBatchSize {
+		writeIngestResponse(w, http.StatusRequestEntityTooLarge, map[string]interface{}{
+			"error": fmt.Sprintf("more than %d trails in request", MaxIngestBatchSize),
+		})
+		return
+	}
+
+	var errs []IngestError
+	for i := range trails {
+		if err := validateTrail(&trails[i]); err != nil {
+			errs = append(errs, IngestError{Index: i, Message: err.Error()})
+		}
+		trails[i].Tenant = project.ID.String()
+		trails[i].Producer = producer.Email
+		trails[i].Source = "http"
+		trails[i].Attempts = 1
+		// HTTP trails are not delivered again, a failed insert is
+		// quarantined right away
+		trails[i].MaxAttempts = 0
+	}
+
+	// trails of event types in reject mode are refused right away, the
+	// other modes are applied once the trails are received
+	if len(errs) == 0 {
+		for i := range trails {
+			eventType, violations := x.schemaViolations(project.ID, trails[i])
+			if len(violations) == 0 || eventType.Mode != resolvers.SchemaReject {
+				continue
+			}
+
+			x.recordSchemaViolation(eventType, trails[i], violations)
+			errs = append(errs, IngestError{Index: i, Message: schemaError(eventType, violations).Error()})
+		}
+	}
+
+	if len(errs) > 0 {
+		writeIngestResponse(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"errors": errs,
+		})
+		return
+	}
+
+	for _, trail := range trails {
+		x.Events <- transistor.NewEvent(transistor.EventName("trail"), transistor.GetAction("create"), trail)
+	}
+
+	log.DebugWithFields("ingested trails over HTTP", log.Fields{
+		"count":  len(trails),
+		"tenant": project.ID.String(),
+	})
+
+	writeIngestResponse(w, http.StatusAccepted, map[string]interface{}{
+		"accepted": len(trails),
+	})
+}
+
+// validateTrail checks the required fields of trail and fills in defaults
+func validateTrail(trail *plugins.Trail) error {
+	if trail.Event == "" {
+		return fmt.Errorf("event is required")
+	}
+
+	if len(trail.Event) > MaxEventLength {
+		return fmt.Errorf("event is longer than %d characters", MaxEventLength)
+	}
+
+	if trail.Actor == "" {
+		return fmt.Errorf("actor is required")
+	}
+
+	if len(trail.ID) > MaxIDLength {
+		return fmt.Errorf("id is longer than %d characters", MaxIDLength)
+	}
+
+	if trail.Timestamp < 0 {
+		return fmt.Errorf("timestamp must be a unix timestamp")
+	}
+
+	if trail.Timestamp == 0 {
+		trail.Timestamp = time.Now().Unix()
+	}
+
+	return nil
+}
+
+func decodeJSON(body []byte) ([]plugins.Trail, error) {
+	body = bytes.TrimSpace(body)
+
+	if len(body) > 0 && body[0] == '[' {
+		var bodies []plugins.TrailBody
+		if err := json.Unmarshal(body, &bodies); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+
+		trails := make([]plugins.Trail, len(bodies))
+		for i, body := range bodies {
+			trails[i] = body.Trail()
+		}
+		return trails, nil
+	}
+
+	trail, err := plugins.ParseTrail(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	return []plugins.Trail{trail}, nil
+}
+
+func decodeNDJSON(body []byte) ([]plugins.Trail, error) {
+	var trails []plugins.Trail
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), MaxIngestBodySize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		trail, err := plugins.ParseTrail(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON on line %d: %v", line, err)
+		}
+		trails = append(trails, trail)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return trails, nil
+}
+
+func writeIngestResponse(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,74 @@
+package inspectr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codeamp/transistor"
+	"github.com/inspectr/backend/plugins"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ingestAPI returns an API emitting ingested trails to its buffered Events,
+// with no event types registered for project
+func ingestAPI(project uuid.UUID) *API {
+	return &API{
+		Events: make(chan transistor.Event, 10),
+		EventTypes: &EventTypeCache{
+			ttl:      time.Hour,
+			projects: map[uuid.UUID]eventTypes{project: {loadedAt: time.Now()}},
+		},
+	}
+}
+
+func TestIngestResetsRoutingFields(t *testing.T) {
+	project := resolvers.Project{}
+	project.ID = uuid.NewV4()
+	producer := resolvers.User{Email: "producer@example.com"}
+
+	x := ingestAPI(project.ID)
+
+	// producers can not route their trails nor ask for redeliveries
+	body := `{
+		"event": "user.login",
+		"actor": "alice",
+		"MessageID": "receipt",
+		"Tenant": "other/project",
+		"Producer": "someone@example.com",
+		"Source": "sqs",
+		"SourceID": "message",
+		"Attempts": 7,
+		"MaxAttempts": 99
+	}`
+
+	w := httptest.NewRecorder()
+	x.ingest(w, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body)), producer, project)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+
+	select {
+	case e := <-x.Events:
+		trail := e.Payload.(plugins.Trail)
+		want := plugins.Trail{
+			Timestamp:   trail.Timestamp,
+			Event:       "user.login",
+			Actor:       "alice",
+			Tenant:      project.ID.String(),
+			Producer:    producer.Email,
+			Source:      "http",
+			Attempts:    1,
+			MaxAttempts: 0,
+		}
+		if trail != want {
+			t.Errorf("emitted %+v, want %+v", trail, want)
+		}
+	default:
+		t.Fatal("no trail emitted")
+	}
+}
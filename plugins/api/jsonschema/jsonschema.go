@@ -0,0 +1,459 @@
+// Package jsonschema validates JSON documents against a subset of JSON
+// Schema: type, enum, const, the object keywords properties, required,
+// additionalProperties, minProperties and maxProperties, the array keywords
+// items, minItems and maxItems, the string keywords minLength, maxLength and
+// pattern, and the number keywords minimum, maximum, exclusiveMinimum and
+// exclusiveMaximum. Annotations such as title and description are allowed
+// and ignored, any other keyword is refused when compiling, so a schema
+// never silently checks less than its author expects.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Types values of the type keyword
+var Types = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+// annotations keywords that do not constrain documents
+var annotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+}
+
+// Schema compiled schema
+type Schema struct {
+	// always set for the boolean schemas true and false
+	always *bool
+
+	types                []string
+	enum                 []interface{}
+	constant             *interface{}
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	minProperties        *int
+	maxProperties        *int
+	items                *Schema
+	minItems             *int
+	maxItems             *int
+	minLength            *int
+	maxLength            *int
+	pattern              *regexp.Regexp
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+}
+
+// Violation of a schema by a document
+type Violation struct {
+	// Path JSON pointer to the offending value, empty for the document
+	Path string
+	// Message
+	Message string
+}
+
+func (v Violation) String() string {
+	path := v.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, v.Message)
+}
+
+// Compile parses the JSON schema in raw
+func Compile(raw []byte) (*Schema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	return compile(normalize(document), "")
+}
+
+// normalize replaces the json.Numbers of document with float64s, the type
+// encoding/json decodes numbers of documents into
+func normalize(document interface{}) interface{} {
+	switch value := document.(type) {
+	case json.Number:
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, v := range value {
+			value[k] = normalize(v)
+		}
+	case []interface{}:
+		for i, v := range value {
+			value[i] = normalize(v)
+		}
+	}
+	return document
+}
+
+func compile(document interface{}, path string) (*Schema, error) {
+	if always, ok := document.(bool); ok {
+		return &Schema{always: &always}, nil
+	}
+
+	keywords, ok := document.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: a schema must be an object or a boolean", pointer(path))
+	}
+
+	// keywords are compiled in order, so errors are reported consistently
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	s := &Schema{}
+	for _, name := range names {
+		value := keywords[name]
+		at := path + "/" + escape(name)
+
+		var err error
+		switch name {
+		case "type":
+			s.types, err = compileTypes(value)
+		case "enum":
+			values, ok := value.([]interface{})
+			if !ok || len(values) == 0 {
+				err = fmt.Errorf("must be a non-empty array")
+			}
+			s.enum = values
+		case "const":
+			constant := value
+			s.constant = &constant
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				err = fmt.Errorf("must be an object")
+				break
+			}
+			s.properties = map[string]*Schema{}
+			for property, schema := range properties {
+				if s.properties[property], err = compile(schema, at+"/"+escape(property)); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			s.required, err = compileStrings(value)
+		case "additionalProperties":
+			s.additionalProperties, err = compile(value, at)
+			if err != nil {
+				return nil, err
+			}
+		case "items":
+			s.items, err = compile(value, at)
+			if err != nil {
+				return nil, err
+			}
+		case "minProperties":
+			s.minProperties, err = compileCount(value)
+		case "maxProperties":
+			s.maxProperties, err = compileCount(value)
+		case "minItems":
+			s.minItems, err = compileCount(value)
+		case "maxItems":
+			s.maxItems, err = compileCount(value)
+		case "minLength":
+			s.minLength, err = compileCount(value)
+		case "maxLength":
+			s.maxLength, err = compileCount(value)
+		case "pattern":
+			expression, ok := value.(string)
+			if !ok {
+				err = fmt.Errorf("must be a string")
+				break
+			}
+			s.pattern, err = regexp.Compile(expression)
+		case "minimum":
+			s.minimum, err = compileNumber(value)
+		case "maximum":
+			s.maximum, err = compileNumber(value)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = compileNumber(value)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = compileNumber(value)
+		default:
+			if !annotations[name] {
+				err = fmt.Errorf("unsupported keyword")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pointer(at), err)
+		}
+	}
+
+	return s, nil
+}
+
+func compileTypes(value interface{}) ([]string, error) {
+	var types []string
+	switch value := value.(type) {
+	case string:
+		types = []string{value}
+	case []interface{}:
+		var err error
+		if types, err = compileStrings(value); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("must be a string or an array of strings")
+	}
+
+	for _, t := range types {
+		if !contains(Types, t) {
+			return nil, fmt.Errorf("unknown type %q, expected one of %s", t, strings.Join(Types, ", "))
+		}
+	}
+
+	return types, nil
+}
+
+func compileStrings(value interface{}) ([]string, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+
+	strs := make([]string, len(values))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		strs[i] = s
+	}
+
+	return strs, nil
+}
+
+func compileCount(value interface{}) (*int, error) {
+	f, ok := value.(float64)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+
+	count := int(f)
+	return &count, nil
+}
+
+func compileNumber(value interface{}) (*float64, error) {
+	f, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &f, nil
+}
+
+// Validate returns the violations of s by document, a value decoded by
+// encoding/json. Violations are ordered by path.
+func (s *Schema) Validate(document interface{}) []Violation {
+	var violations []Violation
+	s.validate(document, "", &violations)
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+
+	return violations
+}
+
+func (s *Schema) validate(value interface{}, path string, violations *[]Violation) {
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			fail("no value is allowed")
+		}
+		return
+	}
+
+	if len(s.types) > 0 && !s.matchesType(value) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(value))
+		return
+	}
+
+	if s.enum != nil {
+		found := false
+		for _, allowed := range s.enum {
+			if equal(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", encode(s.enum))
+		}
+	}
+
+	if s.constant != nil && !equal(value, *s.constant) {
+		fail("must be %s", encode(*s.constant))
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(value, path, violations, fail)
+	case []interface{}:
+		if s.minItems != nil && len(value) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(value) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range value {
+				s.items.validate(item, fmt.Sprintf("%s/%d", path, i), violations)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.minLength != nil && length < *s.minLength {
+			fail("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			fail("must match %s", s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && value < *s.minimum {
+			fail("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && value > *s.maximum {
+			fail("must be at most %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+			fail("must be greater than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+			fail("must be less than %v", *s.exclusiveMaximum)
+		}
+	}
+}
+
+func (s *Schema) validateObject(value map[string]interface{}, path string, violations *[]Violation, fail func(string, ...interface{})) {
+	if s.minProperties != nil && len(value) < *s.minProperties {
+		fail("must have at least %d properties", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(value) > *s.maxProperties {
+		fail("must have at most %d properties", *s.maxProperties)
+	}
+
+	for _, property := range s.required {
+		if _, ok := value[property]; !ok {
+			fail("%s is required", property)
+		}
+	}
+
+	properties := make([]string, 0, len(value))
+	for property := range value {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+
+	for _, property := range properties {
+		at := path + "/" + escape(property)
+		if schema, ok := s.properties[property]; ok {
+			schema.validate(value[property], at, violations)
+			continue
+		}
+		if s.additionalProperties != nil {
+			if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+				*violations = append(*violations, Violation{Path: at, Message: "is not allowed"})
+				continue
+			}
+			s.additionalProperties.validate(value[property], at, violations)
+		}
+	}
+}
+
+func (s *Schema) matchesType(value interface{}) bool {
+	actual := typeOf(value)
+	for _, t := range s.types {
+		if t == actual {
+			return true
+		}
+		if t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON type of value, integer for whole numbers
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case float64:
+		if value == math.Trunc(value) && !math.IsInf(value, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func equal(a interface{}, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func encode(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// escape escapes name for use in a JSON pointer
+func escape(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
+
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
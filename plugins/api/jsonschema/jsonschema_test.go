@@ -0,0 +1,195 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		document string
+		// want violations, as Violation.String
+		want []string
+	}{
+		{"true", `true`, `{"a": 1}`, nil},
+		{"false", `false`, `1`, []string{"/: no value is allowed"}},
+		{"empty schema", `{}`, `[1, "a", null]`, nil},
+		{"annotations", `{"title": "t", "description": "d", "$comment": "c", "default": 1, "examples": [1]}`, `"x"`, nil},
+
+		{"type", `{"type": "string"}`, `"a"`, nil},
+		{"type invalid", `{"type": "string"}`, `1`, []string{"/: expected string, got integer"}},
+		{"type list", `{"type": ["string", "null"]}`, `null`, nil},
+		{"type list invalid", `{"type": ["string", "null"]}`, `true`, []string{"/: expected string or null, got boolean"}},
+		{"integer is a number", `{"type": "number"}`, `3`, nil},
+		{"integer", `{"type": "integer"}`, `3.0`, nil},
+		{"integer invalid", `{"type": "integer"}`, `3.5`, []string{"/: expected integer, got number"}},
+		{"type stops other keywords", `{"type": "string", "minLength": 2}`, `1`, []string{"/: expected string, got integer"}},
+
+		{"enum", `{"enum": ["a", 1, null]}`, `1`, nil},
+		{"enum invalid", `{"enum": ["a", 1, null]}`, `"b"`, []string{`/: must be one of ["a",1,null]`}},
+		{"const", `{"const": {"a": [1]}}`, `{"a": [1]}`, nil},
+		{"const invalid", `{"const": {"a": [1]}}`, `{"a": [2]}`, []string{`/: must be {"a":[1]}`}},
+
+		{"properties", `{"properties": {"a": {"type": "string"}}}`, `{"a": "x", "b": 1}`, nil},
+		{"properties invalid", `{"properties": {"a": {"type": "string"}}}`, `{"a": 1}`, []string{"/a: expected string, got integer"}},
+		{"properties ignore other types", `{"properties": {"a": {"type": "string"}}}`, `[1]`, nil},
+		{"required", `{"required": ["a"]}`, `{"a": null}`, nil},
+		{"required invalid", `{"required": ["a", "b"]}`, `{"b": 1}`, []string{"/: a is required"}},
+		{"additionalProperties false", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1}`, nil},
+		{"additionalProperties false invalid", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, []string{"/b: is not allowed"}},
+		{"additionalProperties schema", `{"additionalProperties": {"type": "integer"}}`, `{"a": 1}`, nil},
+		{"additionalProperties schema invalid", `{"additionalProperties": {"type": "integer"}}`, `{"a": "x"}`, []string{"/a: expected integer, got string"}},
+		{"minProperties", `{"minProperties": 1}`, `{"a": 1}`, nil},
+		{"minProperties invalid", `{"minProperties": 1}`, `{}`, []string{"/: must have at least 1 properties"}},
+		{"maxProperties", `{"maxProperties": 1}`, `{"a": 1}`, nil},
+		{"maxProperties invalid", `{"maxProperties": 1}`, `{"a": 1, "b": 2}`, []string{"/: must have at most 1 properties"}},
+		{"escaped property", `{"properties": {"a/b~c": {"type": "string"}}}`, `{"a/b~c": 1}`, []string{"/a~1b~0c: expected string, got integer"}},
+
+		{"items", `{"items": {"type": "integer"}}`, `[1, 2]`, nil},
+		{"items invalid", `{"items": {"type": "integer"}}`, `[1, "a", 2, true]`, []string{"/1: expected integer, got string", "/3: expected integer, got boolean"}},
+		{"minItems", `{"minItems": 2}`, `[1, 2]`, nil},
+		{"minItems invalid", `{"minItems": 2}`, `[1]`, []string{"/: must have at least 2 items"}},
+		{"maxItems", `{"maxItems": 1}`, `[1]`, nil},
+		{"maxItems invalid", `{"maxItems": 1}`, `[1, 2]`, []string{"/: must have at most 1 items"}},
+
+		{"minLength", `{"minLength": 2}`, `"ab"`, nil},
+		{"minLength counts characters", `{"minLength": 2}`, `"é"`, []string{"/: must be at least 2 characters"}},
+		{"maxLength", `{"maxLength": 2}`, `"éé"`, nil},
+		{"maxLength invalid", `{"maxLength": 2}`, `"abc"`, []string{"/: must be at most 2 characters"}},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc"`, nil},
+		{"pattern invalid", `{"pattern": "^[a-z]+$"}`, `"ab1"`, []string{"/: must match ^[a-z]+$"}},
+		{"pattern is not anchored", `{"pattern": "b"}`, `"abc"`, nil},
+
+		{"minimum", `{"minimum": 1}`, `1`, nil},
+		{"minimum invalid", `{"minimum": 1}`, `0.5`, []string{"/: must be at least 1"}},
+		{"maximum", `{"maximum": 1}`, `1`, nil},
+		{"maximum invalid", `{"maximum": 1}`, `2`, []string{"/: must be at most 1"}},
+		{"exclusiveMinimum", `{"exclusiveMinimum": 1}`, `1.5`, nil},
+		{"exclusiveMinimum invalid", `{"exclusiveMinimum": 1}`, `1`, []string{"/: must be greater than 1"}},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 1}`, `0.5`, nil},
+		{"exclusiveMaximum invalid", `{"exclusiveMaximum": 1}`, `1`, []string{"/: must be less than 1"}},
+		{"number keywords ignore strings", `{"minimum": 1}`, `"0"`, nil},
+
+		{
+			"nested objects and arrays",
+			`{
+				"type": "object",
+				"required": ["user"],
+				"properties": {
+					"user": {
+						"type": "object",
+						"required": ["id"],
+						"properties": {
+							"id": {"type": "integer", "minimum": 1},
+							"roles": {"type": "array", "items": {"enum": ["admin", "member"]}}
+						},
+						"additionalProperties": false
+					}
+				}
+			}`,
+			`{"user": {"id": 7, "roles": ["admin", "member"]}}`,
+			nil,
+		},
+		{
+			"nested objects and arrays invalid",
+			`{
+				"type": "object",
+				"required": ["user"],
+				"properties": {
+					"user": {
+						"type": "object",
+						"required": ["id"],
+						"properties": {
+							"id": {"type": "integer", "minimum": 1},
+							"roles": {"type": "array", "items": {"enum": ["admin", "member"]}},
+							"groups": {"type": "array", "items": {"type": "object", "required": ["name"]}}
+						},
+						"additionalProperties": false
+					}
+				}
+			}`,
+			`{"user": {"id": 0, "roles": ["admin", "owner"], "groups": [{"name": "a"}, {}], "email": "a@b"}}`,
+			[]string{
+				"/user/email: is not allowed",
+				"/user/groups/1: name is required",
+				"/user/id: must be at least 1",
+				`/user/roles/1: must be one of ["admin","member"]`,
+			},
+		},
+		{"missing nested object", `{"required": ["user"], "properties": {"user": {"required": ["id"]}}}`, `{}`, []string{"/: user is required"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, err := Compile([]byte(test.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var document interface{}
+			if err := json.Unmarshal([]byte(test.document), &document); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, violation := range schema.Validate(document) {
+				got = append(got, violation.String())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"not JSON", `{`, "invalid JSON: unexpected EOF"},
+		{"not a schema", `1`, "/: a schema must be an object or a boolean"},
+		{"unknown type", `{"type": "date"}`, `/type: unknown type "date", expected one of null, boolean, object, array, number, integer, string`},
+		{"type not a string", `{"type": 1}`, "/type: must be a string or an array of strings"},
+		{"empty enum", `{"enum": []}`, "/enum: must be a non-empty array"},
+		{"properties not an object", `{"properties": []}`, "/properties: must be an object"},
+		{"nested error", `{"properties": {"a": {"items": {"type": "date"}}}}`, `/properties/a/items/type: unknown type "date", expected one of null, boolean, object, array, number, integer, string`},
+		{"required not strings", `{"required": [1]}`, "/required: must be an array of strings"},
+		{"negative count", `{"minItems": -1}`, "/minItems: must be a non-negative integer"},
+		{"fractional count", `{"maxLength": 1.5}`, "/maxLength: must be a non-negative integer"},
+		{"minimum not a number", `{"minimum": "1"}`, "/minimum: must be a number"},
+		{"invalid pattern", `{"pattern": "("}`, "/pattern: error parsing regexp: missing closing ): `(`"},
+
+		// keywords the validator does not implement are refused, a schema
+		// never checks less than its author expects
+		{"$ref", `{"$ref": "#/definitions/user"}`, "/$ref: unsupported keyword"},
+		{"nested $ref", `{"properties": {"user": {"$ref": "#/definitions/user"}}}`, "/properties/user/$ref: unsupported keyword"},
+		{"definitions", `{"definitions": {"user": {}}}`, "/definitions: unsupported keyword"},
+		{"format", `{"type": "string", "format": "email"}`, "/format: unsupported keyword"},
+		{"allOf", `{"allOf": [{}]}`, "/allOf: unsupported keyword"},
+		{"anyOf", `{"anyOf": [{}]}`, "/anyOf: unsupported keyword"},
+		{"oneOf", `{"oneOf": [{}]}`, "/oneOf: unsupported keyword"},
+		{"not", `{"not": {}}`, "/not: unsupported keyword"},
+		{"if", `{"if": {}}`, "/if: unsupported keyword"},
+		{"patternProperties", `{"patternProperties": {"^a": {}}}`, "/patternProperties: unsupported keyword"},
+		{"uniqueItems", `{"uniqueItems": true}`, "/uniqueItems: unsupported keyword"},
+		{"multipleOf", `{"multipleOf": 2}`, "/multipleOf: unsupported keyword"},
+		{"tuple items", `{"items": [{}]}`, "/items: a schema must be an object or a boolean"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Compile([]byte(test.schema))
+			if err == nil {
+				t.Fatalf("compiled, want %s", test.want)
+			}
+			if err.Error() != test.want {
+				t.Errorf("got %q, want %q", err.Error(), test.want)
+			}
+		})
+	}
+}
@@ -2,23 +2,16 @@ package inspectr
 
 import (
 	"fmt"
+	"time"
 
 	log "github.com/codeamp/logger"
 	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
 	"github.com/jinzhu/gorm"
-	"github.com/spf13/viper"
 	gormigrate "gopkg.in/gormigrate.v1"
 )
 
 func (x *API) Migrate() {
-	db, err := gorm.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s password=%s",
-		viper.GetString("plugins.api.postgres.host"),
-		viper.GetString("plugins.api.postgres.port"),
-		viper.GetString("plugins.api.postgres.user"),
-		viper.GetString("plugins.api.postgres.dbname"),
-		viper.GetString("plugins.api.postgres.sslmode"),
-		viper.GetString("plugins.api.postgres.password"),
-	))
+	db, err := openDB()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -30,6 +23,25 @@ func (x *API) Migrate() {
 		&resolvers.User{},
 		&resolvers.UserPermission{},
 		&resolvers.Trail{},
+		&resolvers.TrailChain{},
+		&resolvers.Checkpoint{},
+		&resolvers.Organization{},
+		&resolvers.Project{},
+		&resolvers.ProjectRole{},
+		&resolvers.RetentionPolicy{},
+		&resolvers.Archive{},
+		&resolvers.ArchivedTrail{},
+		&resolvers.ExportJob{},
+		&resolvers.AlertChannel{},
+		&resolvers.AlertRule{},
+		&resolvers.AlertRuleChannel{},
+		&resolvers.AlertDelivery{},
+		&resolvers.AnomalyBaseline{},
+		&resolvers.Anomaly{},
+		&resolvers.QuarantinedMessage{},
+		&resolvers.TrailKey{},
+		&resolvers.EventType{},
+		&resolvers.SchemaViolation{},
 	)
 
 	m := gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
@@ -60,13 +72,501 @@ func (x *API) Migrate() {
 				return nil
 			},
 		},
+		// index trails for keyset pagination and filtering
+		{
+			ID: "202610180900",
+			Migrate: func(tx *gorm.DB) error {
+				trail := &resolvers.Trail{}
+				if err := tx.Model(trail).AddIndex("idx_trails_created_at_id", "created_at", "id").Error; err != nil {
+					return err
+				}
+
+				for _, column := range []string{"event", "actor", "target", "origin"} {
+					if err := tx.Model(trail).AddIndex(fmt.Sprintf("idx_trails_%s_created_at", column), column, "created_at").Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				trail := &resolvers.Trail{}
+				for _, index := range []string{
+					"idx_trails_created_at_id",
+					"idx_trails_event_created_at",
+					"idx_trails_actor_created_at",
+					"idx_trails_target_created_at",
+					"idx_trails_origin_created_at",
+				} {
+					if err := tx.Model(trail).RemoveIndex(index).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// GIN indexes for metadata containment queries
+		{
+			ID: "202610180910",
+			Migrate: func(tx *gorm.DB) error {
+				for _, column := range resolvers.MetadataColumns {
+					err := tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_trails_%s ON trails USING gin (%s jsonb_path_ops)", column, column)).Error
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, column := range resolvers.MetadataColumns {
+					if err := tx.Exec(fmt.Sprintf("DROP INDEX IF EXISTS idx_trails_%s", column)).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// full-text search vector maintained by trigger, existing trails are
+		// backfilled once migrations ran, see BackfillSearchVectors
+		{
+			ID: "202610180920",
+			Migrate: func(tx *gorm.DB) error {
+				statements := []string{
+					`ALTER TABLE trails ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+					`CREATE OR REPLACE FUNCTION trails_search_vector_update() RETURNS trigger AS $$
+					BEGIN
+						NEW.search_vector :=
+							setweight(to_tsvector('english', coalesce(NEW.event, '') || ' ' || translate(coalesce(NEW.event, ''), '._-:/', '     ')), 'A') ||
+							setweight(to_tsvector('english', coalesce(NEW.actor, '') || ' ' || translate(coalesce(NEW.actor, ''), '._-:/@', '      ')), 'B') ||
+							setweight(to_tsvector('english', coalesce(NEW.target, '') || ' ' || translate(coalesce(NEW.target, ''), '._-:/@', '      ')), 'B') ||
+							setweight(to_tsvector('english', coalesce(NEW.origin, '') || ' ' || translate(coalesce(NEW.origin, ''), '._-:/@', '      ')), 'C') ||
+							setweight(to_tsvector('english', coalesce(NEW.event_metadata, '{}'::jsonb)), 'D') ||
+							setweight(to_tsvector('english', coalesce(NEW.actor_metadata, '{}'::jsonb)), 'D') ||
+							setweight(to_tsvector('english', coalesce(NEW.target_metadata, '{}'::jsonb)), 'D') ||
+							setweight(to_tsvector('english', coalesce(NEW.origin_metadata, '{}'::jsonb)), 'D');
+						RETURN NEW;
+					END
+					$$ LANGUAGE plpgsql`,
+					`DROP TRIGGER IF EXISTS trails_search_vector_update ON trails`,
+					`CREATE TRIGGER trails_search_vector_update BEFORE INSERT OR UPDATE ON trails
+					FOR EACH ROW EXECUTE PROCEDURE trails_search_vector_update()`,
+					`CREATE INDEX IF NOT EXISTS idx_trails_search_vector ON trails USING gin (search_vector)`,
+				}
+
+				for _, statement := range statements {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				statements := []string{
+					`DROP TRIGGER IF EXISTS trails_search_vector_update ON trails`,
+					`DROP FUNCTION IF EXISTS trails_search_vector_update()`,
+					`ALTER TABLE trails DROP COLUMN IF EXISTS search_vector`,
+				}
+
+				for _, statement := range statements {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// unique API keys, users without a key are left out
+		{
+			ID: "202610180930",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS uix_users_api_key ON users (api_key) WHERE api_key <> ''`).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Exec(`DROP INDEX IF EXISTS uix_users_api_key`).Error
+			},
+		},
+		// link existing trails into the hash chain
+		{
+			ID: "202610180940",
+			Migrate: func(tx *gorm.DB) error {
+				// trails are linked per project by 202610180960
+				return tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS uix_trails_sequence ON trails (sequence) WHERE sequence > 0`).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Exec(`DROP INDEX IF EXISTS uix_trails_sequence`).Error
+			},
+		},
+		// checkpoints cover disjoint ranges of a chain
+		{
+			ID: "202610180950",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS uix_checkpoints_chain_from_sequence ON checkpoints (chain, from_sequence)`).Error
+				if err != nil {
+					return err
+				}
+
+				return tx.Exec(`CREATE INDEX IF NOT EXISTS idx_checkpoints_chain_to_sequence ON checkpoints (chain, to_sequence)`).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Exec(`DROP INDEX IF EXISTS idx_checkpoints_chain_to_sequence`).Error; err != nil {
+					return err
+				}
+
+				return tx.Exec(`DROP INDEX IF EXISTS uix_checkpoints_chain_from_sequence`).Error
+			},
+		},
+		// move existing trails, chains and producers into the default project
+		{
+			ID: "202610180960",
+			Migrate: func(tx *gorm.DB) error {
+				project, err := resolvers.CreateProject(tx, resolvers.DefaultTenant)
+				if err != nil {
+					return err
+				}
+
+				statements := []struct {
+					sql  string
+					args []interface{}
+				}{
+					{`CREATE UNIQUE INDEX IF NOT EXISTS uix_projects_organization_id_slug ON projects (organization_id, slug)`, nil},
+					{`CREATE UNIQUE INDEX IF NOT EXISTS uix_project_roles_user_id_project_id_value ON project_roles (user_id, project_id, value)`, nil},
+					{`UPDATE trails SET project_id = ? WHERE project_id IS NULL`, []interface{}{project.ID}},
+					{`UPDATE trail_chains SET name = ? WHERE name = 'default'`, []interface{}{project.ID.String()}},
+					{`UPDATE checkpoints SET chain = ? WHERE chain = 'default'`, []interface{}{project.ID.String()}},
+					{`DROP INDEX IF EXISTS uix_trails_sequence`, nil},
+					{`CREATE UNIQUE INDEX IF NOT EXISTS uix_trails_project_id_sequence ON trails (project_id, sequence) WHERE sequence > 0`, nil},
+					{`CREATE INDEX IF NOT EXISTS idx_trails_project_id_created_at_id ON trails (project_id, created_at, id)`, nil},
+					{`INSERT INTO project_roles (id, created_at, user_id, project_id, value)
+						SELECT uuid_generate_v4(), now(), id, ?, ? FROM users WHERE api_key <> ''
+						ON CONFLICT DO NOTHING`, []interface{}{project.ID, resolvers.RoleProducer}},
+				}
+
+				for _, statement := range statements {
+					if err := tx.Exec(statement.sql, statement.args...).Error; err != nil {
+						return err
+					}
+				}
+
+				return resolvers.LinkUnchainedTrails(tx, project.ID)
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, index := range []string{
+					"idx_trails_project_id_created_at_id",
+					"uix_trails_project_id_sequence",
+					"uix_project_roles_user_id_project_id_value",
+					"uix_projects_organization_id_slug",
+				} {
+					if err := tx.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", index)).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// one retention policy per project and event, index archives
+		{
+			ID: "202610180970",
+			Migrate: func(tx *gorm.DB) error {
+				for _, statement := range []string{
+					`CREATE UNIQUE INDEX IF NOT EXISTS uix_retention_policies_project_id_event ON retention_policies (COALESCE(project_id, '00000000-0000-0000-0000-000000000000'), event)`,
+					`CREATE INDEX IF NOT EXISTS idx_archives_project_id_from_created_at ON archives (project_id, from_created_at)`,
+					`CREATE INDEX IF NOT EXISTS idx_archives_purge_after ON archives (purge_after) WHERE purged_at IS NULL`,
+				} {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, index := range []string{
+					"idx_archives_purge_after",
+					"idx_archives_project_id_from_created_at",
+					"uix_retention_policies_project_id_event",
+				} {
+					if err := tx.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", index)).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// index export jobs by worker queue and requester
+		{
+			ID: "202610180980",
+			Migrate: func(tx *gorm.DB) error {
+				for _, statement := range []string{
+					`CREATE INDEX IF NOT EXISTS idx_export_jobs_status_created_at ON export_jobs (status, created_at)`,
+					`CREATE INDEX IF NOT EXISTS idx_export_jobs_user_id_created_at ON export_jobs (user_id, created_at)`,
+				} {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, index := range []string{
+					"idx_export_jobs_user_id_created_at",
+					"idx_export_jobs_status_created_at",
+				} {
+					if err := tx.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", index)).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// index alert rules by project, deliveries by worker queue, rule and
+		// dedupe key
+		{
+			ID: "202610180990",
+			Migrate: func(tx *gorm.DB) error {
+				for _, statement := range []string{
+					`CREATE INDEX IF NOT EXISTS idx_alert_rules_project_id ON alert_rules (project_id) WHERE enabled`,
+					`CREATE INDEX IF NOT EXISTS idx_alert_channels_project_id ON alert_channels (project_id)`,
+					`CREATE INDEX IF NOT EXISTS idx_alert_rule_channels_channel_id ON alert_rule_channels (channel_id)`,
+					`CREATE INDEX IF NOT EXISTS idx_alert_deliveries_pending ON alert_deliveries (next_attempt_at) WHERE status = 'pending'`,
+					`CREATE INDEX IF NOT EXISTS idx_alert_deliveries_rule_id_created_at ON alert_deliveries (rule_id, created_at)`,
+					`CREATE INDEX IF NOT EXISTS idx_alert_deliveries_rule_id_dedupe_key ON alert_deliveries (rule_id, dedupe_key, created_at)`,
+					`CREATE INDEX IF NOT EXISTS idx_alert_deliveries_project_id_created_at ON alert_deliveries (project_id, created_at)`,
+				} {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, index := range []string{
+					"idx_alert_deliveries_project_id_created_at",
+					"idx_alert_deliveries_rule_id_dedupe_key",
+					"idx_alert_deliveries_rule_id_created_at",
+					"idx_alert_deliveries_pending",
+					"idx_alert_rule_channels_channel_id",
+					"idx_alert_channels_project_id",
+					"idx_alert_rules_project_id",
+				} {
+					if err := tx.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", index)).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// one baseline per series, one anomaly per series and hour
+		{
+			ID: "202610181000",
+			Migrate: func(tx *gorm.DB) error {
+				for _, statement := range []string{
+					`CREATE UNIQUE INDEX IF NOT EXISTS idx_anomaly_baselines_series ON anomaly_baselines (project_id, dimension, value)`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS idx_anomalies_series_starts_at ON anomalies (project_id, dimension, value, starts_at)`,
+					`CREATE INDEX IF NOT EXISTS idx_anomalies_project_id_starts_at ON anomalies (project_id, starts_at)`,
+				} {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, index := range []string{
+					"idx_anomalies_project_id_starts_at",
+					"idx_anomalies_series_starts_at",
+					"idx_anomaly_baselines_series",
+				} {
+					if err := tx.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", index)).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// trail counts per project, event and minute, hour and day, and the
+		// range of trails they cover
+		{
+			ID: "202610181010",
+			Migrate: func(tx *gorm.DB) error {
+				statements := []string{
+					`CREATE TABLE IF NOT EXISTS trail_rollup_state (id integer PRIMARY KEY, rolled_from timestamp with time zone NOT NULL, rolled_until timestamp with time zone NOT NULL)`,
+				}
+				for _, rollup := range resolvers.Rollups {
+					statements = append(statements,
+						fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (project_id uuid NOT NULL, event text NOT NULL, bucket timestamp with time zone NOT NULL, count bigint NOT NULL, PRIMARY KEY (project_id, event, bucket))`, rollup.Table),
+						fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_project_id_bucket ON %s (project_id, bucket)`, rollup.Table, rollup.Table),
+						fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_bucket ON %s (bucket)`, rollup.Table, rollup.Table),
+					)
+				}
+
+				for _, statement := range statements {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				tables := []string{"trail_rollup_state"}
+				for _, rollup := range resolvers.Rollups {
+					tables = append(tables, rollup.Table)
+				}
+
+				for _, table := range tables {
+					if err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", table)).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// partition new installs' trails by created_at, existing installs
+		// migrate online with the partitions migrate command
+		{
+			ID: "202610181020",
+			Migrate: func(tx *gorm.DB) error {
+				var trails struct {
+					Empty bool
+				}
+				if err := tx.Raw(`SELECT NOT EXISTS (SELECT 1 FROM trails) AS empty`).Scan(&trails).Error; err != nil {
+					return err
+				}
+
+				if !trails.Empty {
+					log.Warn("trails are not partitioned, run the partitions migrate command")
+					return nil
+				}
+
+				settings, err := loadPartitionSettings()
+				if err != nil {
+					return err
+				}
+
+				if err := resolvers.PartitionTrails(tx, settings, time.Now(), nil); err != nil {
+					return err
+				}
+
+				return tx.Exec(`DROP TABLE IF EXISTS trails_unpartitioned`).Error
+			},
+			// the partitioned table is kept, it serves every query the
+			// plain one did
+			Rollback: func(tx *gorm.DB) error {
+				return nil
+			},
+		},
+		// quarantined messages are held once per source message
+		{
+			ID: "202610181030",
+			Migrate: func(tx *gorm.DB) error {
+				for _, statement := range []string{
+					`CREATE UNIQUE INDEX IF NOT EXISTS idx_quarantined_messages_source_id ON quarantined_messages (source, source_id) WHERE source_id <> ''`,
+					`CREATE INDEX IF NOT EXISTS idx_quarantined_messages_status_created_at ON quarantined_messages (status, created_at)`,
+					`CREATE INDEX IF NOT EXISTS idx_quarantined_messages_project_id_created_at ON quarantined_messages (project_id, created_at)`,
+				} {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, index := range []string{
+					"idx_quarantined_messages_project_id_created_at",
+					"idx_quarantined_messages_status_created_at",
+					"idx_quarantined_messages_source_id",
+				} {
+					if err := tx.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", index)).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		// expired trail keys are pruned hourly
+		{
+			ID: "202610181040",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.Exec(`CREATE INDEX IF NOT EXISTS idx_trail_keys_expires_at ON trail_keys (expires_at)`).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Exec(`DROP INDEX IF EXISTS idx_trail_keys_expires_at`).Error
+			},
+		},
+		// event types are registered once per project
+		{
+			ID: "202610181050",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_event_types_project_id_name ON event_types (project_id, name)`).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Exec(`DROP INDEX IF EXISTS idx_event_types_project_id_name`).Error
+			},
+		},
+		// replayed trails are announced by the running API, those replayed
+		// before were not and are left as they are
+		{
+			ID: "202610181100",
+			Migrate: func(tx *gorm.DB) error {
+				statements := []string{
+					`UPDATE quarantined_messages SET announced_at = replayed_at WHERE status = 'replayed' AND announced_at IS NULL`,
+					`CREATE INDEX IF NOT EXISTS idx_quarantined_messages_unannounced ON quarantined_messages (replayed_at) WHERE status = 'replayed' AND announced_at IS NULL`,
+				}
+
+				for _, statement := range statements {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Exec(`DROP INDEX IF EXISTS idx_quarantined_messages_unannounced`).Error
+			},
+		},
+		// completion of the search vector backfill, so later starts skip it
+		{
+			ID: "202610181110",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.Exec(`CREATE TABLE IF NOT EXISTS trail_search_backfill (id integer PRIMARY KEY, backfilled_at timestamp with time zone NOT NULL)`).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Exec(`DROP TABLE IF EXISTS trail_search_backfill`).Error
+			},
+		},
 	})
 
 	if err = m.Migrate(); err != nil {
-		log.Fatal("Could not migrate: %v", err)
+		log.Fatal(fmt.Sprintf("Could not migrate: %v", err))
 	}
 
 	log.Info("Migration did run successfully")
 
+	// outside of the migration, so trails stay writable while it runs
+	updated, err := resolvers.BackfillSearchVectors(db, resolvers.SearchBackfillBatch)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Could not backfill search vectors: %v", err))
+	}
+	if updated > 0 {
+		log.Info(fmt.Sprintf("Backfilled the search vectors of %d trails", updated))
+	}
+
 	defer db.Close()
 }
@@ -0,0 +1,230 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Channel types
+const (
+	TypeWebhook = "webhook"
+	TypeSlack   = "slack"
+	TypeSMTP    = "smtp"
+)
+
+// Types
+var Types = []string{TypeWebhook, TypeSlack, TypeSMTP}
+
+// SignatureHeader carries the hex HMAC-SHA256 of webhook bodies, keyed by
+// the channel secret
+const SignatureHeader = "X-Inspectr-Signature"
+
+// requestTimeout time allowed for a webhook request
+const requestTimeout = 30 * time.Second
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// Message is a rendered notification
+type Message struct {
+	// Subject
+	Subject string
+	// Body
+	Body string
+	// Payload JSON document posted to webhooks
+	Payload json.RawMessage
+}
+
+// Notifier delivers messages to a channel
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+// Config of a channel, the fields used depend on its type
+type Config struct {
+	// URL of webhook and slack channels
+	URL string `json:"url,omitempty"`
+	// Headers added to webhook requests
+	Headers map[string]string `json:"headers,omitempty"`
+	// Secret signs webhook bodies, see SignatureHeader
+	Secret string `json:"secret,omitempty"`
+	// To recipients of smtp channels
+	To []string `json:"to,omitempty"`
+}
+
+// Validate checks config holds what a channel of typ needs
+func (c Config) Validate(typ string) error {
+	switch typ {
+	case TypeWebhook, TypeSlack:
+		if !strings.HasPrefix(c.URL, "https://") && !strings.HasPrefix(c.URL, "http://") {
+			return fmt.Errorf("%s channels require an http or https url", typ)
+		}
+	case TypeSMTP:
+		if len(c.To) == 0 {
+			return fmt.Errorf("smtp channels require recipients")
+		}
+		for _, address := range c.To {
+			if !strings.Contains(address, "@") || strings.ContainsAny(address, "\r\n,") {
+				return fmt.Errorf("invalid recipient %q", address)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown channel type %q, expected one of %s", typ, strings.Join(Types, ", "))
+	}
+
+	return nil
+}
+
+// SMTPConfig server smtp channels send through
+type SMTPConfig struct {
+	// Host
+	Host string
+	// Port
+	Port string
+	// Username empty to send without authentication
+	Username string
+	// Password
+	Password string
+	// From sender address
+	From string
+}
+
+// New returns the notifier of a channel of typ
+func New(typ string, config Config, server SMTPConfig) (Notifier, error) {
+	if err := config.Validate(typ); err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case TypeWebhook:
+		return &Webhook{URL: config.URL, Headers: config.Headers, Secret: config.Secret}, nil
+	case TypeSlack:
+		return &Slack{URL: config.URL}, nil
+	default:
+		if server.Host == "" {
+			return nil, fmt.Errorf("no smtp server configured")
+		}
+		return &SMTP{Server: server, To: config.To}, nil
+	}
+}
+
+// Webhook posts the message payload as JSON
+type Webhook struct {
+	// URL
+	URL string
+	// Headers
+	Headers map[string]string
+	// Secret
+	Secret string
+}
+
+// Notify
+func (w *Webhook) Notify(msg Message) error {
+	request, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return err
+	}
+
+	for name, value := range w.Headers {
+		request.Header.Set(name, value)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(msg.Payload)
+		request.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	return post(request)
+}
+
+// Slack posts the message to a Slack-compatible incoming webhook
+type Slack struct {
+	// URL
+	URL string
+}
+
+// Notify
+func (s *Slack) Notify(msg Message) error {
+	text := msg.Body
+	if msg.Subject != "" {
+		text = "*" + msg.Subject + "*\n" + msg.Body
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	return post(request)
+}
+
+// SMTP mails the message
+type SMTP struct {
+	// Server
+	Server SMTPConfig
+	// To
+	To []string
+}
+
+// Notify
+func (s *SMTP) Notify(msg Message) error {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "From: %s\r\n", s.Server.From)
+	fmt.Fprintf(&buffer, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(&buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("\r\n")
+	buffer.WriteString(strings.Replace(msg.Body, "\n", "\r\n", -1))
+
+	var auth smtp.Auth
+	if s.Server.Username != "" {
+		auth = smtp.PlainAuth("", s.Server.Username, s.Server.Password, s.Server.Host)
+	}
+
+	// SendMail upgrades to TLS when the server offers STARTTLS
+	return smtp.SendMail(net.JoinHostPort(s.Server.Host, s.Server.Port), auth, s.Server.From, s.To, buffer.Bytes())
+}
+
+// headerValue removes line breaks from a mail header value and encodes
+// non-ASCII text
+func headerValue(value string) string {
+	return mime.QEncoding.Encode("utf-8", strings.NewReplacer("\r", " ", "\n", " ").Replace(value))
+}
+
+// post sends request, any status but 2xx is an error
+func post(request *http.Request) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("%s responded %s: %s", request.URL.Host, response.Status, strings.TrimSpace(string(body)))
+	}
+
+	io.Copy(ioutil.Discard, response.Body)
+	return nil
+}
@@ -0,0 +1,141 @@
+package inspectr
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/codeamp/logger"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/spf13/viper"
+)
+
+// loadPartitionSettings returns the partition settings configured for the
+// plugin
+func loadPartitionSettings() (resolvers.PartitionSettings, error) {
+	settings := resolvers.DefaultPartitionSettings
+	if period := viper.GetString("plugins.api.partitions.period"); period != "" {
+		settings.Period = period
+	}
+	if viper.IsSet("plugins.api.partitions.ahead") {
+		settings.Ahead = viper.GetInt("plugins.api.partitions.ahead")
+	}
+	if viper.IsSet("plugins.api.partitions.drop") {
+		settings.Drop = viper.GetBool("plugins.api.partitions.drop")
+	}
+
+	if err := settings.Validate(); err != nil {
+		return settings, fmt.Errorf("plugins.api.partitions: %v", err)
+	}
+
+	return settings, nil
+}
+
+// MaintainPartitions creates the partitions of trails ahead of time and
+// removes those whose trails were all archived. It does nothing until
+// trails is partitioned.
+func (x *API) MaintainPartitions() {
+	partitioned, err := resolvers.TrailsPartitioned(x.DB)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if !partitioned {
+		return
+	}
+
+	now := time.Now()
+	created, err := resolvers.CreatePartitions(x.DB, x.Partitions, now)
+	for _, partition := range created {
+		log.InfoWithFields("created trail partition", log.Fields{
+			"partition": partition.Name,
+			"from":      partition.From,
+			"to":        partition.To,
+		})
+	}
+	if err != nil {
+		log.Error(err)
+	}
+
+	expired, err := resolvers.ExpireArchivedPartitions(x.DB, x.Partitions, now)
+	for _, partition := range expired {
+		log.InfoWithFields("removed archived trail partition", log.Fields{
+			"partition": partition.Name,
+			"dropped":   x.Partitions.Drop,
+		})
+	}
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// PartitionTrails converts trails to a partitioned table while the API
+// keeps running
+func (x *API) PartitionTrails() error {
+	settings, err := loadPartitionSettings()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	partitioned, err := resolvers.TrailsPartitioned(db)
+	if err != nil {
+		return err
+	}
+	if partitioned {
+		log.Info("trails are already partitioned")
+		return nil
+	}
+
+	err = resolvers.PartitionTrails(db, settings, time.Now(), func(copied time.Time) {
+		log.InfoWithFields("copied trails", log.Fields{
+			"until": copied.Format("2006-01-02"),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("trails are partitioned, the original table is kept as trails_unpartitioned, drop it once checked")
+	return nil
+}
+
+// ListPartitions prints the partitions of trails
+func (x *API) ListPartitions() error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	partitions, err := resolvers.ListPartitions(db, "trails")
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range partitions {
+		if partition.Default {
+			log.InfoWithFields("trail partition", log.Fields{
+				"partition": partition.Name,
+				"default":   true,
+			})
+			continue
+		}
+
+		log.InfoWithFields("trail partition", log.Fields{
+			"partition": partition.Name,
+			"from":      partition.From.Format(time.RFC3339),
+			"to":        partition.To.Format(time.RFC3339),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package inspectr
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/inspectr/backend/plugins/api/archive"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// countTrails returns the trails of project with event visible in trails
+func countTrails(t *testing.T, db *gorm.DB, project uuid.UUID, event string) int {
+	t.Helper()
+
+	var count int
+	if err := db.Model(&resolvers.Trail{}).Where("project_id = ? AND event = ?", project, event).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestRetentionExpiresMixedPolicyPartition(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	partitioned, err := resolvers.TrailsPartitioned(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !partitioned {
+		t.Skip("trails are not partitioned, PostgreSQL 13 or later is required")
+	}
+
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := archive.NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	old := now.AddDate(0, -6, 0)
+	settings := resolvers.DefaultPartitionSettings
+	if _, err := resolvers.CreatePartitions(db, settings, old); err != nil {
+		t.Fatal(err)
+	}
+
+	archived, err := resolvers.CreateProject(db, "acme/archived")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := resolvers.CreateProject(db, "acme/kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old partition holds trails of a project whose logins are
+	// archived after 30 days and its other events kept, and of a project
+	// without a retention policy
+	for i, trail := range []struct {
+		project uuid.UUID
+		event   string
+	}{
+		{archived.ID, "login"},
+		{archived.ID, "login"},
+		{archived.ID, "keep"},
+		{kept.ID, "login"},
+	} {
+		stored := benchTrail(trail.project, i)
+		stored.Event = trail.event
+		stored.Model.CreatedAt = old.Add(time.Duration(i) * time.Minute)
+		if err := resolvers.CreateTrail(db, &stored, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logins := resolvers.RetentionPolicy{ProjectId: &archived.ID, Event: "login", ArchiveAfterDays: 30}
+	if err := db.Create(&logins).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyRetention(db, store, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// archived trails are no longer visible, the others stay
+	if n := countTrails(t, db, archived.ID, "login"); n != 0 {
+		t.Errorf("%d archived logins still in trails", n)
+	}
+	if n := countTrails(t, db, archived.ID, "keep"); n != 1 {
+		t.Errorf("%d kept trails of the archived project, want 1", n)
+	}
+	if n := countTrails(t, db, kept.ID, "login"); n != 1 {
+		t.Errorf("%d trails of the project without a policy, want 1", n)
+	}
+
+	verification, err := resolvers.VerifyChain(db, archived.ID, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verification.Valid() || verification.Archived != 2 || verification.Checked != 1 {
+		t.Errorf("verified %d trails and %d archived, break %+v, want 1, 2 and none", verification.Checked, verification.Archived, verification.Break)
+	}
+
+	// trails still retained keep the partition, the empty ones after it go
+	name := "trails_p" + resolvers.PartitionStart(old, settings.Period).Format("20060102")
+	expired, err := resolvers.ExpireArchivedPartitions(db, settings, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasPartition(expired, name) {
+		t.Fatalf("expired %s while it holds retained trails", name)
+	}
+
+	// once every trail of the partition is archived it is removed
+	everything := resolvers.RetentionPolicy{ArchiveAfterDays: 30}
+	if err := db.Create(&everything).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := applyRetention(db, store, now); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err = resolvers.ExpireArchivedPartitions(db, settings, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasPartition(expired, name) {
+		t.Fatalf("expired %v, want %s", expired, name)
+	}
+
+	partitions, err := resolvers.ListPartitions(db, "trails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasPartition(partitions, name) {
+		t.Errorf("partition %s is still attached", name)
+	}
+}
+
+// hasPartition reports whether partitions include the one named name
+func hasPartition(partitions []resolvers.Partition, name string) bool {
+	for _, partition := range partitions {
+		if partition.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,429 @@
+package inspectr
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/inspectr/backend/plugins"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// AnnounceReplayedBatch replayed trails claimed at once by AnnounceReplayed
+const AnnounceReplayedBatch = 500
+
+// quarantineTrail holds the message payload of e came in, which failed at
+// stage, and acknowledges it once held. Messages that can not be held are
+// acknowledged as failed, so their source delivers them again.
+func (x *API) quarantineTrail(e transistor.Event, payload plugins.Trail, project *uuid.UUID, stage string, cause error) {
+	// payloads were decoded from JSON, they encode again
+	body, _ := payload.Body()
+
+	x.hold(e, resolvers.QuarantinedMessage{
+		ProjectId: project,
+		Tenant:    payload.Tenant,
+		Source:    payload.Source,
+		SourceId:  payload.SourceID,
+		Body:      string(body),
+		Stage:     stage,
+		Error:     cause.Error(),
+		Attempts:  payload.Attempts,
+	})
+}
+
+// holdMessage holds a message its source could not parse
+func (x *API) holdMessage(e transistor.Event, payload plugins.Quarantine) {
+	var project *uuid.UUID
+	if payload.Tenant != "" {
+		if found, err := resolvers.FindProject(x.DB, payload.Tenant); err == nil {
+			project = &found.ID
+		}
+	}
+
+	x.hold(e, resolvers.QuarantinedMessage{
+		ProjectId: project,
+		Tenant:    payload.Tenant,
+		Source:    payload.Source,
+		SourceId:  payload.SourceID,
+		Body:      payload.Body,
+		Stage:     payload.Stage,
+		Error:     payload.Error,
+		Attempts:  payload.Attempts,
+	})
+}
+
+// hold stores message in the quarantine and acknowledges e
+func (x *API) hold(e transistor.Event, message resolvers.QuarantinedMessage) {
+	if err := resolvers.QuarantineMessage(x.DB, &message); err != nil {
+		log.ErrorWithFields(err.Error(), log.Fields{
+			"source":   message.Source,
+			"sourceId": message.SourceId,
+			"stage":    message.Stage,
+		})
+		x.Events <- e.NewEvent(transistor.GetAction("status"), transistor.GetState("failed"), "ack")
+		return
+	}
+
+	log.WarnWithFields("quarantined message", log.Fields{
+		"id":       message.Model.ID.String(),
+		"source":   message.Source,
+		"sourceId": message.SourceId,
+		"tenant":   message.Tenant,
+		"stage":    message.Stage,
+		"error":    message.Error,
+	})
+
+	x.Events <- e.NewEvent(transistor.GetAction("status"), transistor.GetState("complete"), "ack")
+}
+
+// quarantineFilter returns the filter of the quarantined messages of
+// tenant, every tenant when empty, at stage and with status
+func quarantineFilter(db *gorm.DB, tenant string, stage string, status string, source string) (resolvers.QuarantineFilter, error) {
+	filter := resolvers.QuarantineFilter{}
+	if tenant != "" {
+		project, err := resolvers.FindProject(db, tenant)
+		if err != nil {
+			return filter, err
+		}
+		filter.Project = &project.ID
+	}
+	if stage != "" {
+		filter.Stage = &stage
+	}
+	if status != "" {
+		filter.Status = &status
+	}
+	if source != "" {
+		filter.Source = &source
+	}
+
+	return filter, nil
+}
+
+// ListQuarantined logs up to limit quarantined messages of tenant at stage
+// with status and from source, oldest first
+func (x *API) ListQuarantined(tenant string, stage string, status string, source string, limit int) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	filter, err := quarantineFilter(db, tenant, stage, status, source)
+	if err != nil {
+		return err
+	}
+
+	messages, err := resolvers.FindQuarantinedMessages(db, filter, limit)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		log.InfoWithFields("quarantined message", log.Fields{
+			"id":        message.Model.ID.String(),
+			"createdAt": message.Model.CreatedAt.Format(time.RFC3339),
+			"source":    message.Source,
+			"tenant":    message.Tenant,
+			"stage":     message.Stage,
+			"status":    message.Status,
+			"attempts":  message.Attempts,
+			"error":     message.Error,
+		})
+	}
+
+	return nil
+}
+
+// ShowQuarantined writes the body of the quarantined message with id to
+// stdout, to be fixed up and stored again with EditQuarantined
+func (x *API) ShowQuarantined(id string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	message, err := resolvers.FindQuarantinedMessage(db, id)
+	if err != nil {
+		return err
+	}
+
+	log.InfoWithFields("quarantined message", log.Fields{
+		"id":        message.Model.ID.String(),
+		"createdAt": message.Model.CreatedAt.Format(time.RFC3339),
+		"source":    message.Source,
+		"sourceId":  message.SourceId,
+		"tenant":    message.Tenant,
+		"stage":     message.Stage,
+		"status":    message.Status,
+		"attempts":  message.Attempts,
+		"error":     message.Error,
+	})
+
+	_, err = fmt.Fprintln(os.Stdout, message.Body)
+	return err
+}
+
+// EditQuarantined replaces the body or tenant of the held message with id,
+// when not nil
+func (x *API) EditQuarantined(id string, body *string, tenant *string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	message, err := resolvers.FindQuarantinedMessage(db, id)
+	if err != nil {
+		return err
+	}
+	if message.Status != resolvers.QuarantineHeld {
+		return fmt.Errorf("quarantined message %s is %s", id, message.Status)
+	}
+
+	updates := map[string]interface{}{
+		"updated_at": time.Now(),
+	}
+	if body != nil {
+		updates["body"] = *body
+	}
+	if tenant != nil {
+		updates["tenant"] = *tenant
+		updates["project_id"] = nil
+		if project, err := resolvers.FindProject(db, *tenant); err == nil {
+			updates["project_id"] = project.ID
+		}
+	}
+
+	return db.Model(&message).Updates(updates).Error
+}
+
+// DiscardQuarantined gives up on the held messages with ids
+func (x *API) DiscardQuarantined(ids []string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	for _, id := range ids {
+		message, err := resolvers.FindQuarantinedMessage(db, id)
+		if err != nil {
+			return err
+		}
+		if message.Status != resolvers.QuarantineHeld {
+			return fmt.Errorf("quarantined message %s is %s", id, message.Status)
+		}
+
+		err = db.Model(&message).Updates(map[string]interface{}{
+			"status":     resolvers.QuarantineDiscarded,
+			"updated_at": time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReplayQuarantined stores the trails of the held messages with ids, or of
+// every held message of tenant at stage when ids is empty. Messages that
+// fail again stay held with the new error. The running API announces the
+// stored trails, see AnnounceReplayed.
+func (x *API) ReplayQuarantined(ids []string, tenant string, stage string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	dedupeWindow, err := loadDedupeWindow()
+	if err != nil {
+		return err
+	}
+
+	var messages []resolvers.QuarantinedMessage
+	if len(ids) > 0 {
+		for _, id := range ids {
+			message, err := resolvers.FindQuarantinedMessage(db, id)
+			if err != nil {
+				return err
+			}
+			if message.Status != resolvers.QuarantineHeld {
+				return fmt.Errorf("quarantined message %s is %s", id, message.Status)
+			}
+			messages = append(messages, message)
+		}
+	} else {
+		filter, err := quarantineFilter(db, tenant, stage, resolvers.QuarantineHeld, "")
+		if err != nil {
+			return err
+		}
+
+		messages, err = resolvers.FindQuarantinedMessages(db, filter, -1)
+		if err != nil {
+			return err
+		}
+	}
+
+	replayed := 0
+	for i := range messages {
+		message := &messages[i]
+
+		trail, stage, err := replayMessage(db, message, dedupeWindow)
+		duplicate := err == resolvers.ErrDuplicateTrail
+		if duplicate {
+			log.InfoWithFields("quarantined message was already stored", log.Fields{
+				"id":    message.Model.ID.String(),
+				"trail": trail.Model.ID.String(),
+			})
+		} else if err != nil {
+			log.WarnWithFields("replaying quarantined message failed", log.Fields{
+				"id":    message.Model.ID.String(),
+				"stage": stage,
+				"error": err.Error(),
+			})
+			if err := resolvers.MarkReplayFailed(db, message, stage, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := resolvers.MarkReplayed(db, message, trail.Model.ID, duplicate); err != nil {
+			return err
+		}
+		replayed++
+
+		log.InfoWithFields("replayed quarantined message", log.Fields{
+			"id":    message.Model.ID.String(),
+			"trail": trail.Model.ID.String(),
+		})
+	}
+
+	log.InfoWithFields("replayed quarantined messages", log.Fields{
+		"replayed": replayed,
+		"failed":   len(messages) - replayed,
+	})
+
+	return nil
+}
+
+// AnnounceReplayed publishes the trails replay stored since, evaluates alert
+// rules against them and announces them to plugins, as if they were
+// received
+func (x *API) AnnounceReplayed() {
+	announced := 0
+	for {
+		messages, err := resolvers.ClaimReplayedTrails(x.DB, AnnounceReplayedBatch)
+		if err != nil {
+			log.Error(err)
+		}
+
+		for _, message := range messages {
+			if x.announceReplayed(message) {
+				announced++
+			}
+		}
+
+		if err != nil || len(messages) < AnnounceReplayedBatch {
+			break
+		}
+	}
+
+	if announced > 0 {
+		log.InfoWithFields("announced replayed trails", log.Fields{
+			"trails": announced,
+		})
+	}
+}
+
+// announceReplayed announces the trail message was replayed as
+func (x *API) announceReplayed(message resolvers.QuarantinedMessage) bool {
+	var trail resolvers.Trail
+	if err := x.DB.Where("id = ?", *message.TrailId).Find(&trail).Error; err != nil {
+		log.ErrorWithFields(err.Error(), log.Fields{
+			"id":    message.Model.ID.String(),
+			"trail": message.TrailId.String(),
+		})
+		return false
+	}
+
+	// the body is the one replayed, it parsed then
+	payload, err := plugins.ParseTrail([]byte(message.Body))
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	payload.Tenant = message.Tenant
+	if payload.Tenant == "" {
+		payload.Tenant = resolvers.DefaultTenant
+	}
+
+	x.trailStored(payload, trail)
+	return true
+}
+
+// replayMessage parses, validates and stores the trail of message. On
+// failure it returns the stage that failed. Trails stored within
+// dedupeWindow are returned with resolvers.ErrDuplicateTrail.
+func replayMessage(db *gorm.DB, message *resolvers.QuarantinedMessage, dedupeWindow time.Duration) (resolvers.Trail, string, error) {
+	payload, err := plugins.ParseTrail([]byte(message.Body))
+	if err != nil {
+		return resolvers.Trail{}, resolvers.StageParse, fmt.Errorf("failed to unmarshal message, %v", err)
+	}
+
+	if err := validateTrail(&payload); err != nil {
+		return resolvers.Trail{}, resolvers.StageValidate, err
+	}
+
+	tenant := message.Tenant
+	if tenant == "" {
+		tenant = resolvers.DefaultTenant
+	}
+
+	project, err := resolvers.FindProject(db, tenant)
+	if err != nil {
+		return resolvers.Trail{}, resolvers.StageValidate, err
+	}
+
+	// trails of event types in warn mode are stored despite violations
+	eventTypes, err := resolvers.LoadEventTypes(db, project.ID)
+	if err != nil {
+		return resolvers.Trail{}, resolvers.StageValidate, err
+	}
+	if eventType := eventTypes[payload.Event]; eventType != nil && eventType.Mode != resolvers.SchemaWarn {
+		violations := eventType.Validate(payload.EventMetadata, payload.ActorMetadata, payload.TargetMetadata, payload.OriginMetadata)
+		if len(violations) > 0 {
+			return resolvers.Trail{}, resolvers.StageValidate, schemaError(eventType, violations)
+		}
+	}
+
+	trail := newTrail(project.ID, payload)
+	if message.Source == "sqs" && trail.IdempotencyKey == "" && message.SourceId != "" {
+		trail.IdempotencyKey = "sqs:" + message.SourceId
+	}
+	if err := resolvers.CreateTrail(db, &trail, dedupeWindow); err != nil {
+		if err == resolvers.ErrDuplicateTrail {
+			return trail, "", err
+		}
+		return resolvers.Trail{}, resolvers.StageInsert, err
+	}
+
+	return trail, "", nil
+}
@@ -0,0 +1,37 @@
+package inspectr
+
+import (
+	"encoding/json"
+	"net/http"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+)
+
+// QueryHandler serves GraphQL over HTTP like relay.Handler, reporting the
+// code of authorization failures in the extensions of errors
+type QueryHandler struct {
+	Schema *graphql.Schema
+}
+
+func (h *QueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := resolvers.NewResponse(h.Schema.Exec(r.Context(), params.Query, params.OperationName, params.Variables))
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
@@ -0,0 +1,1479 @@
+package inspectr_resolvers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/codeamp/transistor"
+	redis "github.com/go-redis/redis"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/inspectr/backend/plugins/api/notify"
+	"github.com/jinzhu/gorm"
+	"github.com/jinzhu/gorm/dialects/postgres"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Alert delivery statuses. Deduplicated and throttled deliveries are
+// recorded but never sent.
+const (
+	DeliveryPending      = "pending"
+	DeliverySent         = "sent"
+	DeliveryFailed       = "failed"
+	DeliveryDeduplicated = "deduplicated"
+	DeliveryThrottled    = "throttled"
+)
+
+// Default templates of alert rules
+const (
+	DefaultAlertSubject   = `[inspectr] {{.Rule}}: {{.Event}} by {{.Actor}}`
+	DefaultAlertBody      = "{{.Actor}} {{.Event}} {{.Target}} from {{.Origin}} in {{.Tenant}} at {{.Created.Format \"2006-01-02T15:04:05Z07:00\"}}"
+	DefaultAlertDedupeKey = `{{.Event}} {{.Actor}} {{.Target}}`
+	// DefaultWindowAlertSubject and the following apply to windowed rules
+	DefaultWindowAlertSubject   = `[inspectr] {{.Rule}}: {{.Count}} {{with .Distinct}}distinct {{.}}{{else}}trails{{end}}{{with .Group}} for {{.}}{{end}} in {{.Window}}`
+	DefaultWindowAlertBody      = `{{.Count}} {{with .Distinct}}distinct {{.}} values{{else}}trails{{end}} matched {{.Rule}}{{with .Group}} for {{.}}{{end}} in {{.Tenant}} within {{.Window}}, {{.Condition}} the threshold of {{.Threshold}}`
+	DefaultWindowAlertDedupeKey = `{{.Condition}} {{.Group}}`
+)
+
+// AlertChannel is a destination of alert notifications
+type AlertChannel struct {
+	Model `json:",inline"`
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// Name
+	Name string `json:"name" gorm:"type:varchar(100)"`
+	// Type webhook, slack or smtp
+	Type string `json:"type" gorm:"type:varchar(20)"`
+	// Config notify.Config of the channel, holding its secrets
+	Config postgres.Jsonb `json:"-" gorm:"type:jsonb"`
+}
+
+// NotifyConfig decodes Config
+func (c *AlertChannel) NotifyConfig() (notify.Config, error) {
+	config := notify.Config{}
+	if len(c.Config.RawMessage) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal(c.Config.RawMessage, &config); err != nil {
+		return config, fmt.Errorf("alert channel %s: invalid config: %v", c.Model.ID.String(), err)
+	}
+	return config, nil
+}
+
+// AlertRule notifies its channels of the trails of its project matching
+// Filter. Subject, Body and DedupeKey are text/template templates executed
+// with AlertData.
+//
+// Windowed rules, with a Window, count the matching trails of the last
+// Window seconds instead, per value of GroupBy, and notify when the count
+// reaches Above or falls below Below, see EvaluateAlertWindow and
+// CheckAlertWindows.
+//
+// A match is deduplicated when a notification with the same dedupe key was
+// made within DedupeWindow seconds, and throttled once ThrottleLimit
+// notifications were made within ThrottleWindow seconds. Zero disables
+// either.
+type AlertRule struct {
+	Model `json:",inline"`
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// Name
+	Name string `json:"name" gorm:"type:varchar(100)"`
+	// Enabled
+	Enabled bool `json:"enabled"`
+	// Filter TrailFilter trails are matched with, its projects are ignored
+	Filter postgres.Jsonb `json:"filter" gorm:"type:jsonb"`
+	// Subject template
+	Subject string `json:"subject" gorm:"type:text"`
+	// Body template
+	Body string `json:"body" gorm:"type:text"`
+	// DedupeKey template
+	DedupeKey string `json:"dedupeKey" gorm:"type:text"`
+	// DedupeWindow seconds
+	DedupeWindow int `json:"dedupeWindow"`
+	// ThrottleLimit notifications per throttle window
+	ThrottleLimit int `json:"throttleLimit"`
+	// ThrottleWindow seconds
+	ThrottleWindow int `json:"throttleWindow"`
+	// Window seconds matching trails are counted over, 0 notifies of every
+	// match
+	Window int `json:"window" gorm:"column:window_seconds"`
+	// Aggregate count or distinct_count
+	Aggregate string `json:"aggregate" gorm:"type:varchar(20)"`
+	// DistinctBy dimension whose distinct values are counted
+	DistinctBy string `json:"distinctBy" gorm:"type:varchar(255)"`
+	// GroupBy dimension trails are counted per value of, empty to count
+	// every trail together
+	GroupBy string `json:"groupBy" gorm:"type:varchar(255)"`
+	// Above notifies when the count reaches it, 0 disables
+	Above int `json:"above"`
+	// Below notifies when the count falls below it, 0 disables
+	Below int `json:"below"`
+}
+
+// AlertRuleChannel links a rule to a channel it notifies
+type AlertRuleChannel struct {
+	// RuleId
+	RuleId uuid.UUID `json:"ruleId" gorm:"type:uuid;primary_key"`
+	// ChannelId
+	ChannelId uuid.UUID `json:"channelId" gorm:"type:uuid;primary_key"`
+}
+
+// AlertDelivery records a notification of a channel about a trail, and the
+// attempts to send it
+type AlertDelivery struct {
+	Model `json:",inline"`
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// RuleId
+	RuleId uuid.UUID `json:"ruleId" gorm:"type:uuid"`
+	// ChannelId
+	ChannelId uuid.UUID `json:"channelId" gorm:"type:uuid"`
+	// NotificationId shared by the deliveries of a notification to each
+	// channel of the rule
+	NotificationId uuid.UUID `json:"notificationId" gorm:"type:uuid"`
+	// TrailId trail the rule matched, nil for absence notifications
+	TrailId *uuid.UUID `json:"trailId" gorm:"type:uuid"`
+	// DedupeKey rendered dedupe key
+	DedupeKey string `json:"dedupeKey" gorm:"type:text"`
+	// Status
+	Status string `json:"status" gorm:"type:varchar(20)"`
+	// Attempts number of times sending was tried
+	Attempts int `json:"attempts"`
+	// Error of the last failed attempt
+	Error string `json:"error" gorm:"type:text"`
+	// Subject rendered subject
+	Subject string `json:"subject" gorm:"type:text"`
+	// Body rendered body
+	Body string `json:"body" gorm:"type:text"`
+	// Payload JSON document posted to webhooks
+	Payload postgres.Jsonb `json:"payload" gorm:"type:jsonb"`
+	// NextAttemptAt time the delivery is sent, or sent again
+	NextAttemptAt *time.Time `json:"nextAttemptAt"`
+	// SentAt
+	SentAt *time.Time `json:"sentAt"`
+}
+
+// Message returns the notification of the delivery
+func (d *AlertDelivery) Message() notify.Message {
+	return notify.Message{
+		Subject: d.Subject,
+		Body:    d.Body,
+		Payload: d.Payload.RawMessage,
+	}
+}
+
+// AlertData is what alert templates are executed with. Metadata fields are
+// the decoded metadata documents, e.g. {{.ActorMetadata.ip}}.
+type AlertData struct {
+	// Rule name
+	Rule string
+	// RuleId
+	RuleId string
+	// Tenant organization/project slug
+	Tenant string
+	// TrailId
+	TrailId string
+	// Event
+	Event string
+	// EventMetadata
+	EventMetadata map[string]interface{}
+	// Actor
+	Actor string
+	// ActorMetadata
+	ActorMetadata map[string]interface{}
+	// Target
+	Target string
+	// TargetMetadata
+	TargetMetadata map[string]interface{}
+	// Origin
+	Origin string
+	// OriginMetadata
+	OriginMetadata map[string]interface{}
+	// Created trail timestamp, the time of the check for absence
+	// notifications
+	Created time.Time
+	// Group value of the rule's group by dimension of windowed rules
+	Group string
+	// Distinct dimension counted by windowed rules counting distinct values
+	Distinct string
+	// Count of windowed rules
+	Count int64
+	// Condition "at or above" or "below"
+	Condition string
+	// Threshold the count reached or fell below
+	Threshold int
+	// Window of windowed rules
+	Window time.Duration
+}
+
+// newAlertData returns the template data of trail matched by rule. Trail is
+// nil for absence notifications.
+func newAlertData(rule AlertRule, project Project, trail *Trail) AlertData {
+	data := AlertData{
+		Rule:     rule.Name,
+		RuleId:   rule.Model.ID.String(),
+		Tenant:   project.Tenant(),
+		Created:  time.Now().UTC(),
+		Distinct: rule.DistinctBy,
+		Window:   time.Duration(rule.Window) * time.Second,
+	}
+
+	if trail == nil {
+		trail = &Trail{}
+	} else {
+		data.TrailId = trail.Model.ID.String()
+		data.Created = time.Unix(trail.Timestamp, 0).UTC()
+	}
+	data.Event = trail.Event
+	data.Actor = trail.Actor
+	data.Target = trail.Target
+	data.Origin = trail.Origin
+
+	for _, field := range []struct {
+		value json.RawMessage
+		dest  *map[string]interface{}
+	}{
+		{trail.EventMetadata.RawMessage, &data.EventMetadata},
+		{trail.ActorMetadata.RawMessage, &data.ActorMetadata},
+		{trail.TargetMetadata.RawMessage, &data.TargetMetadata},
+		{trail.OriginMetadata.RawMessage, &data.OriginMetadata},
+	} {
+		// metadata that is not an object is left empty
+		json.Unmarshal(field.value, field.dest)
+		if *field.dest == nil {
+			*field.dest = map[string]interface{}{}
+		}
+	}
+
+	return data
+}
+
+var alertTemplateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// ParseAlertTemplate parses an alert template. Missing metadata keys render
+// as empty values, json, upper and lower are available as functions.
+func ParseAlertTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(alertTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+// renderAlertTemplate executes the template text with data
+func renderAlertTemplate(name string, text string, data AlertData) (string, error) {
+	tmpl, err := ParseAlertTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+
+	// missing keys of maps render as <no value>
+	return strings.Replace(buffer.String(), "<no value>", "", -1), nil
+}
+
+// TrailFilter decodes Filter
+func (r *AlertRule) TrailFilter() (*TrailFilter, error) {
+	var filter *TrailFilter
+	if len(r.Filter.RawMessage) > 0 {
+		if err := json.Unmarshal(r.Filter.RawMessage, &filter); err != nil {
+			return nil, fmt.Errorf("alert rule %s: invalid filter: %v", r.Model.ID.String(), err)
+		}
+	}
+
+	if filter != nil {
+		// rules only match the trails of their project
+		filter.Projects = nil
+	}
+
+	return filter, nil
+}
+
+// Validate
+func (r *AlertRule) Validate(db *gorm.DB) error {
+	if strings.TrimSpace(r.Name) == "" || len(r.Name) > 100 {
+		return fmt.Errorf("name must be between 1 and 100 characters")
+	}
+
+	if r.DedupeWindow < 0 || r.ThrottleLimit < 0 || r.ThrottleWindow < 0 {
+		return fmt.Errorf("dedupe and throttle settings must not be negative")
+	}
+
+	if r.ThrottleLimit > 0 && r.ThrottleWindow == 0 {
+		return fmt.Errorf("throttle limit requires a throttle window")
+	}
+
+	if err := r.validateWindow(); err != nil {
+		return err
+	}
+
+	for name, text := range map[string]string{"subject": r.Subject, "body": r.Body, "dedupe key": r.DedupeKey} {
+		if _, err := ParseAlertTemplate(name, text); err != nil {
+			return err
+		}
+	}
+
+	filter, err := r.TrailFilter()
+	if err != nil {
+		return err
+	}
+
+	_, err = filter.Apply(db)
+	return err
+}
+
+// EvaluateAlerts matches trail against the enabled alert rules of its
+// project and records a delivery for each channel of the rules it matches,
+// windowed rules keep their counts in rds. It returns the number of
+// deliveries left pending for the delivery worker.
+func EvaluateAlerts(db *gorm.DB, rds *redis.Client, trail Trail) (int, error) {
+	var rules []AlertRule
+	if err := db.Where("project_id = ? AND enabled", trail.ProjectId).Order("created_at asc").Find(&rules).Error; err != nil {
+		return 0, err
+	}
+
+	if len(rules) == 0 {
+		return 0, nil
+	}
+
+	project, err := FindProject(db, trail.ProjectId.String())
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	var errs []string
+	for _, rule := range rules {
+		n, err := evaluateAlertRule(db, rds, rule, project, trail)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("alert rule %s: %v", rule.Model.ID.String(), err))
+			continue
+		}
+		pending += n
+	}
+
+	if len(errs) > 0 {
+		return pending, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return pending, nil
+}
+
+// evaluateAlertRule records the deliveries of rule for trail when it
+// matches, returning the number left pending
+func evaluateAlertRule(db *gorm.DB, rds *redis.Client, rule AlertRule, project Project, trail Trail) (int, error) {
+	filter, err := rule.TrailFilter()
+	if err != nil {
+		return 0, err
+	}
+
+	matched, err := filter.Matches(db, trail)
+	if err != nil || !matched {
+		return 0, err
+	}
+
+	if rule.Window > 0 {
+		return EvaluateAlertWindow(db, rds, rule, project, trail, time.Now())
+	}
+
+	return notifyAlertRule(db, rule, newAlertData(rule, project, &trail), &trail)
+}
+
+// notifyAlertRule records a delivery of a notification with data to each
+// channel of rule, unless deduplicated or throttled. It returns the number
+// left pending.
+func notifyAlertRule(db *gorm.DB, rule AlertRule, data AlertData, trail *Trail) (int, error) {
+	var channels []AlertRuleChannel
+	if err := db.Where("rule_id = ?", rule.Model.ID).Find(&channels).Error; err != nil {
+		return 0, err
+	}
+
+	if len(channels) == 0 {
+		return 0, nil
+	}
+
+	delivery := AlertDelivery{
+		ProjectId:      rule.ProjectId,
+		RuleId:         rule.Model.ID,
+		NotificationId: uuid.NewV4(),
+		Status:         DeliveryPending,
+	}
+	if trail != nil {
+		delivery.TrailId = &trail.Model.ID
+	}
+
+	// a rule whose templates fail still records why it did not notify
+	var err error
+	if delivery.Subject, err = renderAlertTemplate("subject", rule.Subject, data); err == nil {
+		if delivery.Body, err = renderAlertTemplate("body", rule.Body, data); err == nil {
+			delivery.DedupeKey, err = renderAlertTemplate("dedupe key", rule.DedupeKey, data)
+		}
+	}
+	if err != nil {
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+	}
+
+	document := map[string]interface{}{
+		"rule": map[string]string{
+			"id":   rule.Model.ID.String(),
+			"name": rule.Name,
+		},
+		"tenant":  data.Tenant,
+		"subject": delivery.Subject,
+		"body":    delivery.Body,
+		"trail":   trail,
+	}
+	if rule.Window > 0 {
+		document["window"] = map[string]interface{}{
+			"seconds":   rule.Window,
+			"group":     data.Group,
+			"count":     data.Count,
+			"condition": data.Condition,
+			"threshold": data.Threshold,
+		}
+	}
+
+	payload, err := json.Marshal(document)
+	if err != nil {
+		return 0, err
+	}
+	delivery.Payload = postgres.Jsonb{RawMessage: payload}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	// serializes the dedupe and throttle checks of the rule across replicas
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "inspectr:alert:"+rule.Model.ID.String()).Error; err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if delivery.Status == DeliveryPending {
+		if delivery.Status, err = suppression(tx, rule, delivery.DedupeKey, time.Now()); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	now := time.Now()
+	delivery.NextAttemptAt = &now
+
+	for _, channel := range channels {
+		channelDelivery := delivery
+		channelDelivery.ChannelId = channel.ChannelId
+		if err := tx.Create(&channelDelivery).Error; err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+
+	if delivery.Status != DeliveryPending {
+		return 0, nil
+	}
+	return len(channels), nil
+}
+
+// suppression returns the status of a notification by rule with dedupeKey
+// at now: DeliveryDeduplicated, DeliveryThrottled, or DeliveryPending when
+// it is sent
+func suppression(tx *gorm.DB, rule AlertRule, dedupeKey string, now time.Time) (string, error) {
+	notified := []string{DeliveryPending, DeliverySent, DeliveryFailed}
+
+	if rule.DedupeWindow > 0 {
+		var count int
+		err := tx.Model(&AlertDelivery{}).
+			Where("rule_id = ? AND dedupe_key = ? AND status IN (?) AND created_at > ?", rule.Model.ID, dedupeKey, notified, now.Add(-time.Duration(rule.DedupeWindow)*time.Second)).
+			Count(&count).Error
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return DeliveryDeduplicated, nil
+		}
+	}
+
+	if rule.ThrottleLimit > 0 {
+		var count int
+		err := tx.Raw(`SELECT COUNT(DISTINCT COALESCE(notification_id, id)) FROM alert_deliveries
+		WHERE rule_id = ? AND status IN (?) AND created_at > ?`, rule.Model.ID, notified, now.Add(-time.Duration(rule.ThrottleWindow)*time.Second)).
+			Row().Scan(&count)
+		if err != nil {
+			return "", err
+		}
+		if count >= rule.ThrottleLimit {
+			return DeliveryThrottled, nil
+		}
+	}
+
+	return DeliveryPending, nil
+}
+
+// canWriteAlerts reports whether the viewer of ctx may change the alert
+// rules and channels of project
+func canWriteAlerts(ctx context.Context, project uuid.UUID) bool {
+	if HasScope(ctx, ScopeAlertsWrite) {
+		return true
+	}
+
+	viewer := ViewerFromContext(ctx)
+	return viewer != nil && transistor.SliceContains(project.String(), viewer.ProjectIDs(RoleAdmin))
+}
+
+// alertProject returns the project identified by id when the viewer of ctx
+// may change its alerts
+func (r *Resolver) alertProject(ctx context.Context, id graphql.ID) (Project, error) {
+	project, err := ViewerProject(ctx, r.DB, &id)
+	if err != nil {
+		return project, err
+	}
+
+	if !canWriteAlerts(ctx, project.Model.ID) {
+		return Project{}, &AuthError{Code: CodeForbidden, Message: "changing alerts requires the admin role in the project"}
+	}
+
+	return project, nil
+}
+
+// AlertChannelInput
+type AlertChannelInput struct {
+	// Name
+	Name *string
+	// Type
+	Type *string
+	// Url
+	Url *string
+	// Headers
+	Headers *[]AlertHeaderInput
+	// Secret
+	Secret *string
+	// To
+	To *[]string
+}
+
+// AlertHeaderInput
+type AlertHeaderInput struct {
+	// Name
+	Name string
+	// Value
+	Value string
+}
+
+// apply sets the fields of input that are set on channel
+func (input *AlertChannelInput) apply(channel *AlertChannel) error {
+	if input.Name != nil {
+		channel.Name = strings.TrimSpace(*input.Name)
+	}
+	if channel.Name == "" || len(channel.Name) > 100 {
+		return fmt.Errorf("name must be between 1 and 100 characters")
+	}
+
+	if input.Type != nil {
+		channel.Type = strings.ToLower(*input.Type)
+	}
+
+	config, err := channel.NotifyConfig()
+	if err != nil {
+		return err
+	}
+
+	if input.Url != nil {
+		config.URL = strings.TrimSpace(*input.Url)
+	}
+	if input.Headers != nil {
+		config.Headers = map[string]string{}
+		for _, header := range *input.Headers {
+			config.Headers[header.Name] = header.Value
+		}
+	}
+	if input.Secret != nil {
+		config.Secret = *input.Secret
+	}
+	if input.To != nil {
+		config.To = *input.To
+	}
+
+	if err := config.Validate(channel.Type); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	channel.Config = postgres.Jsonb{RawMessage: encoded}
+
+	return nil
+}
+
+// AlertRuleInput
+type AlertRuleInput struct {
+	// Name
+	Name string
+	// Enabled
+	Enabled *bool
+	// Filter
+	Filter *TrailFilter
+	// Channels
+	Channels []graphql.ID
+	// Subject
+	Subject *string
+	// Body
+	Body *string
+	// DedupeKey
+	DedupeKey *string
+	// DedupeWindow
+	DedupeWindow *int32
+	// ThrottleLimit
+	ThrottleLimit *int32
+	// ThrottleWindow
+	ThrottleWindow *int32
+	// Window
+	Window *int32
+	// Aggregate
+	Aggregate *string
+	// DistinctBy
+	DistinctBy *string
+	// GroupBy
+	GroupBy *string
+	// Above
+	Above *int32
+	// Below
+	Below *int32
+}
+
+// apply replaces the settings of rule with input, returning the channels
+// the rule notifies
+func (input *AlertRuleInput) apply(db *gorm.DB, rule *AlertRule) ([]uuid.UUID, error) {
+	if input.Filter != nil && input.Filter.Projects != nil {
+		return nil, fmt.Errorf("alert rules match the trails of their project, filter.projects must be omitted")
+	}
+
+	filter, err := json.Marshal(input.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.Name = strings.TrimSpace(input.Name)
+	rule.Enabled = input.Enabled == nil || *input.Enabled
+	rule.Filter = postgres.Jsonb{RawMessage: filter}
+	rule.DedupeWindow = int(int32Or(input.DedupeWindow, 0))
+	rule.ThrottleLimit = int(int32Or(input.ThrottleLimit, 0))
+	rule.ThrottleWindow = int(int32Or(input.ThrottleWindow, 0))
+	rule.Window = int(int32Or(input.Window, 0))
+	rule.Aggregate = ""
+	if input.Aggregate != nil {
+		rule.Aggregate = strings.ToLower(*input.Aggregate)
+	}
+	rule.DistinctBy = strings.TrimSpace(stringOr(input.DistinctBy, ""))
+	rule.GroupBy = strings.TrimSpace(stringOr(input.GroupBy, ""))
+	rule.Above = int(int32Or(input.Above, 0))
+	rule.Below = int(int32Or(input.Below, 0))
+
+	if rule.Window > 0 {
+		if rule.Aggregate == "" {
+			rule.Aggregate = AggregateCount
+		}
+		rule.Subject = stringOr(input.Subject, DefaultWindowAlertSubject)
+		rule.Body = stringOr(input.Body, DefaultWindowAlertBody)
+		rule.DedupeKey = stringOr(input.DedupeKey, DefaultWindowAlertDedupeKey)
+	} else {
+		rule.Subject = stringOr(input.Subject, DefaultAlertSubject)
+		rule.Body = stringOr(input.Body, DefaultAlertBody)
+		rule.DedupeKey = stringOr(input.DedupeKey, DefaultAlertDedupeKey)
+	}
+
+	if err := rule.Validate(db); err != nil {
+		return nil, err
+	}
+
+	if len(input.Channels) == 0 {
+		return nil, fmt.Errorf("alert rules require at least one channel")
+	}
+
+	var ids []uuid.UUID
+	for _, id := range input.Channels {
+		channel, err := uuid.FromString(string(id))
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel id: %v", err)
+		}
+		ids = append(ids, channel)
+	}
+
+	var count int
+	if err := db.Model(&AlertChannel{}).Where("id IN (?) AND project_id = ?", ids, rule.ProjectId).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count != len(ids) {
+		return nil, fmt.Errorf("channels must belong to the rule's project")
+	}
+
+	return ids, nil
+}
+
+func stringOr(value *string, fallback string) string {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+func int32Or(value *int32, fallback int32) int32 {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+// setRuleChannels replaces the channels rule notifies
+func setRuleChannels(tx *gorm.DB, rule uuid.UUID, channels []uuid.UUID) error {
+	if err := tx.Where("rule_id = ?", rule).Delete(&AlertRuleChannel{}).Error; err != nil {
+		return err
+	}
+
+	for _, channel := range channels {
+		if err := tx.Create(&AlertRuleChannel{RuleId: rule, ChannelId: channel}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findAlertRule returns the rule identified by id when the viewer of ctx
+// may change it
+func (r *Resolver) findAlertRule(ctx context.Context, id graphql.ID) (AlertRule, error) {
+	rule := AlertRule{}
+
+	ruleId, err := uuid.FromString(string(id))
+	if err != nil {
+		return rule, fmt.Errorf("invalid alert rule id: %v", err)
+	}
+
+	if err := ScopeProjects(ctx, r.DB, "project_id").Where("id = ?", ruleId).Find(&rule).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rule, fmt.Errorf("alert rule %q not found", string(id))
+		}
+		return rule, err
+	}
+
+	if !canWriteAlerts(ctx, rule.ProjectId) {
+		return rule, &AuthError{Code: CodeForbidden, Message: "changing alerts requires the admin role in the project"}
+	}
+
+	return rule, nil
+}
+
+// findAlertChannel returns the channel identified by id when the viewer of
+// ctx may change it
+func (r *Resolver) findAlertChannel(ctx context.Context, id graphql.ID) (AlertChannel, error) {
+	channel := AlertChannel{}
+
+	channelId, err := uuid.FromString(string(id))
+	if err != nil {
+		return channel, fmt.Errorf("invalid alert channel id: %v", err)
+	}
+
+	if err := ScopeProjects(ctx, r.DB, "project_id").Where("id = ?", channelId).Find(&channel).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return channel, fmt.Errorf("alert channel %q not found", string(id))
+		}
+		return channel, err
+	}
+
+	if !canWriteAlerts(ctx, channel.ProjectId) {
+		return channel, &AuthError{Code: CodeForbidden, Message: "changing alerts requires the admin role in the project"}
+	}
+
+	return channel, nil
+}
+
+// AlertRules of a project
+func (r *Resolver) AlertRules(ctx context.Context, args *struct {
+	Project *graphql.ID
+}) ([]*AlertRuleResolver, error) {
+	if err := Authorize(ctx, "alertRules"); err != nil {
+		return nil, err
+	}
+
+	project, err := ViewerProject(ctx, r.DB, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []AlertRule
+	if err := r.DB.Where("project_id = ?", project.Model.ID).Order("created_at asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*AlertRuleResolver, len(rows))
+	for i, rule := range rows {
+		results[i] = &AlertRuleResolver{AlertRule: rule, DB: r.DB}
+	}
+
+	return results, nil
+}
+
+// AlertChannels of a project
+func (r *Resolver) AlertChannels(ctx context.Context, args *struct {
+	Project *graphql.ID
+}) ([]*AlertChannelResolver, error) {
+	if err := Authorize(ctx, "alertChannels"); err != nil {
+		return nil, err
+	}
+
+	project, err := ViewerProject(ctx, r.DB, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []AlertChannel
+	if err := r.DB.Where("project_id = ?", project.Model.ID).Order("created_at asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*AlertChannelResolver, len(rows))
+	for i, channel := range rows {
+		results[i] = &AlertChannelResolver{AlertChannel: channel}
+	}
+
+	return results, nil
+}
+
+// AlertDeliveries of a project, newest first
+func (r *Resolver) AlertDeliveries(ctx context.Context, args *struct {
+	Project *graphql.ID
+	Rule    *graphql.ID
+	Status  *string
+	First   *int32
+}) ([]*AlertDeliveryResolver, error) {
+	if err := Authorize(ctx, "alertDeliveries"); err != nil {
+		return nil, err
+	}
+
+	project, err := ViewerProject(ctx, r.DB, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	first := int32(DefaultPageSize)
+	if args.First != nil {
+		if *args.First < 0 || *args.First > MaxPageSize {
+			return nil, fmt.Errorf("first must be between 0 and %d", MaxPageSize)
+		}
+		first = *args.First
+	}
+
+	query := r.DB.Where("project_id = ?", project.Model.ID)
+	if args.Rule != nil {
+		rule, err := uuid.FromString(string(*args.Rule))
+		if err != nil {
+			return nil, fmt.Errorf("invalid alert rule id: %v", err)
+		}
+		query = query.Where("rule_id = ?", rule)
+	}
+	if args.Status != nil {
+		query = query.Where("status = ?", strings.ToLower(*args.Status))
+	}
+
+	var rows []AlertDelivery
+	if err := query.Order("created_at desc").Limit(first).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*AlertDeliveryResolver, len(rows))
+	for i, delivery := range rows {
+		results[i] = &AlertDeliveryResolver{AlertDelivery: delivery, DB: r.DB}
+	}
+
+	return results, nil
+}
+
+// CreateAlertChannel
+func (r *Resolver) CreateAlertChannel(ctx context.Context, args *struct {
+	Project graphql.ID
+	Input   AlertChannelInput
+}) (*AlertChannelResolver, error) {
+	if err := Authorize(ctx, "createAlertChannel"); err != nil {
+		return nil, err
+	}
+
+	project, err := r.alertProject(ctx, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Input.Type == nil {
+		return nil, fmt.Errorf("type is required")
+	}
+
+	channel := AlertChannel{ProjectId: project.Model.ID}
+	if err := args.Input.apply(&channel); err != nil {
+		return nil, err
+	}
+
+	if err := r.DB.Create(&channel).Error; err != nil {
+		return nil, err
+	}
+
+	return &AlertChannelResolver{AlertChannel: channel}, nil
+}
+
+// UpdateAlertChannel changes the fields of input that are set, the type of
+// a channel can not be changed
+func (r *Resolver) UpdateAlertChannel(ctx context.Context, args *struct {
+	ID    graphql.ID
+	Input AlertChannelInput
+}) (*AlertChannelResolver, error) {
+	if err := Authorize(ctx, "updateAlertChannel"); err != nil {
+		return nil, err
+	}
+
+	channel, err := r.findAlertChannel(ctx, args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Input.Type != nil && strings.ToLower(*args.Input.Type) != channel.Type {
+		return nil, fmt.Errorf("the type of a channel can not be changed")
+	}
+
+	if err := args.Input.apply(&channel); err != nil {
+		return nil, err
+	}
+
+	if err := r.DB.Model(&channel).Updates(map[string]interface{}{
+		"name":   channel.Name,
+		"config": channel.Config,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return &AlertChannelResolver{AlertChannel: channel}, nil
+}
+
+// DeleteAlertChannel removes a channel from the rules notifying it. Its
+// pending deliveries fail.
+func (r *Resolver) DeleteAlertChannel(ctx context.Context, args *struct {
+	ID graphql.ID
+}) (bool, error) {
+	if err := Authorize(ctx, "deleteAlertChannel"); err != nil {
+		return false, err
+	}
+
+	channel, err := r.findAlertChannel(ctx, args.ID)
+	if err != nil {
+		return false, err
+	}
+
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+
+	err = tx.Where("channel_id = ?", channel.Model.ID).Delete(&AlertRuleChannel{}).Error
+	if err == nil {
+		err = tx.Model(&AlertDelivery{}).Where("channel_id = ? AND status = ?", channel.Model.ID, DeliveryPending).Updates(map[string]interface{}{
+			"status": DeliveryFailed,
+			"error":  "channel deleted",
+		}).Error
+	}
+	if err == nil {
+		err = tx.Delete(&channel).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit().Error
+}
+
+// CreateAlertRule
+func (r *Resolver) CreateAlertRule(ctx context.Context, args *struct {
+	Project graphql.ID
+	Input   AlertRuleInput
+}) (*AlertRuleResolver, error) {
+	if err := Authorize(ctx, "createAlertRule"); err != nil {
+		return nil, err
+	}
+
+	project, err := r.alertProject(ctx, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := AlertRule{ProjectId: project.Model.ID}
+	channels, err := args.Input.apply(r.DB, &rule)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	err = tx.Create(&rule).Error
+	if err == nil {
+		err = setRuleChannels(tx, rule.Model.ID, channels)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return &AlertRuleResolver{AlertRule: rule, DB: r.DB}, nil
+}
+
+// UpdateAlertRule replaces the settings of a rule with input
+func (r *Resolver) UpdateAlertRule(ctx context.Context, args *struct {
+	ID    graphql.ID
+	Input AlertRuleInput
+}) (*AlertRuleResolver, error) {
+	if err := Authorize(ctx, "updateAlertRule"); err != nil {
+		return nil, err
+	}
+
+	rule, err := r.findAlertRule(ctx, args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := args.Input.apply(r.DB, &rule)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	err = tx.Model(&rule).Updates(map[string]interface{}{
+		"name":            rule.Name,
+		"enabled":         rule.Enabled,
+		"filter":          rule.Filter,
+		"subject":         rule.Subject,
+		"body":            rule.Body,
+		"dedupe_key":      rule.DedupeKey,
+		"dedupe_window":   rule.DedupeWindow,
+		"throttle_limit":  rule.ThrottleLimit,
+		"throttle_window": rule.ThrottleWindow,
+		"window_seconds":  rule.Window,
+		"aggregate":       rule.Aggregate,
+		"distinct_by":     rule.DistinctBy,
+		"group_by":        rule.GroupBy,
+		"above":           rule.Above,
+		"below":           rule.Below,
+	}).Error
+	if err == nil {
+		err = setRuleChannels(tx, rule.Model.ID, channels)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	// counts of the previous settings no longer apply
+	ResetAlertWindows(r.Redis, rule.Model.ID)
+
+	return &AlertRuleResolver{AlertRule: rule, DB: r.DB}, nil
+}
+
+// DeleteAlertRule deletes a rule, its pending deliveries fail. The delivery
+// log of the rule is kept.
+func (r *Resolver) DeleteAlertRule(ctx context.Context, args *struct {
+	ID graphql.ID
+}) (bool, error) {
+	if err := Authorize(ctx, "deleteAlertRule"); err != nil {
+		return false, err
+	}
+
+	rule, err := r.findAlertRule(ctx, args.ID)
+	if err != nil {
+		return false, err
+	}
+
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+
+	err = setRuleChannels(tx, rule.Model.ID, nil)
+	if err == nil {
+		err = tx.Model(&AlertDelivery{}).Where("rule_id = ? AND status = ?", rule.Model.ID, DeliveryPending).Updates(map[string]interface{}{
+			"status": DeliveryFailed,
+			"error":  "rule deleted",
+		}).Error
+	}
+	if err == nil {
+		err = tx.Delete(&rule).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+
+	ResetAlertWindows(r.Redis, rule.Model.ID)
+
+	return true, nil
+}
+
+// AlertRuleResolver resolver for AlertRule
+type AlertRuleResolver struct {
+	AlertRule
+	DB *gorm.DB
+}
+
+// ID
+func (r *AlertRuleResolver) ID() graphql.ID {
+	return graphql.ID(r.AlertRule.Model.ID.String())
+}
+
+// ProjectId
+func (r *AlertRuleResolver) ProjectId() graphql.ID {
+	return graphql.ID(r.AlertRule.ProjectId.String())
+}
+
+// Name
+func (r *AlertRuleResolver) Name() string {
+	return r.AlertRule.Name
+}
+
+// Enabled
+func (r *AlertRuleResolver) Enabled() bool {
+	return r.AlertRule.Enabled
+}
+
+// Filter
+func (r *AlertRuleResolver) Filter() (*JSON, error) {
+	filter, err := r.AlertRule.TrailFilter()
+	if err != nil || filter == nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	return &JSON{encoded}, nil
+}
+
+// Channels
+func (r *AlertRuleResolver) Channels() ([]*AlertChannelResolver, error) {
+	var rows []AlertChannel
+	err := r.DB.
+		Joins("JOIN alert_rule_channels ON alert_rule_channels.channel_id = alert_channels.id").
+		Where("alert_rule_channels.rule_id = ?", r.AlertRule.Model.ID).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Name < rows[j].Name
+	})
+
+	results := make([]*AlertChannelResolver, len(rows))
+	for i, channel := range rows {
+		results[i] = &AlertChannelResolver{AlertChannel: channel}
+	}
+
+	return results, nil
+}
+
+// Subject
+func (r *AlertRuleResolver) Subject() string {
+	return r.AlertRule.Subject
+}
+
+// Body
+func (r *AlertRuleResolver) Body() string {
+	return r.AlertRule.Body
+}
+
+// DedupeKey
+func (r *AlertRuleResolver) DedupeKey() string {
+	return r.AlertRule.DedupeKey
+}
+
+// DedupeWindow
+func (r *AlertRuleResolver) DedupeWindow() int32 {
+	return int32(r.AlertRule.DedupeWindow)
+}
+
+// ThrottleLimit
+func (r *AlertRuleResolver) ThrottleLimit() int32 {
+	return int32(r.AlertRule.ThrottleLimit)
+}
+
+// ThrottleWindow
+func (r *AlertRuleResolver) ThrottleWindow() int32 {
+	return int32(r.AlertRule.ThrottleWindow)
+}
+
+// Window
+func (r *AlertRuleResolver) Window() int32 {
+	return int32(r.AlertRule.Window)
+}
+
+// Aggregate null unless windowed
+func (r *AlertRuleResolver) Aggregate() *string {
+	if r.AlertRule.Window == 0 {
+		return nil
+	}
+	aggregate := strings.ToUpper(r.AlertRule.Aggregate)
+	return &aggregate
+}
+
+// DistinctBy
+func (r *AlertRuleResolver) DistinctBy() *string {
+	if r.AlertRule.DistinctBy == "" {
+		return nil
+	}
+	return &r.AlertRule.DistinctBy
+}
+
+// GroupBy
+func (r *AlertRuleResolver) GroupBy() *string {
+	if r.AlertRule.GroupBy == "" {
+		return nil
+	}
+	return &r.AlertRule.GroupBy
+}
+
+// Above
+func (r *AlertRuleResolver) Above() *int32 {
+	if r.AlertRule.Above == 0 {
+		return nil
+	}
+	above := int32(r.AlertRule.Above)
+	return &above
+}
+
+// Below
+func (r *AlertRuleResolver) Below() *int32 {
+	if r.AlertRule.Below == 0 {
+		return nil
+	}
+	below := int32(r.AlertRule.Below)
+	return &below
+}
+
+// CreatedAt
+func (r *AlertRuleResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.AlertRule.Model.CreatedAt}
+}
+
+// AlertChannelResolver resolver for AlertChannel. URLs and secrets are not
+// returned, webhook URLs often embed credentials.
+type AlertChannelResolver struct {
+	AlertChannel
+}
+
+// ID
+func (r *AlertChannelResolver) ID() graphql.ID {
+	return graphql.ID(r.AlertChannel.Model.ID.String())
+}
+
+// ProjectId
+func (r *AlertChannelResolver) ProjectId() graphql.ID {
+	return graphql.ID(r.AlertChannel.ProjectId.String())
+}
+
+// Name
+func (r *AlertChannelResolver) Name() string {
+	return r.AlertChannel.Name
+}
+
+// Type
+func (r *AlertChannelResolver) Type() string {
+	return strings.ToUpper(r.AlertChannel.Type)
+}
+
+// Host of the URL of webhook and slack channels
+func (r *AlertChannelResolver) Host() (*string, error) {
+	config, err := r.AlertChannel.NotifyConfig()
+	if err != nil || config.URL == "" {
+		return nil, err
+	}
+
+	parsed, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, nil
+	}
+	return &parsed.Host, nil
+}
+
+// Headers names of the headers added to webhook requests
+func (r *AlertChannelResolver) Headers() ([]string, error) {
+	config, err := r.AlertChannel.NotifyConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := []string{}
+	for name := range config.Headers {
+		headers = append(headers, name)
+	}
+	sort.Strings(headers)
+
+	return headers, nil
+}
+
+// HasSecret
+func (r *AlertChannelResolver) HasSecret() (bool, error) {
+	config, err := r.AlertChannel.NotifyConfig()
+	return config.Secret != "", err
+}
+
+// To
+func (r *AlertChannelResolver) To() ([]string, error) {
+	config, err := r.AlertChannel.NotifyConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.To == nil {
+		return []string{}, nil
+	}
+	return config.To, nil
+}
+
+// CreatedAt
+func (r *AlertChannelResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.AlertChannel.Model.CreatedAt}
+}
+
+// AlertDeliveryResolver resolver for AlertDelivery
+type AlertDeliveryResolver struct {
+	AlertDelivery
+	DB *gorm.DB
+}
+
+// ID
+func (r *AlertDeliveryResolver) ID() graphql.ID {
+	return graphql.ID(r.AlertDelivery.Model.ID.String())
+}
+
+// RuleId
+func (r *AlertDeliveryResolver) RuleId() graphql.ID {
+	return graphql.ID(r.AlertDelivery.RuleId.String())
+}
+
+// Rule null once the rule is deleted
+func (r *AlertDeliveryResolver) Rule() (*AlertRuleResolver, error) {
+	rule := AlertRule{}
+	if err := r.DB.Where("id = ?", r.AlertDelivery.RuleId).Find(&rule).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &AlertRuleResolver{AlertRule: rule, DB: r.DB}, nil
+}
+
+// ChannelId
+func (r *AlertDeliveryResolver) ChannelId() graphql.ID {
+	return graphql.ID(r.AlertDelivery.ChannelId.String())
+}
+
+// TrailId null for absence notifications
+func (r *AlertDeliveryResolver) TrailId() *graphql.ID {
+	if r.AlertDelivery.TrailId == nil {
+		return nil
+	}
+	id := graphql.ID(r.AlertDelivery.TrailId.String())
+	return &id
+}
+
+// DedupeKey
+func (r *AlertDeliveryResolver) DedupeKey() string {
+	return r.AlertDelivery.DedupeKey
+}
+
+// Status
+func (r *AlertDeliveryResolver) Status() string {
+	return strings.ToUpper(r.AlertDelivery.Status)
+}
+
+// Attempts
+func (r *AlertDeliveryResolver) Attempts() int32 {
+	return int32(r.AlertDelivery.Attempts)
+}
+
+// Error
+func (r *AlertDeliveryResolver) Error() *string {
+	if r.AlertDelivery.Error == "" {
+		return nil
+	}
+	return &r.AlertDelivery.Error
+}
+
+// Subject
+func (r *AlertDeliveryResolver) Subject() string {
+	return r.AlertDelivery.Subject
+}
+
+// Body
+func (r *AlertDeliveryResolver) Body() string {
+	return r.AlertDelivery.Body
+}
+
+// CreatedAt
+func (r *AlertDeliveryResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.AlertDelivery.Model.CreatedAt}
+}
+
+// NextAttemptAt null once the delivery is no longer pending
+func (r *AlertDeliveryResolver) NextAttemptAt() *graphql.Time {
+	if r.AlertDelivery.Status != DeliveryPending || r.AlertDelivery.NextAttemptAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.AlertDelivery.NextAttemptAt}
+}
+
+// SentAt
+func (r *AlertDeliveryResolver) SentAt() *graphql.Time {
+	if r.AlertDelivery.SentAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.AlertDelivery.SentAt}
+}
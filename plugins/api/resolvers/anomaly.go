@@ -0,0 +1,522 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/jinzhu/gorm"
+	"github.com/jinzhu/gorm/dialects/postgres"
+	uuid "github.com/satori/go.uuid"
+)
+
+// AnomalyColumns maps the AnomalyDimension enum to the trail columns
+// baselines are learned for
+var AnomalyColumns = map[string]string{
+	"EVENT": "event",
+	"ACTOR": "actor",
+}
+
+// Anomaly directions
+const (
+	AnomalySpike = "spike"
+	AnomalyDrop  = "drop"
+)
+
+// minTrainingSpan history a series needs before a baseline is learned, two
+// of every weekday
+const minTrainingSpan = 14 * Day
+
+// AnomalySettings tune training and detection
+type AnomalySettings struct {
+	// TrainingDays history baselines are learned from
+	TrainingDays int
+	// MaxSeries values of each dimension baselines are learned for per
+	// project, the most frequent ones
+	MaxSeries int
+	// Threshold score, in standard deviations, from which a count is
+	// anomalous
+	Threshold float64
+	// MinDeviation difference between the observed and expected count below
+	// which a count is never anomalous
+	MinDeviation float64
+}
+
+// DefaultAnomalySettings
+var DefaultAnomalySettings = AnomalySettings{
+	TrainingDays: 56,
+	MaxSeries:    100,
+	Threshold:    4,
+	MinDeviation: 5,
+}
+
+// AnomalyBaseline is the learned hourly trail count of an event or actor of
+// a project. The expected count of an hour is Level * HourFactors[hour] *
+// DayFactors[weekday], in UTC, and counts vary around it with variance
+// Dispersion times the expected count.
+type AnomalyBaseline struct {
+	Model `json:",inline"`
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// Dimension event or actor
+	Dimension string `json:"dimension" gorm:"type:varchar(20)"`
+	// Value of the dimension
+	Value string `json:"value" gorm:"type:text"`
+	// Level mean trails per hour
+	Level float64 `json:"level"`
+	// HourFactors 24 factors of the hours of the day
+	HourFactors postgres.Jsonb `json:"hourFactors" gorm:"type:jsonb"`
+	// DayFactors 7 factors of the days of the week, from Sunday
+	DayFactors postgres.Jsonb `json:"dayFactors" gorm:"type:jsonb"`
+	// Dispersion ratio of the variance of counts to their expected value,
+	// at least 1
+	Dispersion float64 `json:"dispersion"`
+	// Hours the baseline was learned from
+	Hours int `json:"hours"`
+	// TrainedAt
+	TrainedAt time.Time `json:"trainedAt"`
+
+	hourFactors []float64
+	dayFactors  []float64
+}
+
+// Anomaly is an hour in which the count of trails of an event or actor
+// deviated significantly from its baseline
+type Anomaly struct {
+	Model `json:",inline"`
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// Dimension event or actor
+	Dimension string `json:"dimension" gorm:"type:varchar(20)"`
+	// Value of the dimension
+	Value string `json:"value" gorm:"type:text"`
+	// StartsAt start of the hour
+	StartsAt time.Time `json:"startsAt"`
+	// Observed trails
+	Observed int64 `json:"observed" gorm:"type:bigint"`
+	// Expected trails according to the baseline
+	Expected float64 `json:"expected"`
+	// Score deviation in standard deviations, negative for drops
+	Score float64 `json:"score"`
+	// Direction spike or drop
+	Direction string `json:"direction" gorm:"type:varchar(20)"`
+}
+
+// factors decodes HourFactors and DayFactors
+func (b *AnomalyBaseline) factors() error {
+	if b.hourFactors != nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(b.HourFactors.RawMessage, &b.hourFactors); err != nil || len(b.hourFactors) != 24 {
+		return fmt.Errorf("baseline %s: invalid hour factors", b.Model.ID.String())
+	}
+	if err := json.Unmarshal(b.DayFactors.RawMessage, &b.dayFactors); err != nil || len(b.dayFactors) != 7 {
+		return fmt.Errorf("baseline %s: invalid day factors", b.Model.ID.String())
+	}
+
+	return nil
+}
+
+// Expected returns the expected trail count of the hour starting at t
+func (b *AnomalyBaseline) Expected(t time.Time) (float64, error) {
+	if err := b.factors(); err != nil {
+		return 0, err
+	}
+
+	t = t.UTC()
+	return b.Level * b.hourFactors[t.Hour()] * b.dayFactors[t.Weekday()], nil
+}
+
+// Score returns the deviation of observed from the expected count of the
+// hour starting at t, in standard deviations
+func (b *AnomalyBaseline) Score(observed int64, t time.Time) (float64, float64, error) {
+	expected, err := b.Expected(t)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// counts of quiet hours are never exactly zero in expectation, floor the
+	// variance at that of a single trail
+	variance := math.Max(b.Dispersion*expected, b.Dispersion)
+	return expected, (float64(observed) - expected) / math.Sqrt(variance), nil
+}
+
+// TrainBaseline learns the baseline of hourly counts, keyed by the start of
+// each hour, over the hours from from until to. Hours missing from counts
+// had no trails. ok is false when there is too little history.
+func TrainBaseline(counts map[time.Time]int64, from time.Time, to time.Time) (baseline AnomalyBaseline, ok bool) {
+	from = from.UTC().Truncate(time.Hour)
+	to = to.UTC().Truncate(time.Hour)
+
+	// history starts at the first trail of the series
+	first := to
+	for hour := range counts {
+		if hour.Before(first) && !hour.Before(from) {
+			first = hour.UTC()
+		}
+	}
+	from = first
+
+	if to.Sub(from) < minTrainingSpan {
+		return baseline, false
+	}
+
+	var total float64
+	var hourSums, hourCounts [24]float64
+	var daySums, dayCounts [7]float64
+	hours := 0
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		count := float64(counts[t])
+		total += count
+		hourSums[t.Hour()] += count
+		hourCounts[t.Hour()]++
+		daySums[t.Weekday()] += count
+		dayCounts[t.Weekday()]++
+		hours++
+	}
+
+	level := total / float64(hours)
+	if level == 0 {
+		return baseline, false
+	}
+
+	hourFactors := make([]float64, 24)
+	for h := range hourFactors {
+		hourFactors[h] = hourSums[h] / hourCounts[h] / level
+	}
+	dayFactors := make([]float64, 7)
+	for d := range dayFactors {
+		dayFactors[d] = daySums[d] / dayCounts[d] / level
+	}
+
+	baseline = AnomalyBaseline{
+		Level:       level,
+		Hours:       hours,
+		hourFactors: hourFactors,
+		dayFactors:  dayFactors,
+	}
+
+	// quasi-Poisson dispersion of the residuals, 31 factors were estimated
+	var chi2 float64
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		expected, _ := baseline.Expected(t)
+		if expected > 0 {
+			residual := float64(counts[t]) - expected
+			chi2 += residual * residual / expected
+		}
+	}
+	baseline.Dispersion = math.Max(1, chi2/float64(hours-31))
+
+	hourJSON, _ := json.Marshal(hourFactors)
+	dayJSON, _ := json.Marshal(dayFactors)
+	baseline.HourFactors = postgres.Jsonb{RawMessage: hourJSON}
+	baseline.DayFactors = postgres.Jsonb{RawMessage: dayJSON}
+
+	return baseline, true
+}
+
+// hourlyCount is the number of trails of a value in an hour
+type hourlyCount struct {
+	Value string
+	Hour  time.Time
+	Count int64
+}
+
+// hourlyCounts returns the hourly trail counts of the values of column in
+// project between from and to, limited to values when not nil
+func hourlyCounts(db *gorm.DB, project uuid.UUID, column string, values []string, from time.Time, to time.Time) ([]hourlyCount, error) {
+	query := `SELECT ` + column + ` AS value, date_trunc('hour', created_at AT TIME ZONE 'UTC') AS hour, COUNT(*) AS count
+	FROM trails
+	WHERE project_id = ? AND created_at >= ? AND created_at < ?`
+	args := []interface{}{project, from, to}
+	if values != nil {
+		query += ` AND ` + column + ` IN (?)`
+		args = append(args, values)
+	}
+	query += ` GROUP BY 1, 2`
+
+	var rows []hourlyCount
+	if err := db.Raw(query, args...).Scan(&rows).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	for i := range rows {
+		// timestamp without time zone scans as UTC
+		rows[i].Hour = rows[i].Hour.UTC()
+	}
+
+	return rows, nil
+}
+
+// TrainAnomalyBaselines replaces the baselines of project with ones learned
+// from the history before now, returning the number learned
+func TrainAnomalyBaselines(db *gorm.DB, project uuid.UUID, settings AnomalySettings, now time.Time) (int, error) {
+	to := now.UTC().Truncate(time.Hour)
+	from := to.Add(-time.Duration(settings.TrainingDays) * Day)
+
+	var baselines []AnomalyBaseline
+	for _, dimension := range []string{"event", "actor"} {
+		var top []struct {
+			Value string
+		}
+		err := db.Raw(`SELECT `+dimension+` AS value FROM trails
+		WHERE project_id = ? AND created_at >= ? AND created_at < ?
+		GROUP BY 1 ORDER BY COUNT(*) DESC LIMIT ?`, project, from, to, settings.MaxSeries).Scan(&top).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return 0, err
+		}
+		if len(top) == 0 {
+			continue
+		}
+
+		values := make([]string, len(top))
+		for i, row := range top {
+			values[i] = row.Value
+		}
+
+		rows, err := hourlyCounts(db, project, dimension, values, from, to)
+		if err != nil {
+			return 0, err
+		}
+
+		series := map[string]map[time.Time]int64{}
+		for _, row := range rows {
+			if series[row.Value] == nil {
+				series[row.Value] = map[time.Time]int64{}
+			}
+			series[row.Value][row.Hour] = row.Count
+		}
+
+		for _, value := range values {
+			baseline, ok := TrainBaseline(series[value], from, to)
+			if !ok {
+				continue
+			}
+			baseline.ProjectId = project
+			baseline.Dimension = dimension
+			baseline.Value = value
+			baseline.TrainedAt = now
+			baselines = append(baselines, baseline)
+		}
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	// replicas retraining together replace the baselines one after another
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "inspectr:anomalies:"+project.String()).Error; err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Where("project_id = ?", project).Delete(&AnomalyBaseline{}).Error; err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for i := range baselines {
+		if err := tx.Create(&baselines[i]).Error; err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	return len(baselines), tx.Commit().Error
+}
+
+// DetectAnomalies scores the trail counts of the hour starting at hour
+// against the baselines of project, and records the anomalies found.
+// Detecting an hour again records no duplicates.
+func DetectAnomalies(db *gorm.DB, project uuid.UUID, settings AnomalySettings, hour time.Time) ([]Anomaly, error) {
+	hour = hour.UTC().Truncate(time.Hour)
+
+	var baselines []AnomalyBaseline
+	if err := db.Where("project_id = ?", project).Find(&baselines).Error; err != nil {
+		return nil, err
+	}
+
+	if len(baselines) == 0 {
+		return nil, nil
+	}
+
+	counts := map[string]map[string]int64{}
+	for _, dimension := range []string{"event", "actor"} {
+		rows, err := hourlyCounts(db, project, dimension, nil, hour, hour.Add(time.Hour))
+		if err != nil {
+			return nil, err
+		}
+
+		counts[dimension] = map[string]int64{}
+		for _, row := range rows {
+			counts[dimension][row.Value] = row.Count
+		}
+	}
+
+	var anomalies []Anomaly
+	for _, baseline := range baselines {
+		observed := counts[baseline.Dimension][baseline.Value]
+
+		expected, score, err := baseline.Score(observed, hour)
+		if err != nil {
+			return anomalies, err
+		}
+
+		if math.Abs(score) < settings.Threshold || math.Abs(float64(observed)-expected) < settings.MinDeviation {
+			continue
+		}
+
+		anomaly := Anomaly{
+			Model: Model{
+				ID:        uuid.NewV4(),
+				CreatedAt: time.Now(),
+			},
+			ProjectId: project,
+			Dimension: baseline.Dimension,
+			Value:     baseline.Value,
+			StartsAt:  hour,
+			Observed:  observed,
+			Expected:  expected,
+			Score:     score,
+			Direction: AnomalySpike,
+		}
+		if score < 0 {
+			anomaly.Direction = AnomalyDrop
+		}
+
+		// every replica detects on the hour tick
+		created := db.Exec(`INSERT INTO anomalies (id, created_at, project_id, dimension, value, starts_at, observed, expected, score, direction)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (project_id, dimension, value, starts_at) DO NOTHING`,
+			anomaly.Model.ID, anomaly.Model.CreatedAt, anomaly.ProjectId, anomaly.Dimension, anomaly.Value,
+			anomaly.StartsAt, anomaly.Observed, anomaly.Expected, anomaly.Score, anomaly.Direction)
+		if created.Error != nil {
+			return anomalies, created.Error
+		}
+		if created.RowsAffected > 0 {
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+
+	return anomalies, nil
+}
+
+// Anomalies of a project, newest first
+func (r *Resolver) Anomalies(ctx context.Context, args *struct {
+	Project   *graphql.ID
+	Dimension *string
+	Value     *string
+	From      *graphql.Time
+	To        *graphql.Time
+	First     *int32
+}) ([]*AnomalyResolver, error) {
+	if err := Authorize(ctx, "anomalies"); err != nil {
+		return nil, err
+	}
+
+	project, err := ViewerProject(ctx, r.DB, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	first := int32(DefaultPageSize)
+	if args.First != nil {
+		if *args.First < 0 || *args.First > MaxPageSize {
+			return nil, fmt.Errorf("first must be between 0 and %d", MaxPageSize)
+		}
+		first = *args.First
+	}
+
+	query := r.DB.Where("project_id = ?", project.Model.ID)
+	if args.Dimension != nil {
+		query = query.Where("dimension = ?", AnomalyColumns[*args.Dimension])
+	}
+	if args.Value != nil {
+		query = query.Where("value = ?", *args.Value)
+	}
+	if args.From != nil {
+		query = query.Where("starts_at >= ?", args.From.Time)
+	}
+	if args.To != nil {
+		query = query.Where("starts_at <= ?", args.To.Time)
+	}
+
+	var rows []Anomaly
+	if err := query.Order("starts_at desc, score desc").Limit(first).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*AnomalyResolver, len(rows))
+	for i, anomaly := range rows {
+		results[i] = &AnomalyResolver{Anomaly: anomaly}
+	}
+
+	return results, nil
+}
+
+// AnomalyResolver resolver for Anomaly
+type AnomalyResolver struct {
+	Anomaly
+}
+
+// ID
+func (r *AnomalyResolver) ID() graphql.ID {
+	return graphql.ID(r.Anomaly.Model.ID.String())
+}
+
+// ProjectId
+func (r *AnomalyResolver) ProjectId() graphql.ID {
+	return graphql.ID(r.Anomaly.ProjectId.String())
+}
+
+// Dimension
+func (r *AnomalyResolver) Dimension() string {
+	return strings.ToUpper(r.Anomaly.Dimension)
+}
+
+// Value
+func (r *AnomalyResolver) Value() string {
+	return r.Anomaly.Value
+}
+
+// StartsAt
+func (r *AnomalyResolver) StartsAt() graphql.Time {
+	return graphql.Time{Time: r.Anomaly.StartsAt}
+}
+
+// Interval seconds
+func (r *AnomalyResolver) Interval() int32 {
+	return int32(time.Hour / time.Second)
+}
+
+// Observed
+func (r *AnomalyResolver) Observed() string {
+	return strconv.FormatInt(r.Anomaly.Observed, 10)
+}
+
+// Expected
+func (r *AnomalyResolver) Expected() float64 {
+	return r.Anomaly.Expected
+}
+
+// Score
+func (r *AnomalyResolver) Score() float64 {
+	return r.Anomaly.Score
+}
+
+// Direction
+func (r *AnomalyResolver) Direction() string {
+	return strings.ToUpper(r.Anomaly.Direction)
+}
+
+// CreatedAt
+func (r *AnomalyResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.Anomaly.Model.CreatedAt}
+}
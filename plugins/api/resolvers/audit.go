@@ -0,0 +1,122 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jinzhu/gorm/dialects/postgres"
+)
+
+// AuditOrigin origin of the trails the API writes about itself
+const AuditOrigin = "inspectr/api"
+
+// auditTrail returns the trail recording that the viewer of ctx did event
+// to target
+func auditTrail(ctx context.Context, db *gorm.DB, project Project, event string, target User, metadata map[string]interface{}) (Trail, error) {
+	actor := User{}
+	if viewer := ViewerFromContext(ctx); viewer != nil {
+		if err := db.Where("id = ?", viewer.UserID()).Find(&actor).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return Trail{}, err
+		}
+	}
+
+	actorMetadata, err := json.Marshal(map[string]interface{}{
+		"id": actor.Model.ID.String(),
+	})
+	if err != nil {
+		return Trail{}, err
+	}
+
+	targetMetadata := map[string]interface{}{
+		"id": target.Model.ID.String(),
+	}
+	for key, value := range metadata {
+		targetMetadata[key] = value
+	}
+
+	targetMetadataMarshaled, err := json.Marshal(targetMetadata)
+	if err != nil {
+		return Trail{}, err
+	}
+
+	return Trail{
+		ProjectId:      project.Model.ID,
+		Timestamp:      time.Now().Unix(),
+		Event:          event,
+		EventMetadata:  postgres.Jsonb{RawMessage: json.RawMessage(`{}`)},
+		Actor:          actor.Email,
+		ActorMetadata:  postgres.Jsonb{RawMessage: actorMetadata},
+		Target:         target.Email,
+		TargetMetadata: postgres.Jsonb{RawMessage: targetMetadataMarshaled},
+		Origin:         AuditOrigin,
+		OriginMetadata: postgres.Jsonb{RawMessage: json.RawMessage(`{}`)},
+	}, nil
+}
+
+// auditRecord describes a trail written by audit. Target is read once the
+// change ran, so it may point at a user the change creates.
+type auditRecord struct {
+	Project  Project
+	Event    string
+	Target   *User
+	Metadata map[string]interface{}
+}
+
+// audit runs change and writes a trail for each record, in a single
+// transaction
+func (r *Resolver) audit(ctx context.Context, change func(tx *gorm.DB) error, records ...auditRecord) error {
+	tx := r.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := change(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// lock chains in a consistent order
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Project.Model.ID.String() < records[j].Project.Model.ID.String()
+	})
+
+	var trails []Trail
+	for _, record := range records {
+		trail, err := auditTrail(ctx, tx, record.Project, record.Event, *record.Target, record.Metadata)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := AppendTrail(tx, record.Project.Model.ID.String(), &trail); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		trails = append(trails, trail)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	if r.TrailCreated != nil {
+		for _, trail := range trails {
+			r.TrailCreated(trail)
+		}
+	}
+
+	return nil
+}
+
+// auditProject returns the project user management trails are written to
+func (r *Resolver) auditProject() (Project, error) {
+	tenant := r.AuditTenant
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	return FindProject(r.DB, tenant)
+}
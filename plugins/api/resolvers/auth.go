@@ -0,0 +1,271 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/codeamp/transistor"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/errors"
+)
+
+// Scopes are granted by UserPermission values, or by the roles a user holds
+// in projects, see RoleScopes. The admin permission grants every scope.
+const (
+	ScopeAdmin           = "admin"
+	ScopeTrailsRead      = "trails:read"
+	ScopeTrailsExport    = "trails:export"
+	ScopeMetricsRead     = "metrics:read"
+	ScopeChainVerify     = "chain:verify"
+	ScopeCheckpointsRead = "checkpoints:read"
+	ScopeProjectsRead    = "projects:read"
+	ScopeUsersRead       = "users:read"
+	ScopeUsersWrite      = "users:write"
+	ScopeMembersWrite    = "members:write"
+	ScopeAlertsRead      = "alerts:read"
+	ScopeAlertsWrite     = "alerts:write"
+	ScopeSchemasWrite    = "schemas:write"
+)
+
+// Operations maps each root field of the schema to the scopes allowed to
+// run it. Fields missing here are denied to everyone but admins, and
+// ValidateOperations refuses schemas with such fields.
+var Operations = map[string][]string{
+	// Query
+	"user":           {ScopeUsersRead},
+	"users":          {ScopeUsersRead},
+	"projects":       {ScopeProjectsRead},
+	"trails":         {ScopeTrailsRead},
+	"verifyChain":    {ScopeChainVerify},
+	"checkpoints":    {ScopeCheckpointsRead},
+	"checkpoint":     {ScopeCheckpointsRead},
+	"inclusionProof": {ScopeCheckpointsRead},
+	"metrics":        {ScopeMetricsRead},
+	// anomalies of a single project, see ViewerProject
+	"anomalies": {ScopeMetricsRead},
+	// retention policies and archives describe where trails went
+	"retentionPolicies": {ScopeTrailsRead},
+	"archives":          {ScopeTrailsRead},
+	// quarantined messages hold the bodies of trails that were not stored
+	"quarantinedMessages": {ScopeTrailsRead},
+	"quarantinedMessage":  {ScopeTrailsRead},
+	// exports are limited to the viewer's own
+	"exports": {ScopeTrailsExport},
+	"export":  {ScopeTrailsExport},
+	// alerts of a single project, see ViewerProject
+	"alertRules":      {ScopeAlertsRead},
+	"alertChannels":   {ScopeAlertsRead},
+	"alertDeliveries": {ScopeAlertsRead},
+	// event types of a single project, see ViewerProject
+	"eventTypes":       {ScopeTrailsRead},
+	"schemaViolations": {ScopeTrailsRead},
+	// Mutation
+	"inviteUser":        {ScopeUsersWrite, ScopeMembersWrite},
+	"grantPermission":   {ScopeUsersWrite},
+	"revokePermission":  {ScopeUsersWrite},
+	"grantProjectRole":  {ScopeMembersWrite},
+	"revokeProjectRole": {ScopeMembersWrite},
+	"deactivateUser":    {ScopeUsersWrite},
+	"rotateApiKey":      {ScopeUsersWrite},
+	"createExport":      {ScopeTrailsExport},
+	// limited to the projects the viewer is an admin of, see canWriteAlerts
+	"createAlertChannel": {ScopeAlertsWrite},
+	"updateAlertChannel": {ScopeAlertsWrite},
+	"deleteAlertChannel": {ScopeAlertsWrite},
+	"createAlertRule":    {ScopeAlertsWrite},
+	"updateAlertRule":    {ScopeAlertsWrite},
+	"deleteAlertRule":    {ScopeAlertsWrite},
+	// limited to the projects the viewer is an admin of, see canWriteSchemas
+	"createEventType": {ScopeSchemasWrite},
+	"updateEventType": {ScopeSchemasWrite},
+	"deleteEventType": {ScopeSchemasWrite},
+	// Subscription
+	"trailCreated": {ScopeTrailsRead},
+}
+
+// RoleScopes scopes granted by holding a role in any project. Data is still
+// limited to the projects the role is held in, see ReadableProjects.
+var RoleScopes = map[string][]string{
+	RoleAdmin:    {ScopeTrailsRead, ScopeTrailsExport, ScopeMetricsRead, ScopeChainVerify, ScopeCheckpointsRead, ScopeProjectsRead, ScopeUsersRead, ScopeMembersWrite, ScopeAlertsRead, ScopeAlertsWrite, ScopeSchemasWrite},
+	RoleReader:   {ScopeTrailsRead, ScopeTrailsExport, ScopeMetricsRead, ScopeChainVerify, ScopeCheckpointsRead, ScopeProjectsRead, ScopeAlertsRead},
+	RoleProducer: {ScopeProjectsRead},
+}
+
+// Error codes reported in the extensions of GraphQL errors
+const (
+	CodeUnauthenticated = "UNAUTHENTICATED"
+	CodeForbidden       = "FORBIDDEN"
+)
+
+// AuthError is returned by resolvers the viewer may not run
+type AuthError struct {
+	// Code CodeUnauthenticated or CodeForbidden
+	Code string
+	// Message
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// Authorize checks that the viewer of ctx is authenticated and holds one of
+// the scopes of operation, or one of also. Resolvers acting on a single user
+// pass the "user/<id>" scope every user holds for itself.
+func Authorize(ctx context.Context, operation string, also ...string) error {
+	viewer := ViewerFromContext(ctx)
+	if viewer == nil {
+		return &AuthError{Code: CodeUnauthenticated, Message: "authentication required"}
+	}
+
+	if err := viewer.Err(); err != nil {
+		return &AuthError{Code: CodeUnauthenticated, Message: err.Error()}
+	}
+
+	if viewer.IsAdmin() {
+		return nil
+	}
+
+	granted := ViewerScopes(viewer)
+	scopes := Operations[operation]
+	for _, scope := range append(also, scopes...) {
+		if transistor.SliceContains(scope, granted) {
+			return nil
+		}
+	}
+
+	if len(scopes) == 0 {
+		return &AuthError{Code: CodeForbidden, Message: fmt.Sprintf("%s requires the %s permission", operation, ScopeAdmin)}
+	}
+
+	return &AuthError{
+		Code:    CodeForbidden,
+		Message: fmt.Sprintf("%s requires one of the scopes %s", operation, strings.Join(scopes, ", ")),
+	}
+}
+
+// HasScope reports whether the viewer of ctx holds scope through its
+// permissions, not counting project roles
+func HasScope(ctx context.Context, scope string) bool {
+	viewer := ViewerFromContext(ctx)
+	if viewer == nil || viewer.Err() != nil {
+		return false
+	}
+	return viewer.IsAdmin() || transistor.SliceContains(scope, viewer.Scopes())
+}
+
+// ViewerScopes returns the scopes granted to viewer by its permissions and
+// project roles
+func ViewerScopes(viewer Viewer) []string {
+	scopes := append([]string{}, viewer.Scopes()...)
+
+	for role, roleScopes := range RoleScopes {
+		if len(viewer.ProjectIDs(role)) == 0 {
+			continue
+		}
+		for _, scope := range roleScopes {
+			if !transistor.SliceContains(scope, scopes) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	sort.Strings(scopes)
+	return scopes
+}
+
+var rootType = regexp.MustCompile(`(?s)type\s+(Query|Mutation|Subscription)\s*\{(.*?)\n\}`)
+var rootField = regexp.MustCompile(`(?m)^\s*([_A-Za-z][_0-9A-Za-z]*)\s*[(:]`)
+
+// RootFields returns the root fields of schema as Type.field, e.g.
+// Query.trails
+func RootFields(schema string) []string {
+	var fields []string
+
+	for _, match := range rootType.FindAllStringSubmatch(schema, -1) {
+		for _, line := range strings.Split(match[2], "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			field := rootField.FindStringSubmatch(line)
+			if field == nil {
+				continue
+			}
+
+			fields = append(fields, match[1]+"."+field[1])
+		}
+	}
+
+	return fields
+}
+
+// ValidateOperations checks that every root field of schema is listed in
+// Operations
+func ValidateOperations(schema string) error {
+	var missing []string
+
+	for _, field := range RootFields(schema) {
+		if _, ok := Operations[field[strings.Index(field, ".")+1:]]; !ok {
+			missing = append(missing, field)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("no authorization declared for %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// ResponseError is a GraphQL error with extensions
+type ResponseError struct {
+	*errors.QueryError
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Response is a graphql.Response whose errors carry the code of
+// authorization failures in their extensions
+type Response struct {
+	Data       interface{}            `json:"data,omitempty"`
+	Errors     []ResponseError        `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// NewResponse converts response
+func NewResponse(response *graphql.Response) *Response {
+	r := &Response{
+		Extensions: response.Extensions,
+	}
+
+	if response.Data != nil {
+		r.Data = response.Data
+	}
+
+	for _, err := range response.Errors {
+		responseError := ResponseError{QueryError: err}
+		if authError, ok := err.ResolverError.(*AuthError); ok {
+			responseError.Extensions = map[string]interface{}{
+				"code": authError.Code,
+			}
+		}
+		r.Errors = append(r.Errors, responseError)
+	}
+
+	return r
+}
+
+// Unauthenticated reports whether response failed because the viewer is not
+// authenticated
+func (r *Response) Unauthenticated() bool {
+	for _, err := range r.Errors {
+		if err.Extensions["code"] == CodeUnauthenticated {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package inspectr_resolvers_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/inspectr/backend/plugins/api/utils"
+)
+
+var (
+	admin    = resolvers.RoleAdmin
+	reader   = resolvers.RoleReader
+	producer = resolvers.RoleProducer
+)
+
+// operationTests root fields of the schema with the scopes and the project
+// roles that may run them
+var operationTests = []struct {
+	field  string
+	scopes []string
+	roles  []string
+}{
+	// Query
+	{"user", []string{resolvers.ScopeUsersRead}, []string{admin}},
+	{"users", []string{resolvers.ScopeUsersRead}, []string{admin}},
+	{"trails", []string{resolvers.ScopeTrailsRead}, []string{admin, reader}},
+	{"projects", []string{resolvers.ScopeProjectsRead}, []string{admin, reader, producer}},
+	{"verifyChain", []string{resolvers.ScopeChainVerify}, []string{admin, reader}},
+	{"checkpoints", []string{resolvers.ScopeCheckpointsRead}, []string{admin, reader}},
+	{"checkpoint", []string{resolvers.ScopeCheckpointsRead}, []string{admin, reader}},
+	{"inclusionProof", []string{resolvers.ScopeCheckpointsRead}, []string{admin, reader}},
+	{"retentionPolicies", []string{resolvers.ScopeTrailsRead}, []string{admin, reader}},
+	{"archives", []string{resolvers.ScopeTrailsRead}, []string{admin, reader}},
+	{"quarantinedMessages", []string{resolvers.ScopeTrailsRead}, []string{admin, reader}},
+	{"quarantinedMessage", []string{resolvers.ScopeTrailsRead}, []string{admin, reader}},
+	{"exports", []string{resolvers.ScopeTrailsExport}, []string{admin, reader}},
+	{"export", []string{resolvers.ScopeTrailsExport}, []string{admin, reader}},
+	{"alertRules", []string{resolvers.ScopeAlertsRead}, []string{admin, reader}},
+	{"alertChannels", []string{resolvers.ScopeAlertsRead}, []string{admin, reader}},
+	{"alertDeliveries", []string{resolvers.ScopeAlertsRead}, []string{admin, reader}},
+	{"eventTypes", []string{resolvers.ScopeTrailsRead}, []string{admin, reader}},
+	{"schemaViolations", []string{resolvers.ScopeTrailsRead}, []string{admin, reader}},
+	{"anomalies", []string{resolvers.ScopeMetricsRead}, []string{admin, reader}},
+	{"metrics", []string{resolvers.ScopeMetricsRead}, []string{admin, reader}},
+	// Mutation
+	{"inviteUser", []string{resolvers.ScopeUsersWrite, resolvers.ScopeMembersWrite}, []string{admin}},
+	{"grantPermission", []string{resolvers.ScopeUsersWrite}, nil},
+	{"revokePermission", []string{resolvers.ScopeUsersWrite}, nil},
+	{"grantProjectRole", []string{resolvers.ScopeMembersWrite}, []string{admin}},
+	{"revokeProjectRole", []string{resolvers.ScopeMembersWrite}, []string{admin}},
+	{"deactivateUser", []string{resolvers.ScopeUsersWrite}, nil},
+	{"rotateApiKey", []string{resolvers.ScopeUsersWrite}, nil},
+	{"createExport", []string{resolvers.ScopeTrailsExport}, []string{admin, reader}},
+	{"createAlertChannel", []string{resolvers.ScopeAlertsWrite}, []string{admin}},
+	{"updateAlertChannel", []string{resolvers.ScopeAlertsWrite}, []string{admin}},
+	{"deleteAlertChannel", []string{resolvers.ScopeAlertsWrite}, []string{admin}},
+	{"createAlertRule", []string{resolvers.ScopeAlertsWrite}, []string{admin}},
+	{"updateAlertRule", []string{resolvers.ScopeAlertsWrite}, []string{admin}},
+	{"deleteAlertRule", []string{resolvers.ScopeAlertsWrite}, []string{admin}},
+	{"createEventType", []string{resolvers.ScopeSchemasWrite}, []string{admin}},
+	{"updateEventType", []string{resolvers.ScopeSchemasWrite}, []string{admin}},
+	{"deleteEventType", []string{resolvers.ScopeSchemasWrite}, []string{admin}},
+	// Subscription
+	{"trailCreated", []string{resolvers.ScopeTrailsRead}, []string{admin, reader}},
+}
+
+var allScopes = []string{
+	resolvers.ScopeTrailsRead,
+	resolvers.ScopeTrailsExport,
+	resolvers.ScopeMetricsRead,
+	resolvers.ScopeChainVerify,
+	resolvers.ScopeCheckpointsRead,
+	resolvers.ScopeProjectsRead,
+	resolvers.ScopeUsersRead,
+	resolvers.ScopeUsersWrite,
+	resolvers.ScopeMembersWrite,
+	resolvers.ScopeAlertsRead,
+	resolvers.ScopeAlertsWrite,
+	resolvers.ScopeSchemasWrite,
+}
+
+func readSchema(t *testing.T) string {
+	schema, err := ioutil.ReadFile("../schema.graphql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(schema)
+}
+
+func withClaims(claims utils.Claims) context.Context {
+	return context.WithValue(context.Background(), "jwt", claims)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// expectCode fails t unless err is an AuthError with code, or nil when code
+// is empty
+func expectCode(t *testing.T, err error, code string) {
+	t.Helper()
+
+	if code == "" {
+		if err != nil {
+			t.Errorf("got %v, want access", err)
+		}
+		return
+	}
+
+	authError, ok := err.(*resolvers.AuthError)
+	if !ok {
+		t.Errorf("got %v, want an AuthError %s", err, code)
+		return
+	}
+	if authError.Code != code {
+		t.Errorf("got %s (%s), want %s", authError.Code, authError.Message, code)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	for _, test := range operationTests {
+		t.Run(test.field, func(t *testing.T) {
+			t.Run("no claims", func(t *testing.T) {
+				expectCode(t, resolvers.Authorize(context.Background(), test.field), resolvers.CodeUnauthenticated)
+			})
+
+			t.Run("token error", func(t *testing.T) {
+				ctx := withClaims(utils.Claims{
+					UserId:      "user",
+					Permissions: []string{resolvers.ScopeAdmin},
+					Roles:       map[string][]string{"project": {admin}},
+					TokenError:  "token is expired",
+				})
+				expectCode(t, resolvers.Authorize(ctx, test.field), resolvers.CodeUnauthenticated)
+			})
+
+			t.Run("missing scope", func(t *testing.T) {
+				var permissions []string
+				for _, scope := range allScopes {
+					if !contains(test.scopes, scope) {
+						permissions = append(permissions, scope)
+					}
+				}
+				ctx := withClaims(utils.Claims{UserId: "user", Permissions: permissions})
+				expectCode(t, resolvers.Authorize(ctx, test.field), resolvers.CodeForbidden)
+			})
+
+			for _, scope := range test.scopes {
+				t.Run("scope "+scope, func(t *testing.T) {
+					ctx := withClaims(utils.Claims{UserId: "user", Permissions: []string{scope}})
+					expectCode(t, resolvers.Authorize(ctx, test.field), "")
+				})
+			}
+
+			for _, role := range []string{admin, reader, producer} {
+				t.Run("role "+role, func(t *testing.T) {
+					ctx := withClaims(utils.Claims{UserId: "user", Roles: map[string][]string{"project": {role}}})
+					code := resolvers.CodeForbidden
+					if contains(test.roles, role) {
+						code = ""
+					}
+					expectCode(t, resolvers.Authorize(ctx, test.field), code)
+				})
+			}
+
+			t.Run("admin", func(t *testing.T) {
+				ctx := withClaims(utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeAdmin}})
+				expectCode(t, resolvers.Authorize(ctx, test.field), "")
+			})
+		})
+	}
+}
+
+func TestAuthorizeOwnUser(t *testing.T) {
+	// ParseClaims grants every user the user/<id> scope
+	ctx := withClaims(utils.Claims{UserId: "user", Permissions: []string{"user/user"}})
+
+	expectCode(t, resolvers.Authorize(ctx, "rotateApiKey", "user/user"), "")
+	expectCode(t, resolvers.Authorize(ctx, "rotateApiKey", "user/other"), resolvers.CodeForbidden)
+}
+
+func TestAuthorizeUndeclaredOperation(t *testing.T) {
+	ctx := withClaims(utils.Claims{UserId: "user", Permissions: allScopes})
+	expectCode(t, resolvers.Authorize(ctx, "undeclared"), resolvers.CodeForbidden)
+
+	ctx = withClaims(utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeAdmin}})
+	expectCode(t, resolvers.Authorize(ctx, "undeclared"), "")
+}
+
+// TestOperationsMatchSchema keeps operationTests, Operations and the root
+// fields of the schema in step
+func TestOperationsMatchSchema(t *testing.T) {
+	tested := map[string]bool{}
+	for _, test := range operationTests {
+		tested[test.field] = true
+	}
+
+	fields := map[string]bool{}
+	for _, field := range resolvers.RootFields(readSchema(t)) {
+		name := field[strings.Index(field, ".")+1:]
+		fields[name] = true
+		if !tested[name] {
+			t.Errorf("%s is missing from operationTests", field)
+		}
+	}
+
+	for operation := range resolvers.Operations {
+		if !fields[operation] {
+			t.Errorf("Operations declares %s, which is not a root field of the schema", operation)
+		}
+	}
+}
+
+func TestValidateOperations(t *testing.T) {
+	schema := readSchema(t)
+
+	if err := resolvers.ValidateOperations(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	extended := schema + `
+type Mutation {
+  # documented
+  undeclared(id: ID!): Boolean
+}
+`
+	err := resolvers.ValidateOperations(extended)
+	if err == nil || !strings.Contains(err.Error(), "Mutation.undeclared") {
+		t.Errorf("ValidateOperations of a schema with an undeclared field = %v, want an error naming Mutation.undeclared", err)
+	}
+}
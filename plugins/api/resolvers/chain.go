@@ -0,0 +1,709 @@
+package inspectr_resolvers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// verifyBatchSize number of trails loaded at a time while verifying
+const verifyBatchSize = 1000
+
+// TrailChain head of a project's hash chain of trails, named after the
+// project's ID. Appending locks the head row, which serializes writers of
+// the same chain across replicas.
+type TrailChain struct {
+	// Name
+	Name string `json:"name" gorm:"type:varchar(100);primary_key"`
+	// Sequence of the last trail in the chain
+	Sequence int64 `json:"sequence" gorm:"type:bigint"`
+	// Hash of the last trail in the chain
+	Hash string `json:"hash" gorm:"type:varchar(64)"`
+	// UpdatedAt
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CanonicalJSON returns the document a trail's hash is computed over. Keys
+// are sorted and metadata is re-encoded, so the stored jsonb hashes the same
+// as the JSON the trail was created from.
+func (t *Trail) CanonicalJSON() ([]byte, error) {
+	document := map[string]interface{}{
+		"id":           t.Model.ID.String(),
+		"createdAt":    t.Model.CreatedAt.UTC().Format(time.RFC3339Nano),
+		"sequence":     t.Sequence,
+		"previousHash": t.PreviousHash,
+		"timestamp":    t.Timestamp,
+		"event":        t.Event,
+		"actor":        t.Actor,
+		"target":       t.Target,
+		"origin":       t.Origin,
+	}
+
+	metadata := []struct {
+		key   string
+		value json.RawMessage
+	}{
+		{"eventMetadata", t.EventMetadata.RawMessage},
+		{"actorMetadata", t.ActorMetadata.RawMessage},
+		{"targetMetadata", t.TargetMetadata.RawMessage},
+		{"originMetadata", t.OriginMetadata.RawMessage},
+	}
+
+	for _, m := range metadata {
+		var value interface{}
+		if len(m.value) > 0 {
+			if err := json.Unmarshal(m.value, &value); err != nil {
+				return nil, fmt.Errorf("%s: %v", m.key, err)
+			}
+		}
+		document[m.key] = positiveZero(value)
+	}
+
+	return json.Marshal(document)
+}
+
+// positiveZero replaces the negative zeros of value, jsonb stores -0 as 0
+func positiveZero(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == 0 {
+			return float64(0)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = positiveZero(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = positiveZero(item)
+		}
+	}
+	return value
+}
+
+// ComputeHash returns the hex sha256 of the trail's canonical JSON
+func (t *Trail) ComputeHash() (string, error) {
+	canonical, err := t.CanonicalJSON()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CreateTrail appends trail to the hash chain of its project and stores it.
+// It returns ErrDuplicateTrail when a trail with the same idempotency key
+// was stored within dedupeWindow.
+func CreateTrail(db *gorm.DB, trail *Trail, dedupeWindow time.Duration) error {
+	if trail.ProjectId == uuid.Nil {
+		return fmt.Errorf("trail has no project")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	// the chain is locked first, as in CreateTrails
+	if _, err := lockChain(tx, trail.ProjectId.String()); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	duplicates, err := claimKeys(tx, trail.ProjectId, []*Trail{trail}, dedupeWindow)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if duplicates[trail] {
+		tx.Rollback()
+		return ErrDuplicateTrail
+	}
+
+	if err := AppendTrail(tx, trail.ProjectId.String(), trail); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// AppendTrail links trail to the head of chain and inserts it. tx must be a
+// transaction, the chain head stays locked until it ends.
+func AppendTrail(tx *gorm.DB, chain string, trail *Trail) error {
+	head, err := lockChain(tx, chain)
+	if err != nil {
+		return err
+	}
+
+	if err := link(head, trail); err != nil {
+		return err
+	}
+
+	if err := tx.Create(trail).Error; err != nil {
+		return err
+	}
+
+	return tx.Save(head).Error
+}
+
+// trailColumns columns of trails written by insertTrails
+var trailColumns = []string{
+	"id", "created_at", "project_id", "timestamp",
+	"event", "event_metadata", "actor", "actor_metadata",
+	"target", "target_metadata", "origin", "origin_metadata",
+	"sequence", "previous_hash", "hash",
+}
+
+// maxInsertRows rows per INSERT statement, postgres allows 65535 bind
+// parameters
+const maxInsertRows = 65535 / 15
+
+// CreateTrails appends trails to the hash chains of their projects in a
+// single transaction, in the order given, with multi-row inserts. Either
+// every trail is stored or none is. Trails with the idempotency key of a
+// trail stored within dedupeWindow, or of an earlier trail of trails, are
+// skipped and returned.
+func CreateTrails(db *gorm.DB, trails []*Trail, dedupeWindow time.Duration) (map[*Trail]bool, error) {
+	byChain := map[string][]*Trail{}
+	for _, trail := range trails {
+		if trail.ProjectId == uuid.Nil {
+			return nil, fmt.Errorf("trail has no project")
+		}
+		chain := trail.ProjectId.String()
+		byChain[chain] = append(byChain[chain], trail)
+	}
+
+	// chains are always locked in the same order, so concurrent writers can
+	// not deadlock
+	chains := make([]string, 0, len(byChain))
+	for chain := range byChain {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	duplicates := map[*Trail]bool{}
+	for _, chain := range chains {
+		skipped, err := appendTrails(tx, chain, byChain[chain], dedupeWindow)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		for trail := range skipped {
+			duplicates[trail] = true
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return duplicates, nil
+}
+
+// appendTrails links the trails whose idempotency key it claims to the head
+// of chain and inserts them, and returns the others. tx must be a
+// transaction, the chain head stays locked until it ends.
+func appendTrails(tx *gorm.DB, chain string, trails []*Trail, dedupeWindow time.Duration) (map[*Trail]bool, error) {
+	head, err := lockChain(tx, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates, err := claimKeys(tx, trails[0].ProjectId, trails, dedupeWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	linked := make([]*Trail, 0, len(trails))
+	for _, trail := range trails {
+		if duplicates[trail] {
+			continue
+		}
+		if err := link(head, trail); err != nil {
+			return nil, err
+		}
+		linked = append(linked, trail)
+	}
+
+	for start := 0; start < len(linked); start += maxInsertRows {
+		end := start + maxInsertRows
+		if end > len(linked) {
+			end = len(linked)
+		}
+
+		if err := insertTrails(tx, linked[start:end]); err != nil {
+			return nil, err
+		}
+	}
+
+	return duplicates, tx.Save(head).Error
+}
+
+// insertTrails inserts linked trails with a single statement
+func insertTrails(tx *gorm.DB, trails []*Trail) error {
+	rows := make([][]interface{}, len(trails))
+	for i, t := range trails {
+		rows[i] = []interface{}{
+			t.Model.ID, t.Model.CreatedAt, t.ProjectId, t.Timestamp,
+			t.Event, t.EventMetadata, t.Actor, t.ActorMetadata,
+			t.Target, t.TargetMetadata, t.Origin, t.OriginMetadata,
+			t.Sequence, t.PreviousHash, t.Hash,
+		}
+	}
+
+	return tx.Exec(`INSERT INTO trails (`+strings.Join(trailColumns, ", ")+`) VALUES ?`, rows).Error
+}
+
+// LinkUnchainedTrails appends the trails of project stored before hash
+// chaining existed to its chain, oldest first
+func LinkUnchainedTrails(db *gorm.DB, project uuid.UUID) error {
+	chain := project.String()
+
+	for {
+		tx := db.Begin()
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		head, err := lockChain(tx, chain)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		var rows []Trail
+		err = tx.Where("project_id = ? AND (sequence IS NULL OR sequence = 0)", project).
+			Order("created_at asc, id asc").
+			Limit(verifyBatchSize).
+			Find(&rows).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if len(rows) == 0 {
+			return tx.Rollback().Error
+		}
+
+		for i := range rows {
+			trail := &rows[i]
+			if err := link(head, trail); err != nil {
+				tx.Rollback()
+				return err
+			}
+
+			err := tx.Model(trail).UpdateColumns(map[string]interface{}{
+				"sequence":      trail.Sequence,
+				"previous_hash": trail.PreviousHash,
+				"hash":          trail.Hash,
+			}).Error
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		if err := tx.Save(head).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+}
+
+// lockChain returns the head of chain, locked for update
+func lockChain(tx *gorm.DB, chain string) (*TrailChain, error) {
+	err := tx.Exec("INSERT INTO trail_chains (name, sequence, hash, updated_at) VALUES (?, 0, '', ?) ON CONFLICT (name) DO NOTHING", chain, time.Now()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	head := &TrailChain{}
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("name = ?", chain).First(head).Error; err != nil {
+		return nil, err
+	}
+
+	return head, nil
+}
+
+// link sets trail's identity and chain position after head, then advances
+// head to trail
+func link(head *TrailChain, trail *Trail) error {
+	if trail.Model.ID == uuid.Nil {
+		trail.Model.ID = uuid.NewV4()
+	}
+
+	if trail.Model.CreatedAt.IsZero() {
+		trail.Model.CreatedAt = time.Now()
+	}
+	// postgres keeps microseconds
+	trail.Model.CreatedAt = trail.Model.CreatedAt.UTC().Truncate(time.Microsecond)
+
+	trail.Sequence = head.Sequence + 1
+	trail.PreviousHash = head.Hash
+
+	hash, err := trail.ComputeHash()
+	if err != nil {
+		return err
+	}
+	trail.Hash = hash
+
+	head.Sequence = trail.Sequence
+	head.Hash = trail.Hash
+
+	return nil
+}
+
+// ChainBreak first inconsistency found in a hash chain
+type ChainBreak struct {
+	// Sequence where the chain breaks
+	Sequence int64
+	// TrailID of the offending trail, nil when the trail is missing
+	TrailID *uuid.UUID
+	// Reason
+	Reason string
+	// Expected hash
+	Expected string
+	// Actual hash
+	Actual string
+}
+
+// ChainVerification result of VerifyChain
+type ChainVerification struct {
+	// Chain
+	Chain string
+	// Checked number of trails verified
+	Checked int64
+	// Archived number of archived trails whose links were verified, their
+	// contents are in the archive store
+	Archived int64
+	// FromSequence first sequence verified
+	FromSequence int64
+	// ToSequence last sequence verified
+	ToSequence int64
+	// Break nil when the chain is intact
+	Break *ChainBreak
+}
+
+// Valid
+func (v *ChainVerification) Valid() bool {
+	return v.Break == nil
+}
+
+// chainRows reads the trails of a chain and the links archived trails left
+type chainRows interface {
+	// head returns the head of the chain, empty when it has none
+	head() (TrailChain, error)
+	// bounds returns the first and last sequences of the chained trails
+	// created between from and to, nil when there are none
+	bounds(from *time.Time, to *time.Time) (*int64, *int64, error)
+	// trails returns the trails with sequences from first to last, in
+	// sequence order
+	trails(first int64, last int64) ([]Trail, error)
+	// archived returns the links of the archived trails with sequences from
+	// first to last by sequence
+	archived(first int64, last int64) (map[int64]ArchivedTrail, error)
+}
+
+// dbChainRows rows of the chain of project in db
+type dbChainRows struct {
+	db      *gorm.DB
+	project uuid.UUID
+}
+
+func (c dbChainRows) head() (TrailChain, error) {
+	head := TrailChain{}
+	if err := c.db.Where("name = ?", c.project.String()).Find(&head).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return head, err
+	}
+	return head, nil
+}
+
+func (c dbChainRows) bounds(from *time.Time, to *time.Time) (*int64, *int64, error) {
+	scope := c.db.Model(&Trail{}).Where("project_id = ? AND sequence > 0", c.project)
+	if from != nil {
+		scope = scope.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		scope = scope.Where("created_at <= ?", *to)
+	}
+
+	var bounds struct {
+		Min *int64
+		Max *int64
+	}
+	if err := scope.Select("min(sequence) AS min, max(sequence) AS max").Scan(&bounds).Error; err != nil {
+		return nil, nil, err
+	}
+	return bounds.Min, bounds.Max, nil
+}
+
+func (c dbChainRows) trails(first int64, last int64) ([]Trail, error) {
+	var rows []Trail
+	err := c.db.Where("project_id = ? AND sequence >= ? AND sequence <= ?", c.project, first, last).
+		Order("sequence asc").
+		Find(&rows).Error
+	return rows, err
+}
+
+func (c dbChainRows) archived(first int64, last int64) (map[int64]ArchivedTrail, error) {
+	return archivedLinks(c.db, c.project, first, last)
+}
+
+// VerifyChain walks the chain of project in sequence order over the trails
+// created between from and to, recomputing each hash and checking each
+// link. When to is nil the walk also checks that no trail after it was
+// removed. Archived trails are only checked to link up, see ArchivedTrail.
+func VerifyChain(db *gorm.DB, project uuid.UUID, from *time.Time, to *time.Time) (*ChainVerification, error) {
+	return verifyChain(dbChainRows{db: db, project: project}, project.String(), from, to)
+}
+
+func verifyChain(rows chainRows, chain string, from *time.Time, to *time.Time) (*ChainVerification, error) {
+	verification := &ChainVerification{Chain: chain}
+
+	head, err := rows.head()
+	if err != nil {
+		return nil, err
+	}
+
+	lowest, highest, err := rows.bounds(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if lowest == nil {
+		if to != nil || from != nil || head.Sequence == 0 {
+			return verification, nil
+		}
+		// every trail may have been archived
+		first := int64(1)
+		lowest, highest = &first, &head.Sequence
+	}
+
+	first := *lowest
+	last := *highest
+	if from == nil {
+		first = 1
+	}
+	if to == nil && head.Sequence > last {
+		last = head.Sequence
+	}
+
+	verification.FromSequence = first
+	verification.ToSequence = last
+
+	previousHash := ""
+	if first > 1 {
+		previous, err := rows.trails(first-1, first-1)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(previous) > 0 {
+			previousHash = previous[0].Hash
+		} else {
+			archived, err := rows.archived(first-1, first-1)
+			if err != nil {
+				return nil, err
+			}
+
+			link, ok := archived[first-1]
+			if !ok {
+				verification.Break = &ChainBreak{
+					Sequence: first - 1,
+					Reason:   "trail is missing",
+				}
+				return verification, nil
+			}
+			previousHash = link.Hash
+		}
+	}
+
+	expected := first
+	for expected <= last {
+		end := expected + verifyBatchSize - 1
+		if end > last {
+			end = last
+		}
+
+		batch, err := rows.trails(expected, end)
+		if err != nil {
+			return nil, err
+		}
+
+		// trails moved to an archive store left their hashes behind
+		archived := map[int64]ArchivedTrail{}
+		if int64(len(batch)) < end-expected+1 {
+			archived, err = rows.archived(expected, end)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		i := 0
+		for ; expected <= end; expected++ {
+			if i < len(batch) && batch[i].Sequence == expected {
+				trail := batch[i]
+				i++
+
+				id := trail.Model.ID
+				if trail.PreviousHash != previousHash {
+					verification.Break = &ChainBreak{
+						Sequence: trail.Sequence,
+						TrailID:  &id,
+						Reason:   "previous hash does not match the preceding trail",
+						Expected: previousHash,
+						Actual:   trail.PreviousHash,
+					}
+					return verification, nil
+				}
+
+				hash, err := trail.ComputeHash()
+				if err != nil {
+					return nil, err
+				}
+
+				if hash != trail.Hash {
+					verification.Break = &ChainBreak{
+						Sequence: trail.Sequence,
+						TrailID:  &id,
+						Reason:   "trail was modified",
+						Expected: hash,
+						Actual:   trail.Hash,
+					}
+					return verification, nil
+				}
+
+				previousHash = trail.Hash
+				verification.Checked++
+			} else if link, ok := archived[expected]; ok {
+				if link.PreviousHash != previousHash {
+					verification.Break = &ChainBreak{
+						Sequence: expected,
+						Reason:   "previous hash of the archived trail does not match the preceding trail",
+						Expected: previousHash,
+						Actual:   link.PreviousHash,
+					}
+					return verification, nil
+				}
+
+				previousHash = link.Hash
+				verification.Archived++
+			} else {
+				verification.Break = &ChainBreak{
+					Sequence: expected,
+					Reason:   "trail is missing",
+				}
+				return verification, nil
+			}
+
+			if expected == head.Sequence && previousHash != head.Hash {
+				verification.Break = &ChainBreak{
+					Sequence: expected,
+					Reason:   "chain head does not match the last trail",
+					Expected: previousHash,
+					Actual:   head.Hash,
+				}
+				return verification, nil
+			}
+		}
+	}
+
+	return verification, nil
+}
+
+// ChainVerificationResolver resolver for ChainVerification
+type ChainVerificationResolver struct {
+	ChainVerification
+}
+
+// Chain
+func (r *ChainVerificationResolver) Chain() string {
+	return r.ChainVerification.Chain
+}
+
+// Valid
+func (r *ChainVerificationResolver) Valid() bool {
+	return r.ChainVerification.Valid()
+}
+
+// Checked
+func (r *ChainVerificationResolver) Checked() int32 {
+	return int32(r.ChainVerification.Checked)
+}
+
+// Archived
+func (r *ChainVerificationResolver) Archived() int32 {
+	return int32(r.ChainVerification.Archived)
+}
+
+// FromSequence
+func (r *ChainVerificationResolver) FromSequence() string {
+	return strconv.FormatInt(r.ChainVerification.FromSequence, 10)
+}
+
+// ToSequence
+func (r *ChainVerificationResolver) ToSequence() string {
+	return strconv.FormatInt(r.ChainVerification.ToSequence, 10)
+}
+
+// Break
+func (r *ChainVerificationResolver) Break() *ChainBreakResolver {
+	if r.ChainVerification.Break == nil {
+		return nil
+	}
+	return &ChainBreakResolver{ChainBreak: *r.ChainVerification.Break}
+}
+
+// ChainBreakResolver resolver for ChainBreak
+type ChainBreakResolver struct {
+	ChainBreak
+}
+
+// Sequence
+func (r *ChainBreakResolver) Sequence() string {
+	return strconv.FormatInt(r.ChainBreak.Sequence, 10)
+}
+
+// TrailID
+func (r *ChainBreakResolver) TrailID() *graphql.ID {
+	if r.ChainBreak.TrailID == nil {
+		return nil
+	}
+	id := graphql.ID(r.ChainBreak.TrailID.String())
+	return &id
+}
+
+// Reason
+func (r *ChainBreakResolver) Reason() string {
+	return r.ChainBreak.Reason
+}
+
+// Expected
+func (r *ChainBreakResolver) Expected() string {
+	return r.ChainBreak.Expected
+}
+
+// Actual
+func (r *ChainBreakResolver) Actual() string {
+	return r.ChainBreak.Actual
+}
@@ -0,0 +1,370 @@
+package inspectr_resolvers
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm/dialects/postgres"
+	uuid "github.com/satori/go.uuid"
+)
+
+func jsonb(raw string) postgres.Jsonb {
+	return postgres.Jsonb{RawMessage: json.RawMessage(raw)}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	trail := Trail{
+		Timestamp:      1792300000,
+		Event:          "user.login",
+		EventMetadata:  jsonb(`{"z": 1, "a": {"y": [3, 1], "b": null}}`),
+		Actor:          "alice",
+		ActorMetadata:  jsonb(`{"ip": "10.0.0.1", "html": "<b>&</b>", "name": "é"}`),
+		Target:         "web",
+		TargetMetadata: jsonb(`{"whole": 1.0, "fraction": 2.50, "exponent": 1e3, "large": 1e21, "small": 0.000001, "negative": -0}`),
+		Origin:         "10.0.0.1",
+		Sequence:       7,
+		PreviousHash:   "abc",
+	}
+	trail.Model.ID = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	trail.Model.CreatedAt = time.Date(2026, 10, 18, 12, 30, 0, 123456000, time.FixedZone("CEST", 2*60*60))
+
+	// keys are sorted at every level, createdAt is UTC, numbers are
+	// formatted as encoding/json formats float64s with -0 as 0, HTML
+	// characters are escaped, missing metadata is null
+	want := `{"actor":"alice",` +
+		`"actorMetadata":{"html":"\u003cb\u003e\u0026\u003c/b\u003e","ip":"10.0.0.1","name":"é"},` +
+		`"createdAt":"2026-10-18T10:30:00.123456Z",` +
+		`"event":"user.login",` +
+		`"eventMetadata":{"a":{"b":null,"y":[3,1]},"z":1},` +
+		`"id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8",` +
+		`"origin":"10.0.0.1",` +
+		`"originMetadata":null,` +
+		`"previousHash":"abc",` +
+		`"sequence":7,` +
+		`"target":"web",` +
+		`"targetMetadata":{"exponent":1000,"fraction":2.5,"large":1e+21,"negative":0,"small":0.000001,"whole":1},` +
+		`"timestamp":1792300000}`
+
+	canonical, err := trail.CanonicalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(canonical) != want {
+		t.Errorf("CanonicalJSON\n got %s\nwant %s", canonical, want)
+	}
+
+	// jsonb hands metadata back reordered and reformatted, the hash holds
+	hash, err := trail.ComputeHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := trail
+	stored.EventMetadata = jsonb(`{"a": {"b": null, "y": [3, 1]}, "z": 1}`)
+	stored.TargetMetadata = jsonb(`{"exponent": 1000, "fraction": 2.5, "large": 1000000000000000000000, "negative": 0, "small": 1e-6, "whole": 1}`)
+	stored.Model.CreatedAt = trail.Model.CreatedAt.UTC()
+
+	storedHash, err := stored.ComputeHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedHash != hash {
+		t.Errorf("hash of the stored trail %s, want %s", storedHash, hash)
+	}
+
+	// array order is significant
+	stored.EventMetadata = jsonb(`{"a": {"b": null, "y": [1, 3]}, "z": 1}`)
+	if reordered, _ := stored.ComputeHash(); reordered == hash {
+		t.Error("reordering an array kept the hash")
+	}
+}
+
+func TestCanonicalJSONInvalidMetadata(t *testing.T) {
+	trail := Trail{ActorMetadata: jsonb(`{"a":`)}
+	if _, err := trail.CanonicalJSON(); err == nil {
+		t.Error("invalid metadata was encoded")
+	}
+}
+
+func TestLink(t *testing.T) {
+	head := &TrailChain{}
+
+	first := &Trail{Event: "first"}
+	if err := link(head, first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Model.ID == uuid.Nil || first.Model.CreatedAt.IsZero() {
+		t.Error("link did not set the identity of the trail")
+	}
+	if first.Model.CreatedAt.Location() != time.UTC || first.Model.CreatedAt.Nanosecond()%1000 != 0 {
+		t.Errorf("created at %v, want UTC microseconds", first.Model.CreatedAt)
+	}
+	if first.Sequence != 1 || first.PreviousHash != "" {
+		t.Errorf("first trail at %d after %q, want 1 after the empty hash", first.Sequence, first.PreviousHash)
+	}
+	if hash, _ := first.ComputeHash(); first.Hash != hash {
+		t.Errorf("hash %s, want %s", first.Hash, hash)
+	}
+
+	second := &Trail{Event: "second"}
+	if err := link(head, second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Sequence != 2 || second.PreviousHash != first.Hash {
+		t.Errorf("second trail at %d after %s, want 2 after %s", second.Sequence, second.PreviousHash, first.Hash)
+	}
+	if head.Sequence != 2 || head.Hash != second.Hash {
+		t.Errorf("head at %d %s, want 2 %s", head.Sequence, head.Hash, second.Hash)
+	}
+}
+
+// memoryChain rows of a chain kept in memory
+type memoryChain struct {
+	chain    TrailChain
+	rows     []Trail
+	archives map[int64]ArchivedTrail
+}
+
+// newMemoryChain returns a chain of n trails created a minute apart
+func newMemoryChain(t *testing.T, n int) *memoryChain {
+	c := &memoryChain{archives: map[int64]ArchivedTrail{}}
+	start := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < n; i++ {
+		trail := Trail{Event: "event", Actor: "actor", ActorMetadata: jsonb(`{"i": ` + strconv.Itoa(i) + `}`)}
+		trail.Model.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		if err := link(&c.chain, &trail); err != nil {
+			t.Fatal(err)
+		}
+		c.rows = append(c.rows, trail)
+	}
+
+	return c
+}
+
+// at returns the trail with sequence
+func (c *memoryChain) at(sequence int64) *Trail {
+	for i := range c.rows {
+		if c.rows[i].Sequence == sequence {
+			return &c.rows[i]
+		}
+	}
+	return nil
+}
+
+// remove removes the trail with sequence
+func (c *memoryChain) remove(sequence int64) {
+	for i := range c.rows {
+		if c.rows[i].Sequence == sequence {
+			c.rows = append(c.rows[:i], c.rows[i+1:]...)
+			return
+		}
+	}
+}
+
+// archive moves the trail with sequence to an archive
+func (c *memoryChain) archive(sequence int64) {
+	trail := c.at(sequence)
+	c.archives[sequence] = ArchivedTrail{Sequence: sequence, PreviousHash: trail.PreviousHash, Hash: trail.Hash}
+	c.remove(sequence)
+}
+
+func (c *memoryChain) head() (TrailChain, error) {
+	return c.chain, nil
+}
+
+func (c *memoryChain) bounds(from *time.Time, to *time.Time) (*int64, *int64, error) {
+	var lowest, highest *int64
+	for _, trail := range c.rows {
+		sequence := trail.Sequence
+		if sequence <= 0 || (from != nil && trail.Model.CreatedAt.Before(*from)) || (to != nil && trail.Model.CreatedAt.After(*to)) {
+			continue
+		}
+		if lowest == nil || sequence < *lowest {
+			lowest = &sequence
+		}
+		if highest == nil || sequence > *highest {
+			highest = &sequence
+		}
+	}
+	return lowest, highest, nil
+}
+
+func (c *memoryChain) trails(first int64, last int64) ([]Trail, error) {
+	var rows []Trail
+	for _, trail := range c.rows {
+		if trail.Sequence >= first && trail.Sequence <= last {
+			rows = append(rows, trail)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Sequence < rows[j].Sequence })
+	return rows, nil
+}
+
+func (c *memoryChain) archived(first int64, last int64) (map[int64]ArchivedTrail, error) {
+	links := map[int64]ArchivedTrail{}
+	for sequence, link := range c.archives {
+		if sequence >= first && sequence <= last {
+			links[sequence] = link
+		}
+	}
+	return links, nil
+}
+
+func TestVerifyChain(t *testing.T) {
+	minute := func(m int) *time.Time {
+		at := time.Date(2026, 10, 18, 0, m, 0, 0, time.UTC)
+		return &at
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(c *memoryChain)
+		from   *time.Time
+		to     *time.Time
+		// want the sequence and reason of the break, 0 when the chain is
+		// intact
+		want       int64
+		wantReason string
+		// checked and archived trails of intact chains
+		checked  int64
+		archived int64
+	}{
+		{name: "intact", checked: 5},
+		{
+			name:   "modified",
+			tamper: func(c *memoryChain) { c.at(3).Actor = "mallory" },
+			want:   3, wantReason: "trail was modified",
+		},
+		{
+			name:   "modified metadata",
+			tamper: func(c *memoryChain) { c.at(2).ActorMetadata = jsonb(`{"i": 9}`) },
+			want:   2, wantReason: "trail was modified",
+		},
+		{
+			name: "modified and rehashed",
+			tamper: func(c *memoryChain) {
+				trail := c.at(3)
+				trail.Actor = "mallory"
+				trail.Hash, _ = trail.ComputeHash()
+			},
+			want: 4, wantReason: "previous hash does not match the preceding trail",
+		},
+		{
+			name:   "deleted",
+			tamper: func(c *memoryChain) { c.remove(3) },
+			want:   3, wantReason: "trail is missing",
+		},
+		{
+			name:   "deleted first",
+			tamper: func(c *memoryChain) { c.remove(1) },
+			want:   1, wantReason: "trail is missing",
+		},
+		{
+			name:   "deleted last",
+			tamper: func(c *memoryChain) { c.remove(5) },
+			want:   5, wantReason: "trail is missing",
+		},
+		{
+			name: "reordered",
+			tamper: func(c *memoryChain) {
+				second, third := c.at(2), c.at(3)
+				second.Sequence, third.Sequence = 3, 2
+			},
+			want: 2, wantReason: "previous hash does not match the preceding trail",
+		},
+		{
+			name:   "head moved",
+			tamper: func(c *memoryChain) { c.chain.Hash = c.at(4).Hash },
+			want:   5, wantReason: "chain head does not match the last trail",
+		},
+		{
+			name: "archived",
+			tamper: func(c *memoryChain) {
+				c.archive(1)
+				c.archive(2)
+			},
+			checked: 3, archived: 2,
+		},
+		{
+			name: "every trail archived",
+			tamper: func(c *memoryChain) {
+				for sequence := int64(1); sequence <= 5; sequence++ {
+					c.archive(sequence)
+				}
+			},
+			archived: 5,
+		},
+		{
+			name: "archived link forged",
+			tamper: func(c *memoryChain) {
+				c.archive(2)
+				link := c.archives[2]
+				link.PreviousHash = "forged"
+				c.archives[2] = link
+			},
+			want: 2, wantReason: "previous hash of the archived trail does not match the preceding trail",
+		},
+		{
+			name: "archived trail modified after archiving",
+			tamper: func(c *memoryChain) {
+				c.archive(2)
+				link := c.archives[2]
+				link.Hash = "forged"
+				c.archives[2] = link
+			},
+			want: 3, wantReason: "previous hash does not match the preceding trail",
+		},
+		{name: "window", from: minute(2), to: minute(3), checked: 2},
+		{
+			name:   "window after an archived trail",
+			tamper: func(c *memoryChain) { c.archive(2) },
+			from:   minute(2), checked: 3,
+		},
+		{
+			name:   "window after a deleted trail",
+			tamper: func(c *memoryChain) { c.remove(2) },
+			from:   minute(2),
+			want:   2, wantReason: "trail is missing",
+		},
+		{
+			name:   "window before a modified trail",
+			tamper: func(c *memoryChain) { c.at(5).Actor = "mallory" },
+			to:     minute(3), checked: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newMemoryChain(t, 5)
+			if test.tamper != nil {
+				test.tamper(c)
+			}
+
+			verification, err := verifyChain(c, "chain", test.from, test.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.want == 0 {
+				if !verification.Valid() {
+					t.Fatalf("break %+v, want an intact chain", *verification.Break)
+				}
+				if verification.Checked != test.checked || verification.Archived != test.archived {
+					t.Errorf("checked %d and %d archived, want %d and %d", verification.Checked, verification.Archived, test.checked, test.archived)
+				}
+				return
+			}
+
+			if verification.Valid() {
+				t.Fatalf("chain is intact, want a break at %d", test.want)
+			}
+			if verification.Break.Sequence != test.want || verification.Break.Reason != test.wantReason {
+				t.Errorf("break at %d: %s, want at %d: %s", verification.Break.Sequence, verification.Break.Reason, test.want, test.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package inspectr_resolvers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Checkpoint signed Merkle root over the trails appended to a project's
+// chain since the previous checkpoint. Leaves are the trails' hashes in
+// sequence order.
+type Checkpoint struct {
+	Model `json:",inline"`
+	// Chain
+	Chain string `json:"chain" gorm:"type:varchar(100)"`
+	// FromSequence first trail in the tree
+	FromSequence int64 `json:"fromSequence" gorm:"type:bigint"`
+	// ToSequence last trail in the tree
+	ToSequence int64 `json:"toSequence" gorm:"type:bigint"`
+	// TreeSize number of leaves
+	TreeSize int64 `json:"treeSize" gorm:"type:bigint"`
+	// Root hex Merkle root
+	Root string `json:"root" gorm:"type:varchar(64)"`
+	// PreviousRoot root of the previous checkpoint of the chain
+	PreviousRoot string `json:"previousRoot" gorm:"type:varchar(64)"`
+	// PublicKey hex Ed25519 key the checkpoint was signed with
+	PublicKey string `json:"publicKey" gorm:"type:varchar(64)"`
+	// Signature hex Ed25519 signature of Message
+	Signature string `json:"signature" gorm:"type:varchar(128)"`
+}
+
+// Message returns the bytes a checkpoint's signature covers
+func (c *Checkpoint) Message() []byte {
+	return []byte(fmt.Sprintf("inspectr checkpoint v1\nchain %s\nfrom %d\nto %d\nsize %d\nroot %s\nprevious %s\ncreated %s\n",
+		c.Chain,
+		c.FromSequence,
+		c.ToSequence,
+		c.TreeSize,
+		c.Root,
+		c.PreviousRoot,
+		c.Model.CreatedAt.UTC().Format(time.RFC3339Nano),
+	))
+}
+
+// Verify reports whether the checkpoint's signature matches its public key
+func (c *Checkpoint) Verify() bool {
+	publicKey, err := hex.DecodeString(c.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+
+	signature, err := hex.DecodeString(c.Signature)
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(ed25519.PublicKey(publicKey), c.Message(), signature)
+}
+
+// sign sets the public key and signature of the checkpoint
+func (c *Checkpoint) sign(key ed25519.PrivateKey) {
+	c.PublicKey = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	c.Signature = hex.EncodeToString(ed25519.Sign(key, c.Message()))
+}
+
+// CreateCheckpoint signs the trails appended to the chain of project since
+// its last checkpoint. It returns nil when no trail was appended in between.
+func CreateCheckpoint(db *gorm.DB, project uuid.UUID, key ed25519.PrivateKey) (*Checkpoint, error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	checkpoint, err := createCheckpoint(tx, project, key)
+	if err != nil || checkpoint == nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+func createCheckpoint(tx *gorm.DB, project uuid.UUID, key ed25519.PrivateKey) (*Checkpoint, error) {
+	chain := project.String()
+
+	// every replica receives heartbeats, only one of them checkpoints a range
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "inspectr:checkpoint:"+chain).Error; err != nil {
+		return nil, err
+	}
+
+	head := TrailChain{}
+	if err := tx.Where("name = ?", chain).Find(&head).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	previous := Checkpoint{}
+	err := tx.Where("chain = ?", chain).Order("to_sequence desc").Limit(1).Find(&previous).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	if head.Sequence <= previous.ToSequence {
+		return nil, nil
+	}
+
+	leaves, err := checkpointLeaves(tx, project, previous.ToSequence+1, head.Sequence)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{
+		Model: Model{
+			ID:        uuid.NewV4(),
+			CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		},
+		Chain:        chain,
+		FromSequence: previous.ToSequence + 1,
+		ToSequence:   head.Sequence,
+		TreeSize:     int64(len(leaves)),
+		Root:         hex.EncodeToString(MerkleRoot(leaves)),
+		PreviousRoot: previous.Root,
+	}
+	checkpoint.sign(key)
+
+	if err := tx.Create(checkpoint).Error; err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+// checkpointLeaves returns the leaf hashes of the trails of project from
+// through to, archived trails included
+func checkpointLeaves(db *gorm.DB, project uuid.UUID, from int64, to int64) ([][]byte, error) {
+	rows, err := db.Raw(`SELECT sequence, hash FROM trails WHERE project_id = ? AND sequence >= ? AND sequence <= ?
+		UNION ALL SELECT sequence, hash FROM archived_trails WHERE project_id = ? AND sequence >= ? AND sequence <= ?
+		ORDER BY sequence ASC`, project, from, to, project, from, to).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leaves := make([][]byte, 0, to-from+1)
+	expected := from
+	for rows.Next() {
+		var sequence int64
+		var hash string
+		if err := rows.Scan(&sequence, &hash); err != nil {
+			return nil, err
+		}
+
+		if sequence != expected {
+			return nil, fmt.Errorf("trail %d is missing from the chain", expected)
+		}
+
+		data, err := hex.DecodeString(hash)
+		if err != nil {
+			return nil, fmt.Errorf("trail %d: %v", sequence, err)
+		}
+
+		leaves = append(leaves, MerkleLeafHash(data))
+		expected++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if expected <= to {
+		return nil, fmt.Errorf("trail %d is missing from the chain", expected)
+	}
+
+	return leaves, nil
+}
+
+// InclusionProof proves a trail is a leaf of a checkpoint
+type InclusionProof struct {
+	// Trail
+	Trail Trail
+	// Checkpoint
+	Checkpoint Checkpoint
+	// LeafIndex position of the trail in the tree
+	LeafIndex int64
+	// LeafHash
+	LeafHash []byte
+	// AuditPath sibling hashes from the leaf up
+	AuditPath [][]byte
+}
+
+// ProveInclusion returns the inclusion proof of trail in the checkpoint
+// covering it, nil when the trail was not checkpointed yet
+func ProveInclusion(db *gorm.DB, trail Trail) (*InclusionProof, error) {
+	if trail.Sequence == 0 {
+		return nil, fmt.Errorf("trail %s is not part of the hash chain", trail.Model.ID.String())
+	}
+
+	checkpoint := Checkpoint{}
+	err := db.Where("chain = ? AND from_sequence <= ? AND to_sequence >= ?", trail.ProjectId.String(), trail.Sequence, trail.Sequence).
+		Find(&checkpoint).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	leaves, err := checkpointLeaves(db, trail.ProjectId, checkpoint.FromSequence, checkpoint.ToSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	index := trail.Sequence - checkpoint.FromSequence
+	if hex.EncodeToString(MerkleRoot(leaves)) != checkpoint.Root {
+		return nil, fmt.Errorf("trails of checkpoint %s no longer match its root", checkpoint.Model.ID.String())
+	}
+
+	return &InclusionProof{
+		Trail:      trail,
+		Checkpoint: checkpoint,
+		LeafIndex:  index,
+		LeafHash:   leaves[index],
+		AuditPath:  MerkleAuditPath(int(index), leaves),
+	}, nil
+}
+
+// CheckpointResolver resolver for Checkpoint
+type CheckpointResolver struct {
+	Checkpoint
+}
+
+// ID
+func (r *CheckpointResolver) ID() graphql.ID {
+	return graphql.ID(r.Checkpoint.Model.ID.String())
+}
+
+// Chain
+func (r *CheckpointResolver) Chain() string {
+	return r.Checkpoint.Chain
+}
+
+// FromSequence
+func (r *CheckpointResolver) FromSequence() string {
+	return strconv.FormatInt(r.Checkpoint.FromSequence, 10)
+}
+
+// ToSequence
+func (r *CheckpointResolver) ToSequence() string {
+	return strconv.FormatInt(r.Checkpoint.ToSequence, 10)
+}
+
+// TreeSize
+func (r *CheckpointResolver) TreeSize() int32 {
+	return int32(r.Checkpoint.TreeSize)
+}
+
+// Root
+func (r *CheckpointResolver) Root() string {
+	return r.Checkpoint.Root
+}
+
+// PreviousRoot
+func (r *CheckpointResolver) PreviousRoot() string {
+	return r.Checkpoint.PreviousRoot
+}
+
+// PublicKey
+func (r *CheckpointResolver) PublicKey() string {
+	return r.Checkpoint.PublicKey
+}
+
+// Signature
+func (r *CheckpointResolver) Signature() string {
+	return r.Checkpoint.Signature
+}
+
+// Message
+func (r *CheckpointResolver) Message() string {
+	return string(r.Checkpoint.Message())
+}
+
+// CreatedAt
+func (r *CheckpointResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.Checkpoint.Model.CreatedAt}
+}
+
+// InclusionProofResolver resolver for InclusionProof
+type InclusionProofResolver struct {
+	InclusionProof
+	DB *gorm.DB
+}
+
+// Trail
+func (r *InclusionProofResolver) Trail() *TrailResolver {
+	return &TrailResolver{DB: r.DB, Trail: r.InclusionProof.Trail}
+}
+
+// Checkpoint
+func (r *InclusionProofResolver) Checkpoint() *CheckpointResolver {
+	return &CheckpointResolver{Checkpoint: r.InclusionProof.Checkpoint}
+}
+
+// LeafIndex
+func (r *InclusionProofResolver) LeafIndex() int32 {
+	return int32(r.InclusionProof.LeafIndex)
+}
+
+// LeafHash
+func (r *InclusionProofResolver) LeafHash() string {
+	return hex.EncodeToString(r.InclusionProof.LeafHash)
+}
+
+// AuditPath
+func (r *InclusionProofResolver) AuditPath() []string {
+	path := make([]string, len(r.InclusionProof.AuditPath))
+	for i, hash := range r.InclusionProof.AuditPath {
+		path[i] = hex.EncodeToString(hash)
+	}
+	return path
+}
@@ -0,0 +1,83 @@
+package inspectr_resolvers
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestCheckpointVerify(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := Checkpoint{
+		Chain:        "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		FromSequence: 1,
+		ToSequence:   8,
+		TreeSize:     8,
+		Root:         rfc6962Roots[7],
+		PreviousRoot: rfc6962Roots[3],
+	}
+	signed.Model.CreatedAt = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	signed.sign(key)
+
+	if !signed.Verify() {
+		t.Fatal("signed checkpoint does not verify")
+	}
+
+	// flip returns s, a hex string, with one bit of its i-th byte flipped
+	flip := func(s string, i int) string {
+		data, _ := hex.DecodeString(s)
+		data[i] ^= 1
+		return hex.EncodeToString(data)
+	}
+
+	tests := map[string]func(c *Checkpoint){
+		"root":          func(c *Checkpoint) { c.Root = flip(c.Root, 0) },
+		"last root":     func(c *Checkpoint) { c.Root = flip(c.Root, 31) },
+		"previous root": func(c *Checkpoint) { c.PreviousRoot = flip(c.PreviousRoot, 10) },
+		"chain":         func(c *Checkpoint) { c.Chain = "7ba7b810-9dad-11d1-80b4-00c04fd430c8" },
+		"from":          func(c *Checkpoint) { c.FromSequence = 0 },
+		"to":            func(c *Checkpoint) { c.ToSequence = 9 },
+		"size":          func(c *Checkpoint) { c.TreeSize = 9 },
+		"created":       func(c *Checkpoint) { c.Model.CreatedAt = c.Model.CreatedAt.Add(time.Microsecond) },
+		"signature":     func(c *Checkpoint) { c.Signature = flip(c.Signature, 0) },
+		"public key":    func(c *Checkpoint) { c.PublicKey = flip(c.PublicKey, 0) },
+		"no signature":  func(c *Checkpoint) { c.Signature = "" },
+		"not hex":       func(c *Checkpoint) { c.Signature = "zz" + c.Signature[2:] },
+		"short key":     func(c *Checkpoint) { c.PublicKey = c.PublicKey[:62] },
+	}
+
+	for name, tamper := range tests {
+		checkpoint := signed
+		tamper(&checkpoint)
+		if checkpoint.Verify() {
+			t.Errorf("checkpoint with a tampered %s verifies", name)
+		}
+	}
+
+	// every byte of the signature counts
+	for i := 0; i < ed25519.SignatureSize; i++ {
+		checkpoint := signed
+		checkpoint.Signature = flip(checkpoint.Signature, i)
+		if checkpoint.Verify() {
+			t.Errorf("checkpoint verifies with byte %d of its signature flipped", i)
+		}
+	}
+
+	// and every byte of the signed message
+	message := signed.Message()
+	signature, _ := hex.DecodeString(signed.Signature)
+	publicKey := key.Public().(ed25519.PublicKey)
+	for i := range message {
+		tampered := append([]byte(nil), message...)
+		tampered[i] ^= 1
+		if ed25519.Verify(publicKey, tampered, signature) {
+			t.Errorf("signature verifies with byte %d of the message flipped", i)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package inspectr_resolvers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Cursor identifies a trail's position in the created_at, id ordering or,
+// for searches sorted by relevance, the rank, id ordering
+type Cursor struct {
+	// CreatedAt
+	CreatedAt time.Time
+	// ID
+	ID uuid.UUID
+	// Rank
+	Rank *float64
+}
+
+// EncodeCursor returns the opaque cursor of trail
+func EncodeCursor(trail Trail, rank *float64) string {
+	cursor := fmt.Sprintf("%s|%s", trail.Model.CreatedAt.UTC().Format(time.RFC3339Nano), trail.Model.ID.String())
+	if rank != nil {
+		cursor = fmt.Sprintf("%s|%s", cursor, strconv.FormatFloat(*rank, 'g', -1, 64))
+	}
+	return base64.StdEncoding.EncodeToString([]byte(cursor))
+}
+
+// DecodeCursor parses an opaque cursor returned by EncodeCursor
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 2 && len(parts) != 3 {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	id, err := uuid.FromString(parts[1])
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	cursor := Cursor{CreatedAt: createdAt, ID: id}
+
+	if len(parts) == 3 {
+		rank, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return Cursor{}, fmt.Errorf("invalid cursor: %v", err)
+		}
+		cursor.Rank = &rank
+	}
+
+	return cursor, nil
+}
+
+// TrailConnectionResolver resolver for TrailConnection
+type TrailConnectionResolver struct {
+	// Query counts the trails matching the filter, regardless of pagination
+	Query *gorm.DB
+	// Rows
+	Rows []Trail
+	// Ranks search relevance of each row, nil unless searching
+	Ranks []float64
+	// Highlighter
+	Highlighter *Highlighter
+	// RankedCursors encodes rank into cursors when sorting by relevance
+	RankedCursors bool
+	// HasNext
+	HasNext bool
+	// HasPrevious
+	HasPrevious bool
+	DB          *gorm.DB
+}
+
+// TotalCount
+func (r *TrailConnectionResolver) TotalCount() (int32, error) {
+	var count int32
+	if err := r.Query.Model(&Trail{}).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Edges
+func (r *TrailConnectionResolver) Edges() []*TrailEdgeResolver {
+	results := make([]*TrailEdgeResolver, 0, len(r.Rows))
+
+	for i, trail := range r.Rows {
+		edge := &TrailEdgeResolver{DB: r.DB, Trail: trail, Highlighter: r.Highlighter}
+		if r.Ranks != nil {
+			edge.rank = &r.Ranks[i]
+		}
+		edge.RankedCursor = r.RankedCursors
+		results = append(results, edge)
+	}
+
+	return results
+}
+
+// PageInfo
+func (r *TrailConnectionResolver) PageInfo() *PageInfoResolver {
+	pageInfo := &PageInfoResolver{
+		hasNextPage:     r.HasNext,
+		hasPreviousPage: r.HasPrevious,
+	}
+
+	if edges := r.Edges(); len(edges) > 0 {
+		startCursor := edges[0].Cursor()
+		endCursor := edges[len(edges)-1].Cursor()
+		pageInfo.startCursor = &startCursor
+		pageInfo.endCursor = &endCursor
+	}
+
+	return pageInfo
+}
+
+// TrailEdgeResolver resolver for TrailEdge
+type TrailEdgeResolver struct {
+	Trail
+	// RankedCursor
+	RankedCursor bool
+	// Highlighter
+	Highlighter *Highlighter
+	DB          *gorm.DB
+	rank        *float64
+}
+
+// Cursor
+func (r *TrailEdgeResolver) Cursor() string {
+	if r.RankedCursor {
+		return EncodeCursor(r.Trail, r.rank)
+	}
+	return EncodeCursor(r.Trail, nil)
+}
+
+// Rank
+func (r *TrailEdgeResolver) Rank() *float64 {
+	return r.rank
+}
+
+// Highlights
+func (r *TrailEdgeResolver) Highlights() ([]*HighlightResolver, error) {
+	results := []*HighlightResolver{}
+
+	if r.Highlighter == nil {
+		return results, nil
+	}
+
+	highlights, err := r.Highlighter.Get(r.Trail.Model.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, highlight := range highlights {
+		results = append(results, &HighlightResolver{Highlight: highlight})
+	}
+
+	return results, nil
+}
+
+// Node
+func (r *TrailEdgeResolver) Node() *TrailResolver {
+	return &TrailResolver{DB: r.DB, Trail: r.Trail}
+}
+
+// PageInfoResolver resolver for PageInfo
+type PageInfoResolver struct {
+	startCursor     *string
+	endCursor       *string
+	hasNextPage     bool
+	hasPreviousPage bool
+}
+
+// StartCursor
+func (r *PageInfoResolver) StartCursor() *string {
+	return r.startCursor
+}
+
+// EndCursor
+func (r *PageInfoResolver) EndCursor() *string {
+	return r.endCursor
+}
+
+// HasNextPage
+func (r *PageInfoResolver) HasNextPage() bool {
+	return r.hasNextPage
+}
+
+// HasPreviousPage
+func (r *PageInfoResolver) HasPreviousPage() bool {
+	return r.hasPreviousPage
+}
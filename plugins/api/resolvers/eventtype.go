@@ -0,0 +1,597 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codeamp/transistor"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/inspectr/backend/plugins/api/jsonschema"
+	"github.com/jinzhu/gorm"
+	"github.com/jinzhu/gorm/dialects/postgres"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Modes of event types, what happens to trails violating their schemas
+const (
+	// SchemaWarn stores the trail and records the violation
+	SchemaWarn = "warn"
+	// SchemaReject drops the trail
+	SchemaReject = "reject"
+	// SchemaQuarantine holds the trail in the quarantine at stage validate
+	SchemaQuarantine = "quarantine"
+)
+
+// SchemaModes every mode
+var SchemaModes = []string{SchemaWarn, SchemaReject, SchemaQuarantine}
+
+// Severities of event types
+const (
+	SeverityInfo     = "info"
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// Severities every severity, least severe first
+var Severities = []string{SeverityInfo, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+
+// MetadataFields the metadata fields of trails, in the order their schemas
+// are checked
+var MetadataFields = []string{"eventMetadata", "actorMetadata", "targetMetadata", "originMetadata"}
+
+// EventType registers an event of a project, with the JSON schemas its
+// metadata must follow. An empty schema allows any metadata.
+type EventType struct {
+	Model `json:",inline"`
+	// UpdatedAt
+	UpdatedAt time.Time `json:"updatedAt"`
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// Name of the event, as in Trail.Event
+	Name string `json:"name" gorm:"type:varchar(100)"`
+	// Description
+	Description string `json:"description" gorm:"type:text"`
+	// Category
+	Category string `json:"category" gorm:"type:varchar(100)"`
+	// Severity info, low, medium, high or critical
+	Severity string `json:"severity" gorm:"type:varchar(20)"`
+	// Mode warn, reject or quarantine
+	Mode string `json:"mode" gorm:"type:varchar(20)"`
+	// EventMetadataSchema
+	EventMetadataSchema postgres.Jsonb `json:"eventMetadataSchema" gorm:"type:jsonb"`
+	// ActorMetadataSchema
+	ActorMetadataSchema postgres.Jsonb `json:"actorMetadataSchema" gorm:"type:jsonb"`
+	// TargetMetadataSchema
+	TargetMetadataSchema postgres.Jsonb `json:"targetMetadataSchema" gorm:"type:jsonb"`
+	// OriginMetadataSchema
+	OriginMetadataSchema postgres.Jsonb `json:"originMetadataSchema" gorm:"type:jsonb"`
+}
+
+// schemas returns the schemas of t in the order of MetadataFields
+func (t *EventType) schemas() []json.RawMessage {
+	return []json.RawMessage{
+		t.EventMetadataSchema.RawMessage,
+		t.ActorMetadataSchema.RawMessage,
+		t.TargetMetadataSchema.RawMessage,
+		t.OriginMetadataSchema.RawMessage,
+	}
+}
+
+// Compile compiles the schemas of t
+func (t *EventType) Compile() (*CompiledEventType, error) {
+	compiled := &CompiledEventType{EventType: *t, schemas: make([]*jsonschema.Schema, len(MetadataFields))}
+
+	for i, raw := range t.schemas() {
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		schema, err := jsonschema.Compile(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s schema: %v", MetadataFields[i], err)
+		}
+		compiled.schemas[i] = schema
+	}
+
+	return compiled, nil
+}
+
+// Validate
+func (t *EventType) Validate() error {
+	if strings.TrimSpace(t.Name) == "" || len(t.Name) > 100 {
+		return fmt.Errorf("name must be between 1 and 100 characters")
+	}
+
+	if len(t.Category) > 100 {
+		return fmt.Errorf("category must be at most 100 characters")
+	}
+
+	if !transistor.SliceContains(t.Severity, Severities) {
+		return fmt.Errorf("unknown severity %q, expected one of %s", t.Severity, strings.Join(Severities, ", "))
+	}
+
+	if !transistor.SliceContains(t.Mode, SchemaModes) {
+		return fmt.Errorf("unknown mode %q, expected one of %s", t.Mode, strings.Join(SchemaModes, ", "))
+	}
+
+	_, err := t.Compile()
+	return err
+}
+
+// CompiledEventType event type whose schemas are compiled
+type CompiledEventType struct {
+	EventType
+	// schemas in the order of MetadataFields, nil when any metadata is
+	// allowed
+	schemas []*jsonschema.Schema
+}
+
+// Validate returns the violations of the schemas of t by the metadata of a
+// trail, decoded by encoding/json, prefixed by the field they are in
+func (t *CompiledEventType) Validate(eventMetadata, actorMetadata, targetMetadata, originMetadata interface{}) []string {
+	var violations []string
+	for i, metadata := range []interface{}{eventMetadata, actorMetadata, targetMetadata, originMetadata} {
+		if t.schemas[i] == nil {
+			continue
+		}
+		for _, violation := range t.schemas[i].Validate(metadata) {
+			violations = append(violations, MetadataFields[i]+violation.Path+": "+violation.Message)
+		}
+	}
+
+	return violations
+}
+
+// LoadEventTypes returns the compiled event types of project by name. Event
+// types whose schemas no longer compile are left out and reported in the
+// error.
+func LoadEventTypes(db *gorm.DB, project uuid.UUID) (map[string]*CompiledEventType, error) {
+	var rows []EventType
+	if err := db.Where("project_id = ?", project).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	types := map[string]*CompiledEventType{}
+	var errs []string
+	for i := range rows {
+		compiled, err := rows[i].Compile()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("event type %s: %v", rows[i].Name, err))
+			continue
+		}
+		types[rows[i].Name] = compiled
+	}
+
+	if len(errs) > 0 {
+		return types, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return types, nil
+}
+
+// SchemaViolation counts the trails of a producer violating the schemas of
+// an event type of a project
+type SchemaViolation struct {
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid;primary_key"`
+	// Event
+	Event string `json:"event" gorm:"type:varchar(100);primary_key"`
+	// Producer email of the user producing over HTTP, or the producer SQS
+	// message attribute
+	Producer string `json:"producer" gorm:"type:varchar(255);primary_key"`
+	// Count of violating trails
+	Count int64 `json:"count"`
+	// Rejected violating trails that were rejected or quarantined instead
+	// of stored
+	Rejected int64 `json:"rejected"`
+	// LastViolations of the last violating trail
+	LastViolations string `json:"lastViolations" gorm:"type:text"`
+	// FirstSeenAt
+	FirstSeenAt time.Time `json:"firstSeenAt"`
+	// LastSeenAt
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+// RecordSchemaViolation counts a trail of producer violating the schemas
+// of eventType, handled according to mode
+func RecordSchemaViolation(db *gorm.DB, eventType *CompiledEventType, producer string, mode string, violations []string) error {
+	rejected := 0
+	if mode != SchemaWarn {
+		rejected = 1
+	}
+
+	now := time.Now()
+	return db.Exec(`INSERT INTO schema_violations
+	(project_id, event, producer, count, rejected, last_violations, first_seen_at, last_seen_at)
+	VALUES (?, ?, ?, 1, ?, ?, ?, ?)
+	ON CONFLICT (project_id, event, producer) DO UPDATE SET
+		count = schema_violations.count + 1,
+		rejected = schema_violations.rejected + EXCLUDED.rejected,
+		last_violations = EXCLUDED.last_violations,
+		last_seen_at = EXCLUDED.last_seen_at`,
+		eventType.ProjectId, eventType.Name, producer, rejected, strings.Join(violations, "\n"), now, now,
+	).Error
+}
+
+// EventTypeInput
+type EventTypeInput struct {
+	// Name
+	Name string
+	// Description
+	Description *string
+	// Category
+	Category *string
+	// Severity
+	Severity *string
+	// Mode
+	Mode *string
+	// EventMetadataSchema
+	EventMetadataSchema *JSON
+	// ActorMetadataSchema
+	ActorMetadataSchema *JSON
+	// TargetMetadataSchema
+	TargetMetadataSchema *JSON
+	// OriginMetadataSchema
+	OriginMetadataSchema *JSON
+}
+
+// apply replaces the settings of eventType with input
+func (input *EventTypeInput) apply(eventType *EventType) error {
+	eventType.Name = strings.TrimSpace(input.Name)
+	eventType.Description = stringOr(input.Description, "")
+	eventType.Category = strings.TrimSpace(stringOr(input.Category, ""))
+	eventType.Severity = strings.ToLower(stringOr(input.Severity, SeverityInfo))
+	eventType.Mode = strings.ToLower(stringOr(input.Mode, SchemaWarn))
+
+	schemas := []struct {
+		input *JSON
+		value *postgres.Jsonb
+	}{
+		{input.EventMetadataSchema, &eventType.EventMetadataSchema},
+		{input.ActorMetadataSchema, &eventType.ActorMetadataSchema},
+		{input.TargetMetadataSchema, &eventType.TargetMetadataSchema},
+		{input.OriginMetadataSchema, &eventType.OriginMetadataSchema},
+	}
+	for _, schema := range schemas {
+		*schema.value = postgres.Jsonb{}
+		if schema.input != nil {
+			*schema.value = postgres.Jsonb{RawMessage: schema.input.RawMessage}
+		}
+	}
+
+	return eventType.Validate()
+}
+
+// canWriteSchemas reports whether the viewer of ctx may change the event
+// types of project
+func canWriteSchemas(ctx context.Context, project uuid.UUID) bool {
+	if HasScope(ctx, ScopeSchemasWrite) {
+		return true
+	}
+
+	viewer := ViewerFromContext(ctx)
+	return viewer != nil && transistor.SliceContains(project.String(), viewer.ProjectIDs(RoleAdmin))
+}
+
+// findEventType returns the event type identified by id when the viewer of
+// ctx may change it
+func (r *Resolver) findEventType(ctx context.Context, id graphql.ID) (EventType, error) {
+	eventType := EventType{}
+
+	eventTypeId, err := uuid.FromString(string(id))
+	if err != nil {
+		return eventType, fmt.Errorf("invalid event type id: %v", err)
+	}
+
+	if err := ScopeProjects(ctx, r.DB, "project_id").Where("id = ?", eventTypeId).Find(&eventType).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return eventType, fmt.Errorf("event type %q not found", string(id))
+		}
+		return eventType, err
+	}
+
+	if !canWriteSchemas(ctx, eventType.ProjectId) {
+		return eventType, &AuthError{Code: CodeForbidden, Message: "changing event types requires the admin role in the project"}
+	}
+
+	return eventType, nil
+}
+
+// EventTypes of a project, by name
+func (r *Resolver) EventTypes(ctx context.Context, args *struct {
+	Project *graphql.ID
+}) ([]*EventTypeResolver, error) {
+	if err := Authorize(ctx, "eventTypes"); err != nil {
+		return nil, err
+	}
+
+	project, err := ViewerProject(ctx, r.DB, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []EventType
+	if err := r.DB.Where("project_id = ?", project.Model.ID).Order("name asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*EventTypeResolver, len(rows))
+	for i, eventType := range rows {
+		results[i] = &EventTypeResolver{EventType: eventType}
+	}
+
+	return results, nil
+}
+
+// SchemaViolations of a project per producer and event, most recent first
+func (r *Resolver) SchemaViolations(ctx context.Context, args *struct {
+	Project  *graphql.ID
+	Producer *string
+	First    *int32
+}) ([]*SchemaViolationResolver, error) {
+	if err := Authorize(ctx, "schemaViolations"); err != nil {
+		return nil, err
+	}
+
+	first := int32(DefaultPageSize)
+	if args.First != nil {
+		if *args.First < 0 || *args.First > MaxPageSize {
+			return nil, fmt.Errorf("first must be between 0 and %d", MaxPageSize)
+		}
+		first = *args.First
+	}
+
+	project, err := ViewerProject(ctx, r.DB, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.DB.Where("project_id = ?", project.Model.ID)
+	if args.Producer != nil {
+		query = query.Where("producer = ?", *args.Producer)
+	}
+
+	var rows []SchemaViolation
+	if err := query.Order("last_seen_at desc").Limit(int(first)).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*SchemaViolationResolver, len(rows))
+	for i, violation := range rows {
+		results[i] = &SchemaViolationResolver{SchemaViolation: violation}
+	}
+
+	return results, nil
+}
+
+// CreateEventType
+func (r *Resolver) CreateEventType(ctx context.Context, args *struct {
+	Project graphql.ID
+	Input   EventTypeInput
+}) (*EventTypeResolver, error) {
+	if err := Authorize(ctx, "createEventType"); err != nil {
+		return nil, err
+	}
+
+	project, err := ViewerProject(ctx, r.DB, &args.Project)
+	if err != nil {
+		return nil, err
+	}
+	if !canWriteSchemas(ctx, project.Model.ID) {
+		return nil, &AuthError{Code: CodeForbidden, Message: "changing event types requires the admin role in the project"}
+	}
+
+	eventType := EventType{ProjectId: project.Model.ID}
+	if err := args.Input.apply(&eventType); err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := r.DB.Model(&EventType{}).Where("project_id = ? AND name = ?", eventType.ProjectId, eventType.Name).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("event type %q already exists", eventType.Name)
+	}
+
+	if err := r.DB.Create(&eventType).Error; err != nil {
+		return nil, err
+	}
+
+	return &EventTypeResolver{EventType: eventType}, nil
+}
+
+// UpdateEventType replaces the settings of an event type with input
+func (r *Resolver) UpdateEventType(ctx context.Context, args *struct {
+	ID    graphql.ID
+	Input EventTypeInput
+}) (*EventTypeResolver, error) {
+	if err := Authorize(ctx, "updateEventType"); err != nil {
+		return nil, err
+	}
+
+	eventType, err := r.findEventType(ctx, args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := args.Input.apply(&eventType); err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := r.DB.Model(&EventType{}).Where("project_id = ? AND name = ? AND id <> ?", eventType.ProjectId, eventType.Name, eventType.Model.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("event type %q already exists", eventType.Name)
+	}
+
+	eventType.UpdatedAt = time.Now()
+	err = r.DB.Model(&eventType).Updates(map[string]interface{}{
+		"name":                   eventType.Name,
+		"description":            eventType.Description,
+		"category":               eventType.Category,
+		"severity":               eventType.Severity,
+		"mode":                   eventType.Mode,
+		"event_metadata_schema":  eventType.EventMetadataSchema,
+		"actor_metadata_schema":  eventType.ActorMetadataSchema,
+		"target_metadata_schema": eventType.TargetMetadataSchema,
+		"origin_metadata_schema": eventType.OriginMetadataSchema,
+		"updated_at":             eventType.UpdatedAt,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventTypeResolver{EventType: eventType}, nil
+}
+
+// DeleteEventType deletes an event type, its trails are no longer checked.
+// Its schema violations are kept.
+func (r *Resolver) DeleteEventType(ctx context.Context, args *struct {
+	ID graphql.ID
+}) (bool, error) {
+	if err := Authorize(ctx, "deleteEventType"); err != nil {
+		return false, err
+	}
+
+	eventType, err := r.findEventType(ctx, args.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := r.DB.Delete(&eventType).Error; err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// EventTypeResolver resolver for EventType
+type EventTypeResolver struct {
+	EventType
+}
+
+// ID
+func (r *EventTypeResolver) ID() graphql.ID {
+	return graphql.ID(r.EventType.Model.ID.String())
+}
+
+// ProjectId
+func (r *EventTypeResolver) ProjectId() graphql.ID {
+	return graphql.ID(r.EventType.ProjectId.String())
+}
+
+// Name
+func (r *EventTypeResolver) Name() string {
+	return r.EventType.Name
+}
+
+// Description
+func (r *EventTypeResolver) Description() string {
+	return r.EventType.Description
+}
+
+// Category
+func (r *EventTypeResolver) Category() string {
+	return r.EventType.Category
+}
+
+// Severity
+func (r *EventTypeResolver) Severity() string {
+	return strings.ToUpper(r.EventType.Severity)
+}
+
+// Mode
+func (r *EventTypeResolver) Mode() string {
+	return strings.ToUpper(r.EventType.Mode)
+}
+
+// EventMetadataSchema
+func (r *EventTypeResolver) EventMetadataSchema() *JSON {
+	return schemaJSON(r.EventType.EventMetadataSchema)
+}
+
+// ActorMetadataSchema
+func (r *EventTypeResolver) ActorMetadataSchema() *JSON {
+	return schemaJSON(r.EventType.ActorMetadataSchema)
+}
+
+// TargetMetadataSchema
+func (r *EventTypeResolver) TargetMetadataSchema() *JSON {
+	return schemaJSON(r.EventType.TargetMetadataSchema)
+}
+
+// OriginMetadataSchema
+func (r *EventTypeResolver) OriginMetadataSchema() *JSON {
+	return schemaJSON(r.EventType.OriginMetadataSchema)
+}
+
+// CreatedAt
+func (r *EventTypeResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.EventType.Model.CreatedAt}
+}
+
+// UpdatedAt
+func (r *EventTypeResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.EventType.UpdatedAt}
+}
+
+// schemaJSON returns schema, nil when any metadata is allowed
+func schemaJSON(schema postgres.Jsonb) *JSON {
+	if len(schema.RawMessage) == 0 || string(schema.RawMessage) == "null" {
+		return nil
+	}
+	return &JSON{schema.RawMessage}
+}
+
+// SchemaViolationResolver resolver for SchemaViolation
+type SchemaViolationResolver struct {
+	SchemaViolation
+}
+
+// ProjectId
+func (r *SchemaViolationResolver) ProjectId() graphql.ID {
+	return graphql.ID(r.SchemaViolation.ProjectId.String())
+}
+
+// Event
+func (r *SchemaViolationResolver) Event() string {
+	return r.SchemaViolation.Event
+}
+
+// Producer
+func (r *SchemaViolationResolver) Producer() string {
+	return r.SchemaViolation.Producer
+}
+
+// Count
+func (r *SchemaViolationResolver) Count() int32 {
+	return int32(r.SchemaViolation.Count)
+}
+
+// Rejected
+func (r *SchemaViolationResolver) Rejected() int32 {
+	return int32(r.SchemaViolation.Rejected)
+}
+
+// LastViolations
+func (r *SchemaViolationResolver) LastViolations() []string {
+	return strings.Split(r.SchemaViolation.LastViolations, "\n")
+}
+
+// FirstSeenAt
+func (r *SchemaViolationResolver) FirstSeenAt() graphql.Time {
+	return graphql.Time{Time: r.SchemaViolation.FirstSeenAt}
+}
+
+// LastSeenAt
+func (r *SchemaViolationResolver) LastSeenAt() graphql.Time {
+	return graphql.Time{Time: r.SchemaViolation.LastSeenAt}
+}
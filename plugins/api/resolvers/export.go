@@ -0,0 +1,334 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/jinzhu/gorm"
+	"github.com/jinzhu/gorm/dialects/postgres"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Export job statuses
+const (
+	ExportPending  = "pending"
+	ExportRunning  = "running"
+	ExportComplete = "complete"
+	ExportFailed   = "failed"
+	ExportExpired  = "expired"
+)
+
+// ExportJob exports the trails matching a filter to the export store. Jobs
+// are created by the createExport mutation and run by the export worker of
+// the API plugin.
+type ExportJob struct {
+	Model `json:",inline"`
+	// UserId user who requested the export
+	UserId uuid.UUID `json:"userId" gorm:"type:uuid"`
+	// Format csv, jsonl or parquet
+	Format string `json:"format" gorm:"type:varchar(20)"`
+	// Filter TrailFilter the trails are selected by
+	Filter postgres.Jsonb `json:"filter" gorm:"type:jsonb"`
+	// Search full text search the trails are selected by
+	Search string `json:"search" gorm:"type:text"`
+	// Projects the requester could read when the job was created, JSON null
+	// for every project
+	Projects postgres.Jsonb `json:"projects" gorm:"type:jsonb"`
+	// Status
+	Status string `json:"status" gorm:"type:varchar(20)"`
+	// Rows number of trails exported so far
+	Rows int64 `json:"rows" gorm:"type:bigint"`
+	// Total number of trails matching when the job started
+	Total int64 `json:"total" gorm:"type:bigint"`
+	// Bytes size of the exported object
+	Bytes int64 `json:"bytes" gorm:"type:bigint"`
+	// Key of the object in the export store
+	Key string `json:"key" gorm:"type:varchar(255)"`
+	// Error of the last failed attempt
+	Error string `json:"error" gorm:"type:text"`
+	// Attempts number of times the job was started
+	Attempts int `json:"attempts"`
+	// DownloadToken authorizes downloads of the exported object
+	DownloadToken string `json:"-" gorm:"type:varchar(64)"`
+	// StartedAt
+	StartedAt *time.Time `json:"startedAt"`
+	// HeartbeatAt time the worker last reported progress
+	HeartbeatAt *time.Time `json:"heartbeatAt"`
+	// CompletedAt
+	CompletedAt *time.Time `json:"completedAt"`
+	// ExpiresAt time the exported object is deleted
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// Query returns the selection of the trails the job exports
+func (j *ExportJob) Query(db *gorm.DB) (*gorm.DB, error) {
+	var projects []string
+	if len(j.Projects.RawMessage) > 0 {
+		if err := json.Unmarshal(j.Projects.RawMessage, &projects); err != nil {
+			return nil, fmt.Errorf("export %s: invalid projects: %v", j.Model.ID.String(), err)
+		}
+	}
+
+	query := db
+	switch {
+	case projects == nil:
+	case len(projects) == 0:
+		query = query.Where("1 = 0")
+	default:
+		query = query.Where("project_id IN (?)", projects)
+	}
+
+	var filter *TrailFilter
+	if len(j.Filter.RawMessage) > 0 {
+		if err := json.Unmarshal(j.Filter.RawMessage, &filter); err != nil {
+			return nil, fmt.Errorf("export %s: invalid filter: %v", j.Model.ID.String(), err)
+		}
+	}
+
+	query, err := filter.Apply(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.Search != "" {
+		query = query.Where("search_vector @@ "+SearchQuery, j.Search)
+	}
+
+	return query, nil
+}
+
+// NewDownloadToken returns a random token for ExportJob.DownloadToken
+func NewDownloadToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateExport queues an export of the trails matching filter and search,
+// limited to the projects the viewer may read
+func (r *Resolver) CreateExport(ctx context.Context, args *struct {
+	Format string
+	Filter *TrailFilter
+	Search *string
+}) (*ExportResolver, error) {
+	if err := Authorize(ctx, "createExport"); err != nil {
+		return nil, err
+	}
+
+	// reject invalid filters now rather than in the worker
+	if _, err := args.Filter.Apply(r.DB); err != nil {
+		return nil, err
+	}
+
+	filter, err := json.Marshal(args.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	projects, err := json.Marshal(ReadableProjects(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.FromString(ViewerFromContext(ctx).UserID())
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id: %v", err)
+	}
+
+	token, err := NewDownloadToken()
+	if err != nil {
+		return nil, err
+	}
+
+	job := ExportJob{
+		UserId:        userId,
+		Format:        strings.ToLower(args.Format),
+		Filter:        postgres.Jsonb{RawMessage: filter},
+		Projects:      postgres.Jsonb{RawMessage: projects},
+		Status:        ExportPending,
+		DownloadToken: token,
+	}
+	if args.Search != nil {
+		job.Search = strings.TrimSpace(*args.Search)
+	}
+
+	if err := r.DB.Create(&job).Error; err != nil {
+		return nil, err
+	}
+
+	if r.ExportCreated != nil {
+		r.ExportCreated()
+	}
+
+	return &ExportResolver{ExportJob: job, PublicURL: r.PublicURL}, nil
+}
+
+// Exports requested by the viewer, newest first. Admins see every export.
+func (r *Resolver) Exports(ctx context.Context, args *struct {
+	First *int32
+}) ([]*ExportResolver, error) {
+	if err := Authorize(ctx, "exports"); err != nil {
+		return nil, err
+	}
+
+	first := int32(DefaultPageSize)
+	if args.First != nil {
+		if *args.First < 0 || *args.First > MaxPageSize {
+			return nil, fmt.Errorf("first must be between 0 and %d", MaxPageSize)
+		}
+		first = *args.First
+	}
+
+	var rows []ExportJob
+	if err := viewerExports(ctx, r.DB).Order("created_at desc").Limit(first).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*ExportResolver, len(rows))
+	for i, job := range rows {
+		results[i] = &ExportResolver{ExportJob: job, PublicURL: r.PublicURL}
+	}
+
+	return results, nil
+}
+
+// Export
+func (r *Resolver) Export(ctx context.Context, args *struct {
+	ID graphql.ID
+}) (*ExportResolver, error) {
+	if err := Authorize(ctx, "export"); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.FromString(string(args.ID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid export id: %v", err)
+	}
+
+	job := ExportJob{}
+	if err := viewerExports(ctx, r.DB).Where("id = ?", id).Find(&job).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &ExportResolver{ExportJob: job, PublicURL: r.PublicURL}, nil
+}
+
+// viewerExports restricts query to the exports the viewer of ctx requested
+func viewerExports(ctx context.Context, query *gorm.DB) *gorm.DB {
+	viewer := ViewerFromContext(ctx)
+	if viewer.IsAdmin() {
+		return query
+	}
+	return query.Where("user_id = ?", viewer.UserID())
+}
+
+// ExportResolver resolver for ExportJob
+type ExportResolver struct {
+	ExportJob
+	// PublicURL base URL download URLs are relative to
+	PublicURL string
+}
+
+// ID
+func (r *ExportResolver) ID() graphql.ID {
+	return graphql.ID(r.ExportJob.Model.ID.String())
+}
+
+// Format
+func (r *ExportResolver) Format() string {
+	return strings.ToUpper(r.ExportJob.Format)
+}
+
+// Status
+func (r *ExportResolver) Status() string {
+	return strings.ToUpper(r.ExportJob.Status)
+}
+
+// Rows
+func (r *ExportResolver) Rows() string {
+	return strconv.FormatInt(r.ExportJob.Rows, 10)
+}
+
+// Total
+func (r *ExportResolver) Total() string {
+	return strconv.FormatInt(r.ExportJob.Total, 10)
+}
+
+// Progress fraction of the trails exported, between 0 and 1
+func (r *ExportResolver) Progress() float64 {
+	switch {
+	case r.ExportJob.Status == ExportComplete:
+		return 1
+	case r.ExportJob.Total <= 0:
+		return 0
+	case r.ExportJob.Rows >= r.ExportJob.Total:
+		// trails created while exporting
+		return 1
+	default:
+		return float64(r.ExportJob.Rows) / float64(r.ExportJob.Total)
+	}
+}
+
+// Bytes
+func (r *ExportResolver) Bytes() string {
+	return strconv.FormatInt(r.ExportJob.Bytes, 10)
+}
+
+// Error
+func (r *ExportResolver) Error() *string {
+	if r.ExportJob.Error == "" {
+		return nil
+	}
+	return &r.ExportJob.Error
+}
+
+// DownloadUrl null until the export is complete
+func (r *ExportResolver) DownloadUrl() *string {
+	if r.ExportJob.Status != ExportComplete {
+		return nil
+	}
+
+	url := fmt.Sprintf("%s/exports/%s?token=%s", strings.TrimSuffix(r.PublicURL, "/"), r.ExportJob.Model.ID.String(), r.ExportJob.DownloadToken)
+	return &url
+}
+
+// CreatedAt
+func (r *ExportResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.ExportJob.Model.CreatedAt}
+}
+
+// StartedAt
+func (r *ExportResolver) StartedAt() *graphql.Time {
+	if r.ExportJob.StartedAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.ExportJob.StartedAt}
+}
+
+// CompletedAt
+func (r *ExportResolver) CompletedAt() *graphql.Time {
+	if r.ExportJob.CompletedAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.ExportJob.CompletedAt}
+}
+
+// ExpiresAt
+func (r *ExportResolver) ExpiresAt() *graphql.Time {
+	if r.ExportJob.ExpiresAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.ExportJob.ExpiresAt}
+}
@@ -0,0 +1,143 @@
+package inspectr_resolvers
+
+import (
+	"fmt"
+
+	"github.com/codeamp/transistor"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// TrailFilter
+type TrailFilter struct {
+	// Projects
+	Projects *[]graphql.ID `json:"projects,omitempty"`
+	// Event
+	Event *[]string `json:"event,omitempty"`
+	// Actor
+	Actor *[]string `json:"actor,omitempty"`
+	// Target
+	Target *[]string `json:"target,omitempty"`
+	// Origin
+	Origin *[]string `json:"origin,omitempty"`
+	// StartsAt
+	StartsAt *graphql.Time `json:"startsAt,omitempty"`
+	// EndsAt
+	EndsAt *graphql.Time `json:"endsAt,omitempty"`
+	// Metadata
+	Metadata *MetadataFilter `json:"metadata,omitempty"`
+}
+
+// Apply scopes query to the trails matching the filter
+func (f *TrailFilter) Apply(query *gorm.DB) (*gorm.DB, error) {
+	if f == nil {
+		return query, nil
+	}
+
+	columns := []struct {
+		name   string
+		values *[]string
+	}{
+		{"event", f.Event},
+		{"actor", f.Actor},
+		{"target", f.Target},
+		{"origin", f.Origin},
+	}
+
+	for _, column := range columns {
+		if column.values != nil && len(*column.values) > 0 {
+			query = query.Where(column.name+" IN (?)", *column.values)
+		}
+	}
+
+	if f.Projects != nil && len(*f.Projects) > 0 {
+		var projects []uuid.UUID
+		for _, id := range *f.Projects {
+			project, err := uuid.FromString(string(id))
+			if err != nil {
+				return nil, fmt.Errorf("invalid project id: %v", err)
+			}
+			projects = append(projects, project)
+		}
+		query = query.Where("project_id IN (?)", projects)
+	}
+
+	if f.StartsAt != nil {
+		query = query.Where("created_at >= ?", f.StartsAt.Time)
+	}
+
+	if f.EndsAt != nil {
+		query = query.Where("created_at <= ?", f.EndsAt.Time)
+	}
+
+	if f.Metadata != nil {
+		clause, args, err := f.Metadata.Compile()
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(clause, args...)
+	}
+
+	return query, nil
+}
+
+// Matches reports whether trail satisfies the filter. Metadata conditions
+// are evaluated by postgres against the stored trail.
+func (f *TrailFilter) Matches(db *gorm.DB, trail Trail) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+
+	columns := []struct {
+		value  string
+		values *[]string
+	}{
+		{trail.Event, f.Event},
+		{trail.Actor, f.Actor},
+		{trail.Target, f.Target},
+		{trail.Origin, f.Origin},
+	}
+
+	for _, column := range columns {
+		if column.values != nil && len(*column.values) > 0 && !transistor.SliceContains(column.value, *column.values) {
+			return false, nil
+		}
+	}
+
+	if f.Projects != nil && len(*f.Projects) > 0 {
+		matched := false
+		for _, id := range *f.Projects {
+			if string(id) == trail.ProjectId.String() {
+				matched = true
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	if f.StartsAt != nil && trail.Model.CreatedAt.Before(f.StartsAt.Time) {
+		return false, nil
+	}
+
+	if f.EndsAt != nil && trail.Model.CreatedAt.After(f.EndsAt.Time) {
+		return false, nil
+	}
+
+	if f.Metadata == nil {
+		return true, nil
+	}
+
+	query, err := f.Apply(db)
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	if err := query.Model(&Trail{}).Where("id = ?", trail.Model.ID).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,138 @@
+package inspectr_resolvers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultDedupeWindow time a trail's idempotency key is kept
+const DefaultDedupeWindow = 24 * time.Hour
+
+// maxKeyRows rows per INSERT statement of trail keys, postgres allows 65535
+// bind parameters
+const maxKeyRows = 65535 / 5
+
+// ErrDuplicateTrail is returned for trails whose idempotency key was used
+// by a trail stored within the dedupe window. The trail's ID is set to the
+// ID of the stored trail.
+var ErrDuplicateTrail = errors.New("trail was already stored")
+
+// TrailKey idempotency key of a stored trail, unique per project until it
+// expires
+type TrailKey struct {
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid;primary_key"`
+	// Key producer-supplied ID or source ID of the trail
+	Key string `json:"key" gorm:"type:varchar(255);primary_key"`
+	// TrailId trail stored with the key
+	TrailId uuid.UUID `json:"trailId" gorm:"type:uuid"`
+	// CreatedAt
+	CreatedAt time.Time `json:"createdAt"`
+	// ExpiresAt time the key may be used again
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// claimKeys claims the idempotency keys of trails, which belong to project,
+// for window and returns the trails whose key is taken: by a trail stored
+// within the window or by an earlier trail of trails. Keys are claimed in
+// tx, so they are released when it rolls back. Nothing is claimed when
+// window is not positive.
+func claimKeys(tx *gorm.DB, project uuid.UUID, trails []*Trail, window time.Duration) (map[*Trail]bool, error) {
+	duplicates := map[*Trail]bool{}
+	if window <= 0 {
+		return duplicates, nil
+	}
+
+	now := time.Now()
+	first := map[string]*Trail{}
+	var rows [][]interface{}
+	for _, trail := range trails {
+		key := trail.IdempotencyKey
+		if key == "" {
+			continue
+		}
+		if _, ok := first[key]; ok {
+			duplicates[trail] = true
+			continue
+		}
+
+		if trail.Model.ID == uuid.Nil {
+			trail.Model.ID = uuid.NewV4()
+		}
+		first[key] = trail
+		rows = append(rows, []interface{}{project, key, trail.Model.ID, now, now.Add(window)})
+	}
+
+	claimed := map[string]bool{}
+	for start := 0; start < len(rows); start += maxKeyRows {
+		end := start + maxKeyRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		// expired keys are claimed again, live ones are left alone and not
+		// returned
+		result, err := tx.Raw(`INSERT INTO trail_keys (project_id, key, trail_id, created_at, expires_at) VALUES ?
+		ON CONFLICT (project_id, key) DO UPDATE SET
+			trail_id = EXCLUDED.trail_id,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE trail_keys.expires_at <= EXCLUDED.created_at
+		RETURNING key`, rows[start:end]).Rows()
+		if err != nil {
+			return nil, err
+		}
+
+		for result.Next() {
+			var key string
+			if err := result.Scan(&key); err != nil {
+				result.Close()
+				return nil, err
+			}
+			claimed[key] = true
+		}
+		if err := result.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, trail := range first {
+		if !claimed[key] {
+			duplicates[trail] = true
+		}
+	}
+
+	if len(duplicates) == 0 {
+		return duplicates, nil
+	}
+
+	keys := make([]string, 0, len(duplicates))
+	for trail := range duplicates {
+		keys = append(keys, trail.IdempotencyKey)
+	}
+
+	var stored []TrailKey
+	if err := tx.Where("project_id = ? AND key IN (?)", project, keys).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+
+	ids := map[string]uuid.UUID{}
+	for _, key := range stored {
+		ids[key.Key] = key.TrailId
+	}
+	for trail := range duplicates {
+		trail.Model.ID = ids[trail.IdempotencyKey]
+	}
+
+	return duplicates, nil
+}
+
+// PruneTrailKeys deletes the keys that expired before now and returns how
+// many were deleted
+func PruneTrailKeys(db *gorm.DB, now time.Time) (int64, error) {
+	result := db.Where("expires_at < ?", now).Delete(&TrailKey{})
+	return result.RowsAffected, result.Error
+}
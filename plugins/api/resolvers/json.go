@@ -40,3 +40,28 @@ func (r *JSON) UnmarshalGraphQL(input interface{}) error {
 		return fmt.Errorf("JSON type not matched")
 	}
 }
+
+// MetadataValue JSON value a MetadataFilter compares with. Unlike JSON, a
+// GraphQL string is taken as a JSON string rather than JSON text, so "123"
+// matches the string and not the number; other scalars are converted as is.
+type MetadataValue struct {
+	JSON
+}
+
+func (r *MetadataValue) ImplementsGraphQLType(name string) bool {
+	return name == "JSON"
+}
+
+func (r *MetadataValue) UnmarshalGraphQL(input interface{}) error {
+	switch input.(type) {
+	case string, bool, int32, float64:
+		_input, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		r.RawMessage = _input
+		return nil
+	default:
+		return r.JSON.UnmarshalGraphQL(input)
+	}
+}
@@ -0,0 +1,98 @@
+package inspectr_resolvers
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Merkle trees follow RFC 6962: leaves and interior nodes are hashed with
+// distinct prefixes, and a tree of n leaves splits at the largest power of
+// two smaller than n. Proofs can therefore be checked with any Certificate
+// Transparency compatible verifier.
+
+// MerkleLeafHash returns the hash of a leaf holding data
+func MerkleLeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// MerkleNodeHash returns the hash of an interior node
+func MerkleNodeHash(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// MerkleRoot returns the root of the tree over leaf hashes
+func MerkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+
+	k := merkleSplit(len(leaves))
+	return MerkleNodeHash(MerkleRoot(leaves[:k]), MerkleRoot(leaves[k:]))
+}
+
+// MerkleAuditPath returns the sibling hashes needed to recompute the root
+// from the leaf at index, ordered from the leaf up
+func MerkleAuditPath(index int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return [][]byte{}
+	}
+
+	k := merkleSplit(len(leaves))
+	if index < k {
+		return append(MerkleAuditPath(index, leaves[:k]), MerkleRoot(leaves[k:]))
+	}
+	return append(MerkleAuditPath(index-k, leaves[k:]), MerkleRoot(leaves[:k]))
+}
+
+// VerifyMerkleInclusion reports whether path proves leaf is at index of a
+// tree of size leaves with the given root
+func VerifyMerkleInclusion(leaf []byte, index int64, size int64, path [][]byte, root []byte) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+
+	fn := index
+	sn := size - 1
+	hash := leaf
+
+	for _, sibling := range path {
+		if sn == 0 {
+			return false
+		}
+
+		if fn&1 == 1 || fn == sn {
+			hash = MerkleNodeHash(sibling, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = MerkleNodeHash(hash, sibling)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && bytes.Equal(hash, root)
+}
+
+// merkleSplit returns the largest power of two smaller than n
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
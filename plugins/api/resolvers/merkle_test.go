@@ -0,0 +1,167 @@
+package inspectr_resolvers
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// rfc6962Leaves leaf data of the RFC 6962 known-answer tests of Certificate
+// Transparency
+var rfc6962Leaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+// rfc6962Roots roots of the trees over the first 1 to 8 leaves
+var rfc6962Roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// rfc6962Tree returns the leaf hashes of the first n known-answer leaves
+func rfc6962Tree(t *testing.T, n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = MerkleLeafHash(decodeHex(t, rfc6962Leaves[i]))
+	}
+	return leaves
+}
+
+func TestMerkleRoot(t *testing.T) {
+	empty := hex.EncodeToString(MerkleRoot(nil))
+	if empty != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("root of the empty tree %s", empty)
+	}
+
+	for size := 1; size <= len(rfc6962Roots); size++ {
+		root := hex.EncodeToString(MerkleRoot(rfc6962Tree(t, size)))
+		if root != rfc6962Roots[size-1] {
+			t.Errorf("root of %d leaves %s, want %s", size, root, rfc6962Roots[size-1])
+		}
+	}
+}
+
+func TestMerkleAuditPath(t *testing.T) {
+	// known-answer inclusion proofs of Certificate Transparency
+	tests := []struct {
+		index int
+		size  int
+		path  []string
+	}{
+		{0, 1, nil},
+		{0, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{5, 8, []string{
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 3, []string{
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		}},
+		{1, 5, []string{
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	}
+
+	for _, test := range tests {
+		leaves := rfc6962Tree(t, test.size)
+
+		path := MerkleAuditPath(test.index, leaves)
+		if len(path) != len(test.path) {
+			t.Errorf("leaf %d of %d: path of %d hashes, want %d", test.index, test.size, len(path), len(test.path))
+			continue
+		}
+		for i := range path {
+			if hex.EncodeToString(path[i]) != test.path[i] {
+				t.Errorf("leaf %d of %d: hash %d of the path %x, want %s", test.index, test.size, i, path[i], test.path[i])
+			}
+		}
+
+		root := decodeHex(t, rfc6962Roots[test.size-1])
+		if !VerifyMerkleInclusion(leaves[test.index], int64(test.index), int64(test.size), path, root) {
+			t.Errorf("leaf %d of %d: known-answer proof does not verify", test.index, test.size)
+		}
+	}
+}
+
+func TestVerifyMerkleInclusion(t *testing.T) {
+	// every proof of every tree of up to 8 leaves verifies, and only for
+	// its own leaf, position and root
+	for size := 1; size <= len(rfc6962Leaves); size++ {
+		leaves := rfc6962Tree(t, size)
+		root := MerkleRoot(leaves)
+
+		for index := 0; index < size; index++ {
+			leaf := leaves[index]
+			path := MerkleAuditPath(index, leaves)
+
+			if !VerifyMerkleInclusion(leaf, int64(index), int64(size), path, root) {
+				t.Errorf("leaf %d of %d: proof does not verify", index, size)
+				continue
+			}
+
+			other := leaves[(index+1)%size]
+			if size > 1 && VerifyMerkleInclusion(other, int64(index), int64(size), path, root) {
+				t.Errorf("leaf %d of %d: proof verifies another leaf", index, size)
+			}
+			if size > 1 && VerifyMerkleInclusion(leaf, int64((index+1)%size), int64(size), path, root) {
+				t.Errorf("leaf %d of %d: proof verifies at another index", index, size)
+			}
+			if VerifyMerkleInclusion(leaf, int64(index), int64(size), path, leaves[0][:31]) {
+				t.Errorf("leaf %d of %d: proof verifies another root", index, size)
+			}
+			if VerifyMerkleInclusion(leaf, int64(index), int64(size), append(path, root), root) {
+				t.Errorf("leaf %d of %d: proof verifies with an extra hash", index, size)
+			}
+			if len(path) > 0 && VerifyMerkleInclusion(leaf, int64(index), int64(size), path[:len(path)-1], root) {
+				t.Errorf("leaf %d of %d: proof verifies without its last hash", index, size)
+			}
+
+			for i := range path {
+				tampered := make([][]byte, len(path))
+				copy(tampered, path)
+				tampered[i] = append([]byte(nil), path[i]...)
+				tampered[i][0] ^= 1
+				if VerifyMerkleInclusion(leaf, int64(index), int64(size), tampered, root) {
+					t.Errorf("leaf %d of %d: proof verifies with hash %d flipped", index, size, i)
+				}
+			}
+		}
+	}
+
+	leaves := rfc6962Tree(t, 4)
+	root := MerkleRoot(leaves)
+	for _, index := range []int64{-1, 4} {
+		if VerifyMerkleInclusion(leaves[0], index, 4, MerkleAuditPath(0, leaves), root) {
+			t.Errorf("proof verifies at index %d of 4", index)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package inspectr_resolvers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// MaxMetadataFilterDepth limits and/or nesting of a metadata filter
+	MaxMetadataFilterDepth = 8
+	// MaxMetadataFilterConditions limits the number of conditions in a metadata filter
+	MaxMetadataFilterConditions = 64
+)
+
+// MetadataColumns maps the MetadataField enum to trail columns
+var MetadataColumns = map[string]string{
+	"EVENT":  "event_metadata",
+	"ACTOR":  "actor_metadata",
+	"TARGET": "target_metadata",
+	"ORIGIN": "origin_metadata",
+}
+
+// MetadataFilter
+type MetadataFilter struct {
+	// And
+	And *[]*MetadataFilter `json:"and,omitempty"`
+	// Or
+	Or *[]*MetadataFilter `json:"or,omitempty"`
+	// Field
+	Field *string `json:"field,omitempty"`
+	// Path
+	Path *[]string `json:"path,omitempty"`
+	// Op
+	Op *string `json:"op,omitempty"`
+	// Value
+	Value *MetadataValue `json:"value,omitempty"`
+}
+
+// Compile translates the filter into a parameterized SQL condition
+func (f *MetadataFilter) Compile() (string, []interface{}, error) {
+	conditions := 0
+	return f.compile(0, &conditions)
+}
+
+func (f *MetadataFilter) compile(depth int, conditions *int) (string, []interface{}, error) {
+	if depth > MaxMetadataFilterDepth {
+		return "", nil, fmt.Errorf("metadata filter nested deeper than %d levels", MaxMetadataFilterDepth)
+	}
+
+	var clauses []string
+	var args []interface{}
+
+	groups := []struct {
+		operator string
+		filters  *[]*MetadataFilter
+	}{
+		{"AND", f.And},
+		{"OR", f.Or},
+	}
+
+	for _, group := range groups {
+		if group.filters == nil || len(*group.filters) == 0 {
+			continue
+		}
+
+		var groupClauses []string
+		for _, child := range *group.filters {
+			if child == nil {
+				continue
+			}
+			clause, childArgs, err := child.compile(depth+1, conditions)
+			if err != nil {
+				return "", nil, err
+			}
+			groupClauses = append(groupClauses, clause)
+			args = append(args, childArgs...)
+		}
+
+		if len(groupClauses) > 0 {
+			clauses = append(clauses, "("+strings.Join(groupClauses, " "+group.operator+" ")+")")
+		}
+	}
+
+	if f.Field != nil || f.Op != nil || f.Path != nil || f.Value != nil {
+		*conditions++
+		if *conditions > MaxMetadataFilterConditions {
+			return "", nil, fmt.Errorf("metadata filter has more than %d conditions", MaxMetadataFilterConditions)
+		}
+
+		clause, conditionArgs, err := f.compileCondition()
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, clause)
+		args = append(args, conditionArgs...)
+	}
+
+	if len(clauses) == 0 {
+		return "", nil, fmt.Errorf("metadata filter is empty")
+	}
+
+	return strings.Join(clauses, " AND "), args, nil
+}
+
+func (f *MetadataFilter) compileCondition() (string, []interface{}, error) {
+	if f.Field == nil {
+		return "", nil, fmt.Errorf("metadata filter is missing field")
+	}
+
+	column, ok := MetadataColumns[*f.Field]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown metadata field %q", *f.Field)
+	}
+
+	op := "EQ"
+	if f.Op != nil {
+		op = *f.Op
+	}
+
+	path := []string{}
+	if f.Path != nil {
+		path = *f.Path
+	}
+
+	if op != "EXISTS" && f.Value == nil {
+		return "", nil, fmt.Errorf("metadata filter %s requires a value", op)
+	}
+
+	switch op {
+	case "EQ":
+		if indexesArray(path) {
+			return fmt.Sprintf("(%s #> ? = ?::jsonb)", column),
+				[]interface{}{pq.StringArray(path), string(f.Value.RawMessage)}, nil
+		}
+		// containment lets the GIN index narrow candidates, the path
+		// comparison keeps arrays and objects from matching partially
+		document, err := nestDocument(path, f.Value.RawMessage)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("(%s @> ?::jsonb AND %s #> ? = ?::jsonb)", column, column),
+			[]interface{}{document, pq.StringArray(path), string(f.Value.RawMessage)}, nil
+	case "NEQ":
+		return fmt.Sprintf("(%s #> ? IS DISTINCT FROM ?::jsonb)", column),
+			[]interface{}{pq.StringArray(path), string(f.Value.RawMessage)}, nil
+	case "CONTAINS":
+		if indexesArray(path) {
+			return fmt.Sprintf("(%s #> ? @> ?::jsonb)", column),
+				[]interface{}{pq.StringArray(path), string(f.Value.RawMessage)}, nil
+		}
+		document, err := nestDocument(path, f.Value.RawMessage)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("(%s @> ?::jsonb)", column), []interface{}{document}, nil
+	case "EXISTS":
+		if len(path) == 0 {
+			return "", nil, fmt.Errorf("metadata filter EXISTS requires a path")
+		}
+		// the jsonb_path_ops indexes serve containment, which can not test
+		// for any value at path, and jsonpath's @? needs PostgreSQL 12:
+		// existence is checked row by row
+		return fmt.Sprintf("(%s #> ? IS NOT NULL)", column), []interface{}{pq.StringArray(path)}, nil
+	case "GT", "GTE", "LT", "LTE":
+		var number json.Number
+		decoder := json.NewDecoder(strings.NewReader(string(f.Value.RawMessage)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&number); err != nil {
+			return "", nil, fmt.Errorf("metadata filter %s requires a numeric value", op)
+		}
+
+		comparisons := map[string]string{"GT": ">", "GTE": ">=", "LT": "<", "LTE": "<="}
+
+		// the CASE guards the numeric cast from non-numeric values
+		return fmt.Sprintf("(CASE WHEN jsonb_typeof(%s #> ?) = 'number' THEN (%s #>> ?)::numeric %s ?::numeric ELSE false END)",
+				column, column, comparisons[op]),
+			[]interface{}{pq.StringArray(path), pq.StringArray(path), number.String()}, nil
+	default:
+		return "", nil, fmt.Errorf("unknown metadata operator %q", op)
+	}
+}
+
+// indexesArray returns whether a segment of path may be an array index,
+// such paths can not be matched by containment of nested objects
+func indexesArray(path []string) bool {
+	for _, segment := range path {
+		if _, err := strconv.Atoi(segment); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// nestDocument wraps value in objects keyed by path, e.g. ["a", "b"] and 1
+// becomes {"a": {"b": 1}}. Paths through arrays are not supported, see
+// indexesArray.
+func nestDocument(path []string, value json.RawMessage) (string, error) {
+	var document interface{} = value
+
+	for i := len(path) - 1; i >= 0; i-- {
+		document = map[string]interface{}{path[i]: document}
+	}
+
+	marshaled, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+
+	return string(marshaled), nil
+}
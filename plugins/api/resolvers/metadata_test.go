@@ -0,0 +1,215 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/lib/pq"
+)
+
+func TestMetadataFilterCompile(t *testing.T) {
+	field := "ACTOR"
+	tests := []struct {
+		name   string
+		op     string
+		path   []string
+		value  interface{}
+		clause string
+		args   []interface{}
+	}{
+		{
+			name:   "EQ nests objects",
+			op:     "EQ",
+			path:   []string{"user", "id"},
+			value:  "123",
+			clause: "(actor_metadata @> ?::jsonb AND actor_metadata #> ? = ?::jsonb)",
+			args:   []interface{}{`{"user":{"id":"123"}}`, pq.StringArray{"user", "id"}, `"123"`},
+		},
+		{
+			name:   "EQ through an array index",
+			op:     "EQ",
+			path:   []string{"items", "0"},
+			value:  int32(5),
+			clause: "(actor_metadata #> ? = ?::jsonb)",
+			args:   []interface{}{pq.StringArray{"items", "0"}, "5"},
+		},
+		{
+			name:   "CONTAINS nests objects",
+			op:     "CONTAINS",
+			path:   []string{"roles"},
+			value:  []interface{}{"admin"},
+			clause: "(actor_metadata @> ?::jsonb)",
+			args:   []interface{}{`{"roles":["admin"]}`},
+		},
+		{
+			name:   "EXISTS",
+			op:     "EXISTS",
+			path:   []string{"user", "id"},
+			clause: "(actor_metadata #> ? IS NOT NULL)",
+			args:   []interface{}{pq.StringArray{"user", "id"}},
+		},
+		{
+			name:   "CONTAINS through an array index",
+			op:     "CONTAINS",
+			path:   []string{"items", "-1", "tags"},
+			value:  []interface{}{"a"},
+			clause: "(actor_metadata #> ? @> ?::jsonb)",
+			args:   []interface{}{pq.StringArray{"items", "-1", "tags"}, `["a"]`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			op, path := test.op, test.path
+			filter := MetadataFilter{Field: &field, Op: &op, Path: &path}
+			if test.value != nil {
+				filter.Value = &MetadataValue{}
+				if err := filter.Value.UnmarshalGraphQL(test.value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			clause, args, err := filter.Compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if clause != test.clause {
+				t.Errorf("clause = %s, want %s", clause, test.clause)
+			}
+			if !reflect.DeepEqual(args, test.args) {
+				t.Errorf("args = %#v, want %#v", args, test.args)
+			}
+		})
+	}
+}
+
+func TestMetadataValueUnmarshalGraphQL(t *testing.T) {
+	tests := []struct {
+		input interface{}
+		want  string
+	}{
+		{"123", `"123"`},
+		{"true", `"true"`},
+		{`{"a": 1}`, `"{\"a\": 1}"`},
+		{"plain text", `"plain text"`},
+		{int32(123), `123`},
+		{1.5, `1.5`},
+		{true, `true`},
+		{[]interface{}{"a", int32(1)}, `["a",1]`},
+		{map[string]interface{}{"a": int32(1)}, `{"a":1}`},
+	}
+
+	for _, test := range tests {
+		var value MetadataValue
+		if err := value.UnmarshalGraphQL(test.input); err != nil {
+			t.Fatal(err)
+		}
+		if string(value.RawMessage) != test.want {
+			t.Errorf("UnmarshalGraphQL(%#v) = %s, want %s", test.input, value.RawMessage, test.want)
+		}
+	}
+}
+
+func TestJSONUnmarshalGraphQL(t *testing.T) {
+	// JSON arguments other than metadata filter values, such as schemas,
+	// take strings as JSON text
+	tests := []struct {
+		input interface{}
+		want  string
+	}{
+		{`{"type": "object"}`, `{"type": "object"}`},
+		{"true", `true`},
+		{[]interface{}{"a"}, `["a"]`},
+		{map[string]interface{}{"type": "object"}, `{"type":"object"}`},
+	}
+
+	for _, test := range tests {
+		var value JSON
+		if err := value.UnmarshalGraphQL(test.input); err != nil {
+			t.Fatal(err)
+		}
+		if string(value.RawMessage) != test.want {
+			t.Errorf("UnmarshalGraphQL(%#v) = %s, want %s", test.input, value.RawMessage, test.want)
+		}
+	}
+
+	for _, input := range []interface{}{true, int32(1), 1.5} {
+		var value JSON
+		if err := value.UnmarshalGraphQL(input); err == nil {
+			t.Errorf("UnmarshalGraphQL(%#v) = %s, want an error", input, value.RawMessage)
+		}
+	}
+}
+
+// jsonArguments resolves a query taking a metadata filter and another JSON
+// argument
+type jsonArguments struct{}
+
+func (*jsonArguments) Arguments(args struct {
+	Metadata MetadataFilter
+	Schema   JSON
+}) []string {
+	return []string{string(args.Metadata.Value.RawMessage), string(args.Schema.RawMessage)}
+}
+
+func TestJSONArguments(t *testing.T) {
+	schema := graphql.MustParseSchema(`
+		schema { query: Query }
+		scalar JSON
+		enum MetadataField { ACTOR }
+		enum MetadataOperator { EQ }
+		input MetadataFilter {
+			and: [MetadataFilter!]
+			or: [MetadataFilter!]
+			field: MetadataField
+			path: [String!]
+			op: MetadataOperator
+			value: JSON
+		}
+		type Query { arguments(metadata: MetadataFilter!, schema: JSON!): [String!]! }
+	`, &jsonArguments{})
+
+	// the same string is a JSON string in the filter, JSON text elsewhere
+	response := schema.Exec(context.Background(), `query ($schema: JSON!) {
+		arguments(metadata: {field: ACTOR, path: ["id"], value: "{\"type\": \"object\"}"}, schema: $schema)
+	}`, "", map[string]interface{}{"schema": `{"type": "object"}`})
+	if len(response.Errors) > 0 {
+		t.Fatal(response.Errors)
+	}
+
+	var data struct {
+		Arguments []string
+	}
+	if err := json.Unmarshal(response.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`"{\"type\": \"object\"}"`, `{"type": "object"}`}
+	if !reflect.DeepEqual(data.Arguments, want) {
+		t.Errorf("got %q, want %q", data.Arguments, want)
+	}
+}
+
+func TestMetadataFilterJSON(t *testing.T) {
+	// alert rules store their filter as JSON
+	field, path := "ACTOR", []string{"id"}
+	filter := MetadataFilter{Field: &field, Path: &path, Value: &MetadataValue{JSON{json.RawMessage(`"123"`)}}}
+
+	stored, err := json.Marshal(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored) != `{"field":"ACTOR","path":["id"],"value":"123"}` {
+		t.Errorf("stored as %s", stored)
+	}
+
+	var loaded MetadataFilter
+	if err := json.Unmarshal(stored, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Value == nil || string(loaded.Value.RawMessage) != `"123"` {
+		t.Errorf("loaded value %v, want \"123\"", loaded.Value)
+	}
+}
@@ -2,12 +2,32 @@ package inspectr_resolvers
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	graphql "github.com/graph-gophers/graphql-go"
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 )
 
+const (
+	// DefaultMetricGroups groups returned by grouped metrics, the rest are
+	// counted in the other group
+	DefaultMetricGroups = 10
+	// MaxMetricGroups
+	MaxMetricGroups = 100
+)
+
+// MetricColumns maps the MetadataField enum to the trail columns metrics are
+// grouped by
+var MetricColumns = map[string]string{
+	"EVENT":  "event",
+	"ACTOR":  "actor",
+	"TARGET": "target",
+	"ORIGIN": "origin",
+}
+
 // Metric
 type Metric struct {
 	// Interval
@@ -16,6 +36,84 @@ type Metric struct {
 	StartsAt time.Time `json:"startsAt"`
 	// EventMetadata
 	Size int32 `json:"size"`
+	// Group value of the grouped dimension, nil when ungrouped or Other
+	Group *string `json:"group,omitempty"`
+	// Other counts the groups past the top ones
+	Other bool `json:"other,omitempty"`
+}
+
+// MetricGroupBy
+type MetricGroupBy struct {
+	// Field
+	Field string
+	// Path
+	Path *[]string
+}
+
+// expression returns the SQL expression of the value trails are grouped by,
+// NULL for trails missing the metadata path
+func (g *MetricGroupBy) expression() (string, []interface{}, error) {
+	if g.Path == nil || len(*g.Path) == 0 {
+		column, ok := MetricColumns[g.Field]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown metric group field %q", g.Field)
+		}
+		return column, nil, nil
+	}
+
+	column, ok := MetadataColumns[g.Field]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown metric group field %q", g.Field)
+	}
+
+	// the path is quoted rather than bound, Select expands array arguments
+	path, err := pq.StringArray(*g.Path).Value()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return column + " #>> " + quoteLiteral(path.(string)), nil, nil
+}
+
+// quoteLiteral quotes s as a SQL string literal, as pq.QuoteLiteral of
+// newer lib/pq releases
+func quoteLiteral(s string) string {
+	s = strings.Replace(s, "'", "''", -1)
+	if strings.Contains(s, `\`) {
+		return `E'` + strings.Replace(s, `\`, `\\`, -1) + `'`
+	}
+	return `'` + s + `'`
+}
+
+// intervalCount is the number of trails of a group in an interval
+type intervalCount struct {
+	Value    *string   `json:"value"`
+	Count    int32     `json:"count"`
+	Interval time.Time `json:"interval"`
+}
+
+// metricBucket returns the SQL expression of the start of the interval
+// column falls in, taking the interval in seconds twice
+func metricBucket(column string) string {
+	return `to_timestamp(floor((extract('epoch' from ` + column + `) / ? )) * ?) AT TIME ZONE 'UTC'`
+}
+
+// fillMetrics returns the metrics of every interval from startsAt until
+// endsAt, those missing from counts, keyed by their unix start, are empty
+func fillMetrics(counts map[int64]int32, startsAt time.Time, endsAt time.Time, interval int32, group *string, other bool) []Metric {
+	var metrics []Metric
+
+	for t := startsAt; t.Before(endsAt); t = t.Add(time.Duration(interval) * time.Second) {
+		metrics = append(metrics, Metric{
+			Interval: interval,
+			StartsAt: t,
+			Size:     counts[t.Unix()],
+			Group:    group,
+			Other:    other,
+		})
+	}
+
+	return metrics
 }
 
 // TrailResolver resolver for Trail
@@ -39,6 +137,16 @@ func (r *MetricResolver) Size() int32 {
 	return int32(r.Metric.Size)
 }
 
+// Group
+func (r *MetricResolver) Group() *string {
+	return r.Metric.Group
+}
+
+// Other
+func (r *MetricResolver) Other() bool {
+	return r.Metric.Other
+}
+
 func (r *MetricResolver) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&r.Metric)
 }
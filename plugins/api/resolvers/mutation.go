@@ -0,0 +1,547 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codeamp/transistor"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Permissions every value a UserPermission can hold
+var Permissions = []string{
+	ScopeAdmin,
+	ScopeTrailsRead,
+	ScopeTrailsExport,
+	ScopeMetricsRead,
+	ScopeChainVerify,
+	ScopeCheckpointsRead,
+	ScopeProjectsRead,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeMembersWrite,
+	ScopeAlertsRead,
+	ScopeAlertsWrite,
+}
+
+// SecretHashCost bcrypt cost of API secrets, matching utils.HashPassword
+const SecretHashCost = 14
+
+// ProjectRoleInput
+type ProjectRoleInput struct {
+	// Project ID or organization/project slug
+	Project graphql.ID
+	// Role
+	Role string
+}
+
+// InviteUser creates a user who signs in through OIDC with email
+func (r *Resolver) InviteUser(ctx context.Context, args *struct {
+	Email       string
+	Permissions *[]string
+	Roles       *[]ProjectRoleInput
+}) (*UserResolver, error) {
+	if err := Authorize(ctx, "inviteUser"); err != nil {
+		return nil, err
+	}
+
+	email := strings.TrimSpace(strings.ToLower(args.Email))
+	if !strings.Contains(email, "@") || len(email) > 100 {
+		return nil, fmt.Errorf("invalid email %q", args.Email)
+	}
+
+	var permissions []string
+	if args.Permissions != nil {
+		permissions = *args.Permissions
+	}
+
+	var roles []ProjectRoleInput
+	if args.Roles != nil {
+		roles = *args.Roles
+	}
+
+	// members:write alone only invites into the viewer's projects
+	if len(roles) == 0 && !HasScope(ctx, ScopeUsersWrite) {
+		return nil, &AuthError{Code: CodeForbidden, Message: fmt.Sprintf("inviting users without roles requires the %s scope", ScopeUsersWrite)}
+	}
+
+	for _, permission := range permissions {
+		if err := canGrantPermission(ctx, permission); err != nil {
+			return nil, err
+		}
+	}
+
+	auditProject, err := r.auditProject()
+	if err != nil {
+		return nil, err
+	}
+
+	user := User{Email: email}
+	records := []auditRecord{
+		{Project: auditProject, Event: "user.invited", Target: &user},
+	}
+
+	for _, permission := range permissions {
+		records = append(records, auditRecord{
+			Project:  auditProject,
+			Event:    "user.permission.granted",
+			Target:   &user,
+			Metadata: map[string]interface{}{"permission": permission},
+		})
+	}
+
+	var projectRoles []ProjectRole
+	for _, input := range roles {
+		project, role, err := r.manageableRole(ctx, input.Project, input.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		projectRoles = append(projectRoles, ProjectRole{ProjectId: project.Model.ID, Value: role})
+		records = append(records, auditRecord{
+			Project:  project,
+			Event:    "user.role.granted",
+			Target:   &user,
+			Metadata: map[string]interface{}{"project": project.Tenant(), "role": role},
+		})
+	}
+
+	err = r.audit(ctx, func(tx *gorm.DB) error {
+		if !tx.Where("email = ?", email).Find(&User{}).RecordNotFound() {
+			return fmt.Errorf("user %q already exists", email)
+		}
+
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		for _, permission := range permissions {
+			if err := tx.Create(&UserPermission{UserId: user.Model.ID, Value: permission}).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, projectRole := range projectRoles {
+			projectRole.UserId = user.Model.ID
+			if err := tx.Create(&projectRole).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, records...)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.changedUser(ctx, user.Model.ID)
+}
+
+// GrantPermission
+func (r *Resolver) GrantPermission(ctx context.Context, args *struct {
+	UserId     graphql.ID
+	Permission string
+}) (*UserResolver, error) {
+	if err := Authorize(ctx, "grantPermission"); err != nil {
+		return nil, err
+	}
+
+	if err := canGrantPermission(ctx, args.Permission); err != nil {
+		return nil, err
+	}
+
+	user, err := r.findUser(args.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, permission := range user.Permissions {
+		if permission.Value == args.Permission {
+			return r.changedUser(ctx, user.Model.ID)
+		}
+	}
+
+	auditProject, err := r.auditProject()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.audit(ctx, func(tx *gorm.DB) error {
+		return tx.Create(&UserPermission{UserId: user.Model.ID, Value: args.Permission}).Error
+	}, auditRecord{
+		Project:  auditProject,
+		Event:    "user.permission.granted",
+		Target:   &user,
+		Metadata: map[string]interface{}{"permission": args.Permission},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	InvalidateClaims(r.Redis, user.Model.ID)
+
+	return r.changedUser(ctx, user.Model.ID)
+}
+
+// RevokePermission
+func (r *Resolver) RevokePermission(ctx context.Context, args *struct {
+	UserId     graphql.ID
+	Permission string
+}) (*UserResolver, error) {
+	if err := Authorize(ctx, "revokePermission"); err != nil {
+		return nil, err
+	}
+
+	if err := canGrantPermission(ctx, args.Permission); err != nil {
+		return nil, err
+	}
+
+	user, err := r.findUser(args.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	held := false
+	for _, permission := range user.Permissions {
+		if permission.Value == args.Permission {
+			held = true
+		}
+	}
+
+	if !held {
+		return r.changedUser(ctx, user.Model.ID)
+	}
+
+	auditProject, err := r.auditProject()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.audit(ctx, func(tx *gorm.DB) error {
+		return tx.Where("user_id = ? AND value = ?", user.Model.ID, args.Permission).Delete(&UserPermission{}).Error
+	}, auditRecord{
+		Project:  auditProject,
+		Event:    "user.permission.revoked",
+		Target:   &user,
+		Metadata: map[string]interface{}{"permission": args.Permission},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	InvalidateClaims(r.Redis, user.Model.ID)
+
+	return r.changedUser(ctx, user.Model.ID)
+}
+
+// GrantProjectRole
+func (r *Resolver) GrantProjectRole(ctx context.Context, args *struct {
+	UserId  graphql.ID
+	Project graphql.ID
+	Role    string
+}) (*UserResolver, error) {
+	if err := Authorize(ctx, "grantProjectRole"); err != nil {
+		return nil, err
+	}
+
+	project, role, err := r.manageableRole(ctx, args.Project, args.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := r.findUser(args.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, projectRole := range user.ProjectRoles {
+		if projectRole.ProjectId == project.Model.ID && projectRole.Value == role {
+			return r.changedUser(ctx, user.Model.ID)
+		}
+	}
+
+	err = r.audit(ctx, func(tx *gorm.DB) error {
+		return tx.Create(&ProjectRole{UserId: user.Model.ID, ProjectId: project.Model.ID, Value: role}).Error
+	}, auditRecord{
+		Project:  project,
+		Event:    "user.role.granted",
+		Target:   &user,
+		Metadata: map[string]interface{}{"project": project.Tenant(), "role": role},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	InvalidateClaims(r.Redis, user.Model.ID)
+
+	return r.changedUser(ctx, user.Model.ID)
+}
+
+// RevokeProjectRole
+func (r *Resolver) RevokeProjectRole(ctx context.Context, args *struct {
+	UserId  graphql.ID
+	Project graphql.ID
+	Role    string
+}) (*UserResolver, error) {
+	if err := Authorize(ctx, "revokeProjectRole"); err != nil {
+		return nil, err
+	}
+
+	project, role, err := r.manageableRole(ctx, args.Project, args.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := r.findUser(args.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	held := false
+	for _, projectRole := range user.ProjectRoles {
+		if projectRole.ProjectId == project.Model.ID && projectRole.Value == role {
+			held = true
+		}
+	}
+
+	if !held {
+		return r.changedUser(ctx, user.Model.ID)
+	}
+
+	err = r.audit(ctx, func(tx *gorm.DB) error {
+		return tx.Where("user_id = ? AND project_id = ? AND value = ?", user.Model.ID, project.Model.ID, role).Delete(&ProjectRole{}).Error
+	}, auditRecord{
+		Project:  project,
+		Event:    "user.role.revoked",
+		Target:   &user,
+		Metadata: map[string]interface{}{"project": project.Tenant(), "role": role},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	InvalidateClaims(r.Redis, user.Model.ID)
+
+	return r.changedUser(ctx, user.Model.ID)
+}
+
+// DeactivateUser stops user from signing in and producing trails
+func (r *Resolver) DeactivateUser(ctx context.Context, args *struct {
+	UserId graphql.ID
+}) (*UserResolver, error) {
+	if err := Authorize(ctx, "deactivateUser"); err != nil {
+		return nil, err
+	}
+
+	user, err := r.findUser(args.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if viewer := ViewerFromContext(ctx); viewer.UserID() == user.Model.ID.String() {
+		return nil, fmt.Errorf("you can not deactivate yourself")
+	}
+
+	for _, permission := range user.Permissions {
+		if permission.Value == ScopeAdmin && !HasScope(ctx, ScopeAdmin) {
+			return nil, &AuthError{Code: CodeForbidden, Message: "deactivating admins requires the admin permission"}
+		}
+	}
+
+	if user.DeactivatedAt != nil {
+		return r.changedUser(ctx, user.Model.ID)
+	}
+
+	auditProject, err := r.auditProject()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.audit(ctx, func(tx *gorm.DB) error {
+		return tx.Model(&user).UpdateColumn("deactivated_at", time.Now()).Error
+	}, auditRecord{
+		Project: auditProject,
+		Event:   "user.deactivated",
+		Target:  &user,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	InvalidateClaims(r.Redis, user.Model.ID)
+
+	return r.changedUser(ctx, user.Model.ID)
+}
+
+// RotateApiKey replaces the API key and secret of a user. The secret is
+// only ever returned here.
+func (r *Resolver) RotateApiKey(ctx context.Context, args *struct {
+	UserId graphql.ID
+}) (*ApiCredentialsResolver, error) {
+	if err := Authorize(ctx, "rotateApiKey", "user/"+string(args.UserId)); err != nil {
+		return nil, err
+	}
+
+	user, err := r.findUser(args.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.DeactivatedAt != nil {
+		return nil, fmt.Errorf("user %q is deactivated", user.Email)
+	}
+
+	key, err := randomString(20, hex.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), SecretHashCost)
+	if err != nil {
+		return nil, err
+	}
+
+	auditProject, err := r.auditProject()
+	if err != nil {
+		return nil, err
+	}
+
+	previousKey := user.APIKey
+	err = r.audit(ctx, func(tx *gorm.DB) error {
+		return tx.Model(&user).UpdateColumns(map[string]interface{}{
+			"api_key":    key,
+			"api_secret": string(hash),
+		}).Error
+	}, auditRecord{
+		Project:  auditProject,
+		Event:    "user.api_key.rotated",
+		Target:   &user,
+		Metadata: map[string]interface{}{"apiKey": key, "previousApiKey": previousKey},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	userResolver, err := r.changedUser(ctx, user.Model.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApiCredentialsResolver{apiKey: key, apiSecret: secret, user: userResolver}, nil
+}
+
+// ApiCredentialsResolver resolver for the credentials returned by
+// RotateApiKey
+type ApiCredentialsResolver struct {
+	apiKey    string
+	apiSecret string
+	user      *UserResolver
+}
+
+// ApiKey
+func (r *ApiCredentialsResolver) ApiKey() string {
+	return r.apiKey
+}
+
+// ApiSecret
+func (r *ApiCredentialsResolver) ApiSecret() string {
+	return r.apiSecret
+}
+
+// User
+func (r *ApiCredentialsResolver) User() *UserResolver {
+	return r.user
+}
+
+// canGrantPermission checks that the viewer of ctx may grant and revoke
+// permission. Only admins hand out admin, everyone else only the scopes
+// they hold.
+func canGrantPermission(ctx context.Context, permission string) error {
+	if !transistor.SliceContains(permission, Permissions) {
+		return fmt.Errorf("unknown permission %q, expected one of %s", permission, strings.Join(Permissions, ", "))
+	}
+
+	if !HasScope(ctx, permission) {
+		return &AuthError{Code: CodeForbidden, Message: fmt.Sprintf("granting %s requires holding it", permission)}
+	}
+
+	return nil
+}
+
+// manageableRole resolves a project role argument, checking that the viewer
+// of ctx may grant and revoke roles in the project
+func (r *Resolver) manageableRole(ctx context.Context, id graphql.ID, role string) (Project, string, error) {
+	role = strings.ToLower(role)
+	if !transistor.SliceContains(role, Roles) {
+		return Project{}, "", fmt.Errorf("unknown role %q", role)
+	}
+
+	project, err := FindProject(r.DB, string(id))
+	if err != nil {
+		return project, "", err
+	}
+
+	if !CanManageProject(ctx, project.Model.ID) {
+		if !CanReadProject(ctx, project.Model.ID) {
+			// do not reveal projects the viewer can not read
+			return Project{}, "", fmt.Errorf("tenant %q not found", string(id))
+		}
+		return Project{}, "", &AuthError{Code: CodeForbidden, Message: fmt.Sprintf("managing roles in %s requires the admin role", project.Tenant())}
+	}
+
+	return project, role, nil
+}
+
+// findUser loads the user identified by id with its permissions and roles
+func (r *Resolver) findUser(id graphql.ID) (User, error) {
+	user := User{}
+
+	userId, err := uuid.FromString(string(id))
+	if err != nil {
+		return user, fmt.Errorf("invalid user id: %v", err)
+	}
+
+	err = r.DB.Preload("Permissions").
+		Preload("ProjectRoles.Project.Organization").
+		Where("id = ?", userId).
+		Find(&user).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return user, fmt.Errorf("user %s not found", userId.String())
+		}
+		return user, err
+	}
+
+	return user, nil
+}
+
+// changedUser reloads the user a mutation changed. Unlike userResolver it
+// succeeds when the change hid the user from the viewer of ctx.
+func (r *Resolver) changedUser(ctx context.Context, id uuid.UUID) (*UserResolver, error) {
+	user, err := r.findUser(graphql.ID(id.String()))
+	if err != nil {
+		return nil, err
+	}
+	return &UserResolver{User: visibleRoles(ctx, user)}, nil
+}
+
+func randomString(size int, encode func([]byte) string) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
@@ -0,0 +1,441 @@
+package inspectr_resolvers
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Partition periods
+const (
+	PartitionDay   = "day"
+	PartitionWeek  = "week"
+	PartitionMonth = "month"
+)
+
+const (
+	// DefaultPartition receives trails outside every range partition
+	DefaultPartition = "trails_default"
+	// partitionCopySettle trails created this long before the copy reached
+	// them are copied again when the tables are swapped, covering
+	// transactions that committed late
+	partitionCopySettle = time.Hour
+)
+
+// PartitionSettings tune the partitions of trails
+type PartitionSettings struct {
+	// Period day, week or month covered by each partition
+	Period string
+	// Ahead partitions created beyond the current one
+	Ahead int
+	// Drop drops the partitions whose trails were all archived, they are
+	// only detached otherwise
+	Drop bool
+}
+
+// DefaultPartitionSettings
+var DefaultPartitionSettings = PartitionSettings{
+	Period: PartitionMonth,
+	Ahead:  3,
+	Drop:   true,
+}
+
+// Validate
+func (s PartitionSettings) Validate() error {
+	switch s.Period {
+	case PartitionDay, PartitionWeek, PartitionMonth:
+	default:
+		return fmt.Errorf("unknown partition period %q, expected day, week or month", s.Period)
+	}
+
+	if s.Ahead < 1 {
+		return fmt.Errorf("at least one partition must be created ahead")
+	}
+
+	return nil
+}
+
+// PartitionStart returns the start of the partition period t falls in, in
+// UTC. Weeks start on Monday.
+func PartitionStart(t time.Time, period string) time.Time {
+	day := t.UTC().Truncate(Day)
+	switch period {
+	case PartitionDay:
+		return day
+	case PartitionWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	default:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// PartitionEnd returns the end of the partition period starting at start
+func PartitionEnd(start time.Time, period string) time.Time {
+	switch period {
+	case PartitionDay:
+		return start.AddDate(0, 0, 1)
+	case PartitionWeek:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// Partition of trails
+type Partition struct {
+	// Name
+	Name string
+	// From first creation time of its trails
+	From time.Time
+	// To creation time its trails are before
+	To time.Time
+	// Default receives the trails of no other partition
+	Default bool
+}
+
+// partitionBound parses range bounds as printed by pg_get_expr
+var partitionBound = regexp.MustCompile(`FROM \('([^']+)'\) TO \('([^']+)'\)`)
+
+// partitionTime formats t as a timestamptz literal for partition bounds,
+// which can not be bind parameters
+func partitionTime(t time.Time) string {
+	return t.UTC().Format("'2006-01-02 15:04:05+00'")
+}
+
+func parsePartitionTime(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05-07", "2006-01-02 15:04:05-07:00", "2006-01-02 15:04:05.999999-07"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid partition bound %q", value)
+}
+
+// TrailsPartitioned reports whether trails is partitioned
+func TrailsPartitioned(db *gorm.DB) (bool, error) {
+	var result struct {
+		Partitioned bool
+	}
+	err := db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = to_regclass('trails')) AS partitioned`).Scan(&result).Error
+	return result.Partitioned, err
+}
+
+// ListPartitions returns the partitions of table by start
+func ListPartitions(db *gorm.DB, table string) ([]Partition, error) {
+	var rows []struct {
+		Name  string
+		Bound string
+	}
+	err := db.Raw(`SELECT c.relname AS name, pg_get_expr(c.relpartbound, c.oid) AS bound
+	FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+	WHERE i.inhparent = to_regclass(?)`, table).Scan(&rows).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	var partitions []Partition
+	for _, row := range rows {
+		if row.Bound == "DEFAULT" {
+			partitions = append(partitions, Partition{Name: row.Name, Default: true})
+			continue
+		}
+
+		match := partitionBound.FindStringSubmatch(row.Bound)
+		if match == nil {
+			return nil, fmt.Errorf("partition %s: unexpected bound %q", row.Name, row.Bound)
+		}
+
+		from, err := parsePartitionTime(match[1])
+		if err != nil {
+			return nil, err
+		}
+		to, err := parsePartitionTime(match[2])
+		if err != nil {
+			return nil, err
+		}
+
+		partitions = append(partitions, Partition{Name: row.Name, From: from, To: to})
+	}
+
+	return partitions, nil
+}
+
+// sequenceIndex creates the unique index on project_id and sequence of
+// partition when missing. Unique indexes of partitioned tables must include
+// the partition key, so each partition has its own.
+func sequenceIndex(db *gorm.DB, partition string) error {
+	return db.Exec(fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s_project_id_sequence ON %s (project_id, sequence) WHERE sequence > 0`, partition, partition)).Error
+}
+
+// createPartitions creates the partitions of table from the period of from
+// until the period of to, skipping periods overlapping existing partitions,
+// and returns those created. Every partition of table gets the unique index
+// on project_id and sequence.
+func createPartitions(db *gorm.DB, table string, settings PartitionSettings, from time.Time, to time.Time) ([]Partition, error) {
+	existing, err := ListPartitions(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, partition := range existing {
+		if err := sequenceIndex(db, partition.Name); err != nil {
+			return nil, fmt.Errorf("partition %s: %v", partition.Name, err)
+		}
+	}
+
+	var created []Partition
+	for start := PartitionStart(from, settings.Period); !start.After(to); start = PartitionEnd(start, settings.Period) {
+		partition := Partition{
+			Name: "trails_p" + start.Format("20060102"),
+			From: start,
+			To:   PartitionEnd(start, settings.Period),
+		}
+
+		overlaps := false
+		for _, other := range existing {
+			if !other.Default && other.From.Before(partition.To) && partition.From.Before(other.To) {
+				overlaps = true
+			}
+		}
+		if overlaps {
+			continue
+		}
+
+		err := db.Exec(fmt.Sprintf(`CREATE TABLE %s PARTITION OF %s FOR VALUES FROM (%s) TO (%s)`, partition.Name, table, partitionTime(partition.From), partitionTime(partition.To))).Error
+		if err == nil {
+			err = sequenceIndex(db, partition.Name)
+		}
+		if err != nil {
+			return created, fmt.Errorf("partition %s: %v", partition.Name, err)
+		}
+
+		created = append(created, partition)
+		existing = append(existing, partition)
+	}
+
+	return created, nil
+}
+
+// CreatePartitions creates the partitions of trails for the current period
+// and settings.Ahead periods after it
+func CreatePartitions(db *gorm.DB, settings PartitionSettings, now time.Time) ([]Partition, error) {
+	to := PartitionStart(now, settings.Period)
+	for i := 0; i < settings.Ahead; i++ {
+		to = PartitionEnd(to, settings.Period)
+	}
+
+	return createPartitions(db, "trails", settings, now, to)
+}
+
+// ExpireArchivedPartitions detaches the partitions of trails that ended
+// before now and hold no trail any more, retention archived and deleted
+// them all, and drops them when settings.Drop. Trails no retention policy
+// archives keep their partition. It returns the partitions removed.
+func ExpireArchivedPartitions(db *gorm.DB, settings PartitionSettings, now time.Time) ([]Partition, error) {
+	partitions, err := ListPartitions(db, "trails")
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []Partition
+	for _, partition := range partitions {
+		if partition.Default || partition.To.After(now) {
+			continue
+		}
+
+		var remaining struct {
+			Remaining bool
+		}
+		err := db.Raw(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s) AS remaining`, partition.Name)).Scan(&remaining).Error
+		if err != nil {
+			return expired, err
+		}
+		if remaining.Remaining {
+			continue
+		}
+
+		if err := db.Exec(fmt.Sprintf(`ALTER TABLE trails DETACH PARTITION %s`, partition.Name)).Error; err != nil {
+			return expired, err
+		}
+
+		if settings.Drop {
+			if err := db.Exec(fmt.Sprintf(`DROP TABLE %s`, partition.Name)).Error; err != nil {
+				return expired, err
+			}
+		}
+
+		expired = append(expired, partition)
+	}
+
+	return expired, nil
+}
+
+// partitionedIndexes are the indexes of trails, created on the partitioned
+// table under a temporary name and renamed once it replaces trails. The
+// chain's unique index on project_id and sequence can not be created on the
+// partitioned table, unique indexes of partitioned tables must include
+// created_at; each partition has its own, see sequenceIndex. Sequences of a
+// project are unique across partitions through the chain lock.
+var partitionedIndexes = []struct {
+	name       string
+	definition string
+}{
+	{"idx_trails_created_at_id", "(created_at, id)"},
+	{"idx_trails_event_created_at", "(event, created_at)"},
+	{"idx_trails_actor_created_at", "(actor, created_at)"},
+	{"idx_trails_target_created_at", "(target, created_at)"},
+	{"idx_trails_origin_created_at", "(origin, created_at)"},
+	{"idx_trails_event_metadata", "USING gin (event_metadata jsonb_path_ops)"},
+	{"idx_trails_actor_metadata", "USING gin (actor_metadata jsonb_path_ops)"},
+	{"idx_trails_target_metadata", "USING gin (target_metadata jsonb_path_ops)"},
+	{"idx_trails_origin_metadata", "USING gin (origin_metadata jsonb_path_ops)"},
+	{"idx_trails_search_vector", "USING gin (search_vector)"},
+	{"idx_trails_project_id_created_at_id", "(project_id, created_at, id)"},
+	{"idx_trails_project_id_sequence", "(project_id, sequence) WHERE sequence > 0"},
+}
+
+// PartitionTrails replaces trails with a copy partitioned by created_at,
+// while trails keep being written. Trails are copied a day at a time, then
+// trails is locked against writes, the trails created since are copied and
+// the tables are swapped. Trails updated or deleted during the copy, as by
+// retention, are logged by a trigger and copied again, or removed, before
+// the swap. The original table is kept as trails_unpartitioned. It does
+// nothing when trails is already partitioned.
+//
+// created_at is assigned when a trail is stored, so it follows the order of
+// the chains and new trails land in the current partition; retention,
+// exports and time range filters all compare it. The timestamp producers
+// send may be late or skewed and is not used.
+func PartitionTrails(db *gorm.DB, settings PartitionSettings, now time.Time, progress func(time.Time)) error {
+	partitioned, err := TrailsPartitioned(db)
+	if err != nil || partitioned {
+		return err
+	}
+
+	var oldest struct {
+		CreatedAt *time.Time
+	}
+	if err := db.Raw(`SELECT MIN(created_at) AS created_at FROM trails`).Scan(&oldest).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	from := now
+	if oldest.CreatedAt != nil {
+		from = *oldest.CreatedAt
+	}
+
+	// a previous attempt may have stopped half way
+	statements := []string{
+		`DROP TRIGGER IF EXISTS trails_partition_change ON trails`,
+		`DROP TABLE IF EXISTS trails_partitioned`,
+		`DROP TABLE IF EXISTS trails_partition_changes`,
+	}
+
+	// changes are logged before the copy starts, so every trail updated or
+	// deleted after the copy read it is logged
+	statements = append(statements,
+		`CREATE TABLE trails_partition_changes AS SELECT id, created_at FROM trails WITH NO DATA`,
+		`CREATE OR REPLACE FUNCTION trails_partition_change() RETURNS trigger AS $$
+		BEGIN
+			INSERT INTO trails_partition_changes (id, created_at) VALUES (OLD.id, OLD.created_at);
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql`,
+		`CREATE TRIGGER trails_partition_change AFTER UPDATE OR DELETE ON trails
+		FOR EACH ROW EXECUTE PROCEDURE trails_partition_change()`,
+		`CREATE TABLE trails_partitioned (LIKE trails INCLUDING DEFAULTS) PARTITION BY RANGE (created_at)`,
+		`ALTER TABLE trails_partitioned ADD PRIMARY KEY (id, created_at)`,
+		`CREATE TABLE `+DefaultPartition+`_new PARTITION OF trails_partitioned DEFAULT`,
+	)
+	for _, index := range partitionedIndexes {
+		statements = append(statements, fmt.Sprintf(`CREATE INDEX %s_new ON trails_partitioned %s`, index.name, index.definition))
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	to := PartitionStart(now, settings.Period)
+	for i := 0; i < settings.Ahead; i++ {
+		to = PartitionEnd(to, settings.Period)
+	}
+	if _, err := createPartitions(db, "trails_partitioned", settings, from, to); err != nil {
+		return err
+	}
+
+	// trails are created at most partitionCopySettle before they are
+	// visible, copying again from then on before each step catches up on
+	// the trails written during the last one
+	mark := time.Now()
+	for copied := from.UTC().Truncate(Day); copied.Before(now); copied = copied.Add(Day) {
+		mark = time.Now()
+		err := db.Exec(`INSERT INTO trails_partitioned SELECT * FROM trails WHERE created_at >= ? AND created_at < ? ON CONFLICT DO NOTHING`, copied, copied.Add(Day)).Error
+		if err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(copied.Add(Day))
+		}
+	}
+
+	catchUp := `INSERT INTO trails_partitioned SELECT * FROM trails WHERE created_at >= ? ON CONFLICT DO NOTHING`
+	last := mark
+	mark = time.Now()
+	if err := db.Exec(catchUp, last.Add(-partitionCopySettle)).Error; err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	// reads go on, writes wait for the swap and then go to the new table
+	if err := tx.Exec(`LOCK TABLE trails IN EXCLUSIVE MODE`).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Exec(catchUp, mark.Add(-partitionCopySettle)).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// with writes locked out every change is logged, trails copied before
+	// they changed are removed and copied again unless they were deleted
+	statements = []string{
+		`DELETE FROM trails_partitioned p USING trails_partition_changes c WHERE p.id = c.id AND p.created_at = c.created_at`,
+		`INSERT INTO trails_partitioned SELECT * FROM trails WHERE id IN (SELECT id FROM trails_partition_changes) ON CONFLICT DO NOTHING`,
+		`DROP TRIGGER trails_partition_change ON trails`,
+		`DROP TABLE trails_partition_changes`,
+		`DROP FUNCTION trails_partition_change()`,
+		`ALTER TABLE trails RENAME TO trails_unpartitioned`,
+		`ALTER TABLE trails_unpartitioned RENAME CONSTRAINT trails_pkey TO trails_unpartitioned_pkey`,
+		`DROP TRIGGER IF EXISTS trails_search_vector_update ON trails_unpartitioned`,
+		`ALTER TABLE trails_partitioned RENAME TO trails`,
+		`ALTER TABLE trails RENAME CONSTRAINT trails_partitioned_pkey TO trails_pkey`,
+		`ALTER TABLE ` + DefaultPartition + `_new RENAME TO ` + DefaultPartition,
+		`ALTER INDEX ` + DefaultPartition + `_new_project_id_sequence RENAME TO ` + DefaultPartition + `_project_id_sequence`,
+		`ALTER INDEX IF EXISTS uix_trails_project_id_sequence RENAME TO uix_trails_unpartitioned_project_id_sequence`,
+		`CREATE TRIGGER trails_search_vector_update BEFORE INSERT OR UPDATE ON trails
+		FOR EACH ROW EXECUTE PROCEDURE trails_search_vector_update()`,
+	}
+	for _, index := range partitionedIndexes {
+		statements = append(statements,
+			fmt.Sprintf(`ALTER INDEX IF EXISTS %s RENAME TO %s_unpartitioned`, index.name, index.name),
+			fmt.Sprintf(`ALTER INDEX %s_new RENAME TO %s`, index.name, index.name),
+		)
+	}
+
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
@@ -0,0 +1,34 @@
+package inspectr_resolvers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionTime(t *testing.T) {
+	start := time.Date(2026, 10, 1, 2, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	literal := partitionTime(start)
+	if literal != "'2026-10-01 00:00:00+00'" {
+		t.Fatalf("partitionTime = %s, want '2026-10-01 00:00:00+00'", literal)
+	}
+
+	// pg_get_expr prints the bound as it was written
+	bound := "FOR VALUES FROM (" + literal + ") TO (" + partitionTime(PartitionEnd(start, PartitionMonth)) + ")"
+	match := partitionBound.FindStringSubmatch(bound)
+	if match == nil {
+		t.Fatalf("bound %q not parsed", bound)
+	}
+
+	from, err := parsePartitionTime(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parsePartitionTime(match[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(start) || !to.Equal(start.AddDate(0, 1, 0)) {
+		t.Errorf("bound parsed as %v to %v, want %v to %v", from, to, start, start.AddDate(0, 1, 0))
+	}
+}
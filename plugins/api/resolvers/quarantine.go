@@ -0,0 +1,378 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codeamp/transistor"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Stages at which ingestion of a quarantined message failed
+const (
+	StageParse    = "parse"
+	StageValidate = "validate"
+	StageInsert   = "insert"
+)
+
+// Statuses of quarantined messages
+const (
+	QuarantineHeld      = "held"
+	QuarantineReplayed  = "replayed"
+	QuarantineDiscarded = "discarded"
+)
+
+// QuarantineStages every stage, in ingestion order
+var QuarantineStages = []string{StageParse, StageValidate, StageInsert}
+
+// QuarantineStatuses every status
+var QuarantineStatuses = []string{QuarantineHeld, QuarantineReplayed, QuarantineDiscarded}
+
+// QuarantinedMessage message that could not be ingested, kept with its raw
+// body until it is replayed or discarded
+type QuarantinedMessage struct {
+	Model `json:",inline"`
+	// UpdatedAt
+	UpdatedAt time.Time `json:"updatedAt"`
+	// ProjectId project of the tenant, nil when it is unknown
+	ProjectId *uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// Tenant the message was sent to
+	Tenant string `json:"tenant" gorm:"type:varchar(255)"`
+	// Source sqs or http
+	Source string `json:"source" gorm:"type:varchar(20)"`
+	// SourceId ID of the message at its source, such as the SQS message ID
+	SourceId string `json:"sourceId" gorm:"type:varchar(255)"`
+	// Body of the message
+	Body string `json:"body" gorm:"type:text"`
+	// Stage parse, validate or insert
+	Stage string `json:"stage" gorm:"type:varchar(20)"`
+	// Error that kept the message from being ingested
+	Error string `json:"error" gorm:"type:text"`
+	// Attempts times the message was received or replayed
+	Attempts int `json:"attempts"`
+	// Status held, replayed or discarded
+	Status string `json:"status" gorm:"type:varchar(20)"`
+	// ReplayedAt
+	ReplayedAt *time.Time `json:"replayedAt"`
+	// TrailId trail the message was stored as once replayed
+	TrailId *uuid.UUID `json:"trailId" gorm:"type:uuid"`
+	// AnnouncedAt when the running API published the replayed trail,
+	// evaluated alert rules against it and announced it to plugins, nil
+	// until then
+	AnnouncedAt *time.Time `json:"announcedAt"`
+}
+
+// QuarantineMessage holds message. Messages quarantined again, as when SQS
+// redelivers one, update the message held for the same source ID.
+func QuarantineMessage(db *gorm.DB, message *QuarantinedMessage) error {
+	now := time.Now()
+	message.Model.ID = uuid.NewV4()
+	message.Model.CreatedAt = now
+	message.UpdatedAt = now
+	message.Status = QuarantineHeld
+	if message.Attempts < 1 {
+		message.Attempts = 1
+	}
+
+	if message.SourceId == "" {
+		return db.Create(message).Error
+	}
+
+	return db.Raw(`INSERT INTO quarantined_messages
+	(id, created_at, updated_at, project_id, tenant, source, source_id, body, stage, error, attempts, status)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (source, source_id) WHERE source_id <> '' DO UPDATE SET
+		updated_at = EXCLUDED.updated_at,
+		project_id = EXCLUDED.project_id,
+		tenant = EXCLUDED.tenant,
+		body = EXCLUDED.body,
+		stage = EXCLUDED.stage,
+		error = EXCLUDED.error,
+		attempts = GREATEST(quarantined_messages.attempts + 1, EXCLUDED.attempts),
+		status = EXCLUDED.status
+	RETURNING *`,
+		message.Model.ID, message.Model.CreatedAt, message.UpdatedAt, message.ProjectId, message.Tenant,
+		message.Source, message.SourceId, message.Body, message.Stage, message.Error, message.Attempts, message.Status,
+	).Scan(message).Error
+}
+
+// QuarantineFilter
+type QuarantineFilter struct {
+	// Project
+	Project *uuid.UUID
+	// Stage
+	Stage *string
+	// Status
+	Status *string
+	// Source
+	Source *string
+}
+
+// Apply restricts query to the messages matching f
+func (f QuarantineFilter) Apply(query *gorm.DB) (*gorm.DB, error) {
+	if f.Project != nil {
+		query = query.Where("project_id = ?", *f.Project)
+	}
+	if f.Stage != nil {
+		stage := strings.ToLower(*f.Stage)
+		if !transistor.SliceContains(stage, QuarantineStages) {
+			return nil, fmt.Errorf("unknown stage %q, expected one of %s", *f.Stage, strings.Join(QuarantineStages, ", "))
+		}
+		query = query.Where("stage = ?", stage)
+	}
+	if f.Status != nil {
+		status := strings.ToLower(*f.Status)
+		if !transistor.SliceContains(status, QuarantineStatuses) {
+			return nil, fmt.Errorf("unknown status %q, expected one of %s", *f.Status, strings.Join(QuarantineStatuses, ", "))
+		}
+		query = query.Where("status = ?", status)
+	}
+	if f.Source != nil {
+		query = query.Where("source = ?", *f.Source)
+	}
+
+	return query, nil
+}
+
+// FindQuarantinedMessages returns up to limit messages matching filter,
+// oldest first
+func FindQuarantinedMessages(db *gorm.DB, filter QuarantineFilter, limit int) ([]QuarantinedMessage, error) {
+	query, err := filter.Apply(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []QuarantinedMessage
+	if err := query.Order("created_at asc, id asc").Limit(limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// FindQuarantinedMessage returns the message with id
+func FindQuarantinedMessage(db *gorm.DB, id string) (QuarantinedMessage, error) {
+	message := QuarantinedMessage{}
+
+	parsed, err := uuid.FromString(id)
+	if err != nil {
+		return message, fmt.Errorf("invalid quarantined message id %q", id)
+	}
+
+	if err := db.Where("id = ?", parsed).Find(&message).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return message, fmt.Errorf("quarantined message %s not found", id)
+		}
+		return message, err
+	}
+
+	return message, nil
+}
+
+// MarkReplayed records that message was stored as trail. Trails stored by
+// the replay are left for the running API to announce, duplicates were
+// announced when first stored.
+func MarkReplayed(db *gorm.DB, message *QuarantinedMessage, trail uuid.UUID, duplicate bool) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":      QuarantineReplayed,
+		"replayed_at": now,
+		"trail_id":    trail,
+		"updated_at":  now,
+	}
+	if duplicate {
+		updates["announced_at"] = now
+	}
+	return db.Model(message).Updates(updates).Error
+}
+
+// ClaimReplayedTrails marks up to limit replayed messages whose trail was
+// not announced yet as announced and returns them, oldest first. A message
+// is claimed by one caller only, so each trail is announced at most once.
+func ClaimReplayedTrails(db *gorm.DB, limit int) ([]QuarantinedMessage, error) {
+	var pending []QuarantinedMessage
+	err := db.Where("status = ? AND announced_at IS NULL AND trail_id IS NOT NULL", QuarantineReplayed).
+		Order("replayed_at").
+		Limit(limit).
+		Find(&pending).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []QuarantinedMessage
+	for _, message := range pending {
+		now := time.Now()
+		update := db.Model(&QuarantinedMessage{}).
+			Where("id = ? AND announced_at IS NULL", message.Model.ID).
+			Update("announced_at", now)
+		if update.Error != nil {
+			return claimed, update.Error
+		}
+		if update.RowsAffected == 1 {
+			message.AnnouncedAt = &now
+			claimed = append(claimed, message)
+		}
+	}
+
+	return claimed, nil
+}
+
+// MarkReplayFailed records that replaying message failed at stage
+func MarkReplayFailed(db *gorm.DB, message *QuarantinedMessage, stage string, cause error) error {
+	return db.Model(message).Updates(map[string]interface{}{
+		"stage":      stage,
+		"error":      cause.Error(),
+		"attempts":   gorm.Expr("attempts + 1"),
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// QuarantinedMessages held, replayed or discarded messages of the projects
+// the viewer may read, oldest first. Messages of unknown tenants are only
+// listed to admins.
+func (r *Resolver) QuarantinedMessages(ctx context.Context, args *struct {
+	Project *graphql.ID
+	Stage   *string
+	Status  *string
+	Source  *string
+	First   *int32
+}) ([]*QuarantinedMessageResolver, error) {
+	if err := Authorize(ctx, "quarantinedMessages"); err != nil {
+		return nil, err
+	}
+
+	first := int32(DefaultPageSize)
+	if args.First != nil {
+		if *args.First < 0 || *args.First > MaxPageSize {
+			return nil, fmt.Errorf("first must be between 0 and %d", MaxPageSize)
+		}
+		first = *args.First
+	}
+
+	filter := QuarantineFilter{Stage: args.Stage, Status: args.Status, Source: args.Source}
+	if args.Project != nil {
+		project, err := ViewerProject(ctx, r.DB, args.Project)
+		if err != nil {
+			return nil, err
+		}
+		filter.Project = &project.Model.ID
+	}
+
+	messages, err := FindQuarantinedMessages(ScopeProjects(ctx, r.DB, "project_id"), filter, int(first))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*QuarantinedMessageResolver, len(messages))
+	for i, message := range messages {
+		results[i] = &QuarantinedMessageResolver{QuarantinedMessage: message}
+	}
+
+	return results, nil
+}
+
+// QuarantinedMessage returns the quarantined message with id
+func (r *Resolver) QuarantinedMessage(ctx context.Context, args *struct {
+	ID graphql.ID
+}) (*QuarantinedMessageResolver, error) {
+	if err := Authorize(ctx, "quarantinedMessage"); err != nil {
+		return nil, err
+	}
+
+	message, err := FindQuarantinedMessage(ScopeProjects(ctx, r.DB, "project_id"), string(args.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuarantinedMessageResolver{QuarantinedMessage: message}, nil
+}
+
+// QuarantinedMessageResolver resolver for QuarantinedMessage
+type QuarantinedMessageResolver struct {
+	QuarantinedMessage
+}
+
+// ID
+func (r *QuarantinedMessageResolver) ID() graphql.ID {
+	return graphql.ID(r.QuarantinedMessage.Model.ID.String())
+}
+
+// ProjectId
+func (r *QuarantinedMessageResolver) ProjectId() *graphql.ID {
+	if r.QuarantinedMessage.ProjectId == nil {
+		return nil
+	}
+	id := graphql.ID(r.QuarantinedMessage.ProjectId.String())
+	return &id
+}
+
+// Tenant
+func (r *QuarantinedMessageResolver) Tenant() string {
+	return r.QuarantinedMessage.Tenant
+}
+
+// Source
+func (r *QuarantinedMessageResolver) Source() string {
+	return r.QuarantinedMessage.Source
+}
+
+// SourceId
+func (r *QuarantinedMessageResolver) SourceId() string {
+	return r.QuarantinedMessage.SourceId
+}
+
+// Body
+func (r *QuarantinedMessageResolver) Body() string {
+	return r.QuarantinedMessage.Body
+}
+
+// Stage
+func (r *QuarantinedMessageResolver) Stage() string {
+	return strings.ToUpper(r.QuarantinedMessage.Stage)
+}
+
+// Error
+func (r *QuarantinedMessageResolver) Error() string {
+	return r.QuarantinedMessage.Error
+}
+
+// Attempts
+func (r *QuarantinedMessageResolver) Attempts() int32 {
+	return int32(r.QuarantinedMessage.Attempts)
+}
+
+// Status
+func (r *QuarantinedMessageResolver) Status() string {
+	return strings.ToUpper(r.QuarantinedMessage.Status)
+}
+
+// ReplayedAt
+func (r *QuarantinedMessageResolver) ReplayedAt() *graphql.Time {
+	if r.QuarantinedMessage.ReplayedAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.QuarantinedMessage.ReplayedAt}
+}
+
+// TrailId
+func (r *QuarantinedMessageResolver) TrailId() *graphql.ID {
+	if r.QuarantinedMessage.TrailId == nil {
+		return nil
+	}
+	id := graphql.ID(r.QuarantinedMessage.TrailId.String())
+	return &id
+}
+
+// CreatedAt
+func (r *QuarantinedMessageResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.QuarantinedMessage.Model.CreatedAt}
+}
+
+// UpdatedAt
+func (r *QuarantinedMessageResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.QuarantinedMessage.UpdatedAt}
+}
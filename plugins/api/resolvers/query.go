@@ -2,103 +2,600 @@ package inspectr_resolvers
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"time"
 
+	"github.com/codeamp/transistor"
 	graphql "github.com/graph-gophers/graphql-go"
 	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
 )
 
-// User Retrieve single user by ID
-func (r *Resolver) User(args *struct {
+// User Retrieve single user by ID, the viewer when ID is omitted
+func (r *Resolver) User(ctx context.Context, args *struct {
 	ID *graphql.ID
-}) *UserResolver {
-	return nil
+}) (*UserResolver, error) {
+	viewer := ViewerFromContext(ctx)
+
+	id := graphql.ID("")
+	if args.ID != nil {
+		id = *args.ID
+	} else if viewer != nil {
+		id = graphql.ID(viewer.UserID())
+	}
+
+	if err := Authorize(ctx, "user", "user/"+string(id)); err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.FromString(string(id))
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id: %v", err)
+	}
+
+	return r.userResolver(ctx, userId)
 }
 
-// Users Retrieve all users
-func (r *Resolver) Users() []*UserResolver {
-	return nil
+// Users Retrieve all users the viewer may see
+func (r *Resolver) Users(ctx context.Context) ([]*UserResolver, error) {
+	if err := Authorize(ctx, "users"); err != nil {
+		return nil, err
+	}
+
+	query := r.DB.Preload("Permissions").Preload("ProjectRoles.Project.Organization")
+	if !HasScope(ctx, ScopeUsersRead) {
+		// project admins see the members of their projects
+		query = query.Where("id = ? OR id IN (SELECT user_id FROM project_roles WHERE project_id IN (?))",
+			ViewerFromContext(ctx).UserID(), ViewerFromContext(ctx).ProjectIDs(RoleAdmin))
+	}
+
+	var rows []User
+	if err := query.Order("created_at asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*UserResolver, len(rows))
+	for i, user := range rows {
+		results[i] = &UserResolver{User: visibleRoles(ctx, user)}
+	}
+
+	return results, nil
+}
+
+// userResolver loads the user identified by id, when visible to the viewer
+// of ctx
+func (r *Resolver) userResolver(ctx context.Context, id uuid.UUID) (*UserResolver, error) {
+	query := r.DB.Preload("Permissions").Preload("ProjectRoles.Project.Organization").Where("id = ?", id)
+	if viewer := ViewerFromContext(ctx); !HasScope(ctx, ScopeUsersRead) && viewer.UserID() != id.String() {
+		query = query.Where("id IN (SELECT user_id FROM project_roles WHERE project_id IN (?))", viewer.ProjectIDs(RoleAdmin))
+	}
+
+	user := User{}
+	if err := query.Find(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("user %s not found", id.String())
+		}
+		return nil, err
+	}
+
+	return &UserResolver{User: visibleRoles(ctx, user)}, nil
 }
 
+// visibleRoles drops the roles user holds in projects the viewer of ctx
+// does not administer, unless the viewer may read every user or is user
+func visibleRoles(ctx context.Context, user User) User {
+	viewer := ViewerFromContext(ctx)
+	if HasScope(ctx, ScopeUsersRead) || viewer.UserID() == user.Model.ID.String() {
+		return user
+	}
+
+	managed := viewer.ProjectIDs(RoleAdmin)
+
+	var roles []ProjectRole
+	for _, role := range user.ProjectRoles {
+		if transistor.SliceContains(role.ProjectId.String(), managed) {
+			roles = append(roles, role)
+		}
+	}
+	user.ProjectRoles = roles
+
+	return user
+}
+
+const (
+	// DefaultPageSize number of trails returned when first is omitted
+	DefaultPageSize = 50
+	// MaxPageSize upper bound for first
+	MaxPageSize = 1000
+)
+
 // Trails
 func (r *Resolver) Trails(ctx context.Context, args *struct {
-	Event *string
-}) ([]*TrailResolver, error) {
+	First  *int32
+	After  *string
+	Filter *TrailFilter
+	Search *string
+	Sort   string
+}) (*TrailConnectionResolver, error) {
+	if err := Authorize(ctx, "trails"); err != nil {
+		return nil, err
+	}
+
+	first := int32(DefaultPageSize)
+	if args.First != nil {
+		if *args.First < 0 || *args.First > MaxPageSize {
+			return nil, fmt.Errorf("first must be between 0 and %d", MaxPageSize)
+		}
+		first = *args.First
+	}
+
+	query, err := args.Filter.Apply(ScopeProjects(ctx, r.DB, "project_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	search := ""
+	if args.Search != nil {
+		search = strings.TrimSpace(*args.Search)
+	}
+
+	if search != "" {
+		query = query.Where("search_vector @@ "+SearchQuery, search)
+	} else if args.Sort == "RELEVANCE" {
+		return nil, fmt.Errorf("sort RELEVANCE requires search")
+	}
+
+	var cursor *Cursor
+	if args.After != nil && *args.After != "" {
+		_cursor, err := DecodeCursor(*args.After)
+		if err != nil {
+			return nil, err
+		}
+		if (args.Sort == "RELEVANCE") != (_cursor.Rank != nil) {
+			return nil, fmt.Errorf("cursor does not match sort %s", args.Sort)
+		}
+		cursor = &_cursor
+	}
+
+	// Keyset pagination over (created_at, id), or (rank, id) when sorting by
+	// relevance, keeps pages stable while new trails are being inserted
+	page := query
+	switch args.Sort {
+	case "RELEVANCE":
+		if cursor != nil {
+			page = page.Where("("+SearchRank+", id) < (?, ?)", search, *cursor.Rank, cursor.ID)
+		}
+		page = page.Order("rank desc, id desc")
+	case "ASC":
+		if cursor != nil {
+			page = page.Where("(created_at, id) > (?, ?)", cursor.CreatedAt, cursor.ID)
+		}
+		page = page.Order("created_at asc, id asc")
+	default:
+		if cursor != nil {
+			page = page.Where("(created_at, id) < (?, ?)", cursor.CreatedAt, cursor.ID)
+		}
+		page = page.Order("created_at desc, id desc")
+	}
+
 	var rows []Trail
-	var results []*TrailResolver
+	var ranks []float64
+
+	if search != "" {
+		var ranked []struct {
+			Trail
+			Rank float64
+		}
 
-	var query *gorm.DB
+		err = page.Table("trails").
+			Select("trails.*, "+SearchRank+" AS rank", search).
+			Limit(first + 1).
+			Scan(&ranked).Error
+		if err != nil {
+			return nil, err
+		}
 
-	if args.Event != nil && *args.Event != "" {
-		query = r.DB.Where("event IN (?)", strings.Split(*args.Event, ","))
+		for _, row := range ranked {
+			rows = append(rows, row.Trail)
+			ranks = append(ranks, row.Rank)
+		}
 	} else {
-		query = r.DB
+		if err = page.Limit(first + 1).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	hasNext := int32(len(rows)) > first
+	if hasNext {
+		rows = rows[:first]
+		if ranks != nil {
+			ranks = ranks[:first]
+		}
+	}
+
+	connection := &TrailConnectionResolver{
+		DB:            r.DB,
+		Query:         query,
+		Rows:          rows,
+		Ranks:         ranks,
+		RankedCursors: args.Sort == "RELEVANCE",
+		HasNext:       hasNext,
+		HasPrevious:   cursor != nil,
+	}
+
+	if search != "" {
+		var ids []uuid.UUID
+		for _, trail := range rows {
+			ids = append(ids, trail.Model.ID)
+		}
+		connection.Highlighter = &Highlighter{DB: r.DB, Search: search, IDs: ids}
+	}
+
+	return connection, nil
+}
+
+// VerifyChain
+func (r *Resolver) VerifyChain(ctx context.Context, args *struct {
+	Project *graphql.ID
+	From    *graphql.Time
+	To      *graphql.Time
+}) (*ChainVerificationResolver, error) {
+	if err := Authorize(ctx, "verifyChain"); err != nil {
+		return nil, err
+	}
+
+	project, err := ViewerProject(ctx, r.DB, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	var from, to *time.Time
+	if args.From != nil {
+		from = &args.From.Time
+	}
+	if args.To != nil {
+		to = &args.To.Time
+	}
+
+	verification, err := VerifyChain(r.DB, project.Model.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChainVerificationResolver{ChainVerification: *verification}, nil
+}
+
+// Checkpoints newest first
+func (r *Resolver) Checkpoints(ctx context.Context, args *struct {
+	Project *graphql.ID
+	First   *int32
+	From    *graphql.Time
+	To      *graphql.Time
+}) ([]*CheckpointResolver, error) {
+	if err := Authorize(ctx, "checkpoints"); err != nil {
+		return nil, err
+	}
+
+	project, err := ViewerProject(ctx, r.DB, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	first := int32(DefaultPageSize)
+	if args.First != nil {
+		if *args.First < 0 || *args.First > MaxPageSize {
+			return nil, fmt.Errorf("first must be between 0 and %d", MaxPageSize)
+		}
+		first = *args.First
+	}
+
+	query := r.DB.Where("chain = ?", project.Model.ID.String())
+	if args.From != nil {
+		query = query.Where("created_at >= ?", args.From.Time)
+	}
+	if args.To != nil {
+		query = query.Where("created_at <= ?", args.To.Time)
+	}
+
+	var rows []Checkpoint
+	if err := query.Order("to_sequence desc").Limit(first).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*CheckpointResolver, len(rows))
+	for i, checkpoint := range rows {
+		results[i] = &CheckpointResolver{Checkpoint: checkpoint}
+	}
+
+	return results, nil
+}
+
+// Checkpoint
+func (r *Resolver) Checkpoint(ctx context.Context, args *struct {
+	ID graphql.ID
+}) (*CheckpointResolver, error) {
+	if err := Authorize(ctx, "checkpoint"); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.FromString(string(args.ID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint id: %v", err)
+	}
+
+	checkpoint := Checkpoint{}
+	clause, scope := ProjectScope(ctx, "chain")
+	if err := r.DB.Where("id = ?", id).Where(clause, scope...).Find(&checkpoint).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &CheckpointResolver{Checkpoint: checkpoint}, nil
+}
+
+// InclusionProof proves the trail is covered by a signed checkpoint, null
+// until the next checkpoint after the trail was stored
+func (r *Resolver) InclusionProof(ctx context.Context, args *struct {
+	TrailID graphql.ID
+}) (*InclusionProofResolver, error) {
+	if err := Authorize(ctx, "inclusionProof"); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.FromString(string(args.TrailID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid trail id: %v", err)
+	}
+
+	trail := Trail{}
+	if err := ScopeProjects(ctx, r.DB, "project_id").Where("id = ?", id).Find(&trail).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("trail %s not found", id.String())
+		}
+		return nil, err
+	}
+
+	proof, err := ProveInclusion(r.DB, trail)
+	if err != nil || proof == nil {
+		return nil, err
+	}
+
+	return &InclusionProofResolver{InclusionProof: *proof, DB: r.DB}, nil
+}
+
+// Projects the viewer holds a role in
+func (r *Resolver) Projects(ctx context.Context) ([]*ProjectResolver, error) {
+	if err := Authorize(ctx, "projects"); err != nil {
+		return nil, err
+	}
+
+	query := r.DB.Preload("Organization")
+	if viewer := ViewerFromContext(ctx); !viewer.IsAdmin() {
+		query = query.Where("id IN (?)", viewer.ProjectIDs(Roles...))
+	}
+
+	var rows []Project
+	if err := query.Order("created_at asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*ProjectResolver, len(rows))
+	for i, project := range rows {
+		results[i] = &ProjectResolver{Project: project}
+	}
+
+	return results, nil
+}
+
+// RetentionPolicies applying to a project, every policy for admins omitting
+// the project
+func (r *Resolver) RetentionPolicies(ctx context.Context, args *struct {
+	Project *graphql.ID
+}) ([]*RetentionPolicyResolver, error) {
+	if err := Authorize(ctx, "retentionPolicies"); err != nil {
+		return nil, err
+	}
+
+	query := r.DB
+	if args.Project != nil || !ViewerFromContext(ctx).IsAdmin() {
+		project, err := ViewerProject(ctx, r.DB, args.Project)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("project_id = ? OR project_id IS NULL", project.Model.ID)
+	}
+
+	var rows []RetentionPolicy
+	if err := query.Order("created_at asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*RetentionPolicyResolver, len(rows))
+	for i, policy := range rows {
+		results[i] = &RetentionPolicyResolver{RetentionPolicy: policy, DB: r.DB}
+	}
+
+	return results, nil
+}
+
+// Archives of a project's trails, newest first
+func (r *Resolver) Archives(ctx context.Context, args *struct {
+	Project *graphql.ID
+	First   *int32
+	From    *graphql.Time
+	To      *graphql.Time
+}) ([]*ArchiveResolver, error) {
+	if err := Authorize(ctx, "archives"); err != nil {
+		return nil, err
 	}
 
-	query.Order("created_at desc").Find(&rows)
+	project, err := ViewerProject(ctx, r.DB, args.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	first := int32(DefaultPageSize)
+	if args.First != nil {
+		if *args.First < 0 || *args.First > MaxPageSize {
+			return nil, fmt.Errorf("first must be between 0 and %d", MaxPageSize)
+		}
+		first = *args.First
+	}
 
-	for _, trail := range rows {
-		results = append(results, &TrailResolver{DB: r.DB, Trail: trail})
+	query := r.DB.Where("project_id = ?", project.Model.ID)
+	if args.From != nil {
+		query = query.Where("to_created_at >= ?", args.From.Time)
+	}
+	if args.To != nil {
+		query = query.Where("from_created_at <= ?", args.To.Time)
+	}
+
+	var rows []Archive
+	if err := query.Order("from_created_at desc, created_at desc").Limit(first).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*ArchiveResolver, len(rows))
+	for i, archived := range rows {
+		results[i] = &ArchiveResolver{Archive: archived}
 	}
 
 	return results, nil
 }
 
-// Metrics
+// Metrics counts trails per interval, one series per group when grouped
 func (r *Resolver) Metrics(ctx context.Context, args *struct {
 	StartsAt *graphql.Time
 	EndsAt   *graphql.Time
 	Interval *int32
+	GroupBy  *MetricGroupBy
+	Filter   *TrailFilter
+	TopN     *int32
 }) ([]*MetricResolver, error) {
-	//var rows []Trail
-	var results []*MetricResolver
+	if err := Authorize(ctx, "metrics"); err != nil {
+		return nil, err
+	}
 
-	type IntervalMetric struct {
-		Count    int32     `json:"count"`
-		Interval time.Time `json:"interval"`
+	if args.StartsAt == nil || args.EndsAt == nil || args.Interval == nil {
+		return nil, fmt.Errorf("startsAt, endsAt and interval are required")
+	}
+	if *args.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
 	}
 
-	var intervalMetrics []IntervalMetric
-	_intervalMetrics := make(map[int64]Metric)
+	topN := int32(DefaultMetricGroups)
+	if args.TopN != nil {
+		if *args.TopN < 0 || *args.TopN > MaxMetricGroups {
+			return nil, fmt.Errorf("topN must be between 0 and %d", MaxMetricGroups)
+		}
+		topN = *args.TopN
+	}
 
-	r.DB.Debug().Raw(`SELECT COUNT(*) count, 
-	to_timestamp(floor((extract('epoch' from created_at) / $1 )) * $1) 
-	AT TIME ZONE 'UTC' as interval
-	FROM trails
-	WHERE created_at >= $2 AND created_at <= $3
-	GROUP BY interval`, args.Interval, args.StartsAt.Time, args.EndsAt.Time).Scan(&intervalMetrics)
+	query := ScopeProjects(ctx, r.DB.Table("trails"), "project_id").
+		Where("created_at >= ? AND created_at <= ?", args.StartsAt.Time, args.EndsAt.Time)
+	query, err := args.Filter.Apply(query)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, m := range intervalMetrics {
-		_intervalMetrics[m.Interval.Unix()] = Metric{
-			Interval: *args.Interval,
-			StartsAt: m.Interval,
-			Size:     m.Count,
-		}
+	bucket := metricBucket("created_at")
 
+	var intervalMetrics []intervalCount
+	var groups []string
+
+	// totals and event series of trails filtered by project and event are
+	// counted from the coarsest rollup that fits
+	byEvent := args.GroupBy != nil && args.GroupBy.Field == "EVENT" && (args.GroupBy.Path == nil || len(*args.GroupBy.Path) == 0)
+	var rollup Rollup
+	var until time.Time
+	rolledUp := false
+	if (args.GroupBy == nil || byEvent) && rollupFilter(args.Filter) {
+		state, found, err := FindRollupState(r.DB)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			rollup, until, rolledUp = pickRollup(state, args.StartsAt.Time, args.EndsAt.Time, *args.Interval)
+		}
 	}
 
-	var metrics []Metric
+	if rolledUp {
+		intervalMetrics, err = rollupCounts(ctx, r.DB, rollup, args.StartsAt.Time, until, args.EndsAt.Time, *args.Interval, byEvent, args.Filter)
+		if byEvent {
+			groups = topGroups(intervalMetrics, topN)
+		}
+	} else if args.GroupBy == nil {
+		err = query.Select("COUNT(*) count, "+bucket+" AS interval", *args.Interval, *args.Interval).
+			Group("interval").Scan(&intervalMetrics).Error
+	} else {
+		var expression string
+		var expressionArgs []interface{}
+		expression, expressionArgs, err = args.GroupBy.expression()
+		if err != nil {
+			return nil, err
+		}
 
-	t := args.StartsAt.Time
-	endsAt := args.EndsAt.Time
+		var top []struct {
+			Value string
+		}
+		err = query.Select(expression+" AS value", expressionArgs...).
+			Where(expression+" IS NOT NULL", expressionArgs...).
+			Group("1").Order("COUNT(*) DESC, 1").Limit(topN).Scan(&top).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+
+		for _, row := range top {
+			groups = append(groups, row.Value)
+		}
 
-	for t.Before(endsAt) {
-		m := Metric{
-			Interval: *args.Interval,
-			StartsAt: t,
-			Size:     0,
+		// groups past the top ones, and trails missing the metadata path,
+		// are counted together
+		group, groupArgs := "NULL", []interface{}{}
+		if len(groups) > 0 {
+			group = "CASE WHEN " + expression + " IN (?) THEN " + expression + " END"
+			groupArgs = append(append(append(groupArgs, expressionArgs...), groups), expressionArgs...)
 		}
 
-		if val, ok := _intervalMetrics[t.Unix()]; ok {
-			m = val
+		err = query.Select(group+" AS value, COUNT(*) count, "+bucket+" AS interval", append(groupArgs, *args.Interval, *args.Interval)...).
+			Group("1, interval").Scan(&intervalMetrics).Error
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	counts := map[string]map[int64]int32{}
+	other := map[int64]int32{}
+	for _, m := range intervalMetrics {
+		if args.GroupBy != nil && m.Value == nil {
+			other[m.Interval.Unix()] += m.Count
+			continue
 		}
 
-		metrics = append(metrics, m)
+		key := ""
+		if m.Value != nil {
+			key = *m.Value
+		}
+		if counts[key] == nil {
+			counts[key] = map[int64]int32{}
+		}
+		counts[key][m.Interval.Unix()] += m.Count
+	}
 
-		t = t.Add(time.Duration(*args.Interval) * time.Second)
+	var metrics []Metric
+	if args.GroupBy == nil {
+		metrics = fillMetrics(counts[""], args.StartsAt.Time, args.EndsAt.Time, *args.Interval, nil, false)
+	} else {
+		for i := range groups {
+			metrics = append(metrics, fillMetrics(counts[groups[i]], args.StartsAt.Time, args.EndsAt.Time, *args.Interval, &groups[i], false)...)
+		}
+		metrics = append(metrics, fillMetrics(other, args.StartsAt.Time, args.EndsAt.Time, *args.Interval, nil, true)...)
 	}
 
+	var results []*MetricResolver
 	for _, m := range metrics {
 		results = append(results, &MetricResolver{DB: r.DB, Metric: m})
 	}
@@ -3,6 +3,7 @@ package inspectr_resolvers
 import (
 	"time"
 
+	redis "github.com/go-redis/redis"
 	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
 )
@@ -18,4 +19,15 @@ type Model struct {
 type Resolver struct {
 	// DB
 	DB *gorm.DB
+	// Redis caches the claims of signed in users
+	Redis *redis.Client
+	// AuditTenant project trails of user management are written to
+	AuditTenant string
+	// TrailCreated is called with the trails resolvers write
+	TrailCreated func(Trail)
+	// ExportCreated is called when an export job is queued
+	ExportCreated func()
+	// PublicURL base URL of the API, download URLs of exports are relative
+	// to it
+	PublicURL string
 }
@@ -0,0 +1,108 @@
+package inspectr_resolvers_test
+
+import (
+	"context"
+	"testing"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/inspectr/backend/plugins/api/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+// resolverCalls call a root resolver of each root type. insufficient claims
+// may sign in but lack the scopes and roles the field requires. Resolvers
+// authorize before they touch the database, so none is given.
+var resolverCalls = []struct {
+	field        string
+	insufficient utils.Claims
+	call         func(ctx context.Context) error
+}{
+	{
+		"Query.trails",
+		utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeUsersRead}, Roles: map[string][]string{"project": {producer}}},
+		func(ctx context.Context) error {
+			_, err := (&resolvers.Resolver{}).Trails(ctx, &struct {
+				First  *int32
+				After  *string
+				Filter *resolvers.TrailFilter
+				Search *string
+				Sort   string
+			}{})
+			return err
+		},
+	},
+	{
+		"Mutation.createAlertRule",
+		utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeAlertsRead}, Roles: map[string][]string{"project": {reader}}},
+		func(ctx context.Context) error {
+			_, err := (&resolvers.Resolver{}).CreateAlertRule(ctx, &struct {
+				Project graphql.ID
+				Input   resolvers.AlertRuleInput
+			}{Project: "project", Input: resolvers.AlertRuleInput{Name: "logins"}})
+			return err
+		},
+	},
+	{
+		"Mutation.grantProjectRole",
+		utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeUsersRead}, Roles: map[string][]string{"project": {reader, producer}}},
+		func(ctx context.Context) error {
+			_, err := (&resolvers.Resolver{}).GrantProjectRole(ctx, &struct {
+				UserId  graphql.ID
+				Project graphql.ID
+				Role    string
+			}{UserId: "user", Project: "project", Role: admin})
+			return err
+		},
+	},
+	{
+		"Subscription.trailCreated",
+		utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeMetricsRead}, Roles: map[string][]string{"project": {producer}}},
+		func(ctx context.Context) error {
+			_, err := (&resolvers.SubscriptionResolver{}).TrailCreated(ctx, &struct {
+				Filter *resolvers.TrailFilter
+			}{})
+			return err
+		},
+	},
+}
+
+func TestResolversAuthorize(t *testing.T) {
+	for _, test := range resolverCalls {
+		t.Run(test.field, func(t *testing.T) {
+			t.Run("no claims", func(t *testing.T) {
+				expectCode(t, test.call(context.Background()), resolvers.CodeUnauthenticated)
+			})
+
+			t.Run("token error", func(t *testing.T) {
+				ctx := withClaims(utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeAdmin}, TokenError: "token is expired"})
+				expectCode(t, test.call(ctx), resolvers.CodeUnauthenticated)
+			})
+
+			t.Run("insufficient claims", func(t *testing.T) {
+				expectCode(t, test.call(withClaims(test.insufficient)), resolvers.CodeForbidden)
+			})
+		})
+	}
+}
+
+func TestTrailCreatedSkipsOtherProjects(t *testing.T) {
+	readable, other := uuid.NewV4(), uuid.NewV4()
+	claims := utils.Claims{UserId: "user", Roles: map[string][]string{readable.String(): {reader}}}
+
+	for _, test := range []struct {
+		project uuid.UUID
+		err     error
+	}{
+		{readable, nil},
+		{other, resolvers.ErrSkipped},
+	} {
+		ctx := context.WithValue(withClaims(claims), "trail", resolvers.Trail{ProjectId: test.project})
+		_, err := (&resolvers.SubscriptionResolver{}).TrailCreated(ctx, &struct {
+			Filter *resolvers.TrailFilter
+		}{})
+		if err != test.err {
+			t.Errorf("trail of project %s: got %v, want %v", test.project, err, test.err)
+		}
+	}
+}
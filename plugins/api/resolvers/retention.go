@@ -0,0 +1,531 @@
+package inspectr_resolvers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/inspectr/backend/plugins/api/archive"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// archiveBatchSize maximum number of trails in one archive object
+const archiveBatchSize = 5000
+
+// Day retention periods are counted in
+const Day = 24 * time.Hour
+
+// RetentionPolicy moves trails out of Postgres into an archive store once
+// they are ArchiveAfterDays old, and deletes them from the archive once they
+// are PurgeAfterDays old.
+//
+// For each trail the most specific policy applies: one for its project and
+// event, its project and every event, every project and its event, then
+// every project and every event. Trails no policy applies to are kept.
+type RetentionPolicy struct {
+	Model `json:",inline"`
+	// ProjectId nil for every project
+	ProjectId *uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// Event empty for every event
+	Event string `json:"event" gorm:"type:varchar(100)"`
+	// ArchiveAfterDays age at which trails are archived
+	ArchiveAfterDays int `json:"archiveAfterDays"`
+	// PurgeAfterDays age at which archived trails are deleted, 0 keeps them
+	PurgeAfterDays int `json:"purgeAfterDays"`
+}
+
+// Validate
+func (p *RetentionPolicy) Validate() error {
+	if p.ArchiveAfterDays <= 0 {
+		return fmt.Errorf("archive after must be at least one day")
+	}
+
+	if p.PurgeAfterDays != 0 && p.PurgeAfterDays < p.ArchiveAfterDays {
+		return fmt.Errorf("purge after (%d days) must not be before archive after (%d days)", p.PurgeAfterDays, p.ArchiveAfterDays)
+	}
+
+	return nil
+}
+
+// ParseRetention parses a retention period in days: 90d, 12w or 7y, where a
+// year is 365 days
+func ParseRetention(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty retention period")
+	}
+
+	unit := 1
+	switch value[len(value)-1] {
+	case 'd':
+	case 'w':
+		unit = 7
+	case 'y':
+		unit = 365
+	default:
+		return 0, fmt.Errorf("retention period %q must end in d, w or y", value)
+	}
+
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid retention period %q", value)
+	}
+
+	return n * unit, nil
+}
+
+// Archive is the manifest of an archive object, a gzipped JSONL file with
+// one trail per line in sequence order. A copy of the manifest is stored
+// next to it as <key>.manifest.json.
+type Archive struct {
+	Model `json:",inline"`
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// PolicyId retention policy the trails were archived by
+	PolicyId uuid.UUID `json:"policyId" gorm:"type:uuid"`
+	// Key of the object in the archive store
+	Key string `json:"key" gorm:"type:varchar(255)"`
+	// Format
+	Format string `json:"format" gorm:"type:varchar(20)"`
+	// Rows number of trails
+	Rows int64 `json:"rows" gorm:"type:bigint"`
+	// Bytes size of the object
+	Bytes int64 `json:"bytes" gorm:"type:bigint"`
+	// Sha256 hex digest of the object
+	Sha256 string `json:"sha256" gorm:"type:varchar(64)"`
+	// FromSequence first trail
+	FromSequence int64 `json:"fromSequence" gorm:"type:bigint"`
+	// ToSequence last trail, archives of a single event skip sequences
+	ToSequence int64 `json:"toSequence" gorm:"type:bigint"`
+	// FromCreatedAt creation time of the oldest trail
+	FromCreatedAt time.Time `json:"fromCreatedAt"`
+	// ToCreatedAt creation time of the newest trail
+	ToCreatedAt time.Time `json:"toCreatedAt"`
+	// PurgeAfter time the object is deleted, nil to keep it
+	PurgeAfter *time.Time `json:"purgeAfter"`
+	// PurgedAt time the object was deleted
+	PurgedAt *time.Time `json:"purgedAt"`
+}
+
+// ManifestKey key of the manifest copy stored next to the archive
+func (a *Archive) ManifestKey() string {
+	return a.Key + ".manifest.json"
+}
+
+// ArchivedTrail keeps the chain position and hash of an archived trail, so
+// hash chains and checkpoints still verify once the trail left Postgres
+type ArchivedTrail struct {
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid;primary_key"`
+	// Sequence
+	Sequence int64 `json:"sequence" gorm:"type:bigint;primary_key;auto_increment:false"`
+	// PreviousHash
+	PreviousHash string `json:"previousHash" gorm:"type:varchar(64)"`
+	// Hash
+	Hash string `json:"hash" gorm:"type:varchar(64)"`
+	// ArchiveId archive holding the trail
+	ArchiveId uuid.UUID `json:"archiveId" gorm:"type:uuid"`
+}
+
+// RetentionRule selects the trails of a project a policy applies to, those
+// of Events or, when Events is empty, those of every event but Except
+type RetentionRule struct {
+	// Policy
+	Policy RetentionPolicy
+	// Events
+	Events []string
+	// Except
+	Except []string
+}
+
+// RetentionRules returns the rules policies make for project
+func RetentionRules(policies []RetentionPolicy, project uuid.UUID) []RetentionRule {
+	var projectDefault, globalDefault *RetentionPolicy
+	projectEvents := map[string]RetentionPolicy{}
+	globalEvents := map[string]RetentionPolicy{}
+
+	for i := range policies {
+		policy := policies[i]
+		switch {
+		case policy.ProjectId == nil && policy.Event == "":
+			globalDefault = &policy
+		case policy.ProjectId == nil:
+			globalEvents[policy.Event] = policy
+		case *policy.ProjectId != project:
+			continue
+		case policy.Event == "":
+			projectDefault = &policy
+		default:
+			projectEvents[policy.Event] = policy
+		}
+	}
+
+	var events []string
+	for event := range projectEvents {
+		events = append(events, event)
+	}
+	for event := range globalEvents {
+		if _, ok := projectEvents[event]; !ok {
+			events = append(events, event)
+		}
+	}
+	sort.Strings(events)
+
+	var rules []RetentionRule
+	for _, event := range events {
+		policy, ok := projectEvents[event]
+		if !ok && projectDefault != nil {
+			// the project's default overrides policies of every project
+			continue
+		}
+		if !ok {
+			policy = globalEvents[event]
+		}
+		rules = append(rules, RetentionRule{Policy: policy, Events: []string{event}})
+	}
+
+	fallback := projectDefault
+	if fallback == nil {
+		fallback = globalDefault
+	}
+
+	if fallback != nil {
+		var except []string
+		for _, event := range events {
+			if _, ok := projectEvents[event]; ok || projectDefault == nil {
+				except = append(except, event)
+			}
+		}
+		rules = append(rules, RetentionRule{Policy: *fallback, Except: except})
+	}
+
+	return rules
+}
+
+// ArchiveTrails moves the chained trails of project older than rule's
+// archive period into store, returning the archives written. Each archive
+// holds the trails of a single day, and is only recorded, and its trails
+// deleted, once the object and its manifest are stored. Partitions left
+// empty are removed by ExpireArchivedPartitions.
+func ArchiveTrails(db *gorm.DB, store archive.Store, project uuid.UUID, rule RetentionRule, now time.Time) ([]Archive, error) {
+	var archives []Archive
+
+	for {
+		tx := db.Begin()
+		if tx.Error != nil {
+			return archives, tx.Error
+		}
+
+		archived, err := archiveBatch(tx, store, project, rule, now)
+		if err != nil {
+			tx.Rollback()
+			return archives, err
+		}
+
+		if archived == nil {
+			return archives, tx.Rollback().Error
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return archives, err
+		}
+
+		archives = append(archives, *archived)
+	}
+}
+
+func archiveBatch(tx *gorm.DB, store archive.Store, project uuid.UUID, rule RetentionRule, now time.Time) (*Archive, error) {
+	// every replica receives heartbeats, only one of them archives a project
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "inspectr:archive:"+project.String()).Error; err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-time.Duration(rule.Policy.ArchiveAfterDays) * Day)
+	query := tx.Where("project_id = ? AND sequence > 0 AND created_at < ?", project, cutoff)
+	if len(rule.Events) > 0 {
+		query = query.Where("event IN (?)", rule.Events)
+	}
+	if len(rule.Except) > 0 {
+		query = query.Where("event NOT IN (?)", rule.Except)
+	}
+
+	oldest := Trail{}
+	if err := query.Order("created_at asc").Limit(1).Find(&oldest).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	day := oldest.Model.CreatedAt.UTC().Truncate(Day)
+	end := day.Add(Day)
+	if cutoff.Before(end) {
+		end = cutoff
+	}
+
+	var rows []Trail
+	err := query.Where("created_at >= ? AND created_at < ?", day, end).
+		Order("sequence asc").
+		Limit(archiveBatchSize).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	encoder := json.NewEncoder(writer)
+
+	archived := &Archive{
+		Model: Model{
+			ID:        uuid.NewV4(),
+			CreatedAt: now.UTC().Truncate(time.Microsecond),
+		},
+		ProjectId:     project,
+		PolicyId:      rule.Policy.Model.ID,
+		Format:        "jsonl",
+		Rows:          int64(len(rows)),
+		FromSequence:  rows[0].Sequence,
+		ToSequence:    rows[len(rows)-1].Sequence,
+		FromCreatedAt: rows[0].Model.CreatedAt,
+		ToCreatedAt:   rows[0].Model.CreatedAt,
+	}
+	archived.Key = fmt.Sprintf("%s/%s/%s.jsonl.gz", project.String(), day.Format("2006/01/02"), archived.Model.ID.String())
+
+	ids := make([]uuid.UUID, len(rows))
+	for i, trail := range rows {
+		if err := encoder.Encode(&trail); err != nil {
+			return nil, err
+		}
+
+		ids[i] = trail.Model.ID
+		if trail.Model.CreatedAt.Before(archived.FromCreatedAt) {
+			archived.FromCreatedAt = trail.Model.CreatedAt
+		}
+		if trail.Model.CreatedAt.After(archived.ToCreatedAt) {
+			archived.ToCreatedAt = trail.Model.CreatedAt
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(buffer.Bytes())
+	archived.Bytes = int64(buffer.Len())
+	archived.Sha256 = hex.EncodeToString(sum[:])
+
+	if rule.Policy.PurgeAfterDays > 0 {
+		purgeAfter := archived.ToCreatedAt.Add(time.Duration(rule.Policy.PurgeAfterDays) * Day)
+		archived.PurgeAfter = &purgeAfter
+	}
+
+	manifest, err := json.MarshalIndent(archived, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.Put(archived.Key, bytes.NewReader(buffer.Bytes()), "application/gzip"); err != nil {
+		return nil, err
+	}
+
+	if err := store.Put(archived.ManifestKey(), bytes.NewReader(manifest), "application/json"); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(archived).Error; err != nil {
+		return nil, err
+	}
+
+	err = tx.Exec(`INSERT INTO archived_trails (project_id, sequence, previous_hash, hash, archive_id)
+		SELECT project_id, sequence, previous_hash, hash, ? FROM trails WHERE id IN (?)`, archived.Model.ID, ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("id IN (?)", ids).Delete(&Trail{}).Error; err != nil {
+		return nil, err
+	}
+
+	return archived, nil
+}
+
+// PurgeArchives deletes the archive objects past their purge time, and
+// their manifest copies, from store. Archive rows and the hashes of the
+// purged trails are kept.
+func PurgeArchives(db *gorm.DB, store archive.Store, now time.Time) ([]Archive, error) {
+	var archives []Archive
+	if err := db.Where("purge_after < ? AND purged_at IS NULL", now).Order("purge_after asc").Find(&archives).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range archives {
+		archived := &archives[i]
+
+		for _, key := range []string{archived.Key, archived.ManifestKey()} {
+			if err := store.Delete(key); err != nil {
+				return archives[:i], err
+			}
+		}
+
+		if err := db.Model(archived).UpdateColumn("purged_at", now).Error; err != nil {
+			return archives[:i], err
+		}
+	}
+
+	return archives, nil
+}
+
+// archivedLinks returns the archived trails of project between from and to
+// by sequence
+func archivedLinks(db *gorm.DB, project uuid.UUID, from int64, to int64) (map[int64]ArchivedTrail, error) {
+	var rows []ArchivedTrail
+	err := db.Where("project_id = ? AND sequence >= ? AND sequence <= ?", project, from, to).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	links := make(map[int64]ArchivedTrail, len(rows))
+	for _, row := range rows {
+		links[row.Sequence] = row
+	}
+
+	return links, nil
+}
+
+// RetentionPolicyResolver resolver for RetentionPolicy
+type RetentionPolicyResolver struct {
+	RetentionPolicy
+	DB *gorm.DB
+}
+
+// ID
+func (r *RetentionPolicyResolver) ID() graphql.ID {
+	return graphql.ID(r.RetentionPolicy.Model.ID.String())
+}
+
+// Project
+func (r *RetentionPolicyResolver) Project() (*ProjectResolver, error) {
+	if r.RetentionPolicy.ProjectId == nil {
+		return nil, nil
+	}
+
+	project, err := FindProject(r.DB, r.RetentionPolicy.ProjectId.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProjectResolver{Project: project}, nil
+}
+
+// Event
+func (r *RetentionPolicyResolver) Event() *string {
+	if r.RetentionPolicy.Event == "" {
+		return nil
+	}
+	return &r.RetentionPolicy.Event
+}
+
+// ArchiveAfterDays
+func (r *RetentionPolicyResolver) ArchiveAfterDays() int32 {
+	return int32(r.RetentionPolicy.ArchiveAfterDays)
+}
+
+// PurgeAfterDays
+func (r *RetentionPolicyResolver) PurgeAfterDays() *int32 {
+	if r.RetentionPolicy.PurgeAfterDays == 0 {
+		return nil
+	}
+	days := int32(r.RetentionPolicy.PurgeAfterDays)
+	return &days
+}
+
+// ArchiveResolver resolver for Archive
+type ArchiveResolver struct {
+	Archive
+}
+
+// ID
+func (r *ArchiveResolver) ID() graphql.ID {
+	return graphql.ID(r.Archive.Model.ID.String())
+}
+
+// ProjectId
+func (r *ArchiveResolver) ProjectId() graphql.ID {
+	return graphql.ID(r.Archive.ProjectId.String())
+}
+
+// Key
+func (r *ArchiveResolver) Key() string {
+	return r.Archive.Key
+}
+
+// Format
+func (r *ArchiveResolver) Format() string {
+	return r.Archive.Format
+}
+
+// Rows
+func (r *ArchiveResolver) Rows() int32 {
+	return int32(r.Archive.Rows)
+}
+
+// Bytes
+func (r *ArchiveResolver) Bytes() string {
+	return strconv.FormatInt(r.Archive.Bytes, 10)
+}
+
+// Sha256
+func (r *ArchiveResolver) Sha256() string {
+	return r.Archive.Sha256
+}
+
+// FromSequence
+func (r *ArchiveResolver) FromSequence() string {
+	return strconv.FormatInt(r.Archive.FromSequence, 10)
+}
+
+// ToSequence
+func (r *ArchiveResolver) ToSequence() string {
+	return strconv.FormatInt(r.Archive.ToSequence, 10)
+}
+
+// From
+func (r *ArchiveResolver) From() graphql.Time {
+	return graphql.Time{Time: r.Archive.FromCreatedAt}
+}
+
+// To
+func (r *ArchiveResolver) To() graphql.Time {
+	return graphql.Time{Time: r.Archive.ToCreatedAt}
+}
+
+// PurgeAfter
+func (r *ArchiveResolver) PurgeAfter() *graphql.Time {
+	if r.Archive.PurgeAfter == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.Archive.PurgeAfter}
+}
+
+// PurgedAt
+func (r *ArchiveResolver) PurgedAt() *graphql.Time {
+	if r.Archive.PurgedAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.Archive.PurgedAt}
+}
+
+// CreatedAt
+func (r *ArchiveResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.Archive.Model.CreatedAt}
+}
@@ -0,0 +1,395 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Rollup is a table of trail counts per project, event and bucket
+type Rollup struct {
+	// Name minute, hour or day
+	Name string
+	// Table
+	Table string
+	// Size of the buckets
+	Size time.Duration
+}
+
+// Rollups from the finest to the coarsest, each built from the one before
+var Rollups = []Rollup{
+	{Name: "minute", Table: "trail_rollups_minute", Size: time.Minute},
+	{Name: "hour", Table: "trail_rollups_hour", Size: time.Hour},
+	{Name: "day", Table: "trail_rollups_day", Size: Day},
+}
+
+const (
+	// RollupSettle time trails are given to be committed before the minute
+	// they were created in is rolled up
+	RollupSettle = 2 * time.Minute
+	// maxRollupStep time rolled up at once
+	maxRollupStep = Day
+)
+
+// RollupState is the range of trail creation times the rollups cover, from
+// the start of a day until the end of a minute
+type RollupState struct {
+	// ID always 1
+	ID int `gorm:"primary_key"`
+	// RolledFrom
+	RolledFrom time.Time `json:"rolledFrom"`
+	// RolledUntil
+	RolledUntil time.Time `json:"rolledUntil"`
+}
+
+// TableName
+func (RollupState) TableName() string {
+	return "trail_rollup_state"
+}
+
+// FindRollupState returns the range covered by the rollups, false when
+// nothing was rolled up yet
+func FindRollupState(db *gorm.DB) (RollupState, bool, error) {
+	state := RollupState{}
+	err := db.Where("id = 1").Find(&state).Error
+	if err == gorm.ErrRecordNotFound {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+
+	state.RolledFrom = state.RolledFrom.UTC()
+	state.RolledUntil = state.RolledUntil.UTC()
+	return state, true, nil
+}
+
+// lockRollups serializes rollup maintenance across replicas for the rest of
+// tx
+func lockRollups(tx *gorm.DB) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "inspectr:rollups").Error
+}
+
+// rollupRange recomputes the rollups of the trails created between from and
+// to, both on minute boundaries. Hour and day buckets overlapping the range
+// are recomputed from the finer rollup whole.
+func rollupRange(tx *gorm.DB, from time.Time, to time.Time) error {
+	for i, rollup := range Rollups {
+		start := from.Truncate(rollup.Size)
+		end := to.Truncate(rollup.Size)
+		if end.Before(to) {
+			end = end.Add(rollup.Size)
+		}
+
+		if err := tx.Exec(`DELETE FROM `+rollup.Table+` WHERE bucket >= ? AND bucket < ?`, start, end).Error; err != nil {
+			return err
+		}
+
+		bucket := fmt.Sprintf(`date_trunc('%s', %%s AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'`, rollup.Name)
+		var err error
+		if i == 0 {
+			err = tx.Exec(`INSERT INTO `+rollup.Table+` (project_id, event, bucket, count)
+			SELECT project_id, event, `+fmt.Sprintf(bucket, "created_at")+`, COUNT(*)
+			FROM trails
+			WHERE created_at >= ? AND created_at < ?
+			GROUP BY 1, 2, 3`, start, end).Error
+		} else {
+			err = tx.Exec(`INSERT INTO `+rollup.Table+` (project_id, event, bucket, count)
+			SELECT project_id, event, `+fmt.Sprintf(bucket, "bucket")+`, SUM(count)
+			FROM `+Rollups[i-1].Table+`
+			WHERE bucket >= ? AND bucket < ?
+			GROUP BY 1, 2, 3`, start, end).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RollupTrails rolls up the trails created since the last call, at most a
+// day of them, up to RollupSettle before now. The first call starts at the
+// beginning of the current day, older trails are rolled up by
+// BackfillRollups. It returns the end of the covered range.
+func RollupTrails(db *gorm.DB, now time.Time) (time.Time, error) {
+	until := now.UTC().Add(-RollupSettle).Truncate(time.Minute)
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return time.Time{}, tx.Error
+	}
+
+	if err := lockRollups(tx); err != nil {
+		tx.Rollback()
+		return time.Time{}, err
+	}
+
+	state, found, err := FindRollupState(tx)
+	if err != nil {
+		tx.Rollback()
+		return time.Time{}, err
+	}
+	if !found {
+		day := until.Truncate(Day)
+		state = RollupState{ID: 1, RolledFrom: day, RolledUntil: day}
+	}
+
+	if !until.After(state.RolledUntil) {
+		tx.Rollback()
+		return state.RolledUntil, nil
+	}
+
+	if until.Sub(state.RolledUntil) > maxRollupStep {
+		until = state.RolledUntil.Add(maxRollupStep)
+	}
+
+	if err := rollupRange(tx, state.RolledUntil, until); err != nil {
+		tx.Rollback()
+		return time.Time{}, err
+	}
+
+	// backfills move rolled_from without the lock
+	if found {
+		err = tx.Model(&state).Update("rolled_until", until).Error
+	} else {
+		state.RolledUntil = until
+		err = tx.Create(&state).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return time.Time{}, err
+	}
+
+	return until, tx.Commit().Error
+}
+
+// rebuildRollups recomputes the rollups between from and to a day at a
+// time, calling progress after each day
+func rebuildRollups(db *gorm.DB, from time.Time, to time.Time, progress func(time.Time)) error {
+	for day := from; day.Before(to); day = day.Add(Day) {
+		end := day.Add(Day)
+		if end.After(to) {
+			end = to
+		}
+
+		tx := db.Begin()
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		if err := lockRollups(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := rollupRange(tx, day, end); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(end)
+		}
+	}
+
+	return nil
+}
+
+// BackfillRollups extends the rollups back to the day of from, or of the
+// oldest trail when from is nil
+func BackfillRollups(db *gorm.DB, from *time.Time, now time.Time, progress func(time.Time)) error {
+	if _, err := RollupTrails(db, now); err != nil {
+		return err
+	}
+
+	state, _, err := FindRollupState(db)
+	if err != nil {
+		return err
+	}
+
+	if from == nil {
+		var oldest struct {
+			CreatedAt *time.Time
+		}
+		if err := db.Raw(`SELECT MIN(created_at) AS created_at FROM trails`).Scan(&oldest).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if oldest.CreatedAt == nil {
+			return nil
+		}
+		from = oldest.CreatedAt
+	}
+
+	start := from.UTC().Truncate(Day)
+	if !start.Before(state.RolledFrom) {
+		return nil
+	}
+
+	// newest first, so the covered range grows with every day
+	for day := state.RolledFrom.Add(-Day); !day.Before(start); day = day.Add(-Day) {
+		err := rebuildRollups(db, day, day.Add(Day), nil)
+		if err != nil {
+			return err
+		}
+
+		if err := db.Model(&RollupState{}).Where("id = 1").Update("rolled_from", day).Error; err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(day)
+		}
+	}
+
+	return nil
+}
+
+// RepairRollups recomputes the rollups of the trails created between from
+// and to, widened to whole days and limited to the covered range, e.g.
+// after trails were committed later than RollupSettle
+func RepairRollups(db *gorm.DB, from time.Time, to time.Time, progress func(time.Time)) error {
+	state, found, err := FindRollupState(db)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("nothing rolled up yet")
+	}
+
+	from = from.UTC().Truncate(Day)
+	if from.Before(state.RolledFrom) {
+		from = state.RolledFrom
+	}
+	if end := to.UTC().Truncate(Day); end.Before(to) {
+		to = end.Add(Day)
+	}
+	if to.After(state.RolledUntil) {
+		to = state.RolledUntil
+	}
+
+	return rebuildRollups(db, from, to, progress)
+}
+
+// pickRollup returns the coarsest rollup whose buckets line up with
+// intervals of interval seconds starting at startsAt, and the time until
+// which it covers [startsAt, endsAt]. ok is false when no rollup covers
+// startsAt.
+func pickRollup(state RollupState, startsAt time.Time, endsAt time.Time, interval int32) (rollup Rollup, until time.Time, ok bool) {
+	if startsAt.Before(state.RolledFrom) {
+		return rollup, until, false
+	}
+
+	for i := len(Rollups) - 1; i >= 0; i-- {
+		size := int64(Rollups[i].Size / time.Second)
+		if int64(interval)%size != 0 || startsAt.Unix()%size != 0 {
+			continue
+		}
+
+		until = state.RolledUntil
+		if endsAt.Before(until) {
+			until = endsAt
+		}
+		until = until.Truncate(Rollups[i].Size)
+
+		if !until.After(startsAt) {
+			return rollup, until, false
+		}
+
+		return Rollups[i], until, true
+	}
+
+	return rollup, until, false
+}
+
+// rollupCounts counts the trails matching filter per interval, from the
+// buckets of rollup between startsAt and until, and from the trails created
+// after until up to endsAt. Counts are per event when byEvent.
+func rollupCounts(ctx context.Context, db *gorm.DB, rollup Rollup, startsAt time.Time, until time.Time, endsAt time.Time, interval int32, byEvent bool, filter *TrailFilter) ([]intervalCount, error) {
+	value := "NULL"
+	if byEvent {
+		value = "event"
+	}
+
+	sources := []struct {
+		query *gorm.DB
+		count string
+		time  string
+	}{
+		{db.Table(rollup.Table).Where("bucket >= ? AND bucket < ?", startsAt, until), "SUM(count)", "bucket"},
+		{db.Table("trails").Where("created_at >= ? AND created_at <= ?", until, endsAt), "COUNT(*)", "created_at"},
+	}
+
+	var counts []intervalCount
+	for _, source := range sources {
+		query, err := filter.Apply(ScopeProjects(ctx, source.query, "project_id"))
+		if err != nil {
+			return nil, err
+		}
+
+		var rows []intervalCount
+		err = query.Select(value+" AS value, "+source.count+" AS count, "+metricBucket(source.time)+" AS interval", interval, interval).
+			Group("1, interval").Scan(&rows).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+
+		counts = append(counts, rows...)
+	}
+
+	return counts, nil
+}
+
+// rollupFilter reports whether the trails matching filter can be counted
+// from the rollups, which only know the project and event of trails
+func rollupFilter(filter *TrailFilter) bool {
+	return filter == nil ||
+		(filter.Actor == nil || len(*filter.Actor) == 0) &&
+			(filter.Target == nil || len(*filter.Target) == 0) &&
+			(filter.Origin == nil || len(*filter.Origin) == 0) &&
+			filter.StartsAt == nil && filter.EndsAt == nil && filter.Metadata == nil
+}
+
+// topGroups returns the topN values of counts with the most trails, and
+// clears the value of the counts of the rest
+func topGroups(counts []intervalCount, topN int32) []string {
+	totals := map[string]int64{}
+	for _, count := range counts {
+		if count.Value != nil {
+			totals[*count.Value] += int64(count.Count)
+		}
+	}
+
+	groups := make([]string, 0, len(totals))
+	for group := range totals {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if totals[groups[i]] != totals[groups[j]] {
+			return totals[groups[i]] > totals[groups[j]]
+		}
+		return groups[i] < groups[j]
+	})
+	if int32(len(groups)) > topN {
+		groups = groups[:topN]
+	}
+
+	top := map[string]bool{}
+	for _, group := range groups {
+		top[group] = true
+	}
+	for i := range counts {
+		if counts[i].Value != nil && !top[*counts[i].Value] {
+			counts[i].Value = nil
+		}
+	}
+
+	return groups
+}
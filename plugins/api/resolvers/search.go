@@ -0,0 +1,176 @@
+package inspectr_resolvers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// SearchQuery parses free text typed by users into a tsquery
+	SearchQuery = "websearch_to_tsquery('english', ?)"
+	// SearchRank ranks a trail's search_vector against SearchQuery. ts_rank
+	// returns a real, the float8 cursors carry compares equal to it only once
+	// it is cast.
+	SearchRank = "ts_rank(search_vector, " + SearchQuery + ")::float8"
+
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+
+	// SearchBackfillBatch trails whose search vector is computed per
+	// statement by BackfillSearchVectors
+	SearchBackfillBatch = 1000
+)
+
+// SearchColumns columns highlighted in search results, in display order
+var SearchColumns = []struct {
+	Column string
+	Field  string
+}{
+	{"event", "event"},
+	{"actor", "actor"},
+	{"target", "target"},
+	{"origin", "origin"},
+	{"event_metadata", "eventMetadata"},
+	{"actor_metadata", "actorMetadata"},
+	{"target_metadata", "targetMetadata"},
+	{"origin_metadata", "originMetadata"},
+}
+
+// Highlight snippet of a trail field matching the search
+type Highlight struct {
+	// Field
+	Field string
+	// Snippet
+	Snippet string
+}
+
+// HighlightResolver resolver for Highlight
+type HighlightResolver struct {
+	Highlight
+}
+
+// Field
+func (r *HighlightResolver) Field() string {
+	return r.Highlight.Field
+}
+
+// Snippet
+func (r *HighlightResolver) Snippet() string {
+	return r.Highlight.Snippet
+}
+
+// Highlighter loads highlighted snippets for a page of search results on
+// first use, so trails are only rescanned when highlights are requested
+type Highlighter struct {
+	DB     *gorm.DB
+	Search string
+	IDs    []uuid.UUID
+
+	once       sync.Once
+	highlights map[uuid.UUID][]Highlight
+	err        error
+}
+
+// Get returns the highlights of trail id
+func (h *Highlighter) Get(id uuid.UUID) ([]Highlight, error) {
+	h.once.Do(h.load)
+	return h.highlights[id], h.err
+}
+
+func (h *Highlighter) load() {
+	h.highlights = make(map[uuid.UUID][]Highlight)
+	if len(h.IDs) == 0 {
+		return
+	}
+
+	options := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=20, MinWords=5", highlightStart, highlightStop)
+
+	selects := []string{"id"}
+	var args []interface{}
+	for _, column := range SearchColumns {
+		selects = append(selects, fmt.Sprintf("ts_headline('english', coalesce(%s::text, ''), %s, ?)", column.Column, SearchQuery))
+		args = append(args, h.Search, options)
+	}
+
+	rows, err := h.DB.Table("trails").
+		Select(strings.Join(selects, ", "), args...).
+		Where("id IN (?)", h.IDs).
+		Rows()
+	if err != nil {
+		h.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		snippets := make([]string, len(SearchColumns))
+		dest := []interface{}{&id}
+		for i := range snippets {
+			dest = append(dest, &snippets[i])
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			h.err = err
+			return
+		}
+
+		for i, snippet := range snippets {
+			if strings.Contains(snippet, highlightStart) {
+				h.highlights[id] = append(h.highlights[id], Highlight{
+					Field:   SearchColumns[i].Field,
+					Snippet: snippet,
+				})
+			}
+		}
+	}
+
+	h.err = rows.Err()
+}
+
+// BackfillSearchVectors computes the search vectors of trails stored before
+// full-text search was added, batch trails per statement so no statement
+// holds locks on the whole table, and returns how many were updated. New
+// trails get theirs from the trails_search_vector_update trigger. Trails are
+// not found by search until they are backfilled. Completion is recorded in
+// trail_search_backfill, later calls return right away.
+func BackfillSearchVectors(db *gorm.DB, batch int) (int64, error) {
+	var done struct {
+		Done bool
+	}
+	if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM trail_search_backfill) AS done`).Scan(&done).Error; err != nil {
+		return 0, err
+	}
+	if done.Done {
+		return 0, nil
+	}
+
+	var updated int64
+	after := uuid.Nil
+
+	for {
+		var ids []uuid.UUID
+		if err := db.Table("trails").Where("id > ? AND search_vector IS NULL", after).Order("id").Limit(batch).Pluck("id", &ids).Error; err != nil {
+			return updated, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		after = ids[len(ids)-1]
+
+		// the trigger computes the vector of every updated row
+		result := db.Exec(`UPDATE trails SET search_vector = NULL WHERE id IN (?) AND search_vector IS NULL`, ids)
+		if result.Error != nil {
+			return updated, result.Error
+		}
+		updated += result.RowsAffected
+	}
+
+	err := db.Exec(`INSERT INTO trail_search_backfill (id, backfilled_at) VALUES (1, ?) ON CONFLICT (id) DO NOTHING`, time.Now()).Error
+	return updated, err
+}
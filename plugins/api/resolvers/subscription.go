@@ -0,0 +1,48 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrSkipped returned by subscription fields when the published trail does
+// not match the subscription's arguments
+var ErrSkipped = errors.New("trail does not match subscription")
+
+// SubscriptionResolver resolves subscription fields for the trail found in
+// the "trail" context value
+type SubscriptionResolver struct {
+	// DB
+	DB *gorm.DB
+}
+
+// TrailCreated
+func (r *SubscriptionResolver) TrailCreated(ctx context.Context, args *struct {
+	Filter *TrailFilter
+}) (*TrailResolver, error) {
+	if err := Authorize(ctx, "trailCreated"); err != nil {
+		return nil, err
+	}
+
+	trail, ok := ctx.Value("trail").(Trail)
+	if !ok {
+		return nil, ErrSkipped
+	}
+
+	if !CanReadProject(ctx, trail.ProjectId) {
+		return nil, ErrSkipped
+	}
+
+	matched, err := args.Filter.Matches(r.DB, trail)
+	if err != nil {
+		return nil, err
+	}
+
+	if !matched {
+		return nil, ErrSkipped
+	}
+
+	return &TrailResolver{DB: r.DB, Trail: trail}, nil
+}
@@ -0,0 +1,291 @@
+package inspectr_resolvers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/codeamp/transistor"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Trails, hash chains and checkpoints belong to a project, the tenant.
+// Users are granted roles per project.
+const (
+	// RoleAdmin reads the project's trails and manages its members
+	RoleAdmin = "admin"
+	// RoleReader reads the project's trails
+	RoleReader = "reader"
+	// RoleProducer writes trails into the project with the user's API key
+	RoleProducer = "producer"
+)
+
+// Roles every role a user can be granted in a project
+var Roles = []string{RoleAdmin, RoleReader, RoleProducer}
+
+// DefaultTenant project of trails received without a tenant, and of the
+// trails stored before projects existed
+const DefaultTenant = "default/default"
+
+// Organization groups projects
+type Organization struct {
+	Model `json:",inline"`
+	// Name
+	Name string `json:"name" gorm:"type:varchar(100)"`
+	// Slug
+	Slug string `json:"slug" gorm:"type:varchar(100);unique_index"`
+}
+
+// Project tenant trails are scoped to
+type Project struct {
+	Model `json:",inline"`
+	// OrganizationId
+	OrganizationId uuid.UUID `json:"organizationId" gorm:"type:uuid"`
+	// Organization
+	Organization Organization
+	// Name
+	Name string `json:"name" gorm:"type:varchar(100)"`
+	// Slug unique within the organization
+	Slug string `json:"slug" gorm:"type:varchar(100)"`
+}
+
+// Tenant returns the organization/project slug of the project, the
+// Organization must be loaded
+func (p *Project) Tenant() string {
+	return p.Organization.Slug + "/" + p.Slug
+}
+
+// ProjectRole grants a user a role in a project
+type ProjectRole struct {
+	Model `json:",inline"`
+	// UserId
+	UserId uuid.UUID `json:"userId" gorm:"type:uuid"`
+	// ProjectId
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid"`
+	// Project
+	Project Project
+	// Value one of Roles
+	Value string `json:"value" gorm:"type:varchar(50)"`
+}
+
+// FindProject returns the project identified by tenant, either a project ID
+// or an organization/project slug
+func FindProject(db *gorm.DB, tenant string) (Project, error) {
+	project := Project{}
+
+	query := db.Preload("Organization")
+	if id, err := uuid.FromString(tenant); err == nil {
+		query = query.Where("id = ?", id)
+	} else {
+		parts := strings.SplitN(tenant, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return project, fmt.Errorf("tenant %q is neither a project id nor organization/project", tenant)
+		}
+		query = query.
+			Joins("JOIN organizations ON organizations.id = projects.organization_id").
+			Where("organizations.slug = ? AND projects.slug = ?", parts[0], parts[1])
+	}
+
+	if err := query.Find(&project).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return project, fmt.Errorf("tenant %q not found", tenant)
+		}
+		return project, err
+	}
+
+	return project, nil
+}
+
+// CreateProject creates the project named by the organization/project slug
+// tenant, and its organization when missing. Existing projects are returned
+// as is.
+func CreateProject(db *gorm.DB, tenant string) (Project, error) {
+	parts := strings.SplitN(tenant, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Project{}, fmt.Errorf("tenant %q is not organization/project", tenant)
+	}
+
+	organization := Organization{}
+	err := db.Where(Organization{Slug: parts[0]}).Attrs(Organization{Name: parts[0]}).FirstOrCreate(&organization).Error
+	if err != nil {
+		return Project{}, err
+	}
+
+	project := Project{}
+	err = db.Where(Project{OrganizationId: organization.Model.ID, Slug: parts[1]}).Attrs(Project{Name: parts[1]}).FirstOrCreate(&project).Error
+	if err != nil {
+		return Project{}, err
+	}
+	project.Organization = organization
+
+	return project, nil
+}
+
+// Viewer is the caller of a resolver. The claims utils.AuthMiddleware
+// stores in the context under "jwt" implement it.
+type Viewer interface {
+	// Err reports why the viewer could not be authenticated
+	Err() error
+	// UserID
+	UserID() string
+	// Scopes returns the viewer's UserPermission values
+	Scopes() []string
+	// IsAdmin reports whether the viewer may access every project
+	IsAdmin() bool
+	// ProjectIDs returns the projects the viewer holds one of roles in
+	ProjectIDs(roles ...string) []string
+}
+
+// ViewerFromContext returns the viewer of ctx, nil for anonymous requests
+func ViewerFromContext(ctx context.Context) Viewer {
+	viewer, _ := ctx.Value("jwt").(Viewer)
+	return viewer
+}
+
+// ReadableProjects returns the projects the viewer of ctx may read, nil
+// when the viewer may read every project
+func ReadableProjects(ctx context.Context) []string {
+	viewer := ViewerFromContext(ctx)
+	if viewer == nil {
+		return []string{}
+	}
+
+	if viewer.IsAdmin() {
+		return nil
+	}
+
+	projects := viewer.ProjectIDs(RoleAdmin, RoleReader)
+	if projects == nil {
+		return []string{}
+	}
+
+	return projects
+}
+
+// CanReadProject reports whether the viewer of ctx may read project
+func CanReadProject(ctx context.Context, project uuid.UUID) bool {
+	projects := ReadableProjects(ctx)
+	return projects == nil || transistor.SliceContains(project.String(), projects)
+}
+
+// ProjectScope returns the condition restricting column to the projects the
+// viewer of ctx may read
+func ProjectScope(ctx context.Context, column string) (string, []interface{}) {
+	projects := ReadableProjects(ctx)
+	switch {
+	case projects == nil:
+		return "1 = 1", nil
+	case len(projects) == 0:
+		return "1 = 0", nil
+	default:
+		return column + " IN (?)", []interface{}{projects}
+	}
+}
+
+// ScopeProjects restricts query to the projects the viewer of ctx may read
+func ScopeProjects(ctx context.Context, query *gorm.DB, column string) *gorm.DB {
+	clause, args := ProjectScope(ctx, column)
+	return query.Where(clause, args...)
+}
+
+// CanManageProject reports whether the viewer of ctx may grant and revoke
+// roles in project
+func CanManageProject(ctx context.Context, project uuid.UUID) bool {
+	if HasScope(ctx, ScopeMembersWrite) {
+		return true
+	}
+
+	viewer := ViewerFromContext(ctx)
+	return viewer != nil && transistor.SliceContains(project.String(), viewer.ProjectIDs(RoleAdmin))
+}
+
+// ViewerProject returns the project identified by id, or the only project
+// the viewer may read when id is nil
+func ViewerProject(ctx context.Context, db *gorm.DB, id *graphql.ID) (Project, error) {
+	if id == nil {
+		projects := ReadableProjects(ctx)
+		if len(projects) != 1 {
+			return Project{}, fmt.Errorf("project is required")
+		}
+		return FindProject(db, projects[0])
+	}
+
+	project, err := FindProject(db, string(*id))
+	if err != nil {
+		return project, err
+	}
+
+	if !CanReadProject(ctx, project.Model.ID) {
+		// do not reveal projects the viewer can not read
+		return Project{}, fmt.Errorf("tenant %q not found", string(*id))
+	}
+
+	return project, nil
+}
+
+// ProjectResolver resolver for Project
+type ProjectResolver struct {
+	Project
+}
+
+// ID
+func (r *ProjectResolver) ID() graphql.ID {
+	return graphql.ID(r.Project.Model.ID.String())
+}
+
+// Name
+func (r *ProjectResolver) Name() string {
+	return r.Project.Name
+}
+
+// Slug
+func (r *ProjectResolver) Slug() string {
+	return r.Project.Slug
+}
+
+// Tenant
+func (r *ProjectResolver) Tenant() string {
+	return r.Project.Tenant()
+}
+
+// Organization
+func (r *ProjectResolver) Organization() *OrganizationResolver {
+	return &OrganizationResolver{Organization: r.Project.Organization}
+}
+
+// ProjectRoleResolver resolver for ProjectRole, the Project must be loaded
+type ProjectRoleResolver struct {
+	ProjectRole
+}
+
+// Project
+func (r *ProjectRoleResolver) Project() *ProjectResolver {
+	return &ProjectResolver{Project: r.ProjectRole.Project}
+}
+
+// Role
+func (r *ProjectRoleResolver) Role() string {
+	return strings.ToUpper(r.ProjectRole.Value)
+}
+
+// OrganizationResolver resolver for Organization
+type OrganizationResolver struct {
+	Organization
+}
+
+// ID
+func (r *OrganizationResolver) ID() graphql.ID {
+	return graphql.ID(r.Organization.Model.ID.String())
+}
+
+// Name
+func (r *OrganizationResolver) Name() string {
+	return r.Organization.Name
+}
+
+// Slug
+func (r *OrganizationResolver) Slug() string {
+	return r.Organization.Slug
+}
@@ -2,16 +2,20 @@ package inspectr_resolvers
 
 import (
 	"encoding/json"
+	"strconv"
 	"time"
 
 	graphql "github.com/graph-gophers/graphql-go"
 	"github.com/jinzhu/gorm"
 	"github.com/jinzhu/gorm/dialects/postgres"
+	uuid "github.com/satori/go.uuid"
 )
 
 // Trail
 type Trail struct {
 	Model `json:",inline"`
+	// ProjectId tenant of the trail
+	ProjectId uuid.UUID `json:"projectId" gorm:"type:uuid"`
 	// Timestamp
 	Timestamp int64 `json:"timestamp" gorm:"type:integer"`
 	// Event
@@ -30,6 +34,14 @@ type Trail struct {
 	Origin string `json:"origin"`
 	// OriginMetadata
 	OriginMetadata postgres.Jsonb `json:"originMetadata" gorm:"type:jsonb;"`
+	// Sequence position in the hash chain
+	Sequence int64 `json:"sequence" gorm:"type:bigint"`
+	// PreviousHash hash of the preceding trail in the chain
+	PreviousHash string `json:"previousHash" gorm:"type:varchar(64)"`
+	// Hash sha256 of the trail's canonical JSON, see CanonicalJSON
+	Hash string `json:"hash" gorm:"type:varchar(64)"`
+	// IdempotencyKey shared by deliveries of the same trail, see TrailKey
+	IdempotencyKey string `json:"-" gorm:"-"`
 }
 
 // TrailResolver resolver for Trail
@@ -43,6 +55,11 @@ func (r *TrailResolver) ID() graphql.ID {
 	return graphql.ID(r.Trail.Model.ID.String())
 }
 
+// ProjectId
+func (r *TrailResolver) ProjectId() graphql.ID {
+	return graphql.ID(r.Trail.ProjectId.String())
+}
+
 // Timestamp
 func (r *TrailResolver) Timestamp() int64 {
 	return r.Trail.Timestamp
@@ -88,6 +105,21 @@ func (r *TrailResolver) OriginMetadata() JSON {
 	return JSON{r.Trail.OriginMetadata.RawMessage}
 }
 
+// Sequence
+func (r *TrailResolver) Sequence() string {
+	return strconv.FormatInt(r.Trail.Sequence, 10)
+}
+
+// PreviousHash
+func (r *TrailResolver) PreviousHash() string {
+	return r.Trail.PreviousHash
+}
+
+// Hash
+func (r *TrailResolver) Hash() string {
+	return r.Trail.Hash
+}
+
 // Created
 func (r *TrailResolver) Created() graphql.Time {
 	tm := time.Unix(r.Trail.Timestamp, 0)
@@ -2,7 +2,11 @@ package inspectr_resolvers
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
 
+	log "github.com/codeamp/logger"
+	redis "github.com/go-redis/redis"
 	graphql "github.com/graph-gophers/graphql-go"
 	uuid "github.com/satori/go.uuid"
 )
@@ -14,8 +18,16 @@ type User struct {
 	Email string `json:"email" gorm:"type:varchar(100);unique_index"`
 	// Password
 	Password string `json:"password" gorm:"type:varchar(255)"`
+	// APIKey identifies the user when producing trails over HTTP
+	APIKey string `json:"-" gorm:"type:varchar(64)"`
+	// APISecret bcrypt hash of the secret paired with APIKey
+	APISecret string `json:"-" gorm:"type:varchar(255)"`
+	// DeactivatedAt set when the user may no longer sign in or produce trails
+	DeactivatedAt *time.Time `json:"deactivatedAt"`
 	// Permissions
 	Permissions []UserPermission
+	// ProjectRoles
+	ProjectRoles []ProjectRole
 }
 
 // UserPermission
@@ -27,6 +39,31 @@ type UserPermission struct {
 	Value string `json:"value"`
 }
 
+// ClaimsIndexKey returns the redis set holding the keys of the claims cached
+// for user
+func ClaimsIndexKey(user string) string {
+	return fmt.Sprintf("claims_%s", user)
+}
+
+// InvalidateClaims drops the cached claims of user, so changes to its
+// permissions and roles apply to the next request
+func InvalidateClaims(client *redis.Client, user uuid.UUID) {
+	if client == nil {
+		return
+	}
+
+	index := ClaimsIndexKey(user.String())
+	keys, err := client.SMembers(index).Result()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if err := client.Del(append(keys, index)...).Err(); err != nil {
+		log.Error(err)
+	}
+}
+
 // UserResolver resolver for User
 type UserResolver struct {
 	User
@@ -42,9 +79,39 @@ func (r *UserResolver) Email() string {
 	return r.User.Email
 }
 
+// Active
+func (r *UserResolver) Active() bool {
+	return r.User.DeactivatedAt == nil
+}
+
+// DeactivatedAt
+func (r *UserResolver) DeactivatedAt() *graphql.Time {
+	if r.User.DeactivatedAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.User.DeactivatedAt}
+}
+
+// ApiKey
+func (r *UserResolver) ApiKey() *string {
+	if r.User.APIKey == "" {
+		return nil
+	}
+	return &r.User.APIKey
+}
+
+// Roles
+func (r *UserResolver) Roles() []*ProjectRoleResolver {
+	results := make([]*ProjectRoleResolver, len(r.User.ProjectRoles))
+	for i, role := range r.User.ProjectRoles {
+		results[i] = &ProjectRoleResolver{ProjectRole: role}
+	}
+	return results
+}
+
 // Permissions
 func (r *UserResolver) Permissions() []string {
-	var permissions []string
+	permissions := []string{}
 
 	for _, permission := range r.User.Permissions {
 		permissions = append(permissions, permission.Value)
@@ -0,0 +1,377 @@
+package inspectr_resolvers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/codeamp/logger"
+	redis "github.com/go-redis/redis"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Aggregates of windowed alert rules
+const (
+	AggregateCount         = "count"
+	AggregateDistinctCount = "distinct_count"
+)
+
+const (
+	// MaxAlertWindow longest window of a windowed alert rule
+	MaxAlertWindow = 24 * time.Hour
+	// AlertGroupTTL time a group of a windowed rule is remembered without
+	// trails, absence is only reported for groups seen within it
+	AlertGroupTTL = 7 * 24 * time.Hour
+)
+
+// Conditions of windowed alert notifications
+const (
+	conditionAbove = "at or above"
+	conditionBelow = "below"
+)
+
+// Window state of a rule is kept in redis under inspectr:alert:<rule>:
+//
+//	window:<group>  sorted set of the trail ids, or distinct values, counted
+//	                in the window, scored by the time they were last seen
+//	groups          sorted set of the groups, scored by the time last seen
+//	first           hash of the time each group was first seen
+//	firing          set of the groups whose count is below the rule's Below
+func alertKey(rule uuid.UUID, parts ...string) string {
+	return "inspectr:alert:" + rule.String() + ":" + strings.Join(parts, ":")
+}
+
+// score returns t as a sorted set score, in seconds
+func score(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+func formatScore(s float64) string {
+	return strconv.FormatFloat(s, 'f', -1, 64)
+}
+
+// ValidDimension checks that dimension names a trail field, event, actor,
+// target or origin, or a path below a metadata field such as
+// actorMetadata.ip
+func ValidDimension(dimension string) error {
+	path := strings.Split(dimension, ".")
+	switch path[0] {
+	case "event", "actor", "target", "origin":
+		if len(path) > 1 {
+			return fmt.Errorf("%s has no fields", path[0])
+		}
+		return nil
+	case "eventMetadata", "actorMetadata", "targetMetadata", "originMetadata":
+		if len(path) < 2 {
+			return fmt.Errorf("%s requires a path, e.g. %s.id", path[0], path[0])
+		}
+		for _, key := range path[1:] {
+			if key == "" {
+				return fmt.Errorf("invalid metadata path %q", dimension)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown dimension %q, expected event, actor, target, origin or a metadata path", dimension)
+	}
+}
+
+// DimensionValue returns the value of dimension for trail, false when a
+// metadata path is missing. Values that are not strings are JSON encoded.
+func DimensionValue(trail Trail, dimension string) (string, bool) {
+	path := strings.Split(dimension, ".")
+
+	var metadata json.RawMessage
+	switch path[0] {
+	case "event":
+		return trail.Event, true
+	case "actor":
+		return trail.Actor, true
+	case "target":
+		return trail.Target, true
+	case "origin":
+		return trail.Origin, true
+	case "eventMetadata":
+		metadata = trail.EventMetadata.RawMessage
+	case "actorMetadata":
+		metadata = trail.ActorMetadata.RawMessage
+	case "targetMetadata":
+		metadata = trail.TargetMetadata.RawMessage
+	case "originMetadata":
+		metadata = trail.OriginMetadata.RawMessage
+	default:
+		return "", false
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(metadata, &value); err != nil {
+		return "", false
+	}
+
+	for _, key := range path[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(encoded), true
+	}
+}
+
+// validateWindow checks the settings of windowed rules
+func (r *AlertRule) validateWindow() error {
+	if r.Window == 0 {
+		if r.Aggregate != "" || r.DistinctBy != "" || r.GroupBy != "" || r.Above != 0 || r.Below != 0 {
+			return fmt.Errorf("aggregate, distinctBy, groupBy, above and below require a window")
+		}
+		return nil
+	}
+
+	if r.Window < 0 || time.Duration(r.Window)*time.Second > MaxAlertWindow {
+		return fmt.Errorf("window must be between 1 and %d seconds", int(MaxAlertWindow/time.Second))
+	}
+
+	if r.Above < 0 || r.Below < 0 {
+		return fmt.Errorf("above and below must not be negative")
+	}
+
+	if r.Above == 0 && r.Below == 0 {
+		return fmt.Errorf("windowed rules require above, below or both")
+	}
+
+	switch r.Aggregate {
+	case AggregateCount:
+		if r.DistinctBy != "" {
+			return fmt.Errorf("distinctBy requires the DISTINCT_COUNT aggregate")
+		}
+	case AggregateDistinctCount:
+		if r.DistinctBy == "" {
+			return fmt.Errorf("the DISTINCT_COUNT aggregate requires distinctBy")
+		}
+		if err := ValidDimension(r.DistinctBy); err != nil {
+			return fmt.Errorf("distinctBy: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown aggregate %q", r.Aggregate)
+	}
+
+	if r.GroupBy != "" {
+		if err := ValidDimension(r.GroupBy); err != nil {
+			return fmt.Errorf("groupBy: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// EvaluateAlertWindow counts trail, matched by the windowed rule, in the
+// window of its group and notifies when the count reaches rule.Above.
+// Trails missing the group or distinct dimension are not counted. It
+// returns the number of deliveries left pending.
+func EvaluateAlertWindow(db *gorm.DB, rds *redis.Client, rule AlertRule, project Project, trail Trail, now time.Time) (int, error) {
+	if rds == nil {
+		return 0, fmt.Errorf("windowed alert rules require redis")
+	}
+
+	group := ""
+	if rule.GroupBy != "" {
+		var ok bool
+		if group, ok = DimensionValue(trail, rule.GroupBy); !ok {
+			return 0, nil
+		}
+	}
+
+	member := trail.Model.ID.String()
+	if rule.Aggregate == AggregateDistinctCount {
+		var ok bool
+		if member, ok = DimensionValue(trail, rule.DistinctBy); !ok {
+			return 0, nil
+		}
+	}
+
+	window := time.Duration(rule.Window) * time.Second
+	key := alertKey(rule.Model.ID, "window", group)
+
+	var added, count *redis.IntCmd
+	_, err := rds.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(key, "-inf", "("+formatScore(score(now.Add(-window))))
+		added = pipe.ZAdd(key, redis.Z{Score: score(now), Member: member})
+		count = pipe.ZCard(key)
+		pipe.Expire(key, window+time.Minute)
+		pipe.ZAdd(alertKey(rule.Model.ID, "groups"), redis.Z{Score: score(now), Member: group})
+		pipe.HSetNX(alertKey(rule.Model.ID, "first"), group, now.Unix())
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// MULTI gives every trail its own count, so only the trail that takes
+	// the count to Above notifies
+	after := count.Val()
+	before := after - added.Val()
+	if rule.Above == 0 || before >= int64(rule.Above) || after < int64(rule.Above) {
+		return 0, nil
+	}
+
+	data := newAlertData(rule, project, &trail)
+	data.Group = group
+	data.Count = after
+	data.Condition = conditionAbove
+	data.Threshold = rule.Above
+
+	return notifyAlertRule(db, rule, data, &trail)
+}
+
+// CheckAlertWindows forgets the groups of the windowed rule not seen within
+// AlertGroupTTL, and notifies of the groups whose count fell below
+// rule.Below. Groups are only checked once seen for a whole window, and
+// notify once until their count recovers. Every replica may run it, the
+// firing set makes sure only one notifies. It returns the number of
+// deliveries left pending.
+func CheckAlertWindows(db *gorm.DB, rds *redis.Client, rule AlertRule, now time.Time) (int, error) {
+	if rds == nil {
+		return 0, fmt.Errorf("windowed alert rules require redis")
+	}
+
+	groupsKey := alertKey(rule.Model.ID, "groups")
+	firstKey := alertKey(rule.Model.ID, "first")
+	firingKey := alertKey(rule.Model.ID, "firing")
+
+	// rules counting every trail together always have the group ""
+	if rule.GroupBy == "" {
+		if err := rds.HSetNX(firstKey, "", now.Unix()).Err(); err != nil {
+			return 0, err
+		}
+		if err := rds.ZAddNX(groupsKey, redis.Z{Score: score(now), Member: ""}).Err(); err != nil {
+			return 0, err
+		}
+	} else {
+		cutoff := "(" + formatScore(score(now.Add(-AlertGroupTTL)))
+		forgotten, err := rds.ZRangeByScore(groupsKey, redis.ZRangeBy{Min: "-inf", Max: cutoff}).Result()
+		if err != nil {
+			return 0, err
+		}
+		if len(forgotten) > 0 {
+			members := make([]interface{}, len(forgotten))
+			for i, group := range forgotten {
+				members[i] = group
+			}
+			_, err := rds.TxPipelined(func(pipe redis.Pipeliner) error {
+				pipe.ZRemRangeByScore(groupsKey, "-inf", cutoff)
+				pipe.HDel(firstKey, forgotten...)
+				pipe.SRem(firingKey, members...)
+				return nil
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if rule.Below == 0 {
+		return 0, nil
+	}
+
+	first, err := rds.HGetAll(firstKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	window := time.Duration(rule.Window) * time.Second
+	pending := 0
+	var project *Project
+	for group, seen := range first {
+		firstSeen, err := strconv.ParseInt(seen, 10, 64)
+		if err != nil || now.Sub(time.Unix(firstSeen, 0)) < window {
+			continue
+		}
+
+		key := alertKey(rule.Model.ID, "window", group)
+		var count *redis.IntCmd
+		_, err = rds.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.ZRemRangeByScore(key, "-inf", "("+formatScore(score(now.Add(-window))))
+			count = pipe.ZCard(key)
+			return nil
+		})
+		if err != nil {
+			return pending, err
+		}
+
+		if count.Val() >= int64(rule.Below) {
+			if err := rds.SRem(firingKey, group).Err(); err != nil {
+				return pending, err
+			}
+			continue
+		}
+
+		started, err := rds.SAdd(firingKey, group).Result()
+		if err != nil {
+			return pending, err
+		}
+		if started == 0 {
+			continue
+		}
+
+		if project == nil {
+			found, err := FindProject(db, rule.ProjectId.String())
+			if err != nil {
+				return pending, err
+			}
+			project = &found
+		}
+
+		data := newAlertData(rule, *project, nil)
+		data.Group = group
+		data.Count = count.Val()
+		data.Condition = conditionBelow
+		data.Threshold = rule.Below
+
+		n, err := notifyAlertRule(db, rule, data, nil)
+		if err != nil {
+			return pending, err
+		}
+		pending += n
+	}
+
+	return pending, nil
+}
+
+// ResetAlertWindows deletes the window state of rule
+func ResetAlertWindows(rds *redis.Client, rule uuid.UUID) {
+	if rds == nil {
+		return
+	}
+
+	groups, err := rds.ZRange(alertKey(rule, "groups"), 0, -1).Result()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	keys := []string{alertKey(rule, "groups"), alertKey(rule, "first"), alertKey(rule, "firing")}
+	for _, group := range groups {
+		keys = append(keys, alertKey(rule, "window", group))
+	}
+
+	if err := rds.Del(keys...).Err(); err != nil {
+		log.Error(err)
+	}
+}
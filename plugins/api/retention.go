@@ -0,0 +1,267 @@
+package inspectr
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/codeamp/logger"
+	"github.com/inspectr/backend/plugins/api/archive"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+)
+
+// DefaultArchiveTick heartbeat tick retention policies are applied on
+const DefaultArchiveTick = "hour"
+
+// openArchiveStore opens the archive store configured for the plugin. It
+// returns nil when no store is configured.
+func openArchiveStore() (archive.Store, error) {
+	rawurl := viper.GetString("plugins.api.archive.store")
+	if rawurl == "" {
+		return nil, nil
+	}
+
+	return archive.Open(rawurl, archive.S3Config{
+		Endpoint:        viper.GetString("plugins.api.archive.endpoint"),
+		Region:          viper.GetString("plugins.api.archive.region"),
+		AccessKeyID:     viper.GetString("plugins.api.archive.aws_access_key_id"),
+		SecretAccessKey: viper.GetString("plugins.api.archive.aws_secret_access_key"),
+	})
+}
+
+// Retain applies the retention policies: trails past their archive period
+// are moved to the archive store, archives past their purge period are
+// deleted from it
+func (x *API) Retain() {
+	if x.ArchiveStore == nil {
+		return
+	}
+
+	if err := applyRetention(x.DB, x.ArchiveStore, time.Now()); err != nil {
+		log.Error(err)
+	}
+}
+
+// Archive applies the retention policies once
+func (x *API) Archive() error {
+	store, err := openArchiveStore()
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return fmt.Errorf("no archive store configured, set plugins.api.archive.store")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	return applyRetention(db, store, time.Now())
+}
+
+func applyRetention(db *gorm.DB, store archive.Store, now time.Time) error {
+	var policies []resolvers.RetentionPolicy
+	if err := db.Find(&policies).Error; err != nil {
+		return err
+	}
+
+	if len(policies) == 0 {
+		return nil
+	}
+
+	var projects []resolvers.Project
+	if err := db.Preload("Organization").Find(&projects).Error; err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, project := range projects {
+		for _, rule := range resolvers.RetentionRules(policies, project.ID) {
+			archives, err := resolvers.ArchiveTrails(db, store, project.ID, rule, now)
+			for _, archived := range archives {
+				log.InfoWithFields("archived trails", log.Fields{
+					"tenant":       project.Tenant(),
+					"key":          archived.Key,
+					"rows":         archived.Rows,
+					"fromSequence": archived.FromSequence,
+					"toSequence":   archived.ToSequence,
+				})
+			}
+
+			if err != nil {
+				failed++
+				log.ErrorWithFields(err.Error(), log.Fields{
+					"tenant": project.Tenant(),
+					"policy": rule.Policy.ID.String(),
+				})
+			}
+		}
+	}
+
+	purged, err := resolvers.PurgeArchives(db, store, now)
+	for _, archived := range purged {
+		log.InfoWithFields("purged archive", log.Fields{
+			"project": archived.ProjectId.String(),
+			"key":     archived.Key,
+			"rows":    archived.Rows,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("archiving failed for %d retention rules", failed)
+	}
+
+	return nil
+}
+
+// SetRetentionPolicy creates or replaces the policy of tenant and event,
+// either of which may be "*" for all. archiveAfter and purgeAfter are
+// periods such as 90d or 7y, purgeAfter may be empty to keep archives.
+func (x *API) SetRetentionPolicy(tenant string, event string, archiveAfter string, purgeAfter string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	policy, err := findRetentionPolicy(db, tenant, event)
+	if err != nil {
+		return err
+	}
+
+	policy.ArchiveAfterDays, err = resolvers.ParseRetention(archiveAfter)
+	if err != nil {
+		return err
+	}
+
+	policy.PurgeAfterDays = 0
+	if purgeAfter != "" {
+		policy.PurgeAfterDays, err = resolvers.ParseRetention(purgeAfter)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	if err := db.Save(&policy).Error; err != nil {
+		return err
+	}
+
+	log.InfoWithFields("retention policy set", log.Fields{
+		"tenant":           tenant,
+		"event":            event,
+		"archiveAfterDays": policy.ArchiveAfterDays,
+		"purgeAfterDays":   policy.PurgeAfterDays,
+	})
+
+	return nil
+}
+
+// RemoveRetentionPolicy removes the policy of tenant and event
+func (x *API) RemoveRetentionPolicy(tenant string, event string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	policy, err := findRetentionPolicy(db, tenant, event)
+	if err != nil {
+		return err
+	}
+
+	if policy.ID == uuid.Nil {
+		return fmt.Errorf("no retention policy for tenant %q and event %q", tenant, event)
+	}
+
+	if err := db.Delete(&policy).Error; err != nil {
+		return err
+	}
+
+	log.InfoWithFields("retention policy removed", log.Fields{
+		"tenant": tenant,
+		"event":  event,
+	})
+
+	return nil
+}
+
+// ListRetentionPolicies logs every retention policy
+func (x *API) ListRetentionPolicies() error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var policies []resolvers.RetentionPolicy
+	if err := db.Order("created_at asc").Find(&policies).Error; err != nil {
+		return err
+	}
+
+	for _, policy := range policies {
+		tenant := "*"
+		if policy.ProjectId != nil {
+			project, err := resolvers.FindProject(db, policy.ProjectId.String())
+			if err != nil {
+				return err
+			}
+			tenant = project.Tenant()
+		}
+
+		event := "*"
+		if policy.Event != "" {
+			event = policy.Event
+		}
+
+		log.InfoWithFields("retention policy", log.Fields{
+			"tenant":           tenant,
+			"event":            event,
+			"archiveAfterDays": policy.ArchiveAfterDays,
+			"purgeAfterDays":   policy.PurgeAfterDays,
+		})
+	}
+
+	return nil
+}
+
+// findRetentionPolicy returns the policy of tenant and event, a new one
+// when there is none
+func findRetentionPolicy(db *gorm.DB, tenant string, event string) (resolvers.RetentionPolicy, error) {
+	policy := resolvers.RetentionPolicy{}
+
+	query := db
+	if tenant == "*" {
+		query = query.Where("project_id IS NULL")
+	} else {
+		project, err := resolvers.FindProject(db, tenant)
+		if err != nil {
+			return policy, err
+		}
+		policy.ProjectId = &project.ID
+		query = query.Where("project_id = ?", project.ID)
+	}
+
+	if event != "*" {
+		policy.Event = event
+	}
+
+	err := query.Where("event = ?", policy.Event).Find(&policy).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return policy, err
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,52 @@
+package inspectr
+
+import (
+	"time"
+
+	log "github.com/codeamp/logger"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+)
+
+// RollupTrails adds the trails created since the last tick to the metric
+// rollups
+func (x *API) RollupTrails() {
+	if _, err := resolvers.RollupTrails(x.DB, time.Now()); err != nil {
+		log.Error(err)
+	}
+}
+
+// BackfillRollups rolls up the trails created since from, or every trail
+// when from is nil
+func (x *API) BackfillRollups(from *time.Time) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	return resolvers.BackfillRollups(db, from, time.Now(), func(day time.Time) {
+		log.InfoWithFields("rolled up trails", log.Fields{
+			"day": day.Format("2006-01-02"),
+		})
+	})
+}
+
+// RepairRollups recomputes the rollups of the trails created between from
+// and to
+func (x *API) RepairRollups(from time.Time, to time.Time) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	return resolvers.RepairRollups(db, from, to, func(until time.Time) {
+		log.InfoWithFields("repaired rollups", log.Fields{
+			"until": until.Format(time.RFC3339),
+		})
+	})
+}
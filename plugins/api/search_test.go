@@ -0,0 +1,139 @@
+package inspectr
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/inspectr/backend/plugins/api/utils"
+	"github.com/jinzhu/gorm/dialects/postgres"
+)
+
+func TestTrailsRelevancePagesEqualRanks(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	project, err := resolvers.CreateProject(db, "acme/search")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// identical trails rank equally, the ones naming login twice above them
+	want := map[string]bool{}
+	for i := 0; i < 9; i++ {
+		trail := benchTrail(project.ID, i)
+		trail.Event = "login"
+		trail.Actor = "alice"
+		trail.ActorMetadata = postgres.Jsonb{RawMessage: json.RawMessage(`{}`)}
+		if i%4 == 0 {
+			trail.Target = "login"
+		}
+		if err := resolvers.CreateTrail(db, &trail, 0); err != nil {
+			t.Fatal(err)
+		}
+		want[trail.Model.ID.String()] = true
+	}
+
+	ctx := context.WithValue(context.Background(), "jwt", utils.Claims{UserId: "admin", Permissions: []string{resolvers.ScopeAdmin}})
+	r := &resolvers.Resolver{DB: db}
+	search := "login"
+	first := int32(2)
+
+	seen := map[string]bool{}
+	var after *string
+	var last float64
+	for page := 0; ; page++ {
+		if page > len(want) {
+			t.Fatalf("still paging after %d pages", page)
+		}
+
+		connection, err := r.Trails(ctx, &struct {
+			First  *int32
+			After  *string
+			Filter *resolvers.TrailFilter
+			Search *string
+			Sort   string
+		}{First: &first, After: after, Search: &search, Sort: "RELEVANCE"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, edge := range connection.Edges() {
+			id := edge.Trail.Model.ID.String()
+			if seen[id] {
+				t.Errorf("page %d repeats %s", page, id)
+			}
+			seen[id] = true
+
+			rank := *edge.Rank()
+			if len(seen) > 1 && rank > last {
+				t.Errorf("page %d ranks %s %v after %v", page, id, rank, last)
+			}
+			last = rank
+		}
+
+		if !connection.HasNext {
+			break
+		}
+		after = connection.PageInfo().EndCursor()
+	}
+
+	for id := range want {
+		if !seen[id] {
+			t.Errorf("%s was skipped", id)
+		}
+	}
+}
+
+func TestBackfillSearchVectorsRecordsCompletion(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	// Migrate ran the backfill over the empty trails table and recorded it
+	project, err := resolvers.CreateProject(db, "acme/backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trail := benchTrail(project.ID, 0)
+	if err := resolvers.CreateTrail(db, &trail, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// clear its vector the way trails stored before full-text search lack
+	// one, without the trigger computing it again
+	tx := db.Begin()
+	if err := tx.Exec(`SET LOCAL session_replication_role = replica`).Error; err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Exec(`UPDATE trails SET search_vector = NULL`).Error; err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := resolvers.BackfillSearchVectors(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 0 {
+		t.Errorf("updated %d trails after the backfill was recorded, want 0", updated)
+	}
+
+	// without the record trails lacking a vector are backfilled, once
+	if err := db.Exec(`DELETE FROM trail_search_backfill`).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int64{1, 0} {
+		updated, err := resolvers.BackfillSearchVectors(db, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated != want {
+			t.Errorf("updated %d trails, want %d", updated, want)
+		}
+	}
+}
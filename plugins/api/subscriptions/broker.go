@@ -0,0 +1,107 @@
+package subscriptions
+
+import (
+	"encoding/json"
+	"sync"
+
+	log "github.com/codeamp/logger"
+	redis "github.com/go-redis/redis"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+)
+
+// DefaultChannel redis channel trails are published on
+const DefaultChannel = "inspectr:trails"
+
+// Broker fans out created trails to every API replica through redis
+// pub/sub and from there to the local subscribers
+type Broker struct {
+	Redis   *redis.Client
+	Channel string
+
+	mu          sync.RWMutex
+	pubsub      *redis.PubSub
+	subscribers map[chan resolvers.Trail]struct{}
+}
+
+func NewBroker(redisClient *redis.Client, channel string) *Broker {
+	return &Broker{
+		Redis:       redisClient,
+		Channel:     channel,
+		subscribers: make(map[chan resolvers.Trail]struct{}),
+	}
+}
+
+// Publish sends trail to the subscribers of all replicas
+func (b *Broker) Publish(trail resolvers.Trail) error {
+	payload, err := json.Marshal(trail)
+	if err != nil {
+		return err
+	}
+
+	return b.Redis.Publish(b.Channel, payload).Err()
+}
+
+// Listen relays trails published on the redis channel until Close is called
+func (b *Broker) Listen() {
+	b.mu.Lock()
+	b.pubsub = b.Redis.Subscribe(b.Channel)
+	messages := b.pubsub.Channel()
+	b.mu.Unlock()
+
+	for message := range messages {
+		var trail resolvers.Trail
+		if err := json.Unmarshal([]byte(message.Payload), &trail); err != nil {
+			log.Error(err)
+			continue
+		}
+
+		b.deliver(trail)
+	}
+}
+
+// deliver sends trail to the local subscribers, dropping it for those
+// whose channel is full
+func (b *Broker) deliver(trail resolvers.Trail) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- trail:
+		default:
+			log.WarnWithFields("dropping trail for slow subscriber", log.Fields{
+				"id": trail.Model.ID.String(),
+			})
+		}
+	}
+}
+
+// Close stops Listen
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pubsub == nil {
+		return nil
+	}
+
+	return b.pubsub.Close()
+}
+
+// Subscribe returns a channel receiving every published trail
+func (b *Broker) Subscribe() chan resolvers.Trail {
+	subscriber := make(chan resolvers.Trail, 100)
+
+	b.mu.Lock()
+	b.subscribers[subscriber] = struct{}{}
+	b.mu.Unlock()
+
+	return subscriber
+}
+
+// Unsubscribe stops delivery to subscriber
+func (b *Broker) Unsubscribe(subscriber chan resolvers.Trail) {
+	b.mu.Lock()
+	delete(b.subscribers, subscriber)
+	b.mu.Unlock()
+}
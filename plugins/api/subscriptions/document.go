@@ -0,0 +1,176 @@
+package subscriptions
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// graphql-go validates subscription operations but can not execute them.
+// Subscriptions are therefore run as queries against a second schema whose
+// query root is the Subscription type.
+
+var schemaDefinition = regexp.MustCompile(`(?s)schema\s*\{[^}]*\}`)
+
+// SubscriptionSchema rewrites schema so the Subscription type becomes its
+// query root
+func SubscriptionSchema(schema string) string {
+	return schemaDefinition.ReplaceAllLiteralString(schema, "schema {\n  query: Subscription\n}")
+}
+
+// Operation is an operation definition of a GraphQL document
+type Operation struct {
+	// Type query, mutation or subscription
+	Type string
+	// Name empty for anonymous operations
+	Name string
+}
+
+// Document is a GraphQL document whose subscription operations can be
+// executed as queries
+type Document struct {
+	// Operations
+	Operations []Operation
+	// Query document with subscription operations turned into queries
+	Query string
+}
+
+// Operation returns the operation selected by name, following the rules of
+// graphql-go's getOperation
+func (d *Document) Operation(name string) (Operation, error) {
+	if len(d.Operations) == 0 {
+		return Operation{}, fmt.Errorf("no operations in query document")
+	}
+
+	if name == "" {
+		if len(d.Operations) > 1 {
+			return Operation{}, fmt.Errorf("more than one operation in query document and no operation name given")
+		}
+		return d.Operations[0], nil
+	}
+
+	for _, operation := range d.Operations {
+		if operation.Name == name {
+			return operation, nil
+		}
+	}
+
+	return Operation{}, fmt.Errorf("no operation with name %q", name)
+}
+
+// ParseDocument scans the top level definitions of document. Documents
+// that do not parse are left for graphql-go to report.
+func ParseDocument(document string) *Document {
+	d := &Document{}
+
+	var query strings.Builder
+	depth := 0
+	parens := 0
+	expectDefinition := true
+	var current *Operation
+
+	for i := 0; i < len(document); {
+		c := document[i]
+
+		switch {
+		case c == '#':
+			end := strings.IndexAny(document[i:], "\r\n")
+			if end < 0 {
+				end = len(document) - i
+			}
+			query.WriteString(document[i : i+end])
+			i += end
+		case c == '"':
+			end := stringEnd(document, i)
+			query.WriteString(document[i:end])
+			i = end
+		case isNameStart(c):
+			end := i + 1
+			for end < len(document) && isNameContinue(document[end]) {
+				end++
+			}
+			name := document[i:end]
+
+			if depth == 0 && expectDefinition {
+				expectDefinition = false
+				switch name {
+				case "query", "mutation", "subscription":
+					d.Operations = append(d.Operations, Operation{Type: name})
+					current = &d.Operations[len(d.Operations)-1]
+					if name == "subscription" {
+						name = "query"
+					}
+				default:
+					current = nil
+				}
+			} else if depth == 0 && current != nil && current.Name == "" {
+				current.Name = name
+				current = nil
+			}
+
+			query.WriteString(name)
+			i = end
+		default:
+			// braces inside arguments and variable definitions are object
+			// values, not selection sets
+			switch {
+			case c == '{' && parens == 0:
+				if depth == 0 && expectDefinition {
+					d.Operations = append(d.Operations, Operation{Type: "query"})
+					expectDefinition = false
+				}
+				if depth == 0 {
+					current = nil
+				}
+				depth++
+			case c == '}' && parens == 0:
+				depth--
+				if depth == 0 {
+					expectDefinition = true
+				}
+			case c == '(':
+				if depth == 0 {
+					current = nil
+				}
+				parens++
+			case c == ')':
+				parens--
+			}
+			query.WriteByte(c)
+			i++
+		}
+	}
+
+	d.Query = query.String()
+	return d
+}
+
+// stringEnd returns the index just past the string or block string at start
+func stringEnd(document string, start int) int {
+	if strings.HasPrefix(document[start:], `"""`) {
+		end := strings.Index(document[start+3:], `"""`)
+		if end < 0 {
+			return len(document)
+		}
+		return start + 3 + end + 3
+	}
+
+	for i := start + 1; i < len(document); i++ {
+		switch document[i] {
+		case '\\':
+			i++
+		case '"', '\n':
+			return i + 1
+		}
+	}
+
+	return len(document)
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,159 @@
+package subscriptions
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDocument(t *testing.T) {
+	tests := []struct {
+		name       string
+		document   string
+		operations []Operation
+		query      string
+	}{
+		{
+			"shorthand query",
+			`{ trails { totalCount } }`,
+			[]Operation{{Type: "query"}},
+			`{ trails { totalCount } }`,
+		},
+		{
+			"anonymous subscription",
+			`subscription { trailCreated { id } }`,
+			[]Operation{{Type: "subscription"}},
+			`query { trailCreated { id } }`,
+		},
+		{
+			"named operations",
+			`query Trails { trails { totalCount } } subscription Logins { trailCreated { id } } mutation Rotate { rotateApiKey }`,
+			[]Operation{{Type: "query", Name: "Trails"}, {Type: "subscription", Name: "Logins"}, {Type: "mutation", Name: "Rotate"}},
+			`query Trails { trails { totalCount } } query Logins { trailCreated { id } } mutation Rotate { rotateApiKey }`,
+		},
+		{
+			"variables and object values",
+			`subscription Logins($filter: TrailFilter = {event: ["login"]}) { trailCreated(filter: $filter) { id } }`,
+			[]Operation{{Type: "subscription", Name: "Logins"}},
+			`query Logins($filter: TrailFilter = {event: ["login"]}) { trailCreated(filter: $filter) { id } }`,
+		},
+		{
+			"anonymous with variables",
+			`subscription ($filter: TrailFilter) { trailCreated(filter: $filter) { id } }`,
+			[]Operation{{Type: "subscription"}},
+			`query ($filter: TrailFilter) { trailCreated(filter: $filter) { id } }`,
+		},
+		{
+			"fragments are not operations",
+			"fragment fields on Trail { id subscription: event }\nsubscription { trailCreated { ...fields } }",
+			[]Operation{{Type: "subscription"}},
+			"fragment fields on Trail { id subscription: event }\nquery { trailCreated { ...fields } }",
+		},
+		{
+			"keywords in comments and strings",
+			"# subscription Fake {\nsubscription Logins { trailCreated(filter: {event: [\"subscription {\", \"\"\"}\"\"\"]}) { id } }",
+			[]Operation{{Type: "subscription", Name: "Logins"}},
+			"# subscription Fake {\nquery Logins { trailCreated(filter: {event: [\"subscription {\", \"\"\"}\"\"\"]}) { id } }",
+		},
+		{
+			"escaped quote",
+			`subscription A { trailCreated(filter: {event: ["a\"}"]}) { id } } subscription B { trailCreated { id } }`,
+			[]Operation{{Type: "subscription", Name: "A"}, {Type: "subscription", Name: "B"}},
+			`query A { trailCreated(filter: {event: ["a\"}"]}) { id } } query B { trailCreated { id } }`,
+		},
+		{
+			"field named subscription",
+			`query { subscription { id } }`,
+			[]Operation{{Type: "query"}},
+			`query { subscription { id } }`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := ParseDocument(test.document)
+			if !reflect.DeepEqual(d.Operations, test.operations) {
+				t.Errorf("operations %+v, want %+v", d.Operations, test.operations)
+			}
+			if d.Query != test.query {
+				t.Errorf("query %q, want %q", d.Query, test.query)
+			}
+		})
+	}
+}
+
+func TestParseMalformedDocument(t *testing.T) {
+	// malformed documents are scanned without panicking and left for
+	// graphql-go to report
+	documents := []string{
+		``,
+		`subscription`,
+		`subscription {`,
+		`subscription { trailCreated { id } } }`,
+		`}}} subscription Logins { trailCreated { id } }`,
+		`subscription Logins(`,
+		`subscription Logins($filter: TrailFilter = {event: ["login"]) { id }`,
+		`subscription { trailCreated(filter: {event: ["unterminated`,
+		`subscription { trailCreated(filter: {event: ["""unterminated`,
+		"# only a comment",
+		`subscription { trailCreated(filter: {event: ["a\`,
+	}
+
+	for _, document := range documents {
+		d := ParseDocument(document)
+
+		// at most the subscription keyword is rewritten, nothing is dropped
+		if restored := strings.Replace(d.Query, "query", "subscription", -1); restored != document {
+			t.Errorf("%q rewritten to %q", document, d.Query)
+		}
+		if len(d.Operations) > 1 {
+			t.Errorf("%q has operations %+v", document, d.Operations)
+		}
+	}
+}
+
+func TestDocumentOperation(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		// operationName of the start payload
+		operationName string
+		want          Operation
+		err           string
+	}{
+		{"only operation", `subscription { trailCreated { id } }`, "", Operation{Type: "subscription"}, ""},
+		{"named only operation", `subscription Logins { trailCreated { id } }`, "", Operation{Type: "subscription", Name: "Logins"}, ""},
+		{"selected by name", `query Trails { trails { totalCount } } subscription Logins { trailCreated { id } }`, "Logins", Operation{Type: "subscription", Name: "Logins"}, ""},
+		{"query selected by name", `query Trails { trails { totalCount } } subscription Logins { trailCreated { id } }`, "Trails", Operation{Type: "query", Name: "Trails"}, ""},
+		{"no name", `query Trails { trails { totalCount } } subscription Logins { trailCreated { id } }`, "", Operation{}, "more than one operation in query document and no operation name given"},
+		{"unknown name", `subscription Logins { trailCreated { id } }`, "Logouts", Operation{}, `no operation with name "Logouts"`},
+		{"empty document", ``, "", Operation{}, "no operations in query document"},
+		{"only fragments", `fragment fields on Trail { id }`, "", Operation{}, "no operations in query document"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operation, err := ParseDocument(test.document).Operation(test.operationName)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got %v, want %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if operation != test.want {
+				t.Errorf("operation %+v, want %+v", operation, test.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionSchema(t *testing.T) {
+	schema := "schema {\n  query: Query\n  mutation: Mutation\n  subscription: Subscription\n}\n\ntype Query { a: Int }"
+	want := "schema {\n  query: Subscription\n}\n\ntype Query { a: Int }"
+	if got := SubscriptionSchema(schema); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,322 @@
+package subscriptions
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/codeamp/logger"
+	redis "github.com/go-redis/redis"
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/inspectr/backend/plugins/api/utils"
+	"github.com/jinzhu/gorm"
+)
+
+// Message types of the graphql-ws protocol
+// https://github.com/apollographql/subscriptions-transport-ws/blob/master/PROTOCOL.md
+const (
+	connectionInit      = "connection_init"
+	connectionAck       = "connection_ack"
+	connectionError     = "connection_error"
+	connectionKeepAlive = "ka"
+	connectionTerminate = "connection_terminate"
+	start               = "start"
+	stop                = "stop"
+	data                = "data"
+	errorMessage        = "error"
+	complete            = "complete"
+)
+
+// DefaultKeepAlive interval between keep alive messages
+const DefaultKeepAlive = 30 * time.Second
+
+type operationMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type startPayload struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Handler serves GraphQL queries, mutations and subscriptions over
+// WebSocket using the graphql-ws protocol
+type Handler struct {
+	// Schema validates operations and executes queries and mutations
+	Schema *graphql.Schema
+	// SubscriptionSchema executes subscriptions, see SubscriptionSchema
+	SubscriptionSchema *graphql.Schema
+	Broker             *Broker
+	DB                 *gorm.DB
+	Redis              *redis.Client
+	KeepAlive          time.Duration
+	// AllowedOrigins origins browsers may connect from, such as
+	// https://app.example.com, or * for any. Only the API's own origin is
+	// allowed when empty.
+	AllowedOrigins []string
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{"graphql-ws"},
+		CheckOrigin:  h.checkOrigin,
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	c := &connection{
+		handler:    h,
+		ws:         ws,
+		operations: make(map[string]context.CancelFunc),
+	}
+
+	c.serve(r.Context())
+}
+
+// checkOrigin reports whether r comes from an allowed origin. Requests
+// without an Origin header do not come from browsers and are allowed.
+func (h *Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(h.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range h.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	log.Warn("refused subscription connection from origin " + origin)
+	return false
+}
+
+type connection struct {
+	handler *Handler
+	ws      *websocket.Conn
+	ctx     context.Context
+
+	writeMu    sync.Mutex
+	operations map[string]context.CancelFunc
+	mu         sync.Mutex
+	// keepAlive starts the keep alive messages on the first connection_init
+	keepAlive sync.Once
+}
+
+func (c *connection) serve(requestCtx context.Context) {
+	ctx, cancel := context.WithCancel(requestCtx)
+	defer cancel()
+	defer c.ws.Close()
+
+	c.ctx = ctx
+
+	for {
+		_, raw, err := c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Error(err)
+			}
+			return
+		}
+
+		var message operationMessage
+		if err := json.Unmarshal(raw, &message); err != nil {
+			c.write(operationMessage{Type: connectionError, Payload: errorPayload(err.Error())})
+			continue
+		}
+
+		switch message.Type {
+		case connectionInit:
+			c.init(ctx, message.Payload)
+		case start:
+			c.start(message.ID, message.Payload)
+		case stop:
+			c.stop(message.ID)
+		case connectionTerminate:
+			return
+		default:
+			c.write(operationMessage{ID: message.ID, Type: errorMessage, Payload: errorPayload("unknown message type " + message.Type)})
+		}
+	}
+}
+
+// init acknowledges the connection. Browsers can not set headers on
+// WebSocket requests, so an authToken or Authorization in the payload
+// replaces the claims parsed from the upgrade request.
+func (c *connection) init(ctx context.Context, payload json.RawMessage) {
+	var params map[string]interface{}
+	if len(payload) > 0 {
+		json.Unmarshal(payload, &params)
+	}
+
+	authString := ""
+	if token, ok := params["authToken"].(string); ok && token != "" {
+		authString = "Bearer " + token
+	}
+	if authorization, ok := params["Authorization"].(string); ok && authorization != "" {
+		authString = authorization
+	}
+
+	c.mu.Lock()
+	if authString != "" {
+		claims, _ := utils.ParseClaims(authString, c.handler.DB, c.handler.Redis)
+		c.ctx = context.WithValue(ctx, "jwt", claims)
+	}
+	c.mu.Unlock()
+
+	c.write(operationMessage{Type: connectionAck})
+
+	c.keepAlive.Do(func() {
+		c.write(operationMessage{Type: connectionKeepAlive})
+		go c.sendKeepAlives(ctx)
+	})
+}
+
+// sendKeepAlives writes keep alive messages until ctx is done
+func (c *connection) sendKeepAlives(ctx context.Context) {
+	keepAlive := c.handler.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAlive
+	}
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.write(operationMessage{Type: connectionKeepAlive})
+		}
+	}
+}
+
+func (c *connection) start(id string, raw json.RawMessage) {
+	var payload startPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		c.write(operationMessage{ID: id, Type: errorMessage, Payload: errorPayload(err.Error())})
+		return
+	}
+
+	if errs := c.handler.Schema.Validate(payload.Query); len(errs) > 0 {
+		c.writeJSON(id, errorMessage, errs)
+		return
+	}
+
+	document := ParseDocument(payload.Query)
+	operation, err := document.Operation(payload.OperationName)
+	if err != nil {
+		c.write(operationMessage{ID: id, Type: errorMessage, Payload: errorPayload(err.Error())})
+		return
+	}
+
+	c.stop(id)
+
+	c.mu.Lock()
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.operations[id] = cancel
+	c.mu.Unlock()
+
+	if operation.Type != "subscription" {
+		go func() {
+			defer c.stop(id)
+			response := c.handler.Schema.Exec(ctx, payload.Query, payload.OperationName, payload.Variables)
+			c.writeJSON(id, data, resolvers.NewResponse(response))
+		}()
+		return
+	}
+
+	// without a trail the subscription only checks authorization
+	response := resolvers.NewResponse(c.handler.SubscriptionSchema.Exec(ctx, document.Query, payload.OperationName, payload.Variables))
+	if !skipped(response) {
+		c.writeJSON(id, data, response)
+		c.stop(id)
+		return
+	}
+
+	go c.subscribe(ctx, id, document.Query, payload)
+}
+
+func (c *connection) subscribe(ctx context.Context, id string, query string, payload startPayload) {
+	trails := c.handler.Broker.Subscribe()
+	defer c.handler.Broker.Unsubscribe(trails)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case trail := <-trails:
+			response := resolvers.NewResponse(c.handler.SubscriptionSchema.Exec(context.WithValue(ctx, "trail", trail), query, payload.OperationName, payload.Variables))
+			if skipped(response) {
+				continue
+			}
+			c.writeJSON(id, data, response)
+		}
+	}
+}
+
+func (c *connection) stop(id string) {
+	c.mu.Lock()
+	cancel, ok := c.operations[id]
+	delete(c.operations, id)
+	c.mu.Unlock()
+
+	if ok {
+		cancel()
+		c.write(operationMessage{ID: id, Type: complete})
+	}
+}
+
+func (c *connection) writeJSON(id string, messageType string, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	c.write(operationMessage{ID: id, Type: messageType, Payload: raw})
+}
+
+func (c *connection) write(message operationMessage) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := c.ws.WriteJSON(message); err != nil && !strings.Contains(err.Error(), "close sent") {
+		log.Error(err)
+	}
+}
+
+// skipped reports whether the subscription did not match the trail
+func skipped(response *resolvers.Response) bool {
+	for _, err := range response.Errors {
+		if err.ResolverError == resolvers.ErrSkipped {
+			return true
+		}
+	}
+	return false
+}
+
+func errorPayload(message string) json.RawMessage {
+	payload, _ := json.Marshal(map[string]string{"message": message})
+	return payload
+}
@@ -0,0 +1,268 @@
+package subscriptions
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/inspectr/backend/plugins/api/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin", nil, "", true},
+		{"same origin", nil, "https://api.example.com", true},
+		{"cross origin", nil, "https://evil.example.net", false},
+		{"allowed origin", []string{"https://app.example.com/"}, "https://app.example.com", true},
+		{"allowed origin case", []string{"https://App.example.com"}, "https://app.example.com", true},
+		{"origin not allowed", []string{"https://app.example.com"}, "https://api.example.com", false},
+		{"any origin", []string{"*"}, "https://evil.example.net", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &Handler{AllowedOrigins: test.allowed}
+			r := httptest.NewRequest("GET", "https://api.example.com/subscriptions", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+
+			if got := h.checkOrigin(r); got != test.want {
+				t.Errorf("checkOrigin(%q) = %v, want %v", test.origin, got, test.want)
+			}
+		})
+	}
+}
+
+// dial connects to a Handler serving the API schema to a client holding
+// claims, and returns its broker, the connection and a func closing both
+func dial(t *testing.T, claims utils.Claims) (*Broker, *websocket.Conn, func()) {
+	schema, err := ioutil.ReadFile("../schema.graphql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{
+		Schema:             graphql.MustParseSchema(string(schema), &resolvers.Resolver{}),
+		SubscriptionSchema: graphql.MustParseSchema(SubscriptionSchema(string(schema)), &resolvers.SubscriptionResolver{}),
+		Broker:             NewBroker(nil, DefaultChannel),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "jwt", claims)))
+	}))
+
+	dialer := websocket.Dialer{Subprotocols: []string{"graphql-ws"}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	send(t, ws, operationMessage{Type: connectionInit})
+	if message := receive(t, ws); message.Type != connectionAck {
+		t.Fatalf("got %s, want %s", message.Type, connectionAck)
+	}
+
+	return h.Broker, ws, func() {
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		ws.Close()
+		server.Close()
+	}
+}
+
+func send(t *testing.T, ws *websocket.Conn, message operationMessage) {
+	t.Helper()
+
+	if err := ws.WriteJSON(message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// subscribe starts the operation id and waits until it receives trails
+func subscribe(t *testing.T, ws *websocket.Conn, b *Broker, id string, payload string) {
+	t.Helper()
+
+	b.mu.RLock()
+	subscribers := len(b.subscribers)
+	b.mu.RUnlock()
+
+	send(t, ws, operationMessage{ID: id, Type: start, Payload: json.RawMessage(payload)})
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		b.mu.RLock()
+		subscribed := len(b.subscribers) > subscribers
+		b.mu.RUnlock()
+		if subscribed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("operation %s did not subscribe", id)
+		}
+	}
+}
+
+// receive returns the next message other than a keep alive
+func receive(t *testing.T, ws *websocket.Conn) operationMessage {
+	t.Helper()
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var message operationMessage
+		if err := ws.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Type != connectionKeepAlive {
+			return message
+		}
+	}
+}
+
+// receiveTrail returns the trail of the next data message of operation id
+func receiveTrail(t *testing.T, ws *websocket.Conn, id string) resolvers.Trail {
+	t.Helper()
+
+	message := receive(t, ws)
+	if message.ID != id || message.Type != data {
+		t.Fatalf("got %s %s %s, want data of %s", message.ID, message.Type, message.Payload, id)
+	}
+
+	var payload struct {
+		Data struct {
+			TrailCreated struct {
+				ID        string
+				ProjectId string
+				Event     string
+			}
+		}
+	}
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	trail := resolvers.Trail{Event: payload.Data.TrailCreated.Event}
+	trail.Model.ID = uuid.FromStringOrNil(payload.Data.TrailCreated.ID)
+	trail.ProjectId = uuid.FromStringOrNil(payload.Data.TrailCreated.ProjectId)
+	return trail
+}
+
+func newTrail(project uuid.UUID, event string) resolvers.Trail {
+	trail := resolvers.Trail{ProjectId: project, Event: event}
+	trail.Model.ID = uuid.NewV4()
+	return trail
+}
+
+func TestStartSelectsOperationWithVariables(t *testing.T) {
+	b, ws, disconnect := dial(t, utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeAdmin}})
+	defer disconnect()
+
+	subscribe(t, ws, b, "1", `{
+		"query": "subscription All { trailCreated { id event } } subscription Logins($events: [String!]) { trailCreated(filter: {event: $events}) { id event } }",
+		"operationName": "Logins",
+		"variables": {"events": ["login"]}
+	}`)
+
+	project := uuid.NewV4()
+	logout, login := newTrail(project, "logout"), newTrail(project, "login")
+	b.deliver(logout)
+	b.deliver(login)
+
+	// All would have sent the logout, Logins filters it out with $events
+	if trail := receiveTrail(t, ws, "1"); trail.Model.ID != login.Model.ID || trail.Event != "login" {
+		t.Errorf("received %s %s, want login %s", trail.Event, trail.Model.ID, login.Model.ID)
+	}
+}
+
+func TestSubscriptionSkipsOtherProjects(t *testing.T) {
+	readable, other := uuid.NewV4(), uuid.NewV4()
+
+	t.Run("claims", func(t *testing.T) {
+		b, ws, disconnect := dial(t, utils.Claims{UserId: "user", Roles: map[string][]string{readable.String(): {resolvers.RoleReader}}})
+		defer disconnect()
+
+		subscribe(t, ws, b, "1", `{"query": "subscription { trailCreated { id projectId } }"}`)
+
+		hidden, visible := newTrail(other, "login"), newTrail(readable, "login")
+		b.deliver(hidden)
+		b.deliver(visible)
+
+		if trail := receiveTrail(t, ws, "1"); trail.Model.ID != visible.Model.ID {
+			t.Errorf("received trail %s of project %s, want %s of %s", trail.Model.ID, trail.ProjectId, visible.Model.ID, readable)
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		b, ws, disconnect := dial(t, utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeAdmin}})
+		defer disconnect()
+
+		subscribe(t, ws, b, "1", `{
+			"query": "subscription ($projects: [ID!]) { trailCreated(filter: {projects: $projects}) { id projectId } }",
+			"variables": {"projects": ["`+readable.String()+`"]}
+		}`)
+
+		filtered, matched := newTrail(other, "login"), newTrail(readable, "login")
+		b.deliver(filtered)
+		b.deliver(matched)
+
+		if trail := receiveTrail(t, ws, "1"); trail.Model.ID != matched.Model.ID {
+			t.Errorf("received trail %s of project %s, want %s of %s", trail.Model.ID, trail.ProjectId, matched.Model.ID, readable)
+		}
+	})
+}
+
+func TestStartRejectsMalformedOperations(t *testing.T) {
+	_, ws, disconnect := dial(t, utils.Claims{UserId: "user", Permissions: []string{resolvers.ScopeAdmin}})
+	defer disconnect()
+
+	payloads := []string{
+		`1`,
+		`{"query": "subscription {"}`,
+		`{"query": "subscription { trailCreated { id } } }"}`,
+		`{"query": "subscription { unknownField { id } }"}`,
+		`{"query": "subscription A { trailCreated { id } } subscription B { trailCreated { id } }"}`,
+		`{"query": "subscription A { trailCreated { id } }", "operationName": "B"}`,
+	}
+
+	for i, payload := range payloads {
+		id := strconv.Itoa(i)
+		send(t, ws, operationMessage{ID: id, Type: start, Payload: json.RawMessage(payload)})
+
+		if message := receive(t, ws); message.ID != id || message.Type != errorMessage {
+			t.Fatalf("%s: got %s %s, want an error", payload, message.Type, message.Payload)
+		}
+	}
+
+	// variables that do not fit their types fail the operation
+	send(t, ws, operationMessage{ID: "variables", Type: start, Payload: json.RawMessage(`{
+		"query": "subscription ($events: [String!]) { trailCreated(filter: {event: $events}) { id } }",
+		"variables": {"events": 1}
+	}`)})
+
+	message := receive(t, ws)
+	var response struct {
+		Errors []json.RawMessage
+	}
+	if err := json.Unmarshal(message.Payload, &response); err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != data || len(response.Errors) == 0 {
+		t.Errorf("got %s %s, want data with errors", message.Type, message.Payload)
+	}
+	if message := receive(t, ws); message.ID != "variables" || message.Type != complete {
+		t.Errorf("got %s %s, want complete", message.ID, message.Type)
+	}
+}
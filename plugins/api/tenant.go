@@ -0,0 +1,102 @@
+package inspectr
+
+import (
+	"fmt"
+
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+)
+
+// CreateTenant creates the organization/project tenant
+func (x *API) CreateTenant(tenant string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	project, err := resolvers.CreateProject(db, tenant)
+	if err != nil {
+		return err
+	}
+
+	log.InfoWithFields("tenant created", log.Fields{
+		"tenant":  project.Tenant(),
+		"project": project.ID.String(),
+	})
+
+	return nil
+}
+
+// GrantRole grants the user with email role in tenant
+func (x *API) GrantRole(email string, tenant string, role string) error {
+	if !transistor.SliceContains(role, resolvers.Roles) {
+		return fmt.Errorf("role must be one of %v", resolvers.Roles)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	user := resolvers.User{}
+	if db.Where("email = ?", email).Find(&user).RecordNotFound() {
+		return fmt.Errorf("user %q not found", email)
+	}
+
+	project, err := resolvers.FindProject(db, tenant)
+	if err != nil {
+		return err
+	}
+
+	projectRole := resolvers.ProjectRole{
+		UserId:    user.ID,
+		ProjectId: project.ID,
+		Value:     role,
+	}
+	if err := db.Where(projectRole).FirstOrCreate(&projectRole).Error; err != nil {
+		return err
+	}
+
+	log.InfoWithFields("role granted", log.Fields{
+		"email":  email,
+		"tenant": project.Tenant(),
+		"role":   role,
+	})
+
+	return nil
+}
+
+// RevokeRole revokes role in tenant from the user with email
+func (x *API) RevokeRole(email string, tenant string, role string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	user := resolvers.User{}
+	if db.Where("email = ?", email).Find(&user).RecordNotFound() {
+		return fmt.Errorf("user %q not found", email)
+	}
+
+	project, err := resolvers.FindProject(db, tenant)
+	if err != nil {
+		return err
+	}
+
+	err = db.Where("user_id = ? AND project_id = ? AND value = ?", user.ID, project.ID, role).Delete(&resolvers.ProjectRole{}).Error
+	if err != nil {
+		return err
+	}
+
+	log.InfoWithFields("role revoked", log.Fields{
+		"email":  email,
+		"tenant": project.Tenant(),
+		"role":   role,
+	})
+
+	return nil
+}
@@ -2,16 +2,20 @@ package utils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	log "github.com/codeamp/logger"
 	"github.com/codeamp/transistor"
 	oidc "github.com/coreos/go-oidc"
 	"github.com/go-redis/redis"
+	"github.com/inspectr/backend/plugins"
 	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
 	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
@@ -25,7 +29,61 @@ type Claims struct {
 	Verified    bool     `json:"email_verified"`
 	Groups      []string `json:"groups"`
 	Permissions []string `json:"permissions"`
-	TokenError  string   `json:"tokenError"`
+	// Roles maps project IDs to the roles the user holds in them
+	Roles      map[string][]string `json:"roles"`
+	TokenError string              `json:"tokenError"`
+}
+
+// Err reports why the claims could not be verified
+func (c Claims) Err() error {
+	if c.TokenError != "" {
+		return errors.New(c.TokenError)
+	}
+
+	if c.UserId == "" {
+		return errors.New("invalid access token")
+	}
+
+	return nil
+}
+
+// UserID
+func (c Claims) UserID() string {
+	return c.UserId
+}
+
+// Scopes returns the user's permissions
+func (c Claims) Scopes() []string {
+	if c.Err() != nil {
+		return []string{}
+	}
+	return c.Permissions
+}
+
+// IsAdmin reports whether the user may access every project
+func (c Claims) IsAdmin() bool {
+	return c.Err() == nil && transistor.SliceContains(resolvers.ScopeAdmin, c.Permissions)
+}
+
+// ProjectIDs returns the projects the user holds one of roles in
+func (c Claims) ProjectIDs(roles ...string) []string {
+	var projects []string
+
+	if c.Err() != nil {
+		return projects
+	}
+
+	for project, granted := range c.Roles {
+		for _, role := range roles {
+			if transistor.SliceContains(role, granted) {
+				projects = append(projects, project)
+				break
+			}
+		}
+	}
+
+	sort.Strings(projects)
+	return projects
 }
 
 type Cache struct {
@@ -45,91 +103,206 @@ func CheckPasswordHash(password, hash string) bool {
 
 func AuthMiddleware(next http.Handler, db *gorm.DB, redisClient *redis.Client) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		claims := Claims{}
-		authString := r.Header.Get("Authorization")
-		ctx := context.Context(context.Background())
-
-		if len(authString) < 8 {
-			claims.TokenError = "invalid access token"
-			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "jwt", claims)))
-			return
+		claims, challenge := ParseClaims(r.Header.Get("Authorization"), db, redisClient)
+		if challenge {
+			w.Header().Set("Www-Authenticate", "Bearer token_type=\"JWT\"")
 		}
 
-		bearerToken := authString[7:len(authString)]
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "jwt", claims)))
+	})
+}
+
+// ParseClaims verifies the bearer token in authString and loads the user's
+// permissions. Failures are reported through Claims.TokenError; challenge is
+// true when the client should be asked for a new token.
+func ParseClaims(authString string, db *gorm.DB, redisClient *redis.Client) (claims Claims, challenge bool) {
+	ctx := context.Context(context.Background())
+
+	if len(authString) < 8 {
+		claims.TokenError = "invalid access token"
+		return claims, false
+	}
+
+	bearerToken := authString[7:len(authString)]
+
+	// Initialize a provider by specifying dex's issuer URL.
+	provider, err := oidc.NewProvider(ctx, viper.GetString("plugins.api.oidc_uri"))
+	if err != nil {
+		claims.TokenError = err.Error()
+		return claims, false
+	}
+
+	// Create an ID token parser, but only trust ID tokens issued to "example-app"
+	idTokenVerifier := provider.Verifier(&oidc.Config{ClientID: viper.GetString("plugins.api.oidc_client_id")})
+
+	idToken, err := idTokenVerifier.Verify(ctx, bearerToken)
+	if err != nil {
+		claims.TokenError = fmt.Sprintf("could not verify bearer token: %v", err.Error())
+		return claims, true
+	}
+
+	if err := idToken.Claims(&claims); err != nil {
+		claims.TokenError = fmt.Sprintf("failed to parse claims: %v", err.Error())
+		return claims, true
+	}
 
-		// Initialize a provider by specifying dex's issuer URL.
-		provider, err := oidc.NewProvider(ctx, viper.GetString("plugins.api.oidc_uri"))
+	if !claims.Verified {
+		claims.TokenError = fmt.Sprintf("email (%q) in returned claims was not verified", claims.Email)
+		return claims, true
+	}
+
+	cacheKey := fmt.Sprintf("%s_%s", idToken.Nonce, claims.Email)
+	c, err := redisClient.Get(cacheKey).Result()
+	if err == redis.Nil {
+		user := resolvers.User{}
+		if db.Where("email = ?", claims.Email).Find(&user).RecordNotFound() {
+			user.Email = claims.Email
+			db.Create(&user)
+		}
+
+		if user.DeactivatedAt != nil {
+			claims.TokenError = fmt.Sprintf("user %q is deactivated", claims.Email)
+			return claims, false
+		}
+
+		db.Model(&user).Association("Permissions").Find(&user.Permissions)
+
+		var permissions []string
+		for _, permission := range user.Permissions {
+			permissions = append(permissions, permission.Value)
+		}
+
+		// Add user scope
+		permissions = append(permissions, fmt.Sprintf("user/%s", user.ID.String()))
+		claims.UserId = user.ID.String()
+		claims.Permissions = permissions
+		claims.Roles = ProjectRoles(db, user.ID)
+
+		serializedClaims, err := json.Marshal(claims)
 		if err != nil {
-			claims.TokenError = err.Error()
-			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "jwt", claims)))
-			return
+			log.Panic(err)
 		}
 
-		// Create an ID token parser, but only trust ID tokens issued to "example-app"
-		idTokenVerifier := provider.Verifier(&oidc.Config{ClientID: viper.GetString("plugins.api.oidc_client_id")})
+		err = redisClient.Set(cacheKey, serializedClaims, 24*time.Hour).Err()
+		if err != nil {
+			log.Panic(err)
+		}
 
-		idToken, err := idTokenVerifier.Verify(ctx, bearerToken)
+		// index the key so InvalidateClaims finds it
+		index := resolvers.ClaimsIndexKey(claims.UserId)
+		if err := redisClient.SAdd(index, cacheKey).Err(); err != nil {
+			log.Error(err)
+		}
+		redisClient.Expire(index, 24*time.Hour)
+	} else if err != nil {
+		log.Panic(err)
+	} else {
+		err := json.Unmarshal([]byte(c), &claims)
 		if err != nil {
-			claims.TokenError = fmt.Sprintf("could not verify bearer token: %v", err.Error())
-			w.Header().Set("Www-Authenticate", "Bearer token_type=\"JWT\"")
-			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "jwt", claims)))
-			return
+			log.Panic(err)
 		}
+	}
 
-		if err := idToken.Claims(&claims); err != nil {
-			claims.TokenError = fmt.Sprintf("failed to parse claims: %v", err.Error())
-			w.Header().Set("Www-Authenticate", "Bearer token_type=\"JWT\"")
-			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "jwt", claims)))
-			return
+	return claims, false
+}
+
+// ProjectRoles returns the roles user holds by project ID
+func ProjectRoles(db *gorm.DB, user uuid.UUID) map[string][]string {
+	var projectRoles []resolvers.ProjectRole
+	db.Where("user_id = ?", user).Find(&projectRoles)
+
+	roles := make(map[string][]string)
+	for _, role := range projectRoles {
+		project := role.ProjectId.String()
+		roles[project] = append(roles[project], role.Value)
+	}
+
+	return roles
+}
+
+// ParseProducer reads producer credentials from the X-API-Key and
+// X-API-Secret headers or from basic auth
+func ParseProducer(r *http.Request) plugins.User {
+	producer := plugins.User{
+		APIKey:    r.Header.Get("X-API-Key"),
+		APISecret: r.Header.Get("X-API-Secret"),
+	}
+
+	if producer.APIKey == "" {
+		if key, secret, ok := r.BasicAuth(); ok {
+			producer.APIKey = key
+			producer.APISecret = secret
 		}
+	}
 
-		if !claims.Verified {
-			claims.TokenError = fmt.Sprintf("email (%q) in returned claims was not verified", claims.Email)
-			w.Header().Set("Www-Authenticate", "Bearer token_type=\"JWT\"")
-			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "jwt", claims)))
-			return
+	return producer
+}
+
+// AuthenticateProducer returns the user owning the producer's API key.
+// Verified credentials are cached since bcrypt is deliberately slow.
+func AuthenticateProducer(producer plugins.User, db *gorm.DB, redisClient *redis.Client) (resolvers.User, error) {
+	user := resolvers.User{}
+
+	if producer.APIKey == "" || producer.APISecret == "" {
+		return user, errors.New("missing API key or secret")
+	}
+
+	digest := sha256.Sum256([]byte(producer.APIKey + ":" + producer.APISecret))
+	cacheKey := fmt.Sprintf("apikey_%s", hex.EncodeToString(digest[:]))
+
+	c, err := redisClient.Get(cacheKey).Result()
+	if err == nil {
+		if db.Where("id = ? AND api_key = ? AND deactivated_at IS NULL", c, producer.APIKey).Find(&user).RecordNotFound() {
+			redisClient.Del(cacheKey)
+			return user, errors.New("invalid API key or secret")
 		}
+		return user, nil
+	} else if err != redis.Nil {
+		return user, err
+	}
 
-		c, err := redisClient.Get(fmt.Sprintf("%s_%s", idToken.Nonce, claims.Email)).Result()
-		if err == redis.Nil {
-			user := resolvers.User{}
-			if db.Where("email = ?", claims.Email).Find(&user).RecordNotFound() {
-				user.Email = claims.Email
-				db.Create(&user)
-			}
+	if db.Where("api_key = ? AND deactivated_at IS NULL", producer.APIKey).Find(&user).RecordNotFound() {
+		return user, errors.New("invalid API key or secret")
+	}
 
-			db.Model(&user).Association("Permissions").Find(&user.Permissions)
+	if !CheckPasswordHash(producer.APISecret, user.APISecret) {
+		return user, errors.New("invalid API key or secret")
+	}
 
-			var permissions []string
-			for _, permission := range user.Permissions {
-				permissions = append(permissions, permission.Value)
-			}
+	if err := redisClient.Set(cacheKey, user.ID.String(), 5*time.Minute).Err(); err != nil {
+		log.Error(err)
+	}
 
-			// Add user scope
-			permissions = append(permissions, fmt.Sprintf("user/%s", user.ID.String()))
-			claims.UserId = user.ID.String()
-			claims.Permissions = permissions
+	return user, nil
+}
 
-			serializedClaims, err := json.Marshal(claims)
-			if err != nil {
-				log.Panic(err)
-			}
+// ProducerProject returns the project producer writes into, identified by
+// tenant or, when tenant is empty, the only project producer may write into
+func ProducerProject(producer resolvers.User, tenant string, db *gorm.DB) (resolvers.Project, error) {
+	claims := Claims{
+		UserId: producer.ID.String(),
+		Roles:  ProjectRoles(db, producer.ID),
+	}
+	writable := claims.ProjectIDs(resolvers.RoleProducer, resolvers.RoleAdmin)
 
-			err = redisClient.Set(fmt.Sprintf("%s_%s", idToken.Nonce, claims.Email), serializedClaims, 24*time.Hour).Err()
-			if err != nil {
-				log.Panic(err)
-			}
-		} else if err != nil {
-			log.Panic(err)
-		} else {
-			err := json.Unmarshal([]byte(c), &claims)
-			if err != nil {
-				log.Panic(err)
-			}
+	if tenant == "" {
+		if len(writable) != 1 {
+			return resolvers.Project{}, errors.New("tenant is required")
 		}
+		tenant = writable[0]
+	}
 
-		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "jwt", claims)))
-	})
+	project, err := resolvers.FindProject(db, tenant)
+	if err != nil {
+		return project, err
+	}
+
+	if !transistor.SliceContains(project.ID.String(), writable) {
+		// do not reveal projects the producer can not write into
+		return resolvers.Project{}, fmt.Errorf("tenant %q not found", tenant)
+	}
+
+	return project, nil
 }
 
 func CorsMiddleware(next http.Handler) http.Handler {
@@ -137,7 +310,7 @@ func CorsMiddleware(next http.Handler) http.Handler {
 		// allow cross domain AJAX requests
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Expose-Headers", "Cache-Control, Content-Language, Content-Type, Expires, Last-Modified, Pragma, WWW-Authenticate")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization, X-API-Key, X-API-Secret, X-Tenant")
 		if r.Method == "OPTIONS" {
 			//handle preflight in here
 		} else {
@@ -146,27 +319,28 @@ func CorsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// CheckAuth returns the user ID of the claims in ctx when they hold one of
+// scopes, any scope when scopes is empty
 func CheckAuth(ctx context.Context, scopes []string) (string, error) {
-	claims := ctx.Value("jwt").(Claims)
+	claims, ok := ctx.Value("jwt").(Claims)
+	if !ok {
+		return "", errors.New("authentication required")
+	}
 
-	if claims.UserId == "" {
-		return "", errors.New(claims.TokenError)
+	if err := claims.Err(); err != nil {
+		return "", err
 	}
 
-	if transistor.SliceContains("admin1", claims.Permissions) {
+	if claims.IsAdmin() || len(scopes) == 0 {
 		return claims.UserId, nil
 	}
 
-	if len(scopes) == 0 {
-		return claims.UserId, nil
-	} else {
-		for _, scope := range scopes {
-			if transistor.SliceContains(scope, claims.Permissions) {
-				return claims.UserId, nil
-			}
+	granted := resolvers.ViewerScopes(claims)
+	for _, scope := range scopes {
+		if transistor.SliceContains(scope, granted) {
+			return claims.UserId, nil
 		}
-		return "", errors.New("you dont have permission to access this resource")
 	}
 
-	return claims.UserId, nil
+	return "", errors.New("you dont have permission to access this resource")
 }
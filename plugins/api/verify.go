@@ -0,0 +1,63 @@
+package inspectr
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/codeamp/logger"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+)
+
+// Verify checks the hash chain of each project over the trails created
+// between from and to, either of which may be nil
+func (x *API) Verify(from *time.Time, to *time.Time) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.LogMode(false)
+
+	var projects []resolvers.Project
+	if err := db.Preload("Organization").Find(&projects).Error; err != nil {
+		return err
+	}
+
+	broken := 0
+	for _, project := range projects {
+		verification, err := resolvers.VerifyChain(db, project.ID, from, to)
+		if err != nil {
+			return err
+		}
+
+		fields := log.Fields{
+			"tenant":       project.Tenant(),
+			"chain":        verification.Chain,
+			"checked":      verification.Checked,
+			"archived":     verification.Archived,
+			"fromSequence": verification.FromSequence,
+			"toSequence":   verification.ToSequence,
+		}
+
+		if verification.Break != nil {
+			broken++
+
+			fields["sequence"] = verification.Break.Sequence
+			fields["reason"] = verification.Break.Reason
+			if verification.Break.TrailID != nil {
+				fields["trailId"] = verification.Break.TrailID.String()
+			}
+			log.ErrorWithFields("hash chain is broken", fields)
+			continue
+		}
+
+		log.InfoWithFields("hash chain is intact", fields)
+	}
+
+	if broken > 0 {
+		return fmt.Errorf("%d of %d hash chains are broken", broken, len(projects))
+	}
+
+	return nil
+}
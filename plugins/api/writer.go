@@ -0,0 +1,362 @@
+package inspectr
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/codeamp/logger"
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultWriterBatchSize most trails stored at once
+	DefaultWriterBatchSize = 500
+	// DefaultWriterFlushInterval longest time a trail waits for its batch
+	DefaultWriterFlushInterval = 50 * time.Millisecond
+)
+
+// ErrWriterClosed is reported for trails written after the TrailWriter was
+// closed
+var ErrWriterClosed = errors.New("trail writer is closed")
+
+// writerVarsOperation operation VarsHandler is authorized as, only admins
+// may read the writer's counters
+const writerVarsOperation = "trailWriterVars"
+
+// loadWriterSettings returns the batch size and flush interval of the
+// plugin's trail writer
+func loadWriterSettings() (int, time.Duration, error) {
+	batchSize := DefaultWriterBatchSize
+	if viper.IsSet("plugins.api.writer.batch_size") {
+		batchSize = viper.GetInt("plugins.api.writer.batch_size")
+	}
+	if batchSize < 1 {
+		return 0, 0, fmt.Errorf("plugins.api.writer.batch_size must be positive")
+	}
+
+	flushInterval := DefaultWriterFlushInterval
+	if value := viper.GetString("plugins.api.writer.flush_interval"); value != "" {
+		var err error
+		flushInterval, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("plugins.api.writer.flush_interval: %v", err)
+		}
+	}
+	if flushInterval <= 0 {
+		return 0, 0, fmt.Errorf("plugins.api.writer.flush_interval must be positive")
+	}
+
+	return batchSize, flushInterval, nil
+}
+
+// loadDedupeWindow returns the time the idempotency keys of stored trails
+// are kept
+func loadDedupeWindow() (time.Duration, error) {
+	value := viper.GetString("plugins.api.dedupe_window")
+	if value == "" {
+		return resolvers.DefaultDedupeWindow, nil
+	}
+
+	window, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("plugins.api.dedupe_window: %v", err)
+	}
+	if window <= 0 {
+		return 0, fmt.Errorf("plugins.api.dedupe_window must be positive")
+	}
+
+	return window, nil
+}
+
+// pendingTrail trail waiting in a TrailWriter's queue
+type pendingTrail struct {
+	trail *resolvers.Trail
+	done  func(resolvers.Trail, error)
+}
+
+// WriterStats counters of a TrailWriter since it was created
+type WriterStats struct {
+	// Trails stored
+	Trails int64
+	// Failed trails that could not be stored
+	Failed int64
+	// Duplicates trails skipped because their idempotency key was used
+	Duplicates int64
+	// Batches flushed
+	Batches int64
+	// Retried batches whose trails were stored one by one after the batch
+	// failed
+	Retried int64
+	// Flushing time spent storing batches
+	Flushing time.Duration
+}
+
+// TrailWriter stores trails in batches, flushed once full or a flush
+// interval after their first trail arrived. A batch is
+// stored in one transaction with multi-row inserts; when that fails its
+// trails are stored one at a time, so a bad trail fails alone. Trails whose
+// idempotency key was used within the dedupe window are skipped and
+// reported with resolvers.ErrDuplicateTrail. Counters are kept in an
+// expvar.Map.
+type TrailWriter struct {
+	db            *gorm.DB
+	batchSize     int
+	flushInterval time.Duration
+	dedupeWindow  time.Duration
+	vars          *expvar.Map
+	queue         chan pendingTrail
+	stopped       chan struct{}
+	// mu guards closed, Write holds it for reading while it queues
+	mu     sync.RWMutex
+	closed bool
+	// reporting counts the batches whose trails are being reported
+	reporting sync.WaitGroup
+}
+
+// NewTrailWriter returns a writer storing trails into db in the background
+// until Close is called. Idempotency keys are kept for dedupeWindow, not at
+// all when it is zero. Its counters are added to vars.
+func NewTrailWriter(db *gorm.DB, batchSize int, flushInterval time.Duration, dedupeWindow time.Duration, vars *expvar.Map) *TrailWriter {
+	w := &TrailWriter{
+		db:            db,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		dedupeWindow:  dedupeWindow,
+		vars:          vars,
+		queue:         make(chan pendingTrail, 4*batchSize),
+		stopped:       make(chan struct{}),
+	}
+
+	for _, name := range []string{"trails", "failed", "duplicates", "batches", "retried", "lastBatchSize"} {
+		vars.Set(name, new(expvar.Int))
+	}
+	vars.Set("flushSeconds", new(expvar.Float))
+	vars.Set("queued", expvar.Func(func() interface{} {
+		return len(w.queue)
+	}))
+
+	go w.run()
+
+	return w
+}
+
+// Write queues trail to be stored with the next batch, blocking while the
+// queue is full. Once the batch is flushed done is called with the stored
+// trail, or the error that kept it from being stored, from a goroutine
+// reporting the trails of the batch in order. Once the writer is closed
+// done is called with ErrWriterClosed instead.
+func (w *TrailWriter) Write(trail resolvers.Trail, done func(resolvers.Trail, error)) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		if done != nil {
+			go done(trail, ErrWriterClosed)
+		}
+		return
+	}
+
+	w.queue <- pendingTrail{trail: &trail, done: done}
+}
+
+// Close flushes the queued trails, waits until every trail was reported
+// and stops the writer. Trails written afterwards are not stored.
+func (w *TrailWriter) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	<-w.stopped
+	w.reporting.Wait()
+}
+
+// Stats returns the writer's counters
+func (w *TrailWriter) Stats() WriterStats {
+	return WriterStats{
+		Trails:     w.vars.Get("trails").(*expvar.Int).Value(),
+		Failed:     w.vars.Get("failed").(*expvar.Int).Value(),
+		Duplicates: w.vars.Get("duplicates").(*expvar.Int).Value(),
+		Batches:    w.vars.Get("batches").(*expvar.Int).Value(),
+		Retried:    w.vars.Get("retried").(*expvar.Int).Value(),
+		Flushing:   time.Duration(w.vars.Get("flushSeconds").(*expvar.Float).Value() * float64(time.Second)),
+	}
+}
+
+func (w *TrailWriter) run() {
+	defer close(w.stopped)
+
+	batch := make([]pendingTrail, 0, w.batchSize)
+	timer := time.NewTimer(w.flushInterval)
+	timer.Stop()
+
+	for {
+		select {
+		case pending, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+
+			batch = append(batch, pending)
+			if len(batch) == 1 {
+				timer.Reset(w.flushInterval)
+			}
+			if len(batch) < w.batchSize {
+				continue
+			}
+
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+
+		w.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush stores batch and reports the outcome of each trail
+func (w *TrailWriter) flush(batch []pendingTrail) {
+	if len(batch) == 0 {
+		return
+	}
+
+	started := time.Now()
+
+	trails := make([]*resolvers.Trail, len(batch))
+	for i, pending := range batch {
+		trails[i] = pending.trail
+	}
+
+	errs := make([]error, len(batch))
+	duplicates, err := resolvers.CreateTrails(w.db, trails, w.dedupeWindow)
+	if err != nil {
+		log.WarnWithFields("storing trail batch failed, storing its trails one by one", log.Fields{
+			"trails": len(batch),
+			"error":  err.Error(),
+		})
+		w.vars.Add("retried", 1)
+
+		for i, trail := range trails {
+			errs[i] = resolvers.CreateTrail(w.db, trail, w.dedupeWindow)
+		}
+	} else {
+		for i, trail := range trails {
+			if duplicates[trail] {
+				errs[i] = resolvers.ErrDuplicateTrail
+			}
+		}
+	}
+
+	var failed, skipped int64
+	for _, err := range errs {
+		switch err {
+		case nil:
+		case resolvers.ErrDuplicateTrail:
+			skipped++
+		default:
+			failed++
+		}
+	}
+
+	w.vars.Add("trails", int64(len(batch))-failed-skipped)
+	w.vars.Add("failed", failed)
+	w.vars.Add("duplicates", skipped)
+	w.vars.Add("batches", 1)
+	w.vars.Get("lastBatchSize").(*expvar.Int).Set(int64(len(batch)))
+	w.vars.AddFloat("flushSeconds", time.Since(started).Seconds())
+
+	// batch is reused by run
+	reported := append([]pendingTrail(nil), batch...)
+	w.reporting.Add(1)
+	go func() {
+		defer w.reporting.Done()
+		for i, pending := range reported {
+			if pending.done != nil {
+				pending.done(*pending.trail, errs[i])
+			}
+		}
+	}()
+}
+
+// VarsHandler serves the trail writer's counters to admins, in the format of
+// expvar's /debug/vars
+func (x *API) VarsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := resolvers.Authorize(r.Context(), writerVarsOperation); err != nil {
+			status := http.StatusForbidden
+			if authErr, ok := err.(*resolvers.AuthError); ok && authErr.Code == resolvers.CodeUnauthenticated {
+				w.Header().Set("Www-Authenticate", "Bearer token_type=\"JWT\"")
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if x.Writer == nil {
+			fmt.Fprintln(w, "{}")
+			return
+		}
+		fmt.Fprintf(w, "{\"trailWriter\": %s}\n", x.Writer.vars.String())
+	})
+}
+
+// LogWriterStats logs the throughput and batch sizes of the trail writer
+// since the last call
+func (x *API) LogWriterStats() {
+	if x.Writer == nil {
+		return
+	}
+
+	now := time.Now()
+	stats := x.Writer.Stats()
+	last := x.writerStats
+	elapsed := now.Sub(x.writerStatsAt)
+	x.writerStats, x.writerStatsAt = stats, now
+
+	batches := stats.Batches - last.Batches
+	if batches == 0 || elapsed <= 0 {
+		return
+	}
+
+	trails := stats.Trails - last.Trails
+	failed := stats.Failed - last.Failed
+	duplicates := stats.Duplicates - last.Duplicates
+	log.InfoWithFields("trail writer", log.Fields{
+		"trails":        trails,
+		"failed":        failed,
+		"duplicates":    duplicates,
+		"batches":       batches,
+		"retried":       stats.Retried - last.Retried,
+		"perSecond":     fmt.Sprintf("%.1f", float64(trails)/elapsed.Seconds()),
+		"meanBatchSize": fmt.Sprintf("%.1f", float64(trails+failed+duplicates)/float64(batches)),
+		"meanFlush":     ((stats.Flushing - last.Flushing) / time.Duration(batches)).String(),
+	})
+}
+
+// PruneTrailKeys deletes the idempotency keys that outlived the dedupe
+// window
+func (x *API) PruneTrailKeys() {
+	pruned, err := resolvers.PruneTrailKeys(x.DB, time.Now())
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if pruned > 0 {
+		log.InfoWithFields("pruned expired trail keys", log.Fields{
+			"keys": pruned,
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package inspectr
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	resolvers "github.com/inspectr/backend/plugins/api/resolvers"
+	"github.com/inspectr/backend/plugins/api/utils"
+	"github.com/jinzhu/gorm"
+	"github.com/jinzhu/gorm/dialects/postgres"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+)
+
+// testDatabase names the environment variable holding the libpq connection
+// string, without dbname, of a PostgreSQL server tests may create
+// databases on, such as "host=localhost user=postgres sslmode=disable"
+const testDatabase = "INSPECTR_TEST_DATABASE"
+
+// testDB creates and migrates a throwaway database on the server of
+// INSPECTR_TEST_DATABASE, and returns it with a func dropping it. tb is
+// skipped when the variable is not set.
+func testDB(tb testing.TB) (*gorm.DB, func()) {
+	server := os.Getenv(testDatabase)
+	if server == "" {
+		tb.Skipf("%s is not set", testDatabase)
+	}
+
+	admin, err := gorm.Open("postgres", server+" dbname=postgres")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	admin.LogMode(false)
+
+	name := fmt.Sprintf("inspectr_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE DATABASE " + name).Error; err != nil {
+		admin.Close()
+		tb.Fatal(err)
+	}
+
+	drop := func() {
+		if err := admin.Exec("DROP DATABASE IF EXISTS " + name).Error; err != nil {
+			tb.Error(err)
+		}
+		admin.Close()
+	}
+
+	// openDB and Migrate read the connection from the config
+	viper.Set("plugins.api.postgres.port", "5432")
+	viper.Set("plugins.api.postgres.sslmode", "disable")
+	for _, field := range strings.Fields(server) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) == 2 {
+			viper.Set("plugins.api.postgres."+parts[0], parts[1])
+		}
+	}
+	viper.Set("plugins.api.postgres.dbname", name)
+
+	(&API{}).Migrate()
+
+	db, err := openDB()
+	if err != nil {
+		drop()
+		tb.Fatal(err)
+	}
+	db.LogMode(false)
+
+	return db, func() {
+		db.Close()
+		drop()
+	}
+}
+
+// benchProject creates the project benchmark trails are stored into
+func benchProject(b *testing.B, db *gorm.DB) uuid.UUID {
+	project, err := resolvers.CreateProject(db, "bench/bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return project.ID
+}
+
+// benchTrail returns the i-th trail stored by a benchmark
+func benchTrail(project uuid.UUID, i int) resolvers.Trail {
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"index": i,
+		"ip":    fmt.Sprintf("10.0.%d.%d", i/256%256, i%256),
+	})
+
+	return resolvers.Trail{
+		ProjectId:      project,
+		Timestamp:      time.Now().Unix(),
+		Event:          "bench.write",
+		EventMetadata:  postgres.Jsonb{RawMessage: json.RawMessage(`{}`)},
+		Actor:          fmt.Sprintf("bench-%d", i%100),
+		ActorMetadata:  postgres.Jsonb{RawMessage: metadata},
+		Target:         "inspectr",
+		TargetMetadata: postgres.Jsonb{RawMessage: json.RawMessage(`{}`)},
+		Origin:         "inspectr/bench",
+		OriginMetadata: postgres.Jsonb{RawMessage: json.RawMessage(`{}`)},
+	}
+}
+
+// BenchmarkCreateTrail stores trails the way trail:create events were
+// stored before the TrailWriter, one transaction each
+func BenchmarkCreateTrail(b *testing.B) {
+	db, drop := testDB(b)
+	defer drop()
+
+	project := benchProject(b, db)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trail := benchTrail(project, i)
+		if err := resolvers.CreateTrail(db, &trail, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTrailWriter stores trails through a TrailWriter with the default
+// batch size and flush interval
+func BenchmarkTrailWriter(b *testing.B) {
+	db, drop := testDB(b)
+	defer drop()
+
+	project := benchProject(b, db)
+	writer := NewTrailWriter(db, DefaultWriterBatchSize, DefaultWriterFlushInterval, 0, new(expvar.Map).Init())
+
+	var mu sync.Mutex
+	var firstErr error
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		writer.Write(benchTrail(project, i), func(trail resolvers.Trail, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		})
+	}
+	writer.Close()
+	b.StopTimer()
+
+	if firstErr != nil {
+		b.Fatal(firstErr)
+	}
+
+	stats := writer.Stats()
+	if stats.Batches > 0 {
+		b.ReportMetric(float64(stats.Trails)/float64(stats.Batches), "trails/batch")
+	}
+}
+
+func TestVarsHandler(t *testing.T) {
+	x := &API{Writer: NewTrailWriter(nil, 1, time.Second, 0, new(expvar.Map).Init())}
+	defer x.Writer.Close()
+
+	if expvar.Get("trailWriter") != nil {
+		t.Error("trailWriter is published on expvar's /debug/vars")
+	}
+
+	tests := []struct {
+		name   string
+		claims interface{}
+		status int
+	}{
+		{"no claims", nil, http.StatusUnauthorized},
+		{"invalid token", utils.Claims{TokenError: "invalid access token"}, http.StatusUnauthorized},
+		{"not an admin", utils.Claims{UserId: "reader", Permissions: []string{resolvers.ScopeTrailsRead}}, http.StatusForbidden},
+		{"admin", utils.Claims{UserId: "admin", Permissions: []string{resolvers.ScopeAdmin}}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		if test.claims != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "jwt", test.claims))
+		}
+		w := httptest.NewRecorder()
+		x.VarsHandler().ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var vars struct {
+			TrailWriter map[string]float64 `json:"trailWriter"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+			t.Fatalf("%s: %v in %s", test.name, err, w.Body.String())
+		}
+		if _, ok := vars.TrailWriter["batches"]; !ok {
+			t.Errorf("%s: no batches counter in %s", test.name, w.Body.String())
+		}
+	}
+}
+
+// unreachableDB returns a database nothing listens on, its statements fail
+func unreachableDB(t *testing.T) *gorm.DB {
+	sqlDB, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gorm pings the *sql.DB it is given, wrapped it is used as is
+	db, err := gorm.Open("postgres", struct{ *sql.DB }{sqlDB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.LogMode(false)
+	return db
+}
+
+func TestTrailWriterWriteRacesClose(t *testing.T) {
+	writer := NewTrailWriter(unreachableDB(t), 2, time.Millisecond, 0, new(expvar.Map).Init())
+	project := uuid.NewV4()
+
+	// every trail is reported once, whether Write or Close won
+	const writes = 100
+	var reported sync.WaitGroup
+	reported.Add(writes)
+	for i := 0; i < writes; i++ {
+		go func(i int) {
+			writer.Write(benchTrail(project, i), func(trail resolvers.Trail, err error) {
+				if err == nil {
+					t.Errorf("trail %d stored into an unreachable database", i)
+				}
+				reported.Done()
+			})
+		}(i)
+	}
+	writer.Close()
+	reported.Wait()
+
+	closed := make(chan error, 1)
+	writer.Write(benchTrail(project, writes), func(trail resolvers.Trail, err error) {
+		closed <- err
+	})
+	if err := <-closed; err != ErrWriterClosed {
+		t.Errorf("write after close reported %v, want %v", err, ErrWriterClosed)
+	}
+
+	writer.Close()
+}
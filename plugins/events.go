@@ -1,6 +1,8 @@
 package plugins
 
 import (
+	"encoding/json"
+
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -15,6 +17,9 @@ type HeartBeat struct {
 }
 
 type Trail struct {
+	// ID optional producer-supplied idempotency key, deliveries of a trail
+	// with the same ID are stored once
+	ID string `json:"id"`
 	// Timestamp
 	Timestamp int64 `json:"timestamp"`
 	// Event
@@ -34,6 +39,90 @@ type Trail struct {
 	// OriginMetadata
 	OriginMetadata interface{} `json:"originMetadata"`
 
-	// MessageID
+	// MessageID receipt handle of SQS messages. The fields below describe
+	// the message the trail was received in, producer bodies are parsed
+	// with ParseTrail so they can not set them.
+	MessageID string
+	// Tenant project ID or organization/project slug, taken from the
+	// producer's credentials or SQS message attributes
+	Tenant string
+	// Producer email of the user producing over HTTP, or the producer
+	// attribute of SQS messages
+	Producer string
+	// Source sqs or http
+	Source string
+	// SourceID ID of the message at its source, such as the SQS message ID
+	SourceID string
+	// Attempts times the message was received
+	Attempts int
+	// MaxAttempts times the source delivers the message before its trail
+	// is quarantined, 0 when it is not delivered again
+	MaxAttempts int
+}
+
+// Body returns the trail as producers send it
+func (t Trail) Body() ([]byte, error) {
+	return json.Marshal(TrailBody{t.ID, t.Timestamp, t.Event, t.EventMetadata, t.Actor, t.ActorMetadata, t.Target, t.TargetMetadata, t.Origin, t.OriginMetadata})
+}
+
+// TrailBody is a trail as producers send it, without the fields describing
+// the message it was received in
+type TrailBody struct {
+	ID             string      `json:"id,omitempty"`
+	Timestamp      int64       `json:"timestamp"`
+	Event          string      `json:"event"`
+	EventMetadata  interface{} `json:"eventMetadata"`
+	Actor          string      `json:"actor"`
+	ActorMetadata  interface{} `json:"actorMetadata"`
+	Target         string      `json:"target"`
+	TargetMetadata interface{} `json:"targetMetadata"`
+	Origin         string      `json:"origin"`
+	OriginMetadata interface{} `json:"originMetadata"`
+}
+
+// Trail returns the trail of b
+func (b TrailBody) Trail() Trail {
+	return Trail{
+		ID:             b.ID,
+		Timestamp:      b.Timestamp,
+		Event:          b.Event,
+		EventMetadata:  b.EventMetadata,
+		Actor:          b.Actor,
+		ActorMetadata:  b.ActorMetadata,
+		Target:         b.Target,
+		TargetMetadata: b.TargetMetadata,
+		Origin:         b.Origin,
+		OriginMetadata: b.OriginMetadata,
+	}
+}
+
+// ParseTrail parses the body of a trail sent by a producer. The fields
+// describing the message are left for the source to fill in, producers can
+// not set them.
+func ParseTrail(data []byte) (Trail, error) {
+	var body TrailBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return Trail{}, err
+	}
+	return body.Trail(), nil
+}
+
+// Quarantine message that could not be ingested
+type Quarantine struct {
+	// Source sqs or http
+	Source string
+	// SourceID ID of the message at its source
+	SourceID string
+	// Tenant the message was sent to
+	Tenant string
+	// Body of the message
+	Body string
+	// Stage parse, validate or insert
+	Stage string
+	// Error that kept the message from being ingested
+	Error string
+	// Attempts times the message was received
+	Attempts int
+	// MessageID receipt handle of SQS messages
 	MessageID string
 }
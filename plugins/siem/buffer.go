@@ -0,0 +1,409 @@
+package siem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buffer holds messages until they are written to the SIEM. A message
+// stays in the buffer until it is popped, so messages whose write failed
+// are sent again.
+type Buffer interface {
+	// Push appends msg
+	Push(msg []byte) error
+	// Peek returns the oldest message, waiting for one. ok is false once the
+	// buffer is closed.
+	Peek() (msg []byte, ok bool, err error)
+	// Pop removes the message returned by Peek
+	Pop() error
+	// Empty reports whether every message was popped
+	Empty() bool
+	// Close wakes up Peek, messages left in a spool are sent after restart
+	Close() error
+}
+
+// MemoryBuffer holds up to a fixed number of messages in memory, Push
+// blocks while it is full
+type MemoryBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	messages [][]byte
+	size     int
+	closed   bool
+}
+
+// NewMemoryBuffer returns a buffer of size messages
+func NewMemoryBuffer(size int) *MemoryBuffer {
+	b := &MemoryBuffer{size: size}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Push
+func (b *MemoryBuffer) Push(msg []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.messages) >= b.size && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return fmt.Errorf("siem buffer closed")
+	}
+
+	b.messages = append(b.messages, msg)
+	b.cond.Broadcast()
+
+	return nil
+}
+
+// Peek
+func (b *MemoryBuffer) Peek() ([]byte, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.messages) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return nil, false, nil
+	}
+
+	return b.messages[0], true, nil
+}
+
+// Pop
+func (b *MemoryBuffer) Pop() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.messages) > 0 {
+		b.messages[0] = nil
+		b.messages = b.messages[1:]
+	}
+	b.cond.Broadcast()
+
+	return nil
+}
+
+// Empty
+func (b *MemoryBuffer) Empty() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.messages) == 0
+}
+
+// Close drops the buffered messages
+func (b *MemoryBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+
+	return nil
+}
+
+// spoolSegmentSize size at which a spool segment is closed and a new one
+// started
+const spoolSegmentSize = 16 << 20
+
+// Spool keeps messages in segment files below a directory so they survive
+// restarts. Each segment holds length prefixed messages, a cursor file
+// records the position of the oldest message not yet popped. Segments are
+// synced on every Push. The cursor is not, so after a crash a few messages
+// may be sent twice.
+type Spool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	dir    string
+	closed bool
+
+	// writer appends to segment writeSegment, writeOffset bytes long
+	writer       *os.File
+	writeSegment uint64
+	writeOffset  int64
+
+	// reader reads segment readSegment from readOffset
+	reader       *os.File
+	readSegment  uint64
+	readOffset   int64
+	cursor       *os.File
+	peekedLength int64
+}
+
+// OpenSpool opens the spool in dir, creating it when missing
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir}
+	s.cond = sync.NewCond(&s.mu)
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	s.cursor, err = os.OpenFile(filepath.Join(dir, "cursor"), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	var position [16]byte
+	if n, err := s.cursor.ReadAt(position[:], 0); err != nil && !(err == io.EOF && n == 0) {
+		return nil, fmt.Errorf("siem spool cursor: %v", err)
+	}
+	s.readSegment = binary.BigEndian.Uint64(position[0:8])
+	s.readOffset = int64(binary.BigEndian.Uint64(position[8:16]))
+
+	if len(segments) == 0 {
+		segments = []uint64{s.readSegment}
+	}
+	if s.readSegment < segments[0] {
+		s.readSegment, s.readOffset = segments[0], 0
+	}
+
+	// a crash while appending leaves a partial message at the end of the
+	// last segment
+	s.writeSegment = segments[len(segments)-1]
+	s.writer, err = os.OpenFile(s.segmentPath(s.writeSegment), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	if s.writeOffset, err = completeLength(s.writer); err != nil {
+		return nil, err
+	}
+	if err := s.writer.Truncate(s.writeOffset); err != nil {
+		return nil, err
+	}
+	if _, err := s.writer.Seek(s.writeOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Push appends msg and syncs it to disk
+func (s *Spool) Push(msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("siem spool closed")
+	}
+
+	if s.writeOffset >= spoolSegmentSize {
+		writer, err := os.OpenFile(s.segmentPath(s.writeSegment+1), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0640)
+		if err != nil {
+			return err
+		}
+		s.writer.Close()
+		s.writer = writer
+		s.writeSegment++
+		s.writeOffset = 0
+	}
+
+	record := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(record, uint32(len(msg)))
+	copy(record[4:], msg)
+
+	if _, err := s.writer.Write(record); err != nil {
+		// drop what was written of the partial record
+		s.writer.Truncate(s.writeOffset)
+		s.writer.Seek(s.writeOffset, io.SeekStart)
+		return err
+	}
+	if err := s.writer.Sync(); err != nil {
+		return err
+	}
+
+	s.writeOffset += int64(len(record))
+	s.cond.Broadcast()
+
+	return nil
+}
+
+// Peek
+func (s *Spool) Peek() ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.closed {
+			return nil, false, nil
+		}
+
+		if s.readSegment < s.writeSegment && s.readOffset >= s.segmentLength(s.readSegment) {
+			if err := s.nextSegment(); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+
+		if s.readSegment == s.writeSegment && s.readOffset >= s.writeOffset {
+			s.cond.Wait()
+			continue
+		}
+
+		break
+	}
+
+	if s.reader == nil {
+		reader, err := os.Open(s.segmentPath(s.readSegment))
+		if err != nil {
+			return nil, false, err
+		}
+		s.reader = reader
+	}
+
+	var length [4]byte
+	if _, err := s.reader.ReadAt(length[:], s.readOffset); err != nil {
+		return nil, false, fmt.Errorf("siem spool segment %d: %v", s.readSegment, err)
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := s.reader.ReadAt(msg, s.readOffset+4); err != nil {
+		return nil, false, fmt.Errorf("siem spool segment %d: %v", s.readSegment, err)
+	}
+	s.peekedLength = int64(4 + len(msg))
+
+	return msg, true, nil
+}
+
+// Pop
+func (s *Spool) Pop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a message written while closing is sent again after restart
+	if s.peekedLength == 0 || s.closed {
+		return nil
+	}
+
+	s.readOffset += s.peekedLength
+	s.peekedLength = 0
+
+	return s.saveCursor()
+}
+
+// Empty
+func (s *Spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readSegment == s.writeSegment && s.readOffset >= s.writeOffset
+}
+
+// Close
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+
+	if s.reader != nil {
+		s.reader.Close()
+	}
+	s.cursor.Close()
+	return s.writer.Close()
+}
+
+// nextSegment removes the fully read segment and moves to the next one
+func (s *Spool) nextSegment() error {
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader = nil
+	}
+
+	if err := os.Remove(s.segmentPath(s.readSegment)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.readSegment++
+	s.readOffset = 0
+
+	return s.saveCursor()
+}
+
+func (s *Spool) saveCursor() error {
+	var position [16]byte
+	binary.BigEndian.PutUint64(position[0:8], s.readSegment)
+	binary.BigEndian.PutUint64(position[8:16], uint64(s.readOffset))
+	_, err := s.cursor.WriteAt(position[:], 0)
+	return err
+}
+
+func (s *Spool) segmentLength(segment uint64) int64 {
+	if segment == s.writeSegment {
+		return s.writeOffset
+	}
+	info, err := os.Stat(s.segmentPath(segment))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (s *Spool) segmentPath(segment uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d.segment", segment))
+}
+
+// segments returns the numbers of the segments in the spool, ascending
+func (s *Spool) segments() ([]uint64, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []uint64
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".segment") {
+			continue
+		}
+		segment, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), ".segment"), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// completeLength returns the length of the complete messages at the start
+// of segment
+func completeLength(segment *os.File) (int64, error) {
+	info, err := segment.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	var length [4]byte
+	for offset+4 <= info.Size() {
+		if _, err := segment.ReadAt(length[:], offset); err != nil {
+			return 0, err
+		}
+		end := offset + 4 + int64(binary.BigEndian.Uint32(length[:]))
+		if end > info.Size() {
+			break
+		}
+		offset = end
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,355 @@
+package siem
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inspectr/backend/plugins"
+)
+
+// Formats trails can be forwarded in
+const (
+	FormatRFC5424 = "rfc5424"
+	FormatCEF     = "cef"
+	FormatLEEF    = "leef"
+)
+
+// sdID structured data element of RFC 5424 messages, 32473 is the private
+// enterprise number reserved for examples
+const sdID = "inspectr@32473"
+
+// DefaultMappings fields of each format when no mappings are configured
+var DefaultMappings = map[string]map[string]string{
+	FormatRFC5424: {
+		"event":  "event",
+		"actor":  "actor",
+		"target": "target",
+		"origin": "origin",
+		"tenant": "tenant",
+	},
+	FormatCEF: {
+		"act":      "event",
+		"suser":    "actor",
+		"duser":    "target",
+		"cs1Label": `"tenant"`,
+		"cs1":      "tenant",
+		"cs2Label": `"origin"`,
+		"cs2":      "origin",
+	},
+	FormatLEEF: {
+		"cat":      "event",
+		"usrName":  "actor",
+		"resource": "target",
+		"origin":   "origin",
+		"tenant":   "tenant",
+	},
+}
+
+// Header identifies the product in syslog headers, CEF and LEEF
+type Header struct {
+	// Hostname of the RFC 5424 header, the local hostname when empty
+	Hostname string
+	// AppName of the RFC 5424 header
+	AppName string
+	// Facility syslog facility, 13 (log audit) by default
+	Facility int
+	// Severity syslog severity, 6 (informational) by default
+	Severity int
+	// Vendor of CEF and LEEF headers
+	Vendor string
+	// Product of CEF and LEEF headers
+	Product string
+	// Version of CEF and LEEF headers
+	Version string
+	// EventSeverity CEF severity between 0 and 10, also sent as the LEEF
+	// sev attribute
+	EventSeverity int
+}
+
+// Formatter formats trails as syslog messages
+type Formatter struct {
+	format  string
+	header  Header
+	mapping []mapping
+}
+
+// mapping is an output field and the trail field or literal it is set to
+type mapping struct {
+	key    string
+	source string
+}
+
+// NewFormatter returns a formatter of format. mappings map output fields,
+// SD-PARAMs for RFC 5424 and extension keys for CEF and LEEF, to trail
+// fields: event, actor, target, origin, tenant, timestamp, or a path below
+// a metadata field such as actorMetadata.ip. Double quoted sources are
+// literals. DefaultMappings apply when mappings is empty.
+func NewFormatter(format string, header Header, mappings map[string]string) (*Formatter, error) {
+	defaults, ok := DefaultMappings[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown SIEM format %q, expected %s, %s or %s", format, FormatRFC5424, FormatCEF, FormatLEEF)
+	}
+
+	if len(mappings) == 0 {
+		mappings = defaults
+	}
+
+	f := &Formatter{format: format, header: header}
+	for key, source := range mappings {
+		if err := validSource(source); err != nil {
+			return nil, fmt.Errorf("mapping %s: %v", key, err)
+		}
+		f.mapping = append(f.mapping, mapping{key: key, source: source})
+	}
+	sort.Slice(f.mapping, func(i, j int) bool {
+		return f.mapping[i].key < f.mapping[j].key
+	})
+
+	if f.header.Hostname == "" {
+		f.header.Hostname, _ = os.Hostname()
+	}
+
+	return f, nil
+}
+
+// Format returns trail as an RFC 5424 syslog message. The message of CEF
+// and LEEF formats is the CEF or LEEF event.
+func (f *Formatter) Format(trail plugins.Trail) ([]byte, error) {
+	created := time.Now().UTC()
+	if trail.Timestamp > 0 {
+		created = time.Unix(trail.Timestamp, 0).UTC()
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "<%d>1 %s %s %s - %s ",
+		f.header.Facility*8+f.header.Severity,
+		created.Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(f.header.Hostname, 255),
+		headerField(f.header.AppName, 48),
+		headerField(trail.Event, 32),
+	)
+
+	switch f.format {
+	case FormatCEF:
+		buffer.WriteString("- ")
+		f.writeCEF(&buffer, trail, created)
+	case FormatLEEF:
+		buffer.WriteString("- ")
+		f.writeLEEF(&buffer, trail, created)
+	default:
+		if err := f.writeRFC5424(&buffer, trail); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// writeRFC5424 writes the mapped fields as structured data, followed by the
+// trail as JSON
+func (f *Formatter) writeRFC5424(buffer *bytes.Buffer, trail plugins.Trail) error {
+	buffer.WriteString("[" + sdID)
+	for _, m := range f.mapping {
+		value, ok := fieldValue(trail, m.source)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(buffer, ` %s="%s"`, sdName(m.key), sdEscaper.Replace(value))
+	}
+	buffer.WriteString("] ")
+
+	// MessageID is the SQS receipt handle
+	trail.MessageID = ""
+	body, err := json.Marshal(trail)
+	if err != nil {
+		return err
+	}
+	buffer.Write(body)
+
+	return nil
+}
+
+// writeCEF writes a CEF:0 event, rt is always set to the trail timestamp
+func (f *Formatter) writeCEF(buffer *bytes.Buffer, trail plugins.Trail, created time.Time) {
+	fmt.Fprintf(buffer, "CEF:0|%s|%s|%s|%s|%s|%d|rt=%d",
+		cefHeaderEscaper.Replace(f.header.Vendor),
+		cefHeaderEscaper.Replace(f.header.Product),
+		cefHeaderEscaper.Replace(f.header.Version),
+		cefHeaderEscaper.Replace(trail.Event),
+		cefHeaderEscaper.Replace(trail.Event),
+		f.header.EventSeverity,
+		created.UnixNano()/int64(time.Millisecond),
+	)
+
+	for _, m := range f.mapping {
+		value, ok := fieldValue(trail, m.source)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(buffer, " %s=%s", extensionKey(m.key), cefValueEscaper.Replace(value))
+	}
+}
+
+// writeLEEF writes a tab delimited LEEF:1.0 event, devTime and sev are
+// always set
+func (f *Formatter) writeLEEF(buffer *bytes.Buffer, trail plugins.Trail, created time.Time) {
+	fmt.Fprintf(buffer, "LEEF:1.0|%s|%s|%s|%s|devTime=%s\tdevTimeFormat=MMM dd yyyy HH:mm:ss\tsev=%d",
+		leefHeaderEscaper.Replace(f.header.Vendor),
+		leefHeaderEscaper.Replace(f.header.Product),
+		leefHeaderEscaper.Replace(f.header.Version),
+		leefHeaderEscaper.Replace(trail.Event),
+		created.Format("Jan 02 2006 15:04:05"),
+		f.header.EventSeverity,
+	)
+
+	for _, m := range f.mapping {
+		value, ok := fieldValue(trail, m.source)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(buffer, "\t%s=%s", extensionKey(m.key), leefValueEscaper.Replace(value))
+	}
+}
+
+var (
+	sdEscaper         = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	cefHeaderEscaper  = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefValueEscaper   = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	leefHeaderEscaper = strings.NewReplacer(`|`, " ", "\t", " ", "\r", " ", "\n", " ")
+	leefValueEscaper  = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+// headerField returns value as an RFC 5424 header field: printable ASCII
+// without spaces, at most max characters, - when empty
+func headerField(value string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+// sdName returns key as an RFC 5424 SD-NAME
+func sdName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+// extensionKey returns key as a CEF or LEEF extension key, letters, digits
+// and underscores
+func extensionKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// validSource checks that source is a literal, a trail field or a
+// metadata path
+func validSource(source string) error {
+	if isLiteral(source) {
+		return nil
+	}
+
+	root := strings.SplitN(source, ".", 2)[0]
+	switch root {
+	case "event", "actor", "target", "origin", "tenant", "timestamp":
+		if root != source {
+			return fmt.Errorf("%s has no fields", root)
+		}
+		return nil
+	case "eventMetadata", "actorMetadata", "targetMetadata", "originMetadata":
+		return nil
+	default:
+		return fmt.Errorf("unknown trail field %q, quote literals", source)
+	}
+}
+
+func isLiteral(source string) bool {
+	return len(source) >= 2 && strings.HasPrefix(source, `"`) && strings.HasSuffix(source, `"`)
+}
+
+// fieldValue returns the value of source for trail, false when a metadata
+// path is missing. Values that are not strings are JSON encoded.
+func fieldValue(trail plugins.Trail, source string) (string, bool) {
+	if isLiteral(source) {
+		return source[1 : len(source)-1], true
+	}
+
+	path := strings.Split(source, ".")
+
+	var value interface{}
+	switch path[0] {
+	case "event":
+		return trail.Event, true
+	case "actor":
+		return trail.Actor, true
+	case "target":
+		return trail.Target, true
+	case "origin":
+		return trail.Origin, true
+	case "tenant":
+		return trail.Tenant, true
+	case "timestamp":
+		return strconv.FormatInt(trail.Timestamp, 10), true
+	case "eventMetadata":
+		value = trail.EventMetadata
+	case "actorMetadata":
+		value = trail.ActorMetadata
+	case "targetMetadata":
+		value = trail.TargetMetadata
+	case "originMetadata":
+		value = trail.OriginMetadata
+	default:
+		return "", false
+	}
+
+	for _, key := range path[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(encoded), true
+	}
+}
@@ -0,0 +1,264 @@
+package siem
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/codeamp/logger"
+	"github.com/inspectr/backend/plugins"
+)
+
+// Networks messages can be sent over
+const (
+	NetworkUDP = "udp"
+	NetworkTCP = "tcp"
+	NetworkTLS = "tls"
+)
+
+// Framing of messages on TCP and TLS connections, RFC 6587
+const (
+	FramingOctetCounting = "octet-counting"
+	FramingNewline       = "newline"
+)
+
+const (
+	// dialTimeout time allowed to connect to the SIEM
+	dialTimeout = 10 * time.Second
+	// writeTimeout time allowed to write a message
+	writeTimeout = 30 * time.Second
+	// minBackoff and maxBackoff bound the wait between reconnects
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Config
+type Config struct {
+	// Network udp, tcp or tls
+	Network string
+	// Address host:port of the SIEM
+	Address string
+	// Framing octet-counting or newline, ignored for udp
+	Framing string
+	// TLS configures tls connections
+	TLS *tls.Config
+}
+
+// Forwarder sends formatted trails to a SIEM. Trails are pushed to the
+// buffer by Forward and removed once written to the connection, messages
+// whose write fails are sent again after reconnecting. A spool keeps them
+// across restarts, a memory buffer does not. Syslog has no
+// acknowledgements: over UDP, or when a TCP connection drops right after a
+// write, a written message may still be lost.
+type Forwarder struct {
+	config    Config
+	formatter *Formatter
+	buffer    Buffer
+	conn      net.Conn
+	// closed is closed once the SIEM closes conn, see watch
+	closed    chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewForwarder returns a forwarder sending trails formatted by formatter
+// through buffer, in the background until Close is called
+func NewForwarder(config Config, formatter *Formatter, buffer Buffer) (*Forwarder, error) {
+	switch config.Network {
+	case NetworkUDP, NetworkTCP, NetworkTLS:
+	default:
+		return nil, fmt.Errorf("unknown SIEM network %q, expected %s, %s or %s", config.Network, NetworkUDP, NetworkTCP, NetworkTLS)
+	}
+
+	switch config.Framing {
+	case "":
+		config.Framing = FramingOctetCounting
+	case FramingOctetCounting, FramingNewline:
+	default:
+		return nil, fmt.Errorf("unknown SIEM framing %q, expected %s or %s", config.Framing, FramingOctetCounting, FramingNewline)
+	}
+
+	if config.Address == "" {
+		return nil, fmt.Errorf("no SIEM address configured")
+	}
+
+	f := &Forwarder{
+		config:    config,
+		formatter: formatter,
+		buffer:    buffer,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go f.run()
+
+	return f, nil
+}
+
+// Forward formats trail and buffers it for sending
+func (f *Forwarder) Forward(trail plugins.Trail) error {
+	msg, err := f.formatter.Format(trail)
+	if err != nil {
+		return err
+	}
+
+	return f.buffer.Push(f.frame(msg))
+}
+
+// frame returns msg framed for the connection
+func (f *Forwarder) frame(msg []byte) []byte {
+	switch {
+	case f.config.Network == NetworkUDP:
+		return msg
+	case f.config.Framing == FramingNewline:
+		return append(msg, '\n')
+	default:
+		return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+}
+
+// run sends buffered messages until Close is called
+func (f *Forwarder) run() {
+	defer close(f.stopped)
+
+	backoff := minBackoff
+	for {
+		msg, ok, err := f.buffer.Peek()
+		if err != nil {
+			log.Error(err)
+			if !f.wait(backoff) {
+				return
+			}
+			continue
+		}
+		if !ok {
+			return
+		}
+
+		if err := f.write(msg); err != nil {
+			log.ErrorWithFields(err.Error(), log.Fields{
+				"address": f.config.Address,
+				"retryIn": backoff.String(),
+			})
+
+			f.disconnect()
+			if !f.wait(backoff) {
+				return
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+
+		if err := f.buffer.Pop(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// Drain waits until every buffered message is sent, or timeout passes
+func (f *Forwarder) Drain(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !f.buffer.Empty() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("messages left unsent to %s after %v", f.config.Address, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
+
+// Close stops sending and closes the buffer and connection
+func (f *Forwarder) Close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		close(f.done)
+		err = f.buffer.Close()
+		<-f.stopped
+		f.disconnect()
+	})
+	return err
+}
+
+// write sends msg, connecting first when needed
+func (f *Forwarder) write(msg []byte) error {
+	if f.conn != nil && !f.alive() {
+		f.disconnect()
+	}
+
+	if f.conn == nil {
+		conn, err := f.dial()
+		if err != nil {
+			return err
+		}
+		f.conn = conn
+		f.closed = make(chan struct{})
+		if f.config.Network != NetworkUDP {
+			go watch(conn, f.closed)
+		}
+
+		log.InfoWithFields("connected to SIEM", log.Fields{
+			"network": f.config.Network,
+			"address": f.config.Address,
+		})
+	}
+
+	f.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := f.conn.Write(msg)
+	return err
+}
+
+// alive reports whether the SIEM kept the connection open, writing to a
+// closed connection would lose the message
+func (f *Forwarder) alive() bool {
+	select {
+	case <-f.closed:
+		log.InfoWithFields("SIEM closed the connection", log.Fields{
+			"address": f.config.Address,
+		})
+		return false
+	default:
+		return true
+	}
+}
+
+// watch reads conn until it is closed, by the SIEM or by disconnect, then
+// closes closed. Syslog receivers never write, anything read is discarded.
+func watch(conn net.Conn, closed chan struct{}) {
+	io.Copy(ioutil.Discard, conn)
+	close(closed)
+}
+
+func (f *Forwarder) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	switch f.config.Network {
+	case NetworkTLS:
+		return tls.DialWithDialer(dialer, "tcp", f.config.Address, f.config.TLS)
+	default:
+		return dialer.Dial(f.config.Network, f.config.Address)
+	}
+}
+
+func (f *Forwarder) disconnect() {
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
+
+// wait sleeps for d, it returns false when the forwarder is closed first
+func (f *Forwarder) wait(d time.Duration) bool {
+	select {
+	case <-f.done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
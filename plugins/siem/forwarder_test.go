@@ -0,0 +1,349 @@
+package siem
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inspectr/backend/plugins"
+)
+
+// receiveTimeout time a test waits for a message
+const receiveTimeout = 5 * time.Second
+
+// receiver is a syslog server, it sends every message it reads to messages
+// and every connection it accepts to conns
+type receiver struct {
+	address  string
+	framing  string
+	listener net.Listener
+	packet   net.PacketConn
+	messages chan string
+	conns    chan net.Conn
+}
+
+func listen(t *testing.T, network, framing string, config *tls.Config) *receiver {
+	r := &receiver{
+		framing:  framing,
+		messages: make(chan string, 100),
+		conns:    make(chan net.Conn, 10),
+	}
+
+	var err error
+	switch network {
+	case NetworkUDP:
+		r.packet, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.address = r.packet.LocalAddr().String()
+		go r.readPackets()
+	case NetworkTLS:
+		r.listener, err = tls.Listen("tcp", "127.0.0.1:0", config)
+	default:
+		r.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.listener != nil {
+		r.address = r.listener.Addr().String()
+		go r.accept()
+	}
+
+	return r
+}
+
+func (r *receiver) readPackets() {
+	buffer := make([]byte, 65536)
+	for {
+		n, _, err := r.packet.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		r.messages <- string(buffer[:n])
+	}
+}
+
+func (r *receiver) accept() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		r.conns <- conn
+		go r.read(conn)
+	}
+}
+
+// read reads framed messages from conn until it is closed
+func (r *receiver) read(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		var msg string
+		if r.framing == FramingNewline {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			msg = strings.TrimSuffix(line, "\n")
+		} else {
+			prefix, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			length, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+			if err != nil {
+				return
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return
+			}
+			msg = string(data)
+		}
+		r.messages <- msg
+	}
+}
+
+func (r *receiver) close() {
+	if r.listener != nil {
+		r.listener.Close()
+	}
+	if r.packet != nil {
+		r.packet.Close()
+	}
+}
+
+// expect fails t unless the next messages are the events, in order
+func (r *receiver) expect(t *testing.T, events ...string) {
+	t.Helper()
+
+	for _, event := range events {
+		select {
+		case msg := <-r.messages:
+			if !strings.Contains(msg, " "+event+" ") {
+				t.Fatalf("received %q, want event %s", msg, event)
+			}
+		case <-time.After(receiveTimeout):
+			t.Fatalf("event %s not received", event)
+		}
+	}
+}
+
+// nextConn returns the next connection the receiver accepted
+func (r *receiver) nextConn(t *testing.T) net.Conn {
+	t.Helper()
+
+	select {
+	case conn := <-r.conns:
+		return conn
+	case <-time.After(receiveTimeout):
+		t.Fatal("no connection accepted")
+		return nil
+	}
+}
+
+func newTestForwarder(t *testing.T, config Config, buffer Buffer) *Forwarder {
+	formatter, err := NewFormatter(FormatRFC5424, Header{Hostname: "test", AppName: "inspectr", Facility: 13, Severity: 6}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewForwarder(config, formatter, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func forward(t *testing.T, f *Forwarder, events ...string) {
+	t.Helper()
+
+	for _, event := range events {
+		trail := plugins.Trail{
+			Timestamp: time.Now().Unix(),
+			Event:     event,
+			Actor:     "actor",
+			Target:    "target",
+			Origin:    "origin",
+		}
+		if err := f.Forward(trail); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// selfSigned returns the server and client TLS configs of a certificate
+// for 127.0.0.1
+func selfSigned(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "siem"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{RootCAs: roots}
+
+	return server, client
+}
+
+func TestForwarder(t *testing.T) {
+	server, client := selfSigned(t)
+
+	tests := []struct {
+		network string
+		framing string
+	}{
+		{NetworkUDP, ""},
+		{NetworkTCP, FramingNewline},
+		{NetworkTCP, FramingOctetCounting},
+		{NetworkTLS, FramingOctetCounting},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %s", test.network, test.framing), func(t *testing.T) {
+			r := listen(t, test.network, test.framing, server)
+			defer r.close()
+
+			f := newTestForwarder(t, Config{Network: test.network, Address: r.address, Framing: test.framing, TLS: client}, NewMemoryBuffer(10))
+			defer f.Close()
+
+			forward(t, f, "first", "second", "third")
+			r.expect(t, "first", "second", "third")
+
+			if err := f.Drain(receiveTimeout); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestForwarderReconnects(t *testing.T) {
+	server, client := selfSigned(t)
+
+	for _, network := range []string{NetworkTCP, NetworkTLS} {
+		t.Run(network, func(t *testing.T) {
+			r := listen(t, network, FramingOctetCounting, server)
+			defer r.close()
+
+			f := newTestForwarder(t, Config{Network: network, Address: r.address, TLS: client}, NewMemoryBuffer(10))
+			defer f.Close()
+
+			forward(t, f, "before")
+			r.expect(t, "before")
+
+			// the SIEM closes the connection, the forwarder notices before
+			// its next write and connects again
+			r.nextConn(t).Close()
+			time.Sleep(100 * time.Millisecond)
+
+			forward(t, f, "after")
+			r.nextConn(t)
+			r.expect(t, "after")
+		})
+	}
+}
+
+func TestForwarderRetriesUntilReachable(t *testing.T) {
+	r := listen(t, NetworkTCP, FramingOctetCounting, nil)
+	address := r.address
+	r.close()
+
+	f := newTestForwarder(t, Config{Network: NetworkTCP, Address: address}, NewMemoryBuffer(10))
+	defer f.Close()
+
+	forward(t, f, "queued")
+	time.Sleep(100 * time.Millisecond)
+
+	// the first dial failed, the message is sent once the SIEM is back
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("listening on %s again: %v", address, err)
+	}
+	r = &receiver{framing: FramingOctetCounting, listener: listener, messages: make(chan string, 10), conns: make(chan net.Conn, 10)}
+	go r.accept()
+	defer r.close()
+
+	r.expect(t, "queued")
+}
+
+func TestForwarderReplaysSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siem-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the SIEM is down, trails stay in the spool when the forwarder stops
+	down := listen(t, NetworkTCP, FramingOctetCounting, nil)
+	down.close()
+
+	spool, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newTestForwarder(t, Config{Network: NetworkTCP, Address: down.address}, spool)
+	forward(t, f, "one", "two", "three")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// after restart they are sent, in order
+	r := listen(t, NetworkTCP, FramingOctetCounting, nil)
+	defer r.close()
+
+	spool, err = OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = newTestForwarder(t, Config{Network: NetworkTCP, Address: r.address}, spool)
+	defer f.Close()
+
+	r.expect(t, "one", "two", "three")
+
+	forward(t, f, "four")
+	r.expect(t, "four")
+
+	if err := f.Drain(receiveTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
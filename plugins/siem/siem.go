@@ -0,0 +1,239 @@
+package siem
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	log "github.com/codeamp/logger"
+	"github.com/codeamp/transistor"
+	"github.com/inspectr/backend/plugins"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultBufferSize messages held in memory when no spool is configured
+	DefaultBufferSize = 10000
+	// drainTimeout time SendTest waits for its trail to be written
+	drainTimeout = 30 * time.Second
+)
+
+// SIEM forwards every stored trail to a SIEM as syslog
+type SIEM struct {
+	events    chan transistor.Event
+	forwarder *Forwarder
+}
+
+func init() {
+	transistor.RegisterPlugin("siem", func() transistor.Plugin {
+		return &SIEM{}
+	},
+		plugins.Trail{})
+}
+
+func (x *SIEM) Subscribe() []string {
+	return []string{
+		"trail_stored:create",
+	}
+}
+
+func (x *SIEM) Start(e chan transistor.Event) error {
+	x.events = e
+
+	forwarder, err := newForwarder(viper.GetString("plugins.siem.spool"))
+	if err != nil {
+		return err
+	}
+	x.forwarder = forwarder
+
+	log.InfoWithFields("Started SIEM", log.Fields{
+		"network": viper.GetString("plugins.siem.network"),
+		"address": viper.GetString("plugins.siem.address"),
+		"format":  viper.GetString("plugins.siem.format"),
+	})
+
+	return nil
+}
+
+func (x *SIEM) Stop() {
+	log.Info("Stopping SIEM")
+
+	if x.forwarder != nil {
+		if err := x.forwarder.Close(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// Process buffers trails the API stored for forwarding. Trails the API
+// rejects, quarantines or already stored are not announced, so redelivered
+// messages are forwarded once.
+func (x *SIEM) Process(e transistor.Event) error {
+	if e.Name != "trail_stored" || e.Action != transistor.GetAction("create") {
+		return nil
+	}
+
+	trail := e.Payload.(plugins.Trail)
+	if err := x.forwarder.Forward(trail); err != nil {
+		log.ErrorWithFields(err.Error(), log.Fields{
+			"event": trail.Event,
+		})
+		return err
+	}
+
+	return nil
+}
+
+// SendTest forwards a sample trail and waits until it is written, bypassing
+// the spool
+func (x *SIEM) SendTest() error {
+	forwarder, err := newForwarder("")
+	if err != nil {
+		return err
+	}
+	defer forwarder.Close()
+
+	trail := plugins.Trail{
+		Timestamp:      time.Now().Unix(),
+		Event:          "siem.test",
+		EventMetadata:  map[string]interface{}{},
+		Actor:          "inspectr",
+		ActorMetadata:  map[string]interface{}{},
+		Target:         viper.GetString("plugins.siem.address"),
+		TargetMetadata: map[string]interface{}{},
+		Origin:         "inspectr/siem",
+		OriginMetadata: map[string]interface{}{},
+	}
+
+	if err := forwarder.Forward(trail); err != nil {
+		return err
+	}
+
+	if err := forwarder.Drain(drainTimeout); err != nil {
+		return err
+	}
+
+	log.InfoWithFields("sent test trail", log.Fields{
+		"network": viper.GetString("plugins.siem.network"),
+		"address": viper.GetString("plugins.siem.address"),
+	})
+
+	return nil
+}
+
+// newForwarder returns a forwarder configured by the plugins.siem keys,
+// buffering in spool or in memory when spool is empty
+func newForwarder(spool string) (*Forwarder, error) {
+	header := Header{
+		Hostname:      viper.GetString("plugins.siem.hostname"),
+		AppName:       viper.GetString("plugins.siem.app_name"),
+		Facility:      13,
+		Severity:      6,
+		Vendor:        viper.GetString("plugins.siem.vendor"),
+		Product:       viper.GetString("plugins.siem.product"),
+		Version:       viper.GetString("plugins.siem.version"),
+		EventSeverity: viper.GetInt("plugins.siem.event_severity"),
+	}
+	if viper.IsSet("plugins.siem.facility") {
+		header.Facility = viper.GetInt("plugins.siem.facility")
+	}
+	if viper.IsSet("plugins.siem.severity") {
+		header.Severity = viper.GetInt("plugins.siem.severity")
+	}
+
+	if header.Facility < 0 || header.Facility > 23 {
+		return nil, fmt.Errorf("plugins.siem.facility must be between 0 and 23")
+	}
+	if header.Severity < 0 || header.Severity > 7 {
+		return nil, fmt.Errorf("plugins.siem.severity must be between 0 and 7")
+	}
+	if header.EventSeverity < 0 || header.EventSeverity > 10 {
+		return nil, fmt.Errorf("plugins.siem.event_severity must be between 0 and 10")
+	}
+
+	// mappings are field=source strings, viper lowercases map keys
+	format := viper.GetString("plugins.siem.format")
+	mappings := map[string]string{}
+	for _, mapping := range viper.GetStringSlice("plugins.siem.mappings." + format) {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("plugins.siem.mappings.%s: %q is not field=source", format, mapping)
+		}
+		mappings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	formatter, err := NewFormatter(format, header, mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{
+		Network: viper.GetString("plugins.siem.network"),
+		Address: viper.GetString("plugins.siem.address"),
+		Framing: viper.GetString("plugins.siem.framing"),
+	}
+
+	if config.Network == NetworkTLS {
+		config.TLS, err = loadTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buffer Buffer
+	if spool != "" {
+		buffer, err = OpenSpool(spool)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		size := viper.GetInt("plugins.siem.buffer_size")
+		if size <= 0 {
+			size = DefaultBufferSize
+		}
+		buffer = NewMemoryBuffer(size)
+	}
+
+	forwarder, err := NewForwarder(config, formatter, buffer)
+	if err != nil {
+		buffer.Close()
+		return nil, err
+	}
+
+	return forwarder, nil
+}
+
+// loadTLSConfig returns the TLS configuration of the plugins.siem.tls keys
+func loadTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         viper.GetString("plugins.siem.tls.server_name"),
+		InsecureSkipVerify: viper.GetBool("plugins.siem.tls.insecure_skip_verify"),
+	}
+
+	if caFile := viper.GetString("plugins.siem.tls.ca_file"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+
+	certFile := viper.GetString("plugins.siem.tls.cert_file")
+	keyFile := viper.GetString("plugins.siem.tls.key_file")
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,519 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	aws_sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/codeamp/logger"
+)
+
+const (
+	// MaxReceiveMessages most messages SQS returns per receive
+	MaxReceiveMessages = 10
+	// MaxWaitTime longest long poll SQS allows, in seconds
+	MaxWaitTime = 20
+	// MaxVisibilityTimeout longest time SQS hides a received message
+	MaxVisibilityTimeout = 12 * time.Hour
+	// maxBatch most entries SQS accepts per batch request
+	maxBatch = 10
+)
+
+// ConsumerConfig
+type ConsumerConfig struct {
+	// Receivers receive loops running in parallel
+	Receivers int
+	// MaxMessages messages asked for per receive, up to 10
+	MaxMessages int64
+	// WaitTime seconds a receive long polls for, up to 20
+	WaitTime int64
+	// MinBackoff and MaxBackoff bound the wait after a receive returned
+	// nothing or failed, which doubles while the queue stays empty
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// DeleteInterval longest time acknowledged messages wait to be deleted
+	// in a batch
+	DeleteInterval time.Duration
+	// VisibilityTimeout time received messages are hidden from other
+	// receivers, extended while they are in flight
+	VisibilityTimeout time.Duration
+	// MaxExtension longest time a message in flight is kept hidden
+	MaxExtension time.Duration
+	// RetryBackoff and MaxRetryBackoff bound the time a message whose
+	// trail failed is hidden before it is received again, doubling with
+	// every receive
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// MaxReceives times a message is received before its trail is
+	// quarantined
+	MaxReceives int
+}
+
+// DefaultConsumerConfig
+var DefaultConsumerConfig = ConsumerConfig{
+	Receivers:         4,
+	MaxMessages:       MaxReceiveMessages,
+	WaitTime:          MaxWaitTime,
+	MinBackoff:        time.Second,
+	MaxBackoff:        30 * time.Second,
+	DeleteInterval:    time.Second,
+	VisibilityTimeout: 30 * time.Second,
+	MaxExtension:      time.Hour,
+	RetryBackoff:      5 * time.Second,
+	MaxRetryBackoff:   15 * time.Minute,
+	MaxReceives:       5,
+}
+
+// Validate
+func (c ConsumerConfig) Validate() error {
+	if c.Receivers < 1 {
+		return fmt.Errorf("receivers must be positive")
+	}
+	if c.MaxMessages < 1 || c.MaxMessages > MaxReceiveMessages {
+		return fmt.Errorf("max_messages must be between 1 and %d", MaxReceiveMessages)
+	}
+	if c.WaitTime < 0 || c.WaitTime > MaxWaitTime {
+		return fmt.Errorf("wait_time must be between 0 and %d", MaxWaitTime)
+	}
+	if c.MinBackoff <= 0 || c.MaxBackoff < c.MinBackoff {
+		return fmt.Errorf("min_backoff must be positive and at most max_backoff")
+	}
+	if c.DeleteInterval <= 0 {
+		return fmt.Errorf("delete_interval must be positive")
+	}
+	if c.VisibilityTimeout < time.Second || c.VisibilityTimeout > MaxVisibilityTimeout {
+		return fmt.Errorf("visibility_timeout must be between 1s and %s", MaxVisibilityTimeout)
+	}
+	if c.MaxExtension < c.VisibilityTimeout || c.MaxExtension > MaxVisibilityTimeout {
+		return fmt.Errorf("max_extension must be between visibility_timeout and %s", MaxVisibilityTimeout)
+	}
+	if c.RetryBackoff <= 0 || c.MaxRetryBackoff < c.RetryBackoff || c.MaxRetryBackoff > MaxVisibilityTimeout {
+		return fmt.Errorf("retry_backoff must be positive and at most max_retry_backoff, which is at most %s", MaxVisibilityTimeout)
+	}
+	if c.MaxReceives < 1 {
+		return fmt.Errorf("max_receives must be positive")
+	}
+	return nil
+}
+
+// retryBackoff returns the time a message received attempts times is
+// hidden after its trail failed
+func (c ConsumerConfig) retryBackoff(attempts int) time.Duration {
+	backoff := c.RetryBackoff
+	for i := 1; i < attempts && backoff < c.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.MaxRetryBackoff {
+		backoff = c.MaxRetryBackoff
+	}
+	return backoff
+}
+
+// inFlight message handed to the handler and not acknowledged yet
+type inFlight struct {
+	// receivedAt
+	receivedAt time.Time
+	// hiddenUntil time the message becomes visible again
+	hiddenUntil time.Time
+	// attempts times the message was received
+	attempts int
+}
+
+// Consumer receives messages from a queue with parallel long polling
+// receivers and hands them to a handler. Messages acknowledged with Delete
+// are deleted in batches, messages passed to Retry are received again
+// after an exponential backoff. Until then messages are in flight: their
+// visibility timeout is extended, up to MaxExtension, so slow work does not
+// get them delivered twice. Receivers that find the queue empty back off
+// exponentially, so an idle queue costs few requests.
+type Consumer struct {
+	queue  Queue
+	config ConsumerConfig
+	handle func(SQSMessage)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	loops  sync.WaitGroup
+
+	mu       sync.Mutex
+	pending  []string
+	inFlight map[string]*inFlight
+	stopped  bool
+	flushNow chan struct{}
+	stop     chan struct{}
+	deleted  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewConsumer returns a consumer passing the messages of queue to handle
+// in the background until Stop is called. handle is called concurrently by
+// the receivers.
+func NewConsumer(queue Queue, config ConsumerConfig, handle func(SQSMessage)) (*Consumer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Consumer{
+		queue:    queue,
+		config:   config,
+		handle:   handle,
+		ctx:      ctx,
+		cancel:   cancel,
+		inFlight: map[string]*inFlight{},
+		flushNow: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		deleted:  make(chan struct{}),
+	}
+
+	for i := 0; i < config.Receivers; i++ {
+		c.loops.Add(1)
+		go c.receive()
+	}
+	c.loops.Add(1)
+	go c.heartbeat()
+	go c.deleteLoop()
+
+	return c, nil
+}
+
+// Delete queues the message with receiptHandle for deletion. Once the
+// consumer is stopped it is deleted right away.
+func (c *Consumer) Delete(receiptHandle string) {
+	c.mu.Lock()
+	delete(c.inFlight, receiptHandle)
+	c.pending = append(c.pending, receiptHandle)
+	full := len(c.pending) >= maxBatch
+	stopped := c.stopped
+	c.mu.Unlock()
+
+	if stopped {
+		c.flushDeletes()
+		return
+	}
+
+	if full {
+		select {
+		case c.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Retry hides the message with receiptHandle for a backoff growing with
+// the times it was received, after which it is received again
+func (c *Consumer) Retry(receiptHandle string) {
+	c.mu.Lock()
+	attempts := 1
+	if message, ok := c.inFlight[receiptHandle]; ok {
+		attempts = message.attempts
+		delete(c.inFlight, receiptHandle)
+	}
+	c.mu.Unlock()
+
+	backoff := c.config.retryBackoff(attempts)
+	if _, err := c.queue.ChangeVisibility([]string{receiptHandle}, backoff); err != nil {
+		log.Error(err)
+		return
+	}
+
+	log.DebugWithFields("SQS message retried", log.Fields{
+		"attempts": attempts,
+		"backoff":  backoff.String(),
+	})
+}
+
+// Stop interrupts the receivers and heartbeats, waits until they returned
+// and deletes the messages acknowledged so far. Messages still in flight
+// are received again once their visibility timeout expires.
+func (c *Consumer) Stop() {
+	c.stopOnce.Do(func() {
+		c.cancel()
+		c.loops.Wait()
+
+		c.mu.Lock()
+		c.stopped = true
+		c.mu.Unlock()
+
+		close(c.stop)
+		<-c.deleted
+	})
+}
+
+// receive runs a receive loop until the consumer is stopped
+func (c *Consumer) receive() {
+	defer c.loops.Done()
+
+	var backoff time.Duration
+	for {
+		msgs, err := c.queue.GetMessages(c.ctx, c.config.MaxMessages, c.config.WaitTime, c.config.VisibilityTimeout)
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Error(err)
+		}
+
+		now := time.Now()
+		c.mu.Lock()
+		for _, msg := range msgs {
+			c.inFlight[msg.trail.MessageID] = &inFlight{
+				receivedAt:  now,
+				hiddenUntil: now.Add(c.config.VisibilityTimeout),
+				attempts:    msg.trail.Attempts,
+			}
+		}
+		c.mu.Unlock()
+
+		for _, msg := range msgs {
+			c.handle(msg)
+		}
+
+		if len(msgs) > 0 {
+			backoff = 0
+			continue
+		}
+
+		backoff *= 2
+		if backoff < c.config.MinBackoff {
+			backoff = c.config.MinBackoff
+		}
+		if backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// heartbeat extends the visibility timeout of the messages in flight
+// before it expires, until the consumer is stopped
+func (c *Consumer) heartbeat() {
+	defer c.loops.Done()
+
+	ticker := time.NewTicker(c.config.VisibilityTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return
+		}
+
+		c.extendInFlight(time.Now())
+	}
+}
+
+// extendInFlight extends the visibility timeout of the messages in flight
+// that become visible within half of it. Messages an extension would keep
+// hidden for longer than MaxExtension are left to expire.
+func (c *Consumer) extendInFlight(now time.Time) {
+	var handles []string
+
+	c.mu.Lock()
+	for handle, message := range c.inFlight {
+		if message.hiddenUntil.Sub(now) >= c.config.VisibilityTimeout/2 {
+			continue
+		}
+
+		if now.Sub(message.receivedAt)+c.config.VisibilityTimeout > c.config.MaxExtension {
+			log.WarnWithFields("SQS message in flight for too long, it will be received again", log.Fields{
+				"receivedAt": message.receivedAt.Format(time.RFC3339),
+				"attempts":   message.attempts,
+			})
+			delete(c.inFlight, handle)
+			continue
+		}
+
+		handles = append(handles, handle)
+	}
+	c.mu.Unlock()
+
+	for start := 0; start < len(handles); start += maxBatch {
+		end := start + maxBatch
+		if end > len(handles) {
+			end = len(handles)
+		}
+
+		failed, err := c.queue.ChangeVisibility(handles[start:end], c.config.VisibilityTimeout)
+		if err != nil {
+			log.Error(err)
+		}
+
+		c.mu.Lock()
+		for _, handle := range handles[start:end] {
+			message, ok := c.inFlight[handle]
+			if !ok {
+				continue
+			}
+			// the message was deleted or its receipt handle expired
+			if failed[handle] {
+				delete(c.inFlight, handle)
+			} else if err == nil {
+				message.hiddenUntil = now.Add(c.config.VisibilityTimeout)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// deleteLoop deletes the queued messages every DeleteInterval, or as soon
+// as a batch is full, until the consumer is stopped
+func (c *Consumer) deleteLoop() {
+	defer close(c.deleted)
+
+	ticker := time.NewTicker(c.config.DeleteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.flushNow:
+		case <-c.stop:
+			c.flushDeletes()
+			return
+		}
+
+		c.flushDeletes()
+	}
+}
+
+// flushDeletes deletes the queued messages in batches. Messages that could
+// not be deleted are received again once their visibility timeout expires.
+func (c *Consumer) flushDeletes() {
+	c.mu.Lock()
+	handles := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	for start := 0; start < len(handles); start += maxBatch {
+		end := start + maxBatch
+		if end > len(handles) {
+			end = len(handles)
+		}
+
+		if err := c.queue.DeleteMessages(handles[start:end]); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// GetMessages returns the parsed messages from SQS if any. If an error
+// occurs that error will be returned.
+func (q *Queue) GetMessages(ctx aws.Context, numMessages int64, waitTimeout int64, visibilityTimeout time.Duration) ([]SQSMessage, error) {
+	params := aws_sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(q.URL),
+		MaxNumberOfMessages:   aws.Int64(numMessages),
+		VisibilityTimeout:     aws.Int64(int64(visibilityTimeout / time.Second)),
+		MessageAttributeNames: aws.StringSlice([]string{TenantAttribute, ProducerAttribute}),
+		AttributeNames:        aws.StringSlice([]string{aws_sqs.MessageSystemAttributeNameApproximateReceiveCount}),
+	}
+
+	if waitTimeout > 0 {
+		params.WaitTimeSeconds = aws.Int64(waitTimeout)
+	}
+
+	resp, err := q.Client.ReceiveMessageWithContext(ctx, &params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages, %v", err)
+	}
+
+	log.Debug("Succesfully got SQS Messages")
+
+	msgs := make([]SQSMessage, len(resp.Messages))
+	for i, msg := range resp.Messages {
+		msgs[i] = parseMessage(msg)
+	}
+
+	return msgs, nil
+}
+
+// DeleteMessages deletes the messages with receiptHandles, at most 10, in
+// one request
+func (q *Queue) DeleteMessages(receiptHandles []string) error {
+	if len(receiptHandles) == 0 {
+		return nil
+	}
+
+	entries := make([]*aws_sqs.DeleteMessageBatchRequestEntry, len(receiptHandles))
+	for i := range receiptHandles {
+		entries[i] = &aws_sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: aws.String(receiptHandles[i]),
+		}
+	}
+
+	resp, err := q.Client.DeleteMessageBatch(&aws_sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(q.URL),
+		Entries:  entries,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %d messages, %v", len(receiptHandles), err)
+	}
+
+	if len(resp.Failed) > 0 {
+		failures := make([]string, len(resp.Failed))
+		for i, failed := range resp.Failed {
+			failures[i] = fmt.Sprintf("%s: %s", aws.StringValue(failed.Code), aws.StringValue(failed.Message))
+		}
+		return fmt.Errorf("failed to delete %d of %d messages, %s", len(resp.Failed), len(receiptHandles), strings.Join(failures, "; "))
+	}
+
+	log.DebugWithFields("Deleted SQS Messages", log.Fields{
+		"count": len(receiptHandles),
+	})
+
+	return nil
+}
+
+// ChangeVisibility hides the messages with receiptHandles, at most 10, for
+// timeout from now, in one request. It returns the receipt handles SQS
+// refused, such as those of deleted messages.
+func (q *Queue) ChangeVisibility(receiptHandles []string, timeout time.Duration) (map[string]bool, error) {
+	if len(receiptHandles) == 0 {
+		return nil, nil
+	}
+
+	entries := make([]*aws_sqs.ChangeMessageVisibilityBatchRequestEntry, len(receiptHandles))
+	for i := range receiptHandles {
+		entries[i] = &aws_sqs.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			ReceiptHandle:     aws.String(receiptHandles[i]),
+			VisibilityTimeout: aws.Int64(int64(timeout / time.Second)),
+		}
+	}
+
+	resp, err := q.Client.ChangeMessageVisibilityBatch(&aws_sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: aws.String(q.URL),
+		Entries:  entries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to change the visibility of %d messages, %v", len(receiptHandles), err)
+	}
+
+	failed := map[string]bool{}
+	for _, entry := range resp.Failed {
+		i, err := strconv.Atoi(aws.StringValue(entry.Id))
+		if err != nil || i < 0 || i >= len(receiptHandles) {
+			continue
+		}
+		failed[receiptHandles[i]] = true
+
+		log.DebugWithFields("failed to change the visibility of an SQS message", log.Fields{
+			"code":    aws.StringValue(entry.Code),
+			"message": aws.StringValue(entry.Message),
+		})
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,594 @@
+package sqs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	aws_sqs "github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// waitTimeout time a test waits for the consumer to do something
+const waitTimeout = 5 * time.Second
+
+// fakeSQS is an in memory queue serving the calls of the consumer. Received
+// messages are not delivered again.
+type fakeSQS struct {
+	sqsiface.SQSAPI
+
+	mu       sync.Mutex
+	messages []*aws_sqs.Message
+	next     int
+	// longPoll makes receives on an empty queue wait until cancelled
+	longPoll bool
+	// polling receives in progress, and the most seen at once
+	polling    int
+	maxPolling int
+	// receives times every receive was called, returning the number of
+	// messages each returned
+	receives  []time.Time
+	returning []int
+	// deletes receipt handles of every DeleteMessageBatch call
+	deletes [][]string
+	// failDeletes receipt handles DeleteMessageBatch reports as failed
+	failDeletes map[string]bool
+	// visibility entries of every ChangeMessageVisibilityBatch call
+	visibility [][]*aws_sqs.ChangeMessageVisibilityBatchRequestEntry
+	// failVisibility receipt handles ChangeMessageVisibilityBatch reports
+	// as failed
+	failVisibility map[string]bool
+}
+
+func newFakeSQS() *fakeSQS {
+	return &fakeSQS{
+		failDeletes:    map[string]bool{},
+		failVisibility: map[string]bool{},
+	}
+}
+
+// add queues a message received receives times once received, and returns
+// its receipt handle
+func (f *fakeSQS) add(body string, receives int) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.next++
+	handle := fmt.Sprintf("handle-%d", f.next)
+	f.messages = append(f.messages, &aws_sqs.Message{
+		MessageId:     aws.String(fmt.Sprintf("message-%d", f.next)),
+		ReceiptHandle: aws.String(handle),
+		Body:          aws.String(body),
+		Attributes: map[string]*string{
+			aws_sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(strconv.Itoa(receives)),
+		},
+	})
+	return handle
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx aws.Context, input *aws_sqs.ReceiveMessageInput, options ...request.Option) (*aws_sqs.ReceiveMessageOutput, error) {
+	f.mu.Lock()
+	f.receives = append(f.receives, time.Now())
+
+	n := int(aws.Int64Value(input.MaxNumberOfMessages))
+	if n > len(f.messages) {
+		n = len(f.messages)
+	}
+	received := f.messages[:n]
+	f.messages = f.messages[n:]
+	f.returning = append(f.returning, n)
+
+	if n > 0 || !f.longPoll {
+		f.mu.Unlock()
+		return &aws_sqs.ReceiveMessageOutput{Messages: received}, nil
+	}
+
+	f.polling++
+	if f.polling > f.maxPolling {
+		f.maxPolling = f.polling
+	}
+	f.mu.Unlock()
+
+	<-ctx.Done()
+
+	f.mu.Lock()
+	f.polling--
+	f.mu.Unlock()
+
+	return nil, ctx.Err()
+}
+
+func (f *fakeSQS) DeleteMessageBatch(input *aws_sqs.DeleteMessageBatchInput) (*aws_sqs.DeleteMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &aws_sqs.DeleteMessageBatchOutput{}
+	var handles []string
+	for _, entry := range input.Entries {
+		handle := aws.StringValue(entry.ReceiptHandle)
+		handles = append(handles, handle)
+
+		if f.failDeletes[handle] {
+			output.Failed = append(output.Failed, &aws_sqs.BatchResultErrorEntry{
+				Id:      entry.Id,
+				Code:    aws.String("ReceiptHandleIsInvalid"),
+				Message: aws.String("invalid receipt handle " + handle),
+			})
+		} else {
+			output.Successful = append(output.Successful, &aws_sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+		}
+	}
+	f.deletes = append(f.deletes, handles)
+
+	return output, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibilityBatch(input *aws_sqs.ChangeMessageVisibilityBatchInput) (*aws_sqs.ChangeMessageVisibilityBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &aws_sqs.ChangeMessageVisibilityBatchOutput{}
+	for _, entry := range input.Entries {
+		if f.failVisibility[aws.StringValue(entry.ReceiptHandle)] {
+			output.Failed = append(output.Failed, &aws_sqs.BatchResultErrorEntry{
+				Id:      entry.Id,
+				Code:    aws.String("ReceiptHandleIsInvalid"),
+				Message: aws.String("invalid receipt handle"),
+			})
+		} else {
+			output.Successful = append(output.Successful, &aws_sqs.ChangeMessageVisibilityBatchResultEntry{Id: entry.Id})
+		}
+	}
+	f.visibility = append(f.visibility, input.Entries)
+
+	return output, nil
+}
+
+// deleted returns every receipt handle passed to DeleteMessageBatch
+func (f *fakeSQS) deleted() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var handles []string
+	for _, batch := range f.deletes {
+		handles = append(handles, batch...)
+	}
+	return handles
+}
+
+// eventually fails t unless done returns true within waitTimeout
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// testConfig returns a valid config receiving without long polling
+func testConfig() ConsumerConfig {
+	config := DefaultConsumerConfig
+	config.WaitTime = 0
+	config.MinBackoff = 10 * time.Millisecond
+	config.MaxBackoff = 40 * time.Millisecond
+	config.DeleteInterval = time.Hour
+	return config
+}
+
+func TestConsumerReceiversRunInParallel(t *testing.T) {
+	fake := newFakeSQS()
+	fake.longPoll = true
+
+	config := testConfig()
+	config.Receivers = 3
+
+	c, err := NewConsumer(Queue{Client: fake, URL: "queue"}, config, func(SQSMessage) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "3 receivers long polling at once", func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.polling == 3
+	})
+
+	// Stop cancels the long polls in progress
+	stopped := make(chan struct{})
+	go func() {
+		c.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(waitTimeout):
+		t.Fatal("Stop did not interrupt the long polls")
+	}
+
+	if fake.maxPolling != 3 {
+		t.Errorf("%d receivers polled at once, want 3", fake.maxPolling)
+	}
+}
+
+func TestConsumerHandlesInParallel(t *testing.T) {
+	fake := newFakeSQS()
+	for i := 0; i < 3; i++ {
+		fake.add(`{"event": "parallel"}`, 1)
+	}
+
+	config := testConfig()
+	config.Receivers = 3
+	config.MaxMessages = 1
+
+	// every handler waits for the others, which only returns when the
+	// three messages are handled at once
+	var arrived sync.WaitGroup
+	arrived.Add(3)
+	all := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(all)
+	}()
+
+	c, err := NewConsumer(Queue{Client: fake, URL: "queue"}, config, func(msg SQSMessage) {
+		arrived.Done()
+		select {
+		case <-all:
+		case <-time.After(waitTimeout):
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	select {
+	case <-all:
+	case <-time.After(waitTimeout):
+		t.Fatal("messages were not handled in parallel")
+	}
+}
+
+func TestConsumerDeletesInBatches(t *testing.T) {
+	fake := newFakeSQS()
+	var handles []string
+	for i := 0; i < 25; i++ {
+		handles = append(handles, fake.add(`{"event": "delete"}`, 1))
+	}
+	fake.failDeletes[handles[3]] = true
+
+	config := testConfig()
+	config.Receivers = 1
+	config.DeleteInterval = 20 * time.Millisecond
+
+	var c *Consumer
+	ready := make(chan struct{})
+	c, err := NewConsumer(Queue{Client: fake, URL: "queue"}, config, func(msg SQSMessage) {
+		<-ready
+		c.Delete(msg.trail.MessageID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(ready)
+	defer c.Stop()
+
+	eventually(t, "25 messages deleted", func() bool {
+		return len(fake.deleted()) == 25
+	})
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	// the batch holding the failed message did not keep the others from
+	// being deleted, nor was it retried
+	seen := map[string]int{}
+	for _, batch := range fake.deletes {
+		if len(batch) > maxBatch {
+			t.Errorf("DeleteMessageBatch with %d entries, at most %d are allowed", len(batch), maxBatch)
+		}
+		for _, handle := range batch {
+			seen[handle]++
+		}
+	}
+	for _, handle := range handles {
+		if seen[handle] != 1 {
+			t.Errorf("%s deleted %d times, want once", handle, seen[handle])
+		}
+	}
+}
+
+func TestDeleteMessagesReportsFailedEntries(t *testing.T) {
+	fake := newFakeSQS()
+	fake.failDeletes["b"] = true
+	queue := Queue{Client: fake, URL: "queue"}
+
+	err := queue.DeleteMessages([]string{"a", "b", "c"})
+	if err == nil || !strings.Contains(err.Error(), "failed to delete 1 of 3 messages") || !strings.Contains(err.Error(), "ReceiptHandleIsInvalid") {
+		t.Errorf("DeleteMessages = %v, want the failed entry reported", err)
+	}
+	if got := fake.deleted(); strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("deleted %v, want a, b and c in one batch", got)
+	}
+
+	if err := queue.DeleteMessages(nil); err != nil || len(fake.deletes) != 1 {
+		t.Errorf("DeleteMessages of nothing = %v after %d calls, want no call", err, len(fake.deletes))
+	}
+}
+
+func TestConsumerBacksOffOnEmptyQueue(t *testing.T) {
+	fake := newFakeSQS()
+
+	config := testConfig()
+	config.Receivers = 1
+
+	c, err := NewConsumer(Queue{Client: fake, URL: "queue"}, config, func(SQSMessage) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	fake.mu.Lock()
+	receives := append([]time.Time(nil), fake.receives...)
+	fake.mu.Unlock()
+
+	// 10ms, 20ms, then 40ms between receives
+	if len(receives) < 4 || len(receives) > 12 {
+		t.Fatalf("%d receives in 300ms, want the waits between them to grow from 10ms to 40ms", len(receives))
+	}
+	for i := 1; i < len(receives); i++ {
+		want := config.MinBackoff << uint(i-1)
+		if want > config.MaxBackoff {
+			want = config.MaxBackoff
+		}
+		if wait := receives[i].Sub(receives[i-1]); wait < want {
+			t.Errorf("receive %d came %v after the previous one, want at least %v", i, wait, want)
+		}
+	}
+
+	// a message resets the backoff, the next receive comes right away
+	fake.add(`{"event": "wake"}`, 1)
+	var next int
+	eventually(t, "a receive after the message", func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		for i, n := range fake.returning {
+			if n > 0 && i+1 < len(fake.receives) {
+				next = i + 1
+				return true
+			}
+		}
+		return false
+	})
+
+	c.Stop()
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if wait := fake.receives[next].Sub(fake.receives[next-1]); wait >= config.MinBackoff {
+		t.Errorf("receive after a message came %v later, want no backoff", wait)
+	}
+}
+
+func TestConsumerStopFlushesDeletes(t *testing.T) {
+	fake := newFakeSQS()
+	var handles []string
+	for i := 0; i < 3; i++ {
+		handles = append(handles, fake.add(`{"event": "stop"}`, 1))
+	}
+
+	var c *Consumer
+	ready := make(chan struct{})
+	handled := make(chan struct{}, 3)
+	c, err := NewConsumer(Queue{Client: fake, URL: "queue"}, testConfig(), func(msg SQSMessage) {
+		<-ready
+		c.Delete(msg.trail.MessageID)
+		handled <- struct{}{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(ready)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-handled:
+		case <-time.After(waitTimeout):
+			t.Fatal("messages not handled")
+		}
+	}
+
+	// fewer than a batch, waiting for the hour long DeleteInterval
+	if deleted := fake.deleted(); len(deleted) != 0 {
+		t.Fatalf("deleted %v before Stop", deleted)
+	}
+
+	c.Stop()
+
+	if deleted := fake.deleted(); strings.Join(deleted, ",") != strings.Join(handles, ",") {
+		t.Errorf("Stop deleted %v, want %v", deleted, handles)
+	}
+
+	// acknowledged once stopped, the message is deleted right away
+	c.Delete("late")
+	if deleted := fake.deleted(); deleted[len(deleted)-1] != "late" {
+		t.Errorf("Delete after Stop did not delete, deleted %v", deleted)
+	}
+}
+
+// visibilityChanges returns the timeout in seconds of every receipt handle
+// passed to ChangeMessageVisibilityBatch, by handle
+func (f *fakeSQS) visibilityChanges() map[string][]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	changes := map[string][]int64{}
+	for _, batch := range f.visibility {
+		for _, entry := range batch {
+			handle := aws.StringValue(entry.ReceiptHandle)
+			changes[handle] = append(changes[handle], aws.Int64Value(entry.VisibilityTimeout))
+		}
+	}
+	return changes
+}
+
+func TestConsumerHeartbeatExtendsVisibility(t *testing.T) {
+	fake := newFakeSQS()
+	handle := fake.add(`{"event": "slow"}`, 1)
+
+	config := testConfig()
+	config.Receivers = 1
+	config.VisibilityTimeout = time.Second
+	config.MaxExtension = time.Hour
+
+	// the message stays in flight, it is never acknowledged
+	c, err := NewConsumer(Queue{Client: fake, URL: "queue"}, config, func(SQSMessage) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	eventually(t, "the visibility timeout extended twice", func() bool {
+		return len(fake.visibilityChanges()[handle]) >= 2
+	})
+
+	for _, timeout := range fake.visibilityChanges()[handle] {
+		if timeout != 1 {
+			t.Errorf("visibility timeout extended by %ds, want 1s", timeout)
+		}
+	}
+}
+
+func TestExtendInFlight(t *testing.T) {
+	fake := newFakeSQS()
+	fake.failVisibility["refused"] = true
+
+	config := DefaultConsumerConfig
+	config.VisibilityTimeout = 30 * time.Second
+	config.MaxExtension = 2 * time.Minute
+
+	now := time.Now()
+	c := &Consumer{
+		queue:  Queue{Client: fake, URL: "queue"},
+		config: config,
+		inFlight: map[string]*inFlight{
+			// becomes visible within half the timeout
+			"due": {receivedAt: now.Add(-20 * time.Second), hiddenUntil: now.Add(10 * time.Second), attempts: 1},
+			// hidden for long enough
+			"fresh": {receivedAt: now.Add(-5 * time.Second), hiddenUntil: now.Add(25 * time.Second), attempts: 1},
+			// another extension would keep it hidden past MaxExtension
+			"capped": {receivedAt: now.Add(-100 * time.Second), hiddenUntil: now.Add(5 * time.Second), attempts: 1},
+			// deleted meanwhile, SQS refuses its receipt handle
+			"refused": {receivedAt: now.Add(-20 * time.Second), hiddenUntil: now.Add(10 * time.Second), attempts: 1},
+		},
+	}
+	for i := 0; i < 12; i++ {
+		c.inFlight[fmt.Sprintf("bulk-%d", i)] = &inFlight{receivedAt: now.Add(-20 * time.Second), hiddenUntil: now.Add(time.Second), attempts: 1}
+	}
+
+	c.extendInFlight(now)
+
+	changes := fake.visibilityChanges()
+	if len(changes) != 14 {
+		t.Errorf("visibility of %d messages changed, want the 14 due", len(changes))
+	}
+	for handle, timeouts := range changes {
+		if len(timeouts) != 1 || timeouts[0] != 30 {
+			t.Errorf("%s hidden for %v seconds, want 30 once", handle, timeouts)
+		}
+	}
+	for _, handle := range []string{"fresh", "capped"} {
+		if _, ok := changes[handle]; ok {
+			t.Errorf("visibility of %s changed", handle)
+		}
+	}
+	for _, batch := range fake.visibility {
+		if len(batch) > maxBatch {
+			t.Errorf("ChangeMessageVisibilityBatch with %d entries, at most %d are allowed", len(batch), maxBatch)
+		}
+	}
+
+	if message := c.inFlight["due"]; message == nil || !message.hiddenUntil.Equal(now.Add(30*time.Second)) {
+		t.Errorf("due in flight as %+v, want hidden until 30s from now", message)
+	}
+	if message := c.inFlight["fresh"]; message == nil || !message.hiddenUntil.Equal(now.Add(25*time.Second)) {
+		t.Errorf("fresh in flight as %+v, want left as it was", message)
+	}
+	for _, handle := range []string{"capped", "refused"} {
+		if _, ok := c.inFlight[handle]; ok {
+			t.Errorf("%s still in flight, want it left to expire", handle)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	config := DefaultConsumerConfig
+	config.RetryBackoff = 5 * time.Second
+	config.MaxRetryBackoff = time.Minute
+
+	for attempts, want := range map[int]time.Duration{
+		0:  5 * time.Second,
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		4:  40 * time.Second,
+		5:  time.Minute,
+		30: time.Minute,
+	} {
+		if got := config.retryBackoff(attempts); got != want {
+			t.Errorf("retryBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestConsumerRetryHidesForBackoff(t *testing.T) {
+	fake := newFakeSQS()
+	first := fake.add(`{"event": "retry"}`, 1)
+	third := fake.add(`{"event": "retry"}`, 3)
+
+	config := testConfig()
+	config.Receivers = 1
+
+	var c *Consumer
+	ready := make(chan struct{})
+	c, err := NewConsumer(Queue{Client: fake, URL: "queue"}, config, func(msg SQSMessage) {
+		<-ready
+		c.Retry(msg.trail.MessageID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(ready)
+	defer c.Stop()
+
+	eventually(t, "both messages retried", func() bool {
+		return len(fake.visibilityChanges()) == 2
+	})
+
+	// 5s doubling with every receive
+	changes := fake.visibilityChanges()
+	if timeouts := changes[first]; len(timeouts) != 1 || timeouts[0] != 5 {
+		t.Errorf("message received once hidden for %v seconds, want 5", timeouts)
+	}
+	if timeouts := changes[third]; len(timeouts) != 1 || timeouts[0] != 20 {
+		t.Errorf("message received 3 times hidden for %v seconds, want 20", timeouts)
+	}
+
+	// retried messages are no longer extended
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.inFlight) != 0 {
+		t.Errorf("%d messages still in flight after Retry", len(c.inFlight))
+	}
+}
@@ -1,8 +1,8 @@
 package sqs
 
 import (
-	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -16,14 +16,23 @@ import (
 	"github.com/spf13/viper"
 )
 
+// TenantAttribute message attribute naming the project a trail belongs to,
+// either a project ID or organization/project
+const TenantAttribute = "tenant"
+
+// ProducerAttribute message attribute naming the producer of a trail,
+// schema violations are counted per producer
+const ProducerAttribute = "producer"
+
 type SQS struct {
-	events chan transistor.Event
-	queue  Queue
+	events   chan transistor.Event
+	queue    Queue
+	consumer *Consumer
 }
 
 type SQSMessage struct {
-	sqsResponse   aws_sqs.ReceiveMessageOutput
 	trail         plugins.Trail
+	body          string
 	success       bool
 	statusMessage string
 }
@@ -42,15 +51,18 @@ func init() {
 func (x *SQS) Subscribe() []string {
 	return []string{
 		"trail:status",
+		"quarantine:status",
 	}
 }
 
+// Process deletes the messages whose trails were stored or quarantined.
+// Messages that failed are received again after a backoff.
 func (x *SQS) Process(e transistor.Event) error {
 	switch e.State {
 	case transistor.GetState("complete"):
 		return x.deleteMessages(e)
 	case transistor.GetState("failed"):
-		return nil
+		return x.retryMessages(e)
 	}
 	return nil
 }
@@ -65,6 +77,11 @@ func (x *SQS) Start(e chan transistor.Event) error {
 		panic("Missing AWS SQS credentials")
 	}
 
+	config, err := loadConsumerConfig()
+	if err != nil {
+		return err
+	}
+
 	region := viper.GetString("plugins.sqs.aws_region")
 	sess := session.Must(session.NewSessionWithOptions(
 		session.Options{
@@ -86,90 +103,169 @@ func (x *SQS) Start(e chan transistor.Event) error {
 
 	x.queue = queue
 
-	log.Info("Started SQS")
-
-	go func(x *SQS, e chan transistor.Event) {
-		for {
-			msgs, err := x.queue.GetMessages(1, 20)
-			if err != nil {
-				log.Error(err)
-			}
-
-			for _, msg := range msgs {
-				if msg.success == true {
-					e <- transistor.NewEvent(transistor.EventName("trail"), transistor.GetAction("create"), msg.trail)
-				} else if msg.success == false {
-					x.deleteMessages(transistor.NewEvent(transistor.EventName("trail"), transistor.GetAction("delete"), msg.trail))
-				}
-			}
+	consumer, err := NewConsumer(queue, config, x.handle)
+	if err != nil {
+		return err
+	}
+	x.consumer = consumer
 
-			time.Sleep(1)
-		}
-	}(x, e)
+	log.InfoWithFields("Started SQS", log.Fields{
+		"receivers":         config.Receivers,
+		"maxMessages":       config.MaxMessages,
+		"visibilityTimeout": config.VisibilityTimeout.String(),
+		"maxReceives":       config.MaxReceives,
+	})
 
 	return nil
 }
 
 func (x *SQS) Stop() {
 	log.Info("Stopping SQS")
-}
 
-// GetMessages returns the parsed messages from SQS if any. If an error
-// occurs that error will be returned.
-func (q *Queue) GetMessages(numMessages int64, waitTimeout int64) ([]SQSMessage, error) {
-	params := aws_sqs.ReceiveMessageInput{
-		QueueUrl:            aws.String(q.URL),
-		MaxNumberOfMessages: aws.Int64(numMessages),
+	if x.consumer != nil {
+		x.consumer.Stop()
 	}
+}
 
-	if waitTimeout > 0 {
-		params.WaitTimeSeconds = aws.Int64(waitTimeout)
+// handle emits a trail:create event for a received message, and a
+// quarantine:create event for messages that can not be parsed or were
+// received more than MaxReceives times
+func (x *SQS) handle(msg SQSMessage) {
+	maxReceives := x.consumer.config.MaxReceives
+
+	if !msg.success {
+		x.quarantine(msg, "parse", msg.statusMessage)
+		return
 	}
 
-	resp, err := q.Client.ReceiveMessage(&params)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get messages, %v", err)
+	// the trail failed every time it was received and so did holding it
+	if msg.trail.Attempts > maxReceives {
+		x.quarantine(msg, "insert", fmt.Sprintf("not stored after %d receives", msg.trail.Attempts-1))
+		return
 	}
 
-	log.Debug("Succesfully got SQS Messages")
+	msg.trail.MaxAttempts = maxReceives
+	x.events <- transistor.NewEvent(transistor.EventName("trail"), transistor.GetAction("create"), msg.trail)
+}
+
+// quarantine emits a quarantine:create event for msg, which failed at stage
+func (x *SQS) quarantine(msg SQSMessage, stage string, cause string) {
+	x.events <- transistor.NewEvent(transistor.EventName("quarantine"), transistor.GetAction("create"), plugins.Quarantine{
+		Source:    msg.trail.Source,
+		SourceID:  msg.trail.SourceID,
+		Tenant:    msg.trail.Tenant,
+		Body:      msg.body,
+		Stage:     stage,
+		Error:     cause,
+		Attempts:  msg.trail.Attempts,
+		MessageID: msg.trail.MessageID,
+	})
+}
+
+// loadConsumerConfig returns the consumer settings configured for the
+// plugin
+func loadConsumerConfig() (ConsumerConfig, error) {
+	config := DefaultConsumerConfig
+	if viper.IsSet("plugins.sqs.receivers") {
+		config.Receivers = viper.GetInt("plugins.sqs.receivers")
+	}
+	if viper.IsSet("plugins.sqs.max_messages") {
+		config.MaxMessages = viper.GetInt64("plugins.sqs.max_messages")
+	}
+	if viper.IsSet("plugins.sqs.wait_time") {
+		config.WaitTime = viper.GetInt64("plugins.sqs.wait_time")
+	}
+	if viper.IsSet("plugins.sqs.max_receives") {
+		config.MaxReceives = viper.GetInt("plugins.sqs.max_receives")
+	}
 
-	msgs := make([]SQSMessage, len(resp.Messages))
-	for i, msg := range resp.Messages {
-		sqsMessage := SQSMessage{}
-		parsedTrail := plugins.Trail{}
-		parsedTrail.MessageID = *msg.ReceiptHandle
-		if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &parsedTrail); err != nil {
-			sqsMessage.success = false
-			sqsMessage.statusMessage = fmt.Sprintf("failed to unmarshal message, %v", err)
+	durations := []struct {
+		key   string
+		value *time.Duration
+	}{
+		{"plugins.sqs.min_backoff", &config.MinBackoff},
+		{"plugins.sqs.max_backoff", &config.MaxBackoff},
+		{"plugins.sqs.delete_interval", &config.DeleteInterval},
+		{"plugins.sqs.visibility_timeout", &config.VisibilityTimeout},
+		{"plugins.sqs.max_extension", &config.MaxExtension},
+		{"plugins.sqs.retry_backoff", &config.RetryBackoff},
+		{"plugins.sqs.max_retry_backoff", &config.MaxRetryBackoff},
+	}
+	for _, d := range durations {
+		if value := viper.GetString(d.key); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return config, fmt.Errorf("%s: %v", d.key, err)
+			}
+			*d.value = parsed
 		}
-		sqsMessage.success = true
-		sqsMessage.trail = parsedTrail
+	}
 
-		msgs[i] = sqsMessage
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("plugins.sqs: %v", err)
 	}
 
-	return msgs, nil
+	return config, nil
 }
 
-func (x *SQS) deleteMessages(e transistor.Event) error {
-	if e.PayloadModel == "plugins.Trail" {
+// parseMessage returns the trail carried by msg
+func parseMessage(msg *aws_sqs.Message) SQSMessage {
+	sqsMessage := SQSMessage{
+		body:    aws.StringValue(msg.Body),
+		success: true,
+	}
+	parsedTrail, err := plugins.ParseTrail([]byte(sqsMessage.body))
+	if err != nil {
+		sqsMessage.success = false
+		sqsMessage.statusMessage = fmt.Sprintf("failed to unmarshal message, %v", err)
+	}
 
-		msg := e.Payload.(plugins.Trail)
+	parsedTrail.MessageID = aws.StringValue(msg.ReceiptHandle)
+	parsedTrail.Source = "sqs"
+	parsedTrail.SourceID = aws.StringValue(msg.MessageId)
+	parsedTrail.Attempts, _ = strconv.Atoi(aws.StringValue(msg.Attributes[aws_sqs.MessageSystemAttributeNameApproximateReceiveCount]))
 
-		deleteParams := aws_sqs.DeleteMessageInput{
-			QueueUrl:      aws.String(x.queue.URL),
-			ReceiptHandle: &msg.MessageID,
-		}
+	if attribute, ok := msg.MessageAttributes[TenantAttribute]; ok {
+		parsedTrail.Tenant = aws.StringValue(attribute.StringValue)
+	}
+	if attribute, ok := msg.MessageAttributes[ProducerAttribute]; ok {
+		parsedTrail.Producer = aws.StringValue(attribute.StringValue)
+	}
+	sqsMessage.trail = parsedTrail
 
-		_, err := x.queue.Client.DeleteMessage(&deleteParams)
-		if err != nil {
-			log.Info("Failed to delete SQS Message")
-			return err
-		} else {
-			log.Debug("Deleted SQS Message")
-			return nil
-		}
+	return sqsMessage
+}
 
+// receiptHandle returns the receipt handle of the message the payload of e
+// was received in, empty for trails ingested over HTTP
+func receiptHandle(e transistor.Event) string {
+	switch msg := e.Payload.(type) {
+	case plugins.Trail:
+		return msg.MessageID
+	case plugins.Quarantine:
+		return msg.MessageID
 	}
+	return ""
+}
+
+func (x *SQS) deleteMessages(e transistor.Event) error {
+	if handle := receiptHandle(e); handle != "" {
+		x.consumer.Delete(handle)
+	}
+	return nil
+}
+
+// retryMessages hides the message whose trail was neither stored nor
+// quarantined until its backoff expired
+func (x *SQS) retryMessages(e transistor.Event) error {
+	handle := receiptHandle(e)
+	if handle == "" {
+		return nil
+	}
+
+	log.WarnWithFields("SQS message was neither stored nor quarantined", log.Fields{
+		"event": e.Event(),
+	})
+	x.consumer.Retry(handle)
 	return nil
 }
@@ -0,0 +1,52 @@
+package sqs
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	aws_sqs "github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/inspectr/backend/plugins"
+)
+
+func TestParseMessageIgnoresRoutingFields(t *testing.T) {
+	// the body tries to route the trail, only the message may
+	msg := &aws_sqs.Message{
+		MessageId:     aws.String("message"),
+		ReceiptHandle: aws.String("receipt"),
+		Body: aws.String(`{
+			"event": "user.login",
+			"actor": "alice",
+			"MessageID": "forged",
+			"Tenant": "other/project",
+			"Producer": "someone@example.com",
+			"Source": "http",
+			"SourceID": "forged",
+			"Attempts": 7,
+			"MaxAttempts": 99
+		}`),
+		Attributes: map[string]*string{
+			aws_sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("2"),
+		},
+		MessageAttributes: map[string]*aws_sqs.MessageAttributeValue{
+			TenantAttribute: {StringValue: aws.String("acme/web")},
+		},
+	}
+
+	parsed := parseMessage(msg)
+	if !parsed.success {
+		t.Fatal(parsed.statusMessage)
+	}
+
+	want := plugins.Trail{
+		Event:     "user.login",
+		Actor:     "alice",
+		MessageID: "receipt",
+		Tenant:    "acme/web",
+		Source:    "sqs",
+		SourceID:  "message",
+		Attempts:  2,
+	}
+	if parsed.trail != want {
+		t.Errorf("parsed %+v, want %+v", parsed.trail, want)
+	}
+}